@@ -0,0 +1,38 @@
+package commands
+
+import (
+	"context"
+	"time"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/server"
+	"github.com/buildkite/buildkite-custom-scheduler/internal/storage"
+	"github.com/rs/zerolog/log"
+)
+
+type CleanupCmd struct {
+	RedisAddr     string `help:"Redis address" default:"localhost:6379" env:"REDIS_ADDR"`
+	WorkerTimeout string `help:"How long a worker can go without a heartbeat before its claims count as orphaned" default:"30s" env:"WORKER_TIMEOUT"`
+	DryRun        bool   `help:"Report orphaned jobs without changing anything"`
+	Expunge       bool   `help:"Delete orphaned jobs instead of requeueing them"`
+}
+
+func (c *CleanupCmd) Run() error {
+	workerTimeout, err := time.ParseDuration(c.WorkerTimeout)
+	if err != nil {
+		return err
+	}
+
+	store, err := storage.NewRedisStore(c.RedisAddr, storage.DispatchOrder{})
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	orphans, err := server.NewSweeper(store, workerTimeout).Sweep(context.Background(), c.DryRun, c.Expunge)
+	if err != nil {
+		return err
+	}
+
+	log.Info().Int("orphans", len(orphans)).Bool("dry_run", c.DryRun).Msg("Cleanup complete")
+	return nil
+}
@@ -0,0 +1,73 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+)
+
+// serverAPI is embedded in commands that act through the server's API, so
+// their changes are authorized and audited like any other client's.
+type serverAPI struct {
+	APIServer string `help:"API server URL" default:"http://localhost:18888" env:"SCHEDULER_API_SERVER"`
+	APIToken  string `help:"Bearer token for the API server: its API_TOKEN, or a minted admin token" env:"SCHEDULER_API_TOKEN"`
+}
+
+var apiHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// call makes an API request, encoding body as JSON if it isn't nil and
+// decoding the response into out if it isn't nil. Error responses are
+// returned as *types.APIError.
+func (s serverAPI) call(ctx context.Context, method, path string, body, out any) error {
+	var reqBody io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("encoding request: %w", err)
+		}
+		reqBody = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(s.APIServer, "/")+path, reqBody)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if s.APIToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.APIToken)
+	}
+
+	resp, err := apiHTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(resp.Body)
+		var apiErr types.APIErrorResponse
+		if json.Unmarshal(respBody, &apiErr) == nil && apiErr.Error.Message != "" {
+			return &apiErr.Error
+		}
+		return fmt.Errorf("%s %s: unexpected status %d: %s", method, path, resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding %s response: %w", path, err)
+	}
+	return nil
+}
+
+func (s serverAPI) get(ctx context.Context, path string, out any) error {
+	return s.call(ctx, http.MethodGet, path, nil, out)
+}
@@ -0,0 +1,129 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/alecthomas/kong"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/rs/zerolog/log"
+)
+
+type DevCmd struct {
+	AgentToken string   `help:"Buildkite agent token for the cluster whose queues to serve" env:"BUILDKITE_AGENT_TOKEN" required:""`
+	StackKey   string   `help:"Stack key to register" default:"custom-scheduler-dev"`
+	Queues     []string `help:"Queue keys to monitor; workers are spread across them" default:"default" sep:","`
+	Workers    int      `help:"Number of workers to run" default:"1"`
+	Listen     string   `help:"HTTP listen address for the server" default:"localhost:18888"`
+	AgentPath  string   `help:"Path to buildkite-agent binary" default:"/usr/local/bin/buildkite-agent" env:"BUILDKITE_AGENT_PATH"`
+	RedisAddr  string   `help:"Redis to use instead of an in-memory one, e.g. to inspect it while developing"`
+}
+
+func (c *DevCmd) Run() error {
+	if c.Workers < 0 {
+		return fmt.Errorf("--workers can't be negative")
+	}
+	if len(c.Queues) == 0 {
+		return fmt.Errorf("at least one queue is required")
+	}
+
+	redisAddr := c.RedisAddr
+	if redisAddr == "" {
+		mr := miniredis.NewMiniRedis()
+		if err := mr.Start(); err != nil {
+			return fmt.Errorf("starting in-memory Redis: %w", err)
+		}
+		defer mr.Close()
+		redisAddr = mr.Addr()
+		log.Info().Str("redis", redisAddr).Msg("Started in-memory Redis; its contents are lost on exit")
+	}
+
+	// Intervals are short so changes show up quickly, at the cost of more
+	// calls to Buildkite than production settings would make.
+	var server ServerCmd
+	if err := parseDefaults(&server,
+		"--agent-token="+c.AgentToken,
+		"--stack-key="+c.StackKey,
+		"--queues="+strings.Join(c.Queues, ","),
+		"--redis-addr="+redisAddr,
+		"--listen="+c.Listen,
+		"--poll-interval=500ms",
+		"--max-poll-interval=2s",
+		"--reservation-expiry=1m",
+		"--claim-lease=20s",
+		"--worker-timeout=10s",
+		"--reconcile-interval=5s",
+		"--orphan-sweep-interval=30s",
+		"--stack-heartbeat-interval=30s",
+	); err != nil {
+		return err
+	}
+
+	apiServer := "http://" + c.Listen
+	if strings.HasPrefix(c.Listen, ":") {
+		apiServer = "http://localhost" + c.Listen
+	}
+	workers := make([]*WorkerCmd, c.Workers)
+	for i := range workers {
+		workers[i] = &WorkerCmd{}
+		if err := parseDefaults(workers[i],
+			"--agent-token="+c.AgentToken,
+			"--api-server="+apiServer,
+			"--agent-query-rules=queue="+c.Queues[i%len(c.Queues)],
+			"--agent-path="+c.AgentPath,
+			"--poll-interval=500ms",
+			"--claim-wait=5s",
+			"--heartbeat-interval=3s",
+			"--drain-timeout=30s",
+		); err != nil {
+			return err
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Everything stops together: if the server or a worker fails, the rest
+	// shut down rather than carrying on half working.
+	var wg sync.WaitGroup
+	errs := make(chan error, len(workers)+1)
+	run := func(name string, run func(context.Context) error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := run(ctx); err != nil {
+				errs <- fmt.Errorf("%s: %w", name, err)
+			}
+			cancel()
+		}()
+	}
+	run("server", func(ctx context.Context) error { return server.run(ctx, nil) })
+	for i, w := range workers {
+		run(fmt.Sprintf("worker %d", i+1), w.run)
+	}
+	log.Info().Str("api_server", apiServer).Int("workers", len(workers)).Strs("queues", c.Queues).Msg("Development mode running, Ctrl-C to stop")
+
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// parseDefaults fills in cmd as if it were run with args, so it gets the
+// same defaults and env vars as on the command line.
+func parseDefaults(cmd any, args ...string) error {
+	parser, err := kong.New(cmd, kong.Name("dev"), kong.Exit(func(int) {}))
+	if err != nil {
+		return err
+	}
+	if _, err := parser.Parse(args); err != nil {
+		return fmt.Errorf("configuring %T: %w", cmd, err)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/storage"
+	"github.com/buildkite/stacksapi"
+)
+
+type DoctorCmd struct {
+	RedisAddr  string `help:"Redis address" default:"localhost:6379" env:"REDIS_ADDR"`
+	AgentToken string `help:"Buildkite agent token to check against the Stacks API" env:"BUILDKITE_AGENT_TOKEN"`
+	StackKey   string `help:"Stack key the server registers" default:"custom-scheduler-demo"`
+	Queue      string `help:"Queue key to list scheduled jobs from" default:"default"`
+	AgentPath  string `help:"Path to buildkite-agent binary" default:"/usr/local/bin/buildkite-agent" env:"BUILDKITE_AGENT_PATH"`
+	Timeout    string `help:"How long each check may take" default:"10s"`
+}
+
+// errSkipped marks a check that couldn't run for want of settings.
+var errSkipped = errors.New("skipped")
+
+// doctorCheck is one diagnosis: run reports what it found, or an error
+// saying what's wrong and what to do about it.
+type doctorCheck struct {
+	name string
+	run  func(ctx context.Context) (string, error)
+}
+
+func (c *DoctorCmd) Run() error {
+	timeout, err := time.ParseDuration(c.Timeout)
+	if err != nil {
+		return err
+	}
+
+	checks := []doctorCheck{
+		{"DNS", c.checkDNS},
+		{"Redis", c.checkRedis},
+		{"Stacks API", c.checkStacksAPI},
+		{"Agent", c.checkAgent},
+	}
+	failed := 0
+	for _, check := range checks {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		detail, err := check.run(ctx)
+		cancel()
+		switch {
+		case errors.Is(err, errSkipped):
+			fmt.Printf("SKIP  %-10s  %s\n", check.name, detail)
+		case err != nil:
+			failed++
+			fmt.Printf("FAIL  %-10s  %s\n", check.name, err)
+		default:
+			fmt.Printf("PASS  %-10s  %s\n", check.name, detail)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+	}
+	return nil
+}
+
+// checkDNS resolves the hosts the scheduler connects to, so a resolver
+// problem shows up as one rather than as connection failures below.
+func (c *DoctorCmd) checkDNS(ctx context.Context) (string, error) {
+	redisHost, _, err := net.SplitHostPort(c.RedisAddr)
+	if err != nil {
+		return "", fmt.Errorf("REDIS_ADDR %q isn't host:port: %w", c.RedisAddr, err)
+	}
+	stacksHost := strings.TrimSuffix(strings.TrimPrefix(stacksapi.DefaultBaseURL, "https://"), "/v3/")
+
+	var resolved []string
+	var unresolved []string
+	for _, host := range []string{redisHost, stacksHost} {
+		if net.ParseIP(host) != nil {
+			continue
+		}
+		addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+		if err != nil {
+			unresolved = append(unresolved, err.Error())
+			continue
+		}
+		resolved = append(resolved, fmt.Sprintf("%s -> %s", host, strings.Join(addrs, ",")))
+	}
+	if len(unresolved) > 0 {
+		return "", fmt.Errorf("%s; check /etc/resolv.conf and that the names are right", strings.Join(unresolved, "; "))
+	}
+	return strings.Join(resolved, "; "), nil
+}
+
+func (c *DoctorCmd) checkRedis(ctx context.Context) (string, error) {
+	store, err := storage.NewRedisStore(c.RedisAddr, storage.DispatchOrder{})
+	if err != nil {
+		return "", fmt.Errorf("%w; check REDIS_ADDR and that Redis is running and reachable from here", err)
+	}
+	defer store.Close()
+	if err := store.Ping(ctx); err != nil {
+		return "", err
+	}
+	return "connected to " + c.RedisAddr, nil
+}
+
+// checkStacksAPI lists the queue's scheduled jobs, which needs a valid agent
+// token for the queue's cluster, and the stack key to be registered.
+func (c *DoctorCmd) checkStacksAPI(ctx context.Context) (string, error) {
+	if c.AgentToken == "" {
+		return "set BUILDKITE_AGENT_TOKEN to check the token", errSkipped
+	}
+	client, err := stacksapi.NewClient(c.AgentToken)
+	if err != nil {
+		return "", err
+	}
+	resp, _, err := client.ListScheduledJobs(ctx, stacksapi.ListScheduledJobsRequest{
+		StackKey:        c.StackKey,
+		ClusterQueueKey: c.Queue,
+		PageSize:        1,
+	}, stacksapi.WithNoRetry())
+
+	var errResp *stacksapi.ErrorResponse
+	switch {
+	case err == nil:
+		detail := fmt.Sprintf("token is valid and stack %s can list queue %s", c.StackKey, c.Queue)
+		if resp.ClusterQueue.Paused {
+			detail += " (the queue is paused in Buildkite)"
+		}
+		return detail, nil
+	case !errors.As(err, &errResp) || errResp.Response == nil:
+		return "", fmt.Errorf("%w; check outbound HTTPS to %s", err, stacksapi.DefaultBaseURL)
+	}
+	switch status := errResp.Response.StatusCode; status {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return "", fmt.Errorf("the agent token was rejected (%d %s); check BUILDKITE_AGENT_TOKEN is a current token for the queue's cluster", status, errResp.Message)
+	case http.StatusNotFound, http.StatusUnprocessableEntity:
+		return "", fmt.Errorf("the token is valid, but stack %s can't list queue %s (%d %s); check the queue key, and that the stack is registered by a running server with this stack key", c.StackKey, c.Queue, status, errResp.Message)
+	default:
+		return "", fmt.Errorf("unexpected response: %w", err)
+	}
+}
+
+func (c *DoctorCmd) checkAgent(ctx context.Context) (string, error) {
+	info, err := os.Stat(c.AgentPath)
+	if err != nil {
+		return "", fmt.Errorf("%w; install buildkite-agent or set BUILDKITE_AGENT_PATH (only needed on hosts running workers)", err)
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("%s is a directory, not the agent binary", c.AgentPath)
+	}
+	output, err := exec.CommandContext(ctx, c.AgentPath, "--version").CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("running %s --version: %w: %s", c.AgentPath, err, strings.TrimSpace(string(output)))
+	}
+	version, _, _ := strings.Cut(strings.TrimSpace(string(output)), "\n")
+	return version, nil
+}
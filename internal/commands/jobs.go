@@ -0,0 +1,179 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+)
+
+type JobsCmd struct {
+	List    JobsListCmd    `cmd:"" help:"List jobs the scheduler is tracking"`
+	Show    JobsShowCmd    `cmd:"" help:"Show everything the scheduler knows about a job"`
+	Requeue JobsRequeueCmd `cmd:"" help:"Put claimed or dead-lettered jobs back for another worker"`
+}
+
+type JobsListCmd struct {
+	serverAPI `embed:""`
+
+	Status string `help:"Only list jobs with this status, e.g. claimed or dead"`
+	Queue  string `help:"Only list jobs from this queue"`
+	Worker string `help:"Only list jobs claimed by this worker ID"`
+	MinAge string `help:"Only list jobs reserved at least this long ago, e.g. 10m"`
+	MaxAge string `help:"Only list jobs reserved at most this long ago, e.g. 1h"`
+	Limit  int    `help:"Most jobs to list (0 for all)" default:"100"`
+	JSON   bool   `help:"Print the jobs as JSON instead of a table" name:"json"`
+}
+
+func (c *JobsListCmd) Run() error {
+	ctx := context.Background()
+	params := url.Values{}
+	for param, value := range map[string]string{"status": c.Status, "queue": c.Queue, "worker": c.Worker, "min_age": c.MinAge, "max_age": c.MaxAge} {
+		if value != "" {
+			params.Set(param, value)
+		}
+	}
+
+	// Pages can be short, so keep going until there are enough or no more.
+	jobs := []types.JobSummary{}
+	for c.Limit <= 0 || len(jobs) < c.Limit {
+		pageSize := 500
+		if c.Limit > 0 {
+			pageSize = min(c.Limit-len(jobs), pageSize)
+		}
+		params.Set("limit", strconv.Itoa(pageSize))
+		var page struct {
+			Jobs       []types.JobSummary `json:"jobs"`
+			NextCursor string             `json:"next_cursor"`
+		}
+		if err := c.get(ctx, "/jobs/search?"+params.Encode(), &page); err != nil {
+			return err
+		}
+		jobs = append(jobs, page.Jobs...)
+		if page.NextCursor == "" {
+			break
+		}
+		params.Set("cursor", page.NextCursor)
+	}
+	if c.Limit > 0 && len(jobs) > c.Limit {
+		jobs = jobs[:c.Limit]
+	}
+
+	if c.JSON {
+		return printJSON(jobs)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "UUID\tQUEUE\tSTATUS\tWORKER\tATTEMPTS\tPIPELINE\tRESERVED")
+	for _, job := range jobs {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", job.Job.UUID, job.Job.QueueKey, job.Status,
+			orDash(job.ClaimedBy), job.Attempts, orDash(job.Job.PipelineSlug), ago(job.Job.ReservedAt))
+	}
+	return tw.Flush()
+}
+
+type JobsShowCmd struct {
+	serverAPI `embed:""`
+
+	UUID string `arg:"" help:"UUID of the job"`
+	JSON bool   `help:"Print the job as JSON" name:"json"`
+}
+
+func (c *JobsShowCmd) Run() error {
+	var detail types.JobDetail
+	if err := c.get(context.Background(), "/jobs/"+url.PathEscape(c.UUID), &detail); err != nil {
+		return fmt.Errorf("job %s: %w", c.UUID, err)
+	}
+	if c.JSON {
+		return printJSON(detail)
+	}
+
+	job := detail.Job
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	row := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(tw, "%s:\t%s\n", name, value)
+		}
+	}
+	row("UUID", job.UUID)
+	row("Status", detail.Status)
+	row("Queue", job.QueueKey)
+	row("Stack", job.StackKey)
+	row("Pipeline", job.PipelineSlug)
+	row("Build", job.BuildUUID)
+	row("Query rules", strings.Join(job.AgentQueryRules, ","))
+	row("Priority", strconv.Itoa(job.Priority))
+	row("Claimed by", detail.ClaimedBy)
+	row("Attempts", strconv.Itoa(detail.Attempts))
+	for _, t := range []struct {
+		name string
+		at   time.Time
+	}{
+		{"Scheduled", job.ScheduledAt},
+		{"Reserved", job.ReservedAt},
+		{"Not before", job.NotBefore},
+		{"Claimed", detail.ClaimedAt},
+		{"Started", detail.StartedAt},
+		{"Completed", detail.CompletedAt},
+		{"Lease expires", detail.LeaseExpiresAt},
+		{"Reservation expires", detail.ReservationExpiresAt},
+	} {
+		if !t.at.IsZero() {
+			row(t.name, t.at.Local().Format(time.RFC3339))
+		}
+	}
+	for i, failure := range detail.Failures {
+		row(fmt.Sprintf("Failure %d", i+1), fmt.Sprintf("%s on %s at %s", failure.Error, orDash(failure.WorkerID), failure.FailedAt.Local().Format(time.RFC3339)))
+	}
+	return tw.Flush()
+}
+
+type JobsRequeueCmd struct {
+	serverAPI `embed:""`
+
+	UUIDs []string `arg:"" name:"uuid" help:"UUIDs of the jobs to requeue"`
+}
+
+func (c *JobsRequeueCmd) Run() error {
+	failed := 0
+	for _, uuid := range c.UUIDs {
+		if err := c.call(context.Background(), http.MethodPost, "/jobs/"+url.PathEscape(uuid)+"/requeue", nil, nil); err != nil {
+			fmt.Fprintf(os.Stderr, "Couldn't requeue %s: %s\n", uuid, err)
+			failed++
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "Requeued %s\n", uuid)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d jobs weren't requeued", failed, len(c.UUIDs))
+	}
+	return nil
+}
+
+func printJSON(v any) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// ago formats how long before now t was, to the second.
+func ago(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return time.Since(t).Round(time.Second).String() + " ago"
+}
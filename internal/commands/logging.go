@@ -0,0 +1,38 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// LogFlags are the global logging settings.
+type LogFlags struct {
+	LogLevel  string `help:"Least severe level to log" enum:"trace,debug,info,warn,error" default:"info" env:"LOG_LEVEL"`
+	LogFormat string `help:"Log as human readable console output or as one JSON object per line" enum:"console,json" default:"console" env:"LOG_FORMAT"`
+}
+
+// Apply sets up the global logger.
+func (f LogFlags) Apply() error {
+	if err := SetLogLevel(f.LogLevel); err != nil {
+		return err
+	}
+	if f.LogFormat == "json" {
+		log.Logger = zerolog.New(os.Stderr).With().Timestamp().Logger()
+	} else {
+		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	}
+	return nil
+}
+
+// SetLogLevel changes the least severe level logged.
+func SetLogLevel(level string) error {
+	parsed, err := zerolog.ParseLevel(level)
+	if err != nil || level == "" {
+		return fmt.Errorf("invalid log level %q", level)
+	}
+	zerolog.SetGlobalLevel(parsed)
+	return nil
+}
@@ -0,0 +1,165 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/server"
+	"github.com/buildkite/buildkite-custom-scheduler/internal/storage"
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+	"github.com/buildkite/stacksapi"
+	"github.com/rs/zerolog/log"
+)
+
+type PurgeCmd struct {
+	RedisAddr        string            `help:"Redis address" default:"localhost:6379" env:"REDIS_ADDR"`
+	Queue            string            `help:"Only purge jobs from this queue"`
+	OlderThan        string            `help:"Only purge jobs reserved longer ago than this, e.g. 24h"`
+	AgentToken       string            `help:"Buildkite agent token, to release reservations before purging" env:"BUILDKITE_AGENT_TOKEN"`
+	StackKey         string            `help:"Stack key of jobs stored before jobs recorded their stack" default:"custom-scheduler-demo"`
+	StackAgentTokens map[string]string `help:"Agent tokens by stack key, for jobs reserved by stacks in other clusters" env:"STACK_AGENT_TOKENS"`
+	Release          bool              `help:"Release reservations before purging, so Buildkite can dispatch the jobs elsewhere straight away" default:"true" negatable:""`
+	WorkerTimeout    string            `help:"How recently a worker must have heartbeated to count as running" default:"30s" env:"WORKER_TIMEOUT"`
+	Force            bool              `help:"Purge even while workers are running"`
+	DryRun           bool              `help:"Report what would be purged without changing anything"`
+	Yes              bool              `help:"Don't ask for confirmation" short:"y"`
+}
+
+// heldStatuses are the statuses of jobs whose reservations the scheduler
+// holds, and so should release.
+var heldStatuses = []string{"reserved", "delayed", "claimed", "dead"}
+
+func (c *PurgeCmd) Run() error {
+	workerTimeout, err := time.ParseDuration(c.WorkerTimeout)
+	if err != nil {
+		return err
+	}
+	filter := storage.JobFilter{QueueKey: c.Queue}
+	if c.OlderThan != "" {
+		if filter.MinAge, err = time.ParseDuration(c.OlderThan); err != nil {
+			return fmt.Errorf("--older-than: %w", err)
+		}
+		if filter.MinAge <= 0 {
+			return fmt.Errorf("--older-than must be positive")
+		}
+	}
+	everything := filter == storage.JobFilter{}
+
+	ctx := context.Background()
+	store, err := storage.NewRedisStore(c.RedisAddr, storage.DispatchOrder{})
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	// Workers would go on claiming and heartbeating jobs as they're deleted.
+	workers, err := store.ActiveWorkerCount(ctx, time.Now().Add(-workerTimeout))
+	if err != nil {
+		return err
+	}
+	if workers > 0 && !c.Force {
+		return fmt.Errorf("%d workers are still running; stop them and the servers first, or use --force", workers)
+	}
+
+	jobs, err := listAllJobs(ctx, store, filter)
+	if err != nil {
+		return err
+	}
+	held := map[string][]string{}
+	heldCount := 0
+	for _, job := range jobs {
+		if slices.Contains(heldStatuses, job.Status) {
+			stackKey := job.Job.StackKey
+			if stackKey == "" {
+				stackKey = c.StackKey
+			}
+			held[stackKey] = append(held[stackKey], job.Job.UUID)
+			heldCount++
+		}
+	}
+
+	what := fmt.Sprintf("purge %d jobs, %d of them holding reservations", len(jobs), heldCount)
+	if everything {
+		what += ", and every other scheduler key in Redis: workers, settings, quotas, tokens and the audit log"
+	}
+	if c.DryRun {
+		log.Info().Int("jobs", len(jobs)).Int("reservations", heldCount).Bool("everything", everything).Msg("Dry run, would " + what)
+		return nil
+	}
+	if !c.Yes {
+		if err := confirm(what + " from " + c.RedisAddr); err != nil {
+			return err
+		}
+	}
+
+	// Release first, so if it fails nothing is lost and it can be retried.
+	if c.Release && heldCount > 0 {
+		if err := c.release(ctx, held); err != nil {
+			return fmt.Errorf("%w; nothing was purged (use --no-release to leave the reservations to expire)", err)
+		}
+		log.Info().Int("count", heldCount).Msg("Released reservations")
+	}
+
+	if everything {
+		deleted, err := store.PurgeAll(ctx)
+		if err != nil {
+			return err
+		}
+		log.Info().Int("keys", deleted).Msg("Purged all scheduler state")
+		return nil
+	}
+	for i, job := range jobs {
+		if err := store.ExpungeJob(ctx, job.Job.UUID); err != nil {
+			return fmt.Errorf("purged %d of %d jobs: %w", i, len(jobs), err)
+		}
+	}
+	log.Info().Int("jobs", len(jobs)).Str("queue", c.Queue).Str("older_than", c.OlderThan).Msg("Purged jobs")
+	return nil
+}
+
+// release hands back each stack's reservations, with the stack's own agent
+// token if it has one.
+func (c *PurgeCmd) release(ctx context.Context, held map[string][]string) error {
+	for _, stackKey := range slices.Sorted(maps.Keys(held)) {
+		token := c.AgentToken
+		if stackToken, ok := c.StackAgentTokens[stackKey]; ok {
+			token = stackToken
+		}
+		if token == "" {
+			return fmt.Errorf("stack %s: set BUILDKITE_AGENT_TOKEN to release its reservations", stackKey)
+		}
+		client, err := stacksapi.NewClient(token)
+		if err != nil {
+			return fmt.Errorf("stack %s: %w", stackKey, err)
+		}
+		if _, err := server.ReleaseReservations(ctx, client, stackKey, held[stackKey], 100); err != nil {
+			return fmt.Errorf("stack %s: %w", stackKey, err)
+		}
+	}
+	return nil
+}
+
+// listAllJobs returns every job matching filter, once each.
+func listAllJobs(ctx context.Context, store *storage.RedisStore, filter storage.JobFilter) ([]types.JobSummary, error) {
+	var jobs []types.JobSummary
+	seen := map[string]bool{}
+	var cursor uint64
+	for {
+		page, next, err := store.ListJobs(ctx, filter, cursor, 500)
+		if err != nil {
+			return nil, err
+		}
+		for _, job := range page {
+			if !seen[job.Job.UUID] {
+				seen[job.Job.UUID] = true
+				jobs = append(jobs, job)
+			}
+		}
+		if cursor = next; cursor == 0 {
+			return jobs, nil
+		}
+	}
+}
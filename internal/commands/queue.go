@@ -0,0 +1,156 @@
+package commands
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+)
+
+type QueueCmd struct {
+	Pause  QueuePauseCmd  `cmd:"" help:"Stop workers claiming queues' jobs"`
+	Resume QueueResumeCmd `cmd:"" help:"Let workers claim paused queues' jobs again"`
+}
+
+// queueSelection picks the queues a queue command acts on, and confirms
+// before acting on them.
+type queueSelection struct {
+	Queues []string `arg:"" optional:"" name:"queue" help:"Queue keys"`
+	All    bool     `help:"Act on every queue: those the server polls when pausing, or those paused when resuming"`
+	Yes    bool     `help:"Don't ask for confirmation" short:"y"`
+}
+
+func (q queueSelection) validate() error {
+	if q.All && len(q.Queues) > 0 {
+		return fmt.Errorf("give queue keys or --all, not both")
+	}
+	if !q.All && len(q.Queues) == 0 {
+		return fmt.Errorf("give the queue keys, or --all")
+	}
+	return nil
+}
+
+// confirm asks whether to go ahead with action on queues, unless --yes was
+// given.
+func (q queueSelection) confirm(action string, queues []string) error {
+	if q.Yes {
+		return nil
+	}
+	return confirm(fmt.Sprintf("%s %d queue(s): %s", action, len(queues), strings.Join(queues, ", ")))
+}
+
+// confirm asks on the terminal whether to go ahead with action, returning an
+// error unless the answer is yes. Without a terminal to ask on, it refuses.
+func confirm(action string) error {
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return fmt.Errorf("not a terminal, so can't confirm; use --yes to go ahead without asking")
+	}
+	fmt.Fprintf(os.Stderr, "About to %s. Continue? [y/N] ", action)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+		return fmt.Errorf("cancelled")
+	}
+	return nil
+}
+
+// queueStats is the part of GET /stats the queue commands use.
+type queueStats struct {
+	PausedQueues    map[string]bool `json:"paused_queues"`
+	MonitoredQueues []string        `json:"monitored_queues"`
+}
+
+// postEach POSTs to path for every queue, reporting how each went, and fails
+// if any did. action and done name what's being done, e.g. pause and Paused.
+func (s serverAPI) postEach(ctx context.Context, queues []string, action, done string, path func(queueKey string) string) error {
+	failed := 0
+	for _, queueKey := range queues {
+		if err := s.call(ctx, http.MethodPost, path(queueKey), nil, nil); err != nil {
+			fmt.Fprintf(os.Stderr, "Couldn't %s %s: %s\n", action, queueKey, err)
+			failed++
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "%s %s\n", done, queueKey)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d queues failed", failed, len(queues))
+	}
+	return nil
+}
+
+type QueuePauseCmd struct {
+	serverAPI      `embed:""`
+	queueSelection `embed:""`
+
+	StopReserving bool `help:"Also stop reserving the queues' jobs from Buildkite, leaving them for other stacks"`
+}
+
+func (c *QueuePauseCmd) Run() error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	queues := c.Queues
+	if c.All {
+		var stats queueStats
+		if err := c.get(ctx, "/stats", &stats); err != nil {
+			return err
+		}
+		queues = stats.MonitoredQueues
+		if len(queues) == 0 {
+			return fmt.Errorf("the server isn't polling any queues")
+		}
+	}
+	if err := c.confirm("pause", queues); err != nil {
+		return err
+	}
+
+	params := ""
+	if c.StopReserving {
+		params = "?stop_reserving=true"
+	}
+	return c.postEach(ctx, queues, "pause", "Paused", func(queueKey string) string {
+		return "/queues/" + url.PathEscape(queueKey) + "/pause" + params
+	})
+}
+
+type QueueResumeCmd struct {
+	serverAPI      `embed:""`
+	queueSelection `embed:""`
+}
+
+func (c *QueueResumeCmd) Run() error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	queues := c.Queues
+	if c.All {
+		var stats queueStats
+		if err := c.get(ctx, "/stats", &stats); err != nil {
+			return err
+		}
+		for queueKey := range stats.PausedQueues {
+			queues = append(queues, queueKey)
+		}
+		if len(queues) == 0 {
+			fmt.Fprintln(os.Stderr, "No queues are paused")
+			return nil
+		}
+		slices.Sort(queues)
+	}
+	if err := c.confirm("resume", queues); err != nil {
+		return err
+	}
+
+	return c.postEach(ctx, queues, "resume", "Resumed", func(queueKey string) string {
+		return "/queues/" + url.PathEscape(queueKey) + "/resume"
+	})
+}
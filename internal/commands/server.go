@@ -3,113 +3,888 @@ package commands
 import (
 	"context"
 	"fmt"
+	"maps"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/alecthomas/kong"
 	"github.com/buildkite/buildkite-custom-scheduler/internal/server"
 	"github.com/buildkite/buildkite-custom-scheduler/internal/storage"
+	"github.com/buildkite/buildkite-custom-scheduler/internal/systemd"
+	"github.com/buildkite/buildkite-custom-scheduler/internal/tracing"
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+	"github.com/buildkite/buildkite-custom-scheduler/internal/version"
 	"github.com/buildkite/stacksapi"
 	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 type ServerCmd struct {
-	AgentToken   string   `help:"Buildkite agent token" env:"BUILDKITE_AGENT_TOKEN" required:""`
-	StackKey     string   `help:"Unique stack key" default:"custom-scheduler-demo"`
-	Queues       []string `help:"Queue keys to monitor" default:"default" env:"SCHEDULER_QUEUES" sep:","`
-	RedisAddr    string   `help:"Redis address" default:"localhost:6379" env:"REDIS_ADDR"`
-	Listen       string   `help:"HTTP listen address" default:":18888" env:"LISTEN"`
-	PollInterval string   `help:"Poll interval" default:"1s" env:"POLL_INTERVAL"`
+	Config                 kong.ConfigFlag   `help:"YAML or JSON file of server settings, keyed by flag name; env vars and flags override it"`
+	AgentToken             string            `help:"Buildkite agent token" env:"BUILDKITE_AGENT_TOKEN" required:""`
+	StackKey               string            `help:"Unique stack key" default:"custom-scheduler-demo"`
+	ExtraStacks            map[string]string `help:"Further stacks to register, by key, with the queues each monitors, e.g. staging=default,deploy" env:"EXTRA_STACKS"`
+	StackAgentTokens       map[string]string `help:"Agent tokens for --extra-stacks by stack key, for stacks in other clusters" env:"STACK_AGENT_TOKENS"`
+	Queues                 []string          `help:"Queue keys to monitor" default:"default" env:"SCHEDULER_QUEUES" sep:","`
+	DiscoverQueues         []string          `help:"Also monitor the cluster's queues matching these patterns, e.g. linux-* (needs --buildkite-api-token)" env:"DISCOVER_QUEUES" sep:","`
+	QueueDiscoveryInterval string            `help:"How often to list the cluster's queues for --discover-queues" default:"1m" env:"QUEUE_DISCOVERY_INTERVAL"`
+	BuildkiteAPIToken      string            `help:"Buildkite API access token with read_clusters, for queue discovery" name:"buildkite-api-token" env:"BUILDKITE_API_TOKEN"`
+	BuildkiteOrg           string            `help:"Buildkite organization slug, for queue discovery" env:"BUILDKITE_ORG"`
+	BuildkiteClusterID     string            `help:"Buildkite cluster ID, for queue discovery" name:"buildkite-cluster-id" env:"BUILDKITE_CLUSTER_ID"`
+	RedisAddr              string            `help:"Redis address" default:"localhost:6379" env:"REDIS_ADDR"`
+	Listen                 string            `help:"HTTP listen address" default:":18888" env:"LISTEN"`
+	TLSCert                string            `help:"TLS certificate file, to serve HTTPS (reloaded when it changes)" name:"tls-cert" env:"TLS_CERT"`
+	TLSKey                 string            `help:"TLS private key file for --tls-cert" name:"tls-key" env:"TLS_KEY"`
+	TLSAutocertDomains     []string          `help:"Domains to get TLS certificates for from Let's Encrypt, instead of --tls-cert" name:"tls-autocert-domains" env:"TLS_AUTOCERT_DOMAINS" sep:","`
+	TLSAutocertEmail       string            `help:"Contact email for the Let's Encrypt account" name:"tls-autocert-email" env:"TLS_AUTOCERT_EMAIL"`
+	TLSAutocertCache       string            `help:"Directory to cache Let's Encrypt certificates and the account key in" name:"tls-autocert-cache" default:"autocert-cache" env:"TLS_AUTOCERT_CACHE"`
+	GRPCListen             string            `help:"gRPC listen address for the admin service (empty to not serve it)" name:"grpc-listen" env:"GRPC_LISTEN"`
+	APIToken               string            `help:"Shared secret workers must send as a bearer token (empty leaves the API open)" env:"API_TOKEN"`
+	APIRateLimit           string            `help:"Per-worker limit on claims and other API writes, e.g. 200/10s (empty for no limit)" default:"200/10s" env:"API_RATE_LIMIT"`
+	APIIPRateLimit         string            `help:"Per-client-IP limit on claims and other API writes, e.g. 500/10s (empty for no limit)" name:"api-ip-rate-limit" env:"API_IP_RATE_LIMIT"`
+	CORSAllowedOrigins     []string          `help:"Origins allowed to call the API from a browser, or * for any" name:"cors-allowed-origins" env:"CORS_ALLOWED_ORIGINS" sep:","`
+	CORSAllowedMethods     []string          `help:"HTTP methods allowed for cross-origin requests" name:"cors-allowed-methods" default:"GET,POST,PUT,DELETE" env:"CORS_ALLOWED_METHODS" sep:","`
+	PollInterval           string            `help:"Poll interval while jobs are flowing" default:"1s" env:"POLL_INTERVAL"`
+	MaxPollInterval        string            `help:"Longest poll interval to back off to while queues are empty" default:"10s" env:"MAX_POLL_INTERVAL"`
+	QueuePollInterval      map[string]string `help:"Per-queue fixed poll intervals, e.g. nightly=30s;deploy=250ms" env:"QUEUE_POLL_INTERVAL"`
+	WebhookToken           string            `help:"Token of a Buildkite webhook sending job.scheduled events to /webhooks/buildkite (empty disables webhooks)" env:"BUILDKITE_WEBHOOK_TOKEN"`
+	WebhookPollInterval    string            `help:"Poll interval with webhooks enabled, as a fallback for missed deliveries" default:"30s" env:"WEBHOOK_POLL_INTERVAL"`
+	CapacityFactor         float64           `help:"Jobs to hold reserved per active worker (0 disables backpressure)" default:"2" env:"CAPACITY_FACTOR"`
+	DispatchRateLimits     map[string]string `help:"Per-queue dispatch rate limits, e.g. deploy=5/1m" env:"DISPATCH_RATE_LIMITS"`
+	MaxReserved            int               `help:"Most jobs to hold reserved at once across all queues and servers (0 for no limit)" default:"0" env:"MAX_RESERVED"`
+	MaxPendingPerQueue     int               `help:"Stop reserving jobs for a queue once this many are pending (0 for no limit)" default:"0" env:"MAX_PENDING_PER_QUEUE"`
+	ObserveOnly            bool              `help:"List scheduled jobs and record metrics, but never reserve them, to trial the scheduler without affecting dispatch" env:"OBSERVE_ONLY"`
+	StickyBuilds           bool              `help:"Route all jobs from a build to the worker that claimed its first job" env:"STICKY_BUILDS"`
+	StickyBuildTTL         string            `help:"How long a worker keeps ownership of a build between claims" default:"2m" env:"STICKY_BUILD_TTL"`
+	DispatchOrder          string            `help:"Order to hand out pending jobs in" enum:"fifo,priority" default:"fifo" env:"DISPATCH_ORDER"`
+	PriorityAging          float64           `help:"Priority points a waiting job gains per minute in priority order" default:"0" env:"PRIORITY_AGING"`
+	PipelineQuotas         map[string]int    `help:"Max concurrently claimed jobs per pipeline slug, e.g. monorepo=10" env:"PIPELINE_QUOTAS"`
+	TeamQuotas             map[string]int    `help:"Max concurrently claimed jobs per team, e.g. payments=20" env:"TEAM_QUOTAS"`
+	TeamTag                string            `help:"Agent tag that identifies a job's team for team quotas" default:"team" env:"TEAM_TAG"`
+	ResourceScheduling     bool              `help:"Match jobs to workers by free resources declared in query rules" env:"RESOURCE_SCHEDULING"`
+	ResourceTags           []string          `help:"Agent tags treated as resource requirements" default:"cpu,mem" env:"RESOURCE_TAGS" sep:","`
+	ClaimLease             string            `help:"How long a claim lasts without a worker heartbeat before the job is requeued (0 to disable)" default:"60s" env:"CLAIM_LEASE"`
+	MaxJobAttempts         int               `help:"Move a job to the dead-letter queue after this many failed runs (0 retries forever)" default:"3" env:"MAX_JOB_ATTEMPTS"`
+	ReservationExpiry      string            `help:"How long Buildkite holds a reserved job for this stack (at least 1m)" default:"5m" env:"RESERVATION_EXPIRY"`
+	QueueReservationExpiry map[string]string `help:"Per-queue reservation expiry overrides, e.g. long-builds=30m" env:"QUEUE_RESERVATION_EXPIRY"`
+	PollPageSize           int               `help:"Scheduled jobs to list per Stacks API request when polling a queue" default:"50" env:"POLL_PAGE_SIZE"`
+	QueuePollPageSize      map[string]int    `help:"Per-queue --poll-page-size overrides, e.g. big-queue=200" env:"QUEUE_POLL_PAGE_SIZE"`
+	ReserveBatchSize       int               `help:"Jobs to reserve, renew or release per Stacks API request" default:"100" env:"RESERVE_BATCH_SIZE"`
+	PausedPollInterval     string            `help:"How often to poll a queue paused in Buildkite to see if it has resumed" name:"paused-queue-poll-interval" default:"1m" env:"PAUSED_QUEUE_POLL_INTERVAL"`
+	PauseDispatchWithQueue bool              `help:"Also stop workers claiming a queue's jobs while it's paused in Buildkite" env:"PAUSE_DISPATCH_WITH_QUEUE"`
+	QueueEnv               map[string]string `help:"Per-queue environment variables for jobs, e.g. deploy=AWS_REGION=us-east-1,LOG_LEVEL=debug" env:"QUEUE_ENV"`
+	EventStream            string            `help:"Redis stream to append every job event to, for billing, analytics and other consumers (empty for none)" env:"EVENT_STREAM"`
+	EventStreamMaxLen      int64             `help:"Trim the event stream to about this many entries (0 to never trim)" default:"1000000" env:"EVENT_STREAM_MAX_LEN"`
+	AlertWebhookURL        string            `help:"URL to post alerts to: a Slack incoming webhook, or any endpoint taking JSON (empty for no alerts)" name:"alert-webhook-url" env:"ALERT_WEBHOOK_URL"`
+	AlertFormat            string            `help:"Post alerts as Slack messages or as JSON alert objects" enum:"slack,json" default:"json" env:"ALERT_FORMAT"`
+	AlertEvents            []string          `help:"Events to alert on" enum:"queue_depth,dead_lettered,stack_lost,fleet_low" default:"queue_depth,dead_lettered,stack_lost,fleet_low" env:"ALERT_EVENTS" sep:","`
+	AlertQueueDepth        map[string]int    `help:"Alert when more jobs than this are waiting in a queue, e.g. deploy=50; * applies to every polled queue without its own" env:"ALERT_QUEUE_DEPTH"`
+	AlertMinWorkers        int               `help:"Alert while fewer workers than this are active (0 to never)" default:"0" env:"ALERT_MIN_WORKERS"`
+	AlertInterval          string            `help:"How often to check alert conditions" default:"1m" env:"ALERT_INTERVAL"`
+	AlertResend            string            `help:"How often to resend an alert that is still firing (0 to never)" default:"1h" env:"ALERT_RESEND"`
+	WorkerTimeout          string            `help:"How long a worker can go without a heartbeat before it is marked offline" default:"30s" env:"WORKER_TIMEOUT"`
+	OrphanSweepInterval    string            `help:"How often to requeue orphaned jobs (0 to disable)" default:"5m" env:"ORPHAN_SWEEP_INTERVAL"`
+	ReconcileInterval      string            `help:"How often to cross-check jobs in Redis against Buildkite" default:"10s" env:"RECONCILE_INTERVAL"`
+	StackHeartbeatInterval string            `help:"How often to re-register the stack so Buildkite knows the scheduler is alive (0 to disable)" default:"1m" env:"STACK_HEARTBEAT_INTERVAL"`
+	ShutdownTimeout        string            `help:"How long to wait on shutdown for requests and background work to finish, and reservations to be released" default:"10s" env:"SHUTDOWN_TIMEOUT"`
+	ReleaseOnShutdown      bool              `help:"Release reservations for unclaimed jobs on shutdown" default:"true" negatable:"" env:"RELEASE_ON_SHUTDOWN"`
+	BreakerThreshold       int               `help:"Stop calling the Stacks API for a stack after this many failures in a row (0 disables)" name:"circuit-breaker-threshold" default:"5" env:"CIRCUIT_BREAKER_THRESHOLD"`
+	BreakerCooldown        string            `help:"How long the circuit breaker stays open before probing the Stacks API again" name:"circuit-breaker-cooldown" default:"30s" env:"CIRCUIT_BREAKER_COOLDOWN"`
+	LeaderElection         bool              `help:"Elect one of several servers sharing Redis to poll and reserve jobs" env:"LEADER_ELECTION"`
+	LeaderTTL              string            `help:"How long the leader's lease lasts without renewal; failover takes at most this long" name:"leader-ttl" default:"10s" env:"LEADER_TTL"`
+	Sharding               bool              `help:"Share queues out among servers sharing Redis, each polling and reserving its share" env:"SHARDING"`
+	ShardTTL               string            `help:"How long a server keeps its share of queues without a heartbeat" name:"shard-ttl" default:"10s" env:"SHARD_TTL"`
+	ServerID               string            `help:"This server's name in leader election or sharding (defaults to hostname and PID)" env:"SERVER_ID"`
 }
 
-func (s *ServerCmd) Run() error {
-	if len(s.Queues) == 0 {
-		return fmt.Errorf("at least one queue is required")
+// Reloader parses the server's settings again, from its config file, the
+// environment and the command line, along with the global log flags.
+type Reloader func() (*ServerCmd, LogFlags, error)
+
+func (s *ServerCmd) Run(reload Reloader) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return s.run(ctx, reload)
+}
+
+// run serves until ctx is done, then shuts down gracefully. If reload is set,
+// SIGHUP reloads the settings that can change without a restart.
+func (s *ServerCmd) run(parent context.Context, reload Reloader) error {
+	stackConfigs, err := s.stackConfigs()
+	if err != nil {
+		return err
+	}
+	if (s.TLSCert == "") != (s.TLSKey == "") {
+		return fmt.Errorf("--tls-cert and --tls-key must be set together")
+	}
+	if s.TLSCert != "" && len(s.TLSAutocertDomains) > 0 {
+		return fmt.Errorf("--tls-cert and --tls-autocert-domains can't be used together")
+	}
+	if s.LeaderElection && s.Sharding {
+		return fmt.Errorf("--leader-election and --sharding can't be used together")
+	}
+
+	shutdownTimeout, err := time.ParseDuration(s.ShutdownTimeout)
+	if err != nil {
+		return fmt.Errorf("--shutdown-timeout: %w", err)
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(parent)
 	defer cancel()
+	// The monitors and other loops are waited for on shutdown, so none is
+	// still reserving jobs as reservations are released, or using Redis
+	// once it's closed.
+	var background goroutines
 
-	log.Info().Msg("Starting server...")
-	log.Info().Str("stack_key", s.StackKey).Msg("Stack key")
-	log.Info().Strs("queues", s.Queues).Msg("Queues")
+	log.Info().Str("version", version.String()).Msg("Starting server...")
+	for _, cfg := range stackConfigs {
+		log.Info().Str("stack_key", cfg.key).Strs("queues", cfg.queues).Msg("Stack")
+	}
 	log.Info().Str("redis", s.RedisAddr).Msg("Redis")
 	log.Info().Str("listen", s.Listen).Msg("Listen")
+	log.Info().Float64("capacity_factor", s.CapacityFactor).Msg("Capacity factor")
+	log.Info().Str("order", s.DispatchOrder).Float64("aging", s.PriorityAging).Msg("Dispatch order")
+	if s.ObserveOnly {
+		log.Warn().Msg("Observe-only mode: jobs are listed but never reserved, so Buildkite dispatches them as usual")
+	}
 
-	store, err := storage.NewRedisStore(s.RedisAddr)
+	store, err := storage.NewRedisStore(s.RedisAddr, storage.DispatchOrder{
+		Priority:  s.DispatchOrder == "priority",
+		AgingRate: s.PriorityAging,
+	})
 	if err != nil {
 		return err
 	}
 	defer store.Close()
+	// However run returns, background loops stop before Redis is closed.
+	defer func() {
+		cancel()
+		waitCtx, waitCancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer waitCancel()
+		background.Wait(waitCtx)
+	}()
 	log.Info().Str("redis", s.RedisAddr).Msg("Connected to Redis")
 
-	client, err := stacksapi.NewClient(s.AgentToken)
+	if err := s.storeSettings(ctx, store); err != nil {
+		return err
+	}
+	if migrated, err := store.MigratePendingSets(ctx); err != nil {
+		return err
+	} else if migrated > 0 {
+		log.Info().Int("keys", migrated).Msg("Migrated pending jobs from legacy jobs: keys")
+	}
+	// Left open, the server wouldn't check minted tokens, so they'd only look
+	// like they restricted access.
+	if s.APIToken == "" {
+		tokens, err := store.ListAPITokens(ctx)
+		if err != nil {
+			return err
+		}
+		if len(tokens) > 0 {
+			return fmt.Errorf("%d API tokens are minted but API_TOKEN is unset, so they wouldn't be checked; set it, or revoke them with scheduler tokens revoke", len(tokens))
+		}
+	}
+
+	stackHeartbeatInterval, err := time.ParseDuration(s.StackHeartbeatInterval)
+	if err != nil {
+		return err
+	}
+	reconcileInterval, err := time.ParseDuration(s.ReconcileInterval)
 	if err != nil {
 		return err
 	}
 
-	stack, _, err := client.RegisterStack(ctx, stacksapi.RegisterStackRequest{
-		Key:      s.StackKey,
-		Type:     stacksapi.StackTypeCustom,
-		QueueKey: s.Queues[0],
-		Metadata: map[string]string{
-			"version": "1.0.0",
-			"type":    "custom-scheduler-demo",
-		},
-	})
+	intervals, err := s.pollIntervals()
 	if err != nil {
 		return err
 	}
-	log.Info().Str("key", stack.Key).Str("queue", stack.ClusterQueueKey).Msg("Registered stack")
+	if s.WebhookToken != "" {
+		log.Info().Dur("interval", intervals.poll).Msg("Buildkite webhooks enabled, polling as a fallback")
+	}
 
-	defer func() {
-		log.Info().Str("stack_key", s.StackKey).Msg("Deregistering stack")
-		if _, err := client.DeregisterStack(context.Background(), s.StackKey); err != nil {
-			log.Error().Err(err).Msg("Failed to deregister stack")
+	workerTimeout, err := time.ParseDuration(s.WorkerTimeout)
+	if err != nil {
+		return err
+	}
+
+	reservationExpiry, err := parseReservationExpiry(s.ReservationExpiry)
+	if err != nil {
+		return err
+	}
+	queueReservationExpiry := make(map[string]time.Duration, len(s.QueueReservationExpiry))
+	for queueKey, value := range s.QueueReservationExpiry {
+		expiry, err := parseReservationExpiry(value)
+		if err != nil {
+			return fmt.Errorf("queue %s: %w", queueKey, err)
 		}
-	}()
+		queueReservationExpiry[queueKey] = expiry
+		log.Info().Str("queue", queueKey).Dur("expiry", expiry).Msg("Reservation expiry override")
+	}
+
+	if s.PollPageSize <= 0 {
+		return fmt.Errorf("--poll-page-size must be positive")
+	}
+	for queueKey, size := range s.QueuePollPageSize {
+		if size <= 0 {
+			return fmt.Errorf("queue %s: page size must be positive", queueKey)
+		}
+		log.Info().Str("queue", queueKey).Int("page_size", size).Msg("Poll page size override")
+	}
+	if s.ReserveBatchSize <= 0 {
+		return fmt.Errorf("--reserve-batch-size must be positive")
+	}
 
-	pollInterval, err := time.ParseDuration(s.PollInterval)
+	pausedPollInterval, err := time.ParseDuration(s.PausedPollInterval)
+	if err != nil {
+		return fmt.Errorf("--paused-queue-poll-interval: %w", err)
+	}
+
+	breakerCooldown, err := time.ParseDuration(s.BreakerCooldown)
+	if err != nil {
+		return fmt.Errorf("--circuit-breaker-cooldown: %w", err)
+	}
+
+	var resourceTags []string
+	if s.ResourceScheduling {
+		resourceTags = s.ResourceTags
+		log.Info().Strs("resource_tags", resourceTags).Msg("Resource-aware scheduling enabled")
+	}
+
+	// Without leader election there's a single server, which always leads.
+	var elector *server.LeaderElector
+	if s.LeaderElection {
+		leaderTTL, err := time.ParseDuration(s.LeaderTTL)
+		if err != nil {
+			return fmt.Errorf("--leader-ttl: %w", err)
+		}
+		elector = server.NewLeaderElector(store, s.serverID(), leaderTTL)
+		background.Go(func() {
+			if err := elector.Start(ctx); err != nil && err != context.Canceled {
+				log.Error().Err(err).Msg("Leader election error")
+			}
+		})
+	}
+	// leading is whether this server led when it was asked to shut down, and
+	// so should release reservations and deregister its stacks.
+	leading := true
+
+	// With sharding, every server polls its share of the queues.
+	var shards *server.ShardRing
+	if s.Sharding {
+		shardTTL, err := time.ParseDuration(s.ShardTTL)
+		if err != nil {
+			return fmt.Errorf("--shard-ttl: %w", err)
+		}
+		shards = server.NewShardRing(store, s.serverID(), shardTTL)
+		background.Go(func() {
+			if err := shards.Start(ctx); err != nil && err != context.Canceled {
+				log.Error().Err(err).Msg("Queue sharding error")
+			}
+		})
+		server.RegisterShardMetrics(shards)
+	}
+
+	var stacks server.Stacks
+	for i, cfg := range stackConfigs {
+		var breaker *server.CircuitBreaker
+		if s.BreakerThreshold > 0 {
+			breaker = server.NewCircuitBreaker(cfg.key, s.BreakerThreshold, breakerCooldown)
+		}
+		client, err := stacksapi.NewClient(cfg.agentToken, stacksapi.WithHTTPClient(server.StacksAPIHTTPClient(breaker)))
+		if err != nil {
+			return fmt.Errorf("stack %s: %w", cfg.key, err)
+		}
+
+		registerReq := stacksapi.RegisterStackRequest{
+			Key:      cfg.key,
+			Type:     stacksapi.StackTypeCustom,
+			QueueKey: cfg.queues[0],
+			Metadata: map[string]string{
+				"version": version.String(),
+				"type":    "custom-scheduler-demo",
+			},
+		}
+		stack, _, err := client.RegisterStack(ctx, registerReq)
+		if err != nil {
+			return fmt.Errorf("registering stack %s: %w", cfg.key, err)
+		}
+		log.Info().Str("key", stack.Key).Str("queue", stack.ClusterQueueKey).Msg("Registered stack")
+
+		defer func() {
+			// Shards share the stack, so the others still need it.
+			if !leading || shards != nil {
+				return
+			}
+			log.Info().Str("stack_key", cfg.key).Msg("Deregistering stack")
+			if _, err := client.DeregisterStack(context.Background(), cfg.key); err != nil {
+				log.Error().Err(err).Str("stack_key", cfg.key).Msg("Failed to deregister stack")
+			}
+		}()
+
+		var liveness *server.Liveness
+		if stackHeartbeatInterval > 0 {
+			liveness = server.NewLiveness(client, registerReq, stackHeartbeatInterval)
+			background.Go(func() {
+				if err := liveness.Start(ctx); err != nil && err != context.Canceled {
+					log.Error().Err(err).Str("stack_key", cfg.key).Msg("Stack heartbeat error")
+				}
+			})
+		}
+
+		monitor := server.NewMonitor(client, store, server.MonitorConfig{
+			StackKey:               cfg.key,
+			Queues:                 cfg.queues,
+			Primary:                i == 0,
+			Leader:                 elector,
+			Shards:                 shards,
+			PollInterval:           intervals.poll,
+			MaxPollInterval:        intervals.max,
+			QueuePollInterval:      intervals.perQueue,
+			CapacityFactor:         s.CapacityFactor,
+			WorkerTimeout:          workerTimeout,
+			ReservationExpiry:      reservationExpiry,
+			QueueReservationExpiry: queueReservationExpiry,
+			PageSize:               s.PollPageSize,
+			QueuePageSize:          s.QueuePollPageSize,
+			ReserveBatchSize:       s.ReserveBatchSize,
+			PausedPollInterval:     pausedPollInterval,
+			PauseDispatch:          s.PauseDispatchWithQueue,
+			Breaker:                breaker,
+			ObserveOnly:            s.ObserveOnly,
+			MaxReserved:            s.MaxReserved,
+			MaxPendingPerQueue:     s.MaxPendingPerQueue,
+			ResourceTags:           resourceTags,
+		})
+		background.Go(func() {
+			if err := monitor.Start(ctx); err != nil && err != context.Canceled {
+				log.Error().Err(err).Str("stack_key", cfg.key).Msg("Monitor error")
+			}
+		})
+
+		reconciler := server.NewReconciler(client, store, cfg.key, i == 0, elector, shards, reconcileInterval)
+		background.Go(func() {
+			if err := reconciler.Start(ctx); err != nil && err != context.Canceled {
+				log.Error().Err(err).Str("stack_key", cfg.key).Msg("Reconciler error")
+			}
+		})
+
+		stacks = append(stacks, server.Stack{Monitor: monitor, Liveness: liveness})
+	}
+
+	if len(s.DiscoverQueues) > 0 {
+		discoveryInterval, err := time.ParseDuration(s.QueueDiscoveryInterval)
+		if err != nil {
+			return fmt.Errorf("--queue-discovery-interval: %w", err)
+		}
+		discovery, err := server.NewQueueDiscovery(server.QueueDiscoveryConfig{
+			APIToken:  s.BuildkiteAPIToken,
+			Org:       s.BuildkiteOrg,
+			ClusterID: s.BuildkiteClusterID,
+			Patterns:  s.DiscoverQueues,
+			Interval:  discoveryInterval,
+		}, stacks[0].Monitor)
+		if err != nil {
+			return err
+		}
+		background.Go(func() {
+			if err := discovery.Start(ctx); err != nil && err != context.Canceled {
+				log.Error().Err(err).Msg("Queue discovery error")
+			}
+		})
+	}
+
+	orphanSweepInterval, err := time.ParseDuration(s.OrphanSweepInterval)
 	if err != nil {
 		return err
 	}
+	if orphanSweepInterval > 0 {
+		sweeper := server.NewSweeper(store, workerTimeout)
+		background.Go(func() {
+			if err := sweeper.Start(ctx, orphanSweepInterval); err != nil && err != context.Canceled {
+				log.Error().Err(err).Msg("Orphan sweeper error")
+			}
+		})
+	}
 
-	monitor := server.NewMonitor(client, s.StackKey, s.Queues, store, pollInterval)
-	go func() {
-		if err := monitor.Start(ctx); err != nil && err != context.Canceled {
-			log.Error().Err(err).Msg("Monitor error")
+	var stickyBuildTTL time.Duration
+	if s.StickyBuilds {
+		stickyBuildTTL, err = time.ParseDuration(s.StickyBuildTTL)
+		if err != nil {
+			return err
 		}
-	}()
+		log.Info().Dur("ttl", stickyBuildTTL).Msg("Sticky build routing enabled")
+	}
+
+	claimLease, err := time.ParseDuration(s.ClaimLease)
+	if err != nil {
+		return err
+	}
+	reapInterval := min(workerTimeout/4, 5*time.Second)
+	if claimLease > 0 {
+		reapInterval = min(reapInterval, claimLease/4)
+	}
+	reaper := server.NewReaper(store, reapInterval, workerTimeout)
+	background.Go(func() {
+		if err := reaper.Start(ctx); err != nil && err != context.Canceled {
+			log.Error().Err(err).Msg("Reaper error")
+		}
+	})
+
+	server.RegisterStoreMetrics(store)
+	server.RegisterLeaderMetrics(elector)
+	server.RegisterMonitorMetrics(stacks)
+
+	notifier := server.NewJobNotifier(store)
+	background.Go(func() {
+		if err := notifier.Start(ctx); err != nil && err != context.Canceled {
+			log.Error().Err(err).Msg("Job notifier error")
+		}
+	})
+
+	if s.AlertWebhookURL != "" {
+		alertConfig, err := s.alertConfig(workerTimeout)
+		if err != nil {
+			return err
+		}
+		alerter := server.NewAlerter(store, stacks, alertConfig)
+		background.Go(func() {
+			if err := alerter.Start(ctx); err != nil && err != context.Canceled {
+				log.Error().Err(err).Msg("Alerter error")
+			}
+		})
+	}
 
-	api := server.NewAPI(store, &log.Logger)
+	api := server.NewAPI(store, stacks, notifier, &log.Logger, storage.ClaimOptions{
+		StickyBuildTTL: stickyBuildTTL,
+		TeamTag:        s.TeamTag,
+		Lease:          claimLease,
+	}, s.MaxJobAttempts)
+	handler := api.Handler()
+	perWorker, err := newRateLimiter(s.APIRateLimit)
+	if err != nil {
+		return fmt.Errorf("--api-rate-limit: %w", err)
+	}
+	perIP, err := newRateLimiter(s.APIIPRateLimit)
+	if err != nil {
+		return fmt.Errorf("--api-ip-rate-limit: %w", err)
+	}
+	if perWorker != nil || perIP != nil {
+		handler = server.RateLimit(perWorker, perIP, &log.Logger, handler)
+	}
+	if s.APIToken != "" {
+		handler = server.RequireToken(s.APIToken, store, &log.Logger, handler)
+	} else {
+		log.Warn().Msg("API_TOKEN not set, the API is open to anyone who can reach it")
+		handler = server.RefuseMinting(handler)
+	}
+	if s.WebhookToken != "" {
+		handler = server.BuildkiteWebhook(s.WebhookToken, stacks, &log.Logger, handler)
+	}
+	if len(s.CORSAllowedOrigins) > 0 {
+		handler = server.CORS(s.CORSAllowedOrigins, s.CORSAllowedMethods, handler)
+		log.Info().Strs("origins", s.CORSAllowedOrigins).Strs("methods", s.CORSAllowedMethods).Msg("CORS enabled")
+	}
+	handler = tracing.Handler(handler)
 	httpServer := &http.Server{
 		Addr:    s.Listen,
-		Handler: api.Handler(),
+		Handler: handler,
+	}
+	var grpcOpts []grpc.ServerOption
+	if s.TLSCert != "" {
+		certs, err := server.NewCertLoader(s.TLSCert, s.TLSKey)
+		if err != nil {
+			return err
+		}
+		httpServer.TLSConfig = certs.TLSConfig()
+		grpcOpts = append(grpcOpts, grpc.Creds(credentials.NewTLS(certs.TLSConfig())))
+	}
+	if len(s.TLSAutocertDomains) > 0 {
+		httpServer.TLSConfig = server.AutocertConfig(s.TLSAutocertDomains, s.TLSAutocertEmail, s.TLSAutocertCache)
+		grpcOpts = append(grpcOpts, grpc.Creds(credentials.NewTLS(httpServer.TLSConfig.Clone())))
+	}
+
+	var grpcServer *grpc.Server
+	if s.GRPCListen != "" {
+		listener, err := net.Listen("tcp", s.GRPCListen)
+		if err != nil {
+			return fmt.Errorf("--grpc-listen: %w", err)
+		}
+		grpcServer = server.NewGRPCServer(api, s.APIToken, grpcOpts...)
+		go func() {
+			log.Info().Str("listen", s.GRPCListen).Bool("tls", httpServer.TLSConfig != nil).Msg("Starting gRPC server")
+			if err := grpcServer.Serve(listener); err != nil {
+				log.Error().Err(err).Msg("gRPC server error")
+			}
+		}()
 	}
 
 	go func() {
-		log.Info().Str("listen", s.Listen).Msg("Starting HTTP server")
-		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Info().Str("listen", s.Listen).Bool("tls", httpServer.TLSConfig != nil).Msg("Starting HTTP server")
+		var err error
+		if httpServer.TLSConfig != nil {
+			err = httpServer.ListenAndServeTLS("", "")
+		} else {
+			err = httpServer.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Error().Err(err).Msg("HTTP server error")
 		}
 	}()
 
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-	<-sigChan
+	// With a watchdog, systemd restarts the server if a monitor loop hangs.
+	systemd.Ready()
+	go systemd.Watchdog(ctx, stacks.Alive)
+
+	hup := make(chan os.Signal, 1)
+	if reload != nil {
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+	}
+	for ctx.Err() == nil {
+		select {
+		case <-ctx.Done():
+		case <-hup:
+			log.Info().Msg("Reloading settings")
+			systemd.Reloading()
+			if err := s.reload(ctx, reload, store, stacks); err != nil {
+				log.Error().Err(err).Msg("Failed to reload settings, keeping the current ones")
+			}
+			systemd.Ready()
+		}
+	}
 
 	log.Info().Msg("Shutting down gracefully...")
+	systemd.Stopping()
+	leading = elector.IsLeader()
 	cancel()
 
-	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), shutdownTimeout)
 	defer shutdownCancel()
 
 	if err := httpServer.Shutdown(shutdownCtx); err != nil {
 		log.Error().Err(err).Msg("HTTP server shutdown error")
 	}
+	if grpcServer != nil {
+		stopGRPC(shutdownCtx, grpcServer)
+	}
+	if !background.Wait(shutdownCtx) {
+		log.Warn().Dur("timeout", shutdownTimeout).Msg("Timed out waiting for background work to stop")
+	}
+
+	if s.ReleaseOnShutdown && leading {
+		log.Info().Msg("Releasing unclaimed reservations")
+		if err := stacks.ReleaseAll(shutdownCtx); err != nil {
+			log.Error().Err(err).Msg("Failed to release reservations")
+		}
+	}
 
 	log.Info().Msg("Shutdown complete")
 	return nil
 }
+
+// reload parses the settings again and applies those that can change while
+// the server runs: the stacks' queues, poll intervals, reservation limits,
+// dispatch rate limits, quotas, queue env and the log level. Nothing is
+// applied if the new settings are invalid. The rest only change on restart.
+func (s *ServerCmd) reload(ctx context.Context, reload Reloader, store *storage.RedisStore, stacks server.Stacks) error {
+	fresh, logFlags, err := reload()
+	if err != nil {
+		return err
+	}
+	stackConfigs, err := fresh.stackConfigs()
+	if err != nil {
+		return err
+	}
+	if len(stackConfigs) != len(stacks) {
+		return fmt.Errorf("stacks can't be added or removed without a restart")
+	}
+	for i, cfg := range stackConfigs {
+		if cfg.key != stacks[i].Key() {
+			return fmt.Errorf("stacks can't be added or removed without a restart")
+		}
+	}
+	intervals, err := fresh.pollIntervals()
+	if err != nil {
+		return err
+	}
+
+	if err := SetLogLevel(logFlags.LogLevel); err != nil {
+		return err
+	}
+	if err := fresh.storeSettings(ctx, store); err != nil {
+		return err
+	}
+	for i, cfg := range stackConfigs {
+		stacks[i].Monitor.Reconfigure(server.MonitorConfig{
+			Queues:             cfg.queues,
+			PollInterval:       intervals.poll,
+			MaxPollInterval:    intervals.max,
+			QueuePollInterval:  intervals.perQueue,
+			CapacityFactor:     fresh.CapacityFactor,
+			MaxReserved:        fresh.MaxReserved,
+			MaxPendingPerQueue: fresh.MaxPendingPerQueue,
+		})
+	}
+	log.Info().Str("log_level", logFlags.LogLevel).Float64("capacity_factor", fresh.CapacityFactor).Msg("Reloaded settings")
+	return nil
+}
+
+// storeSettings saves the settings kept in Redis, which every server sharing
+// it uses. Entries removed from the map flags are left in place, but the event
+// stream always follows its flag.
+func (s *ServerCmd) storeSettings(ctx context.Context, store *storage.RedisStore) error {
+	for queueKey, value := range s.DispatchRateLimits {
+		limit, err := types.ParseRateLimit(value)
+		if err != nil {
+			return fmt.Errorf("queue %s: %w", queueKey, err)
+		}
+		if err := store.SetDispatchRateLimit(ctx, queueKey, limit); err != nil {
+			return err
+		}
+		log.Info().Str("queue", queueKey).Str("limit", limit.String()).Msg("Dispatch rate limit")
+	}
+
+	for slug, limit := range s.PipelineQuotas {
+		if err := store.SetPipelineQuota(ctx, slug, limit); err != nil {
+			return err
+		}
+		log.Info().Str("pipeline", slug).Int("limit", limit).Msg("Pipeline quota")
+	}
+	for team, limit := range s.TeamQuotas {
+		if err := store.SetTeamQuota(ctx, team, limit); err != nil {
+			return err
+		}
+		log.Info().Str("team", team).Int("limit", limit).Msg("Team quota")
+	}
+
+	for queueKey, value := range s.QueueEnv {
+		env, err := types.ParseEnv(value)
+		if err != nil {
+			return fmt.Errorf("queue %s: %w", queueKey, err)
+		}
+		if err := store.SetQueueEnv(ctx, queueKey, env); err != nil {
+			return err
+		}
+		log.Info().Str("queue", queueKey).Int("vars", len(env)).Msg("Queue env")
+	}
+
+	if s.EventStreamMaxLen < 0 {
+		return fmt.Errorf("--event-stream-max-len can't be negative")
+	}
+	if err := store.SetEventStream(ctx, s.EventStream, s.EventStreamMaxLen); err != nil {
+		return err
+	}
+	if s.EventStream != "" {
+		log.Info().Str("stream", s.EventStream).Int64("max_len", s.EventStreamMaxLen).Msg("Appending job events to stream")
+	}
+	return nil
+}
+
+type pollIntervals struct {
+	poll     time.Duration
+	max      time.Duration
+	perQueue map[string]time.Duration
+}
+
+// pollIntervals parses the poll interval flags. With webhooks, the shortest
+// interval is the fallback --webhook-poll-interval.
+func (s *ServerCmd) pollIntervals() (pollIntervals, error) {
+	var intervals pollIntervals
+	var err error
+	if intervals.poll, err = time.ParseDuration(s.PollInterval); err != nil {
+		return intervals, err
+	}
+	if intervals.max, err = time.ParseDuration(s.MaxPollInterval); err != nil {
+		return intervals, fmt.Errorf("--max-poll-interval: %w", err)
+	}
+	if s.WebhookToken != "" {
+		if intervals.poll, err = time.ParseDuration(s.WebhookPollInterval); err != nil {
+			return intervals, fmt.Errorf("--webhook-poll-interval: %w", err)
+		}
+	}
+
+	intervals.perQueue = make(map[string]time.Duration, len(s.QueuePollInterval))
+	for queueKey, value := range s.QueuePollInterval {
+		interval, err := time.ParseDuration(value)
+		if err != nil {
+			return intervals, fmt.Errorf("queue %s: %w", queueKey, err)
+		}
+		if interval <= 0 {
+			return intervals, fmt.Errorf("queue %s: poll interval must be positive", queueKey)
+		}
+		intervals.perQueue[queueKey] = interval
+		log.Info().Str("queue", queueKey).Dur("interval", interval).Msg("Poll interval override")
+	}
+	return intervals, nil
+}
+
+type stackConfig struct {
+	key        string
+	agentToken string
+	queues     []string
+}
+
+// stackConfigs returns the stacks to register: the --stack-key stack, then
+// any --extra-stacks in key order. Each queue may only be monitored by one
+// stack, so jobs can be traced back to the stack that reserved them.
+func (s *ServerCmd) stackConfigs() ([]stackConfig, error) {
+	if len(s.Queues) == 0 {
+		return nil, fmt.Errorf("at least one queue is required")
+	}
+	configs := []stackConfig{{key: s.StackKey, agentToken: s.AgentToken, queues: s.Queues}}
+	for _, key := range slices.Sorted(maps.Keys(s.ExtraStacks)) {
+		if key == s.StackKey {
+			return nil, fmt.Errorf("extra stack %s: already registered by --stack-key", key)
+		}
+		var queues []string
+		for _, queueKey := range strings.Split(s.ExtraStacks[key], ",") {
+			if queueKey = strings.TrimSpace(queueKey); queueKey != "" {
+				queues = append(queues, queueKey)
+			}
+		}
+		if len(queues) == 0 {
+			return nil, fmt.Errorf("extra stack %s: at least one queue is required", key)
+		}
+		agentToken := s.AgentToken
+		if token, ok := s.StackAgentTokens[key]; ok {
+			agentToken = token
+		}
+		configs = append(configs, stackConfig{key: key, agentToken: agentToken, queues: queues})
+	}
+	for key := range s.StackAgentTokens {
+		if _, ok := s.ExtraStacks[key]; !ok {
+			return nil, fmt.Errorf("agent token for stack %s, which isn't in --extra-stacks", key)
+		}
+	}
+
+	owners := map[string]string{}
+	for _, cfg := range configs {
+		for _, queueKey := range cfg.queues {
+			if owner, ok := owners[queueKey]; ok {
+				return nil, fmt.Errorf("queue %s is monitored by both stack %s and stack %s", queueKey, owner, cfg.key)
+			}
+			owners[queueKey] = cfg.key
+		}
+	}
+	return configs, nil
+}
+
+// goroutines tracks a group of goroutines, like a sync.WaitGroup, with a
+// bounded wait.
+type goroutines struct {
+	wg sync.WaitGroup
+}
+
+func (g *goroutines) Go(f func()) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		f()
+	}()
+}
+
+// Wait waits for the goroutines to return or ctx to be done, reporting
+// whether they all returned.
+func (g *goroutines) Wait(ctx context.Context) bool {
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// stopGRPC lets in-flight calls finish, cutting them off if ctx is done
+// first.
+func stopGRPC(ctx context.Context, grpcServer *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		log.Error().Msg("gRPC server shutdown timed out")
+		grpcServer.Stop()
+	}
+}
+
+// alertConfig parses the alert flags.
+func (s *ServerCmd) alertConfig(workerTimeout time.Duration) (server.AlertConfig, error) {
+	interval, err := time.ParseDuration(s.AlertInterval)
+	if err != nil {
+		return server.AlertConfig{}, fmt.Errorf("--alert-interval: %w", err)
+	}
+	if interval <= 0 {
+		return server.AlertConfig{}, fmt.Errorf("--alert-interval must be positive")
+	}
+	resend, err := time.ParseDuration(s.AlertResend)
+	if err != nil {
+		return server.AlertConfig{}, fmt.Errorf("--alert-resend: %w", err)
+	}
+	for queueKey, threshold := range s.AlertQueueDepth {
+		if threshold < 0 {
+			return server.AlertConfig{}, fmt.Errorf("--alert-queue-depth: queue %s: threshold can't be negative", queueKey)
+		}
+	}
+	return server.AlertConfig{
+		URL:           s.AlertWebhookURL,
+		Slack:         s.AlertFormat == "slack",
+		Events:        s.AlertEvents,
+		QueueDepth:    s.AlertQueueDepth,
+		MinWorkers:    s.AlertMinWorkers,
+		WorkerTimeout: workerTimeout,
+		Interval:      interval,
+		Resend:        resend,
+		ServerID:      s.serverID(),
+	}, nil
+}
+
+// serverID names this server among others sharing Redis.
+func (s *ServerCmd) serverID() string {
+	if s.ServerID != "" {
+		return s.ServerID
+	}
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
+
+// parseReservationExpiry parses a reservation length. Reservations are renewed
+// every 30 seconds, so anything much shorter than a minute would lapse between
+// renewals.
+func parseReservationExpiry(value string) (time.Duration, error) {
+	expiry, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if expiry < time.Minute {
+		return 0, fmt.Errorf("reservation expiry %s is shorter than the 1m minimum", expiry)
+	}
+	return expiry, nil
+}
+
+// newRateLimiter parses a rate limit flag, returning nil if it is empty.
+func newRateLimiter(value string) (*server.RateLimiter, error) {
+	if value == "" {
+		return nil, nil
+	}
+	limit, err := types.ParseRateLimit(value)
+	if err != nil {
+		return nil, err
+	}
+	return server.NewRateLimiter(limit), nil
+}
@@ -0,0 +1,117 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+)
+
+type StatsCmd struct {
+	serverAPI `embed:""`
+
+	JSON     bool   `help:"Print the stats as JSON instead of a table" name:"json"`
+	Watch    bool   `help:"Keep refreshing the stats until interrupted"`
+	Interval string `help:"How often to refresh with --watch" default:"2s"`
+}
+
+// serverStats is the part of GET /stats the table shows.
+type serverStats struct {
+	Total        int64           `json:"total"`
+	Delayed      int64           `json:"delayed"`
+	DeadLetter   int64           `json:"dead_letter"`
+	Workers      int64           `json:"workers"`
+	Capacity     int64           `json:"capacity"`
+	PausedQueues map[string]bool `json:"paused_queues"`
+}
+
+func (c *StatsCmd) Run() error {
+	interval, err := time.ParseDuration(c.Interval)
+	if err != nil {
+		return err
+	}
+	if c.Watch && interval < time.Second {
+		return fmt.Errorf("--interval must be at least 1s")
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	for {
+		if err := c.print(ctx); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if !c.Watch {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (c *StatsCmd) print(ctx context.Context) error {
+	var raw json.RawMessage
+	if err := c.get(ctx, "/stats", &raw); err != nil {
+		return err
+	}
+	var queues struct {
+		Queues []types.QueueSummary `json:"queues"`
+	}
+	if err := c.get(ctx, "/queues", &queues); err != nil {
+		return err
+	}
+
+	if c.JSON {
+		// One object per line, so --watch output can be piped to jq.
+		return json.NewEncoder(os.Stdout).Encode(map[string]any{"stats": raw, "queues": queues.Queues})
+	}
+
+	var stats serverStats
+	if err := json.Unmarshal(raw, &stats); err != nil {
+		return fmt.Errorf("decoding stats: %w", err)
+	}
+	if c.Watch {
+		// Clear the terminal so each refresh replaces the last.
+		fmt.Print("\033[H\033[2J")
+		fmt.Printf("%s  (every %s, Ctrl-C to stop)\n\n", time.Now().Format(time.TimeOnly), c.Interval)
+	}
+	return writeStats(os.Stdout, stats, queues.Queues)
+}
+
+func writeStats(w io.Writer, stats serverStats, queues []types.QueueSummary) error {
+	fmt.Fprintf(w, "Pending: %d  Delayed: %d  Dead letter: %d  Workers: %d (%d slots)\n",
+		stats.Total, stats.Delayed, stats.DeadLetter, stats.Workers, stats.Capacity)
+	if len(stats.PausedQueues) > 0 {
+		paused := make([]string, 0, len(stats.PausedQueues))
+		for queueKey := range stats.PausedQueues {
+			paused = append(paused, queueKey)
+		}
+		slices.Sort(paused)
+		fmt.Fprintf(w, "Paused: %v\n", paused)
+	}
+	fmt.Fprintln(w)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "QUERY RULES\tDEPTH\tOLDEST\tCLAIMS/MIN")
+	for _, queue := range queues {
+		oldest := "-"
+		if queue.Depth > 0 {
+			oldest = (time.Duration(queue.OldestJobAge) * time.Second).String()
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%.1f\n", queue.QueryRules, queue.Depth, oldest, queue.ClaimRate)
+	}
+	return tw.Flush()
+}
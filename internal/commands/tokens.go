@@ -0,0 +1,97 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/storage"
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+)
+
+type TokensCmd struct {
+	Create TokensCreateCmd `cmd:"" help:"Mint an API token and print its secret"`
+	List   TokensListCmd   `cmd:"" help:"List API tokens"`
+	Revoke TokensRevokeCmd `cmd:"" help:"Revoke an API token"`
+}
+
+type tokensRedis struct {
+	RedisAddr string `help:"Redis address" default:"localhost:6379" env:"REDIS_ADDR"`
+}
+
+type TokensCreateCmd struct {
+	tokensRedis `embed:""`
+
+	Name     string `arg:"" help:"What the token is for"`
+	Scope    string `help:"Routes the token may use: worker or admin" default:"worker" enum:"worker,admin"`
+	WorkerID string `help:"Worker ID the token may act as; required for worker tokens"`
+}
+
+func (c *TokensCreateCmd) Run() error {
+	if c.Scope == types.TokenScopeAdmin && c.WorkerID != "" {
+		return fmt.Errorf("admin tokens can't be bound to a worker")
+	}
+	if c.Scope == types.TokenScopeWorker && c.WorkerID == "" {
+		return fmt.Errorf("worker tokens must be bound to a worker with --worker-id")
+	}
+
+	store, err := storage.NewRedisStore(c.RedisAddr, storage.DispatchOrder{})
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	secret, token, err := store.CreateAPIToken(context.Background(), c.Name, c.Scope, c.WorkerID)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Created %s token %s; it won't be shown again:\n", token.Scope, token.ID)
+	fmt.Fprintln(os.Stderr, "Servers only accept it with API_TOKEN set, and won't start without it while it's unrevoked.")
+	fmt.Println(secret)
+	return nil
+}
+
+type TokensListCmd struct {
+	tokensRedis `embed:""`
+}
+
+func (c *TokensListCmd) Run() error {
+	store, err := storage.NewRedisStore(c.RedisAddr, storage.DispatchOrder{})
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	tokens, err := store.ListAPITokens(context.Background())
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tSCOPE\tWORKER\tCREATED")
+	for _, token := range tokens {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", token.ID, token.Name, token.Scope, token.WorkerID, token.CreatedAt.Format(time.RFC3339))
+	}
+	return tw.Flush()
+}
+
+type TokensRevokeCmd struct {
+	tokensRedis `embed:""`
+
+	ID string `arg:"" help:"ID of the token to revoke"`
+}
+
+func (c *TokensRevokeCmd) Run() error {
+	store, err := storage.NewRedisStore(c.RedisAddr, storage.DispatchOrder{})
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if err := store.RevokeAPIToken(context.Background(), c.ID); err != nil {
+		return fmt.Errorf("revoking %s: %w", c.ID, err)
+	}
+	fmt.Fprintf(os.Stderr, "Revoked token %s\n", c.ID)
+	return nil
+}
@@ -0,0 +1,18 @@
+package commands
+
+import (
+	"context"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/tracing"
+)
+
+// TracingFlags are the global tracing settings.
+type TracingFlags struct {
+	OTLPEndpoint string `help:"OTLP/HTTP collector to export traces to, e.g. http://localhost:4318 (empty for no tracing)" name:"otlp-endpoint" env:"OTEL_EXPORTER_OTLP_ENDPOINT"`
+}
+
+// Apply sets up tracing for command, returning a function that flushes any
+// spans not yet exported.
+func (f TracingFlags) Apply(ctx context.Context, command string) (func(context.Context) error, error) {
+	return tracing.Setup(ctx, f.OTLPEndpoint, command)
+}
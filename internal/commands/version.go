@@ -0,0 +1,14 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/version"
+)
+
+type VersionCmd struct{}
+
+func (c *VersionCmd) Run() error {
+	fmt.Println(version.String())
+	return nil
+}
@@ -3,37 +3,294 @@ package commands
 import (
 	"context"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/alecthomas/kong"
+	"github.com/buildkite/buildkite-custom-scheduler/internal/systemd"
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
 	"github.com/buildkite/buildkite-custom-scheduler/internal/worker"
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 )
 
 type WorkerCmd struct {
-	APIServer       string   `help:"API server URL" default:"http://localhost:18888" env:"WORKER_API_SERVER"`
-	AgentQueryRules []string `help:"Agent query rules (defines job matching)" default:"queue=default" env:"WORKER_AGENT_QUERY_RULES" sep:","`
-	Tags            []string `help:"Additional agent tags (metadata only, not used for job matching)" env:"WORKER_TAGS" sep:","`
-	Queue           string   `help:"Buildkite queue name" default:"" env:"WORKER_QUEUE"`
-	AgentPath       string   `help:"Path to buildkite-agent binary" default:"/usr/local/bin/buildkite-agent" env:"BUILDKITE_AGENT_PATH"`
-	AgentToken      string   `help:"Buildkite agent token" env:"BUILDKITE_AGENT_TOKEN" required:""`
-	PollInterval    string   `help:"Poll interval" default:"2s" env:"WORKER_POLL_INTERVAL"`
+	Config                kong.ConfigFlag   `help:"YAML or JSON file of worker settings, keyed by flag name; env vars and flags override it"`
+	APIServer             string            `help:"API server URL" default:"http://localhost:18888" env:"WORKER_API_SERVER"`
+	ServerToken           string            `help:"Bearer token for the API server: its API_TOKEN or a worker token bound to --worker-id" env:"WORKER_SERVER_TOKEN"`
+	WorkerID              string            `help:"ID to register as, e.g. the one a worker token is bound to (default: the state file's, or a random one)" env:"WORKER_ID"`
+	AgentQueryRules       []string          `help:"Agent query rules (defines job matching)" default:"queue=default" env:"WORKER_AGENT_QUERY_RULES" sep:","`
+	Tags                  []string          `help:"Additional agent tags (metadata only, not used for job matching)" env:"WORKER_TAGS" sep:","`
+	Queue                 string            `help:"Buildkite queue name" default:"" env:"WORKER_QUEUE"`
+	AgentPath             string            `help:"Path to buildkite-agent binary" default:"/usr/local/bin/buildkite-agent" env:"BUILDKITE_AGENT_PATH"`
+	Executor              string            `help:"Where to run the agent for each job" enum:"host,docker,kubernetes,ecs,nomad,microvm" default:"host" env:"WORKER_EXECUTOR"`
+	DockerPath            string            `help:"Path to the docker CLI, for the docker executor" default:"docker" env:"WORKER_DOCKER_PATH"`
+	DockerImage           string            `help:"Image to run agents in, for the docker executor; must have buildkite-agent on its PATH" default:"buildkite/agent:3" env:"WORKER_DOCKER_IMAGE"`
+	DockerVolumes         []string          `help:"Bind mounts for agent containers, in docker's -v format" env:"WORKER_DOCKER_VOLUMES" sep:","`
+	DockerEnv             []string          `help:"Extra environment for agent containers, as KEY=VALUE or KEY to pass through" env:"WORKER_DOCKER_ENV" sep:","`
+	K8sAPIServer          string            `help:"Kubernetes API URL, for the kubernetes executor (defaults to the in-cluster service account)" name:"k8s-api-server" env:"WORKER_K8S_API_SERVER"`
+	K8sNamespace          string            `help:"Namespace to create agent pods in" default:"default" name:"k8s-namespace" env:"WORKER_K8S_NAMESPACE"`
+	K8sPodTemplate        string            `help:"File with a Pod as JSON to base agent pods on" name:"k8s-pod-template" env:"WORKER_K8S_POD_TEMPLATE"`
+	K8sImage              string            `help:"Image for the agent container in agent pods" default:"buildkite/agent:3" name:"k8s-image" env:"WORKER_K8S_IMAGE"`
+	K8sServiceAccount     string            `help:"Service account for agent pods" name:"k8s-service-account" env:"WORKER_K8S_SERVICE_ACCOUNT"`
+	K8sNodeSelector       map[string]string `help:"Query rule keys to copy into agent pods' node selector, as rule=label, e.g. arch=kubernetes.io/arch" name:"k8s-node-selector" env:"WORKER_K8S_NODE_SELECTOR"`
+	K8sTokenSecret        string            `help:"Secret holding the agent token, as name/key, instead of putting it in the pod spec" name:"k8s-token-secret" env:"WORKER_K8S_TOKEN_SECRET"`
+	ECSRegion             string            `help:"AWS region, for the ecs executor and S3 log uploads" env:"AWS_REGION"`
+	ECSCluster            string            `help:"ECS cluster to run agent tasks in" default:"default" env:"WORKER_ECS_CLUSTER"`
+	ECSTaskDefinition     string            `help:"Task definition for agent tasks; its agent container's entrypoint must be buildkite-agent" env:"WORKER_ECS_TASK_DEFINITION"`
+	ECSContainer          string            `help:"Name of the agent container in the task definition" default:"agent" env:"WORKER_ECS_CONTAINER"`
+	ECSLaunchType         string            `help:"Launch type for agent tasks, e.g. FARGATE or EC2" env:"WORKER_ECS_LAUNCH_TYPE"`
+	ECSSubnets            []string          `help:"Subnets for awsvpc agent tasks" env:"WORKER_ECS_SUBNETS" sep:","`
+	ECSSecurityGroups     []string          `help:"Security groups for awsvpc agent tasks" env:"WORKER_ECS_SECURITY_GROUPS" sep:","`
+	ECSAssignPublicIP     bool              `help:"Give awsvpc agent tasks a public IP" env:"WORKER_ECS_ASSIGN_PUBLIC_IP"`
+	NomadAddr             string            `help:"Nomad API address, for the nomad executor" default:"http://127.0.0.1:4646" env:"NOMAD_ADDR"`
+	NomadToken            string            `help:"Nomad ACL token" env:"NOMAD_TOKEN"`
+	NomadNamespace        string            `help:"Nomad namespace of the parameterized job" env:"NOMAD_NAMESPACE"`
+	NomadJob              string            `help:"Parameterized Nomad job to dispatch for each Buildkite job" env:"WORKER_NOMAD_JOB"`
+	IgnitePath            string            `help:"Path to the ignite CLI, for the microvm executor" default:"ignite" env:"WORKER_IGNITE_PATH"`
+	VMImage               string            `help:"OCI image to boot agent microVMs from; must have buildkite-agent on its PATH" default:"buildkite/agent:3" env:"WORKER_VM_IMAGE"`
+	VMKernelImage         string            `help:"Kernel image for agent microVMs (defaults to ignite's)" env:"WORKER_VM_KERNEL_IMAGE"`
+	VMCPUs                int               `help:"vCPUs for each agent microVM" default:"2" env:"WORKER_VM_CPUS"`
+	VMMemory              string            `help:"Memory for each agent microVM" default:"2GB" env:"WORKER_VM_MEMORY"`
+	VMDiskSize            string            `help:"Root disk size for each agent microVM" default:"10GB" env:"WORKER_VM_DISK_SIZE"`
+	JobCPULimit           float64           `help:"CPU cores each job's agent may use, for the host, docker and kubernetes executors (0 for no limit)" default:"0" env:"WORKER_JOB_CPU_LIMIT"`
+	JobMemoryLimit        string            `help:"Memory each job's agent may use, e.g. 4g, for the host, docker and kubernetes executors" env:"WORKER_JOB_MEMORY_LIMIT"`
+	JobCgroup             string            `help:"cgroup v2 directory the host executor creates job cgroups in" default:"/sys/fs/cgroup/buildkite-jobs" env:"WORKER_JOB_CGROUP"`
+	AgentToken            string            `help:"Buildkite agent token" env:"BUILDKITE_AGENT_TOKEN" required:""`
+	PollInterval          string            `help:"Poll interval" default:"2s" env:"WORKER_POLL_INTERVAL"`
+	Resources             []string          `help:"Resources this worker offers for resource-aware scheduling, e.g. cpu=8,mem=16g" env:"WORKER_RESOURCES" sep:","`
+	HeartbeatInterval     string            `help:"How often to heartbeat the worker and its running job's claim (0 to disable)" default:"15s" env:"WORKER_HEARTBEAT_INTERVAL"`
+	StateFile             string            `help:"File to record the in-flight job in, so a restarted worker can resume it" env:"WORKER_STATE_FILE"`
+	ClaimWait             string            `help:"How long the server may hold a claim request open waiting for a job (0 to poll without waiting)" default:"30s" env:"WORKER_CLAIM_WAIT"`
+	Stream                bool              `help:"Subscribe to the server's job stream and claim as soon as a job is offered, polling only while disconnected" env:"WORKER_STREAM"`
+	MaxBackoff            string            `help:"Maximum delay between retries after repeated errors claiming or running jobs" default:"1m" env:"WORKER_MAX_BACKOFF"`
+	DrainTimeout          string            `help:"How long running jobs get to finish on shutdown before their agents are interrupted" default:"5m" env:"WORKER_DRAIN_TIMEOUT"`
+	PreJobHook            string            `help:"Executable to run before each job; if it fails the job is aborted" env:"WORKER_PRE_JOB_HOOK"`
+	PostJobHook           string            `help:"Executable to run after each job; if it fails the job is failed" env:"WORKER_POST_JOB_HOOK"`
+	Concurrency           int               `help:"Number of jobs to run at once" default:"1" env:"WORKER_CONCURRENCY"`
+	Subscriptions         []string          `help:"Query rule sets to claim jobs for, each with its own slots, as rules[@concurrency], e.g. queue=default;queue=deploy,arch=amd64@2; replaces the agent query rules, queue and concurrency" env:"WORKER_SUBSCRIPTIONS" sep:";"`
+	TerminationNotices    string            `help:"Cloud whose metadata service to watch for spot or preemption notices; on notice the worker hands back its jobs and exits" enum:"none,aws,gcp" default:"none" env:"WORKER_TERMINATION_NOTICES"`
+	IdleTimeout           string            `help:"Exit once the worker has gone this long without a job (0 to run forever)" default:"0" env:"WORKER_IDLE_TIMEOUT"`
+	IdleDeregister        bool              `help:"Deregister from the server when exiting after the idle timeout" env:"WORKER_IDLE_DEREGISTER"`
+	PreflightMinFreeDisk  string            `help:"Don't claim jobs unless the preflight disk path has this much free space, e.g. 10g" env:"WORKER_PREFLIGHT_MIN_FREE_DISK"`
+	PreflightDiskPath     string            `help:"Filesystem to check for free space before claiming" default:"/" env:"WORKER_PREFLIGHT_DISK_PATH"`
+	PreflightDocker       bool              `help:"Don't claim jobs unless the docker daemon is reachable" env:"WORKER_PREFLIGHT_DOCKER"`
+	PreflightMaxClockSkew string            `help:"Don't claim jobs while the host clock is further than this from the API server's (0 to skip)" default:"0" env:"WORKER_PREFLIGHT_MAX_CLOCK_SKEW"`
+	AcquireRetries        int               `help:"Times to rerun the agent when it fails within the acquire window, which usually means it couldn't acquire the job" default:"2" env:"WORKER_ACQUIRE_RETRIES"`
+	AcquireWindow         string            `help:"How soon after starting an agent failure counts as failing to acquire the job (0 to never retry)" default:"30s" env:"WORKER_ACQUIRE_WINDOW"`
+	BuildPath             string            `help:"Directory to give each job its own build path under, for the host executor; each job's directory is removed when it finishes" env:"WORKER_BUILD_PATH"`
+	BuildDirKeep          bool              `help:"Keep each job's build directory after it finishes, leaving cleanup to the max age and size" env:"WORKER_BUILD_DIR_KEEP"`
+	BuildDirMaxAge        string            `help:"Remove build directories unused for this long (0 for no limit)" default:"0" env:"WORKER_BUILD_DIR_MAX_AGE"`
+	BuildDirMaxSize       string            `help:"Remove the least recently used build directories while they total more than this, e.g. 50g" env:"WORKER_BUILD_DIR_MAX_SIZE"`
+	LogDir                string            `help:"Directory to write each job's agent and hook output to, as <job uuid>.log" env:"WORKER_LOG_DIR"`
+	LogUpload             string            `help:"Where to upload failed jobs' output" enum:"none,server,s3" default:"none" env:"WORKER_LOG_UPLOAD"`
+	LogUploadAll          bool              `help:"Upload every job's output, not just failed jobs'" env:"WORKER_LOG_UPLOAD_ALL"`
+	LogS3Bucket           string            `help:"S3 bucket for job output uploads" env:"WORKER_LOG_S3_BUCKET"`
+	LogS3Prefix           string            `help:"Key prefix for job output uploaded to S3" env:"WORKER_LOG_S3_PREFIX"`
+	MetricsListen         string            `help:"Address to serve the worker's Prometheus metrics on at /metrics, e.g. :9464 (empty for none)" env:"WORKER_METRICS_LISTEN"`
 }
 
 func (w *WorkerCmd) Run() error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return w.run(ctx)
+}
+
+// run claims and runs jobs until ctx is done or the runner stops, e.g. after
+// its idle timeout.
+func (w *WorkerCmd) run(parent context.Context) error {
 	if len(w.AgentQueryRules) == 0 {
 		return fmt.Errorf("at least one agent query rule is required")
 	}
 
+	if w.Concurrency < 1 {
+		return fmt.Errorf("concurrency must be at least 1")
+	}
+
+	subscriptions, err := parseSubscriptions(w.Subscriptions)
+	if err != nil {
+		return err
+	}
+
 	pollInterval, err := time.ParseDuration(w.PollInterval)
 	if err != nil {
 		return err
 	}
 
-	workerID := uuid.New().String()
+	heartbeatInterval, err := time.ParseDuration(w.HeartbeatInterval)
+	if err != nil {
+		return err
+	}
+
+	claimWait, err := time.ParseDuration(w.ClaimWait)
+	if err != nil {
+		return err
+	}
+
+	maxBackoff, err := time.ParseDuration(w.MaxBackoff)
+	if err != nil {
+		return err
+	}
+
+	drainTimeout, err := time.ParseDuration(w.DrainTimeout)
+	if err != nil {
+		return err
+	}
+
+	limits := worker.JobLimits{CPU: w.JobCPULimit}
+	if w.JobMemoryLimit != "" {
+		memory, err := types.ParseQuantity(w.JobMemoryLimit)
+		if err != nil {
+			return fmt.Errorf("job memory limit: %w", err)
+		}
+		limits.Memory = int64(memory)
+	}
+	if (limits.CPU > 0 || limits.Memory > 0) && w.Executor != "host" && w.Executor != "docker" && w.Executor != "kubernetes" {
+		return fmt.Errorf("job limits aren't supported by the %s executor", w.Executor)
+	}
+
+	acquireWindow, err := time.ParseDuration(w.AcquireWindow)
+	if err != nil {
+		return err
+	}
+
+	idleTimeout, err := time.ParseDuration(w.IdleTimeout)
+	if err != nil {
+		return err
+	}
+
+	preflight := worker.PreflightConfig{DiskPath: w.PreflightDiskPath}
+	if w.PreflightMinFreeDisk != "" {
+		minFree, err := types.ParseQuantity(w.PreflightMinFreeDisk)
+		if err != nil {
+			return fmt.Errorf("preflight min free disk: %w", err)
+		}
+		preflight.MinFreeDisk = uint64(minFree)
+	}
+	if w.PreflightDocker {
+		preflight.DockerPath = w.DockerPath
+	}
+	if preflight.MaxClockSkew, err = time.ParseDuration(w.PreflightMaxClockSkew); err != nil {
+		return err
+	}
+
+	buildDirs := worker.BuildDirConfig{Root: w.BuildPath, Keep: w.BuildDirKeep}
+	if buildDirs.MaxAge, err = time.ParseDuration(w.BuildDirMaxAge); err != nil {
+		return err
+	}
+	if w.BuildDirMaxSize != "" {
+		maxSize, err := types.ParseQuantity(w.BuildDirMaxSize)
+		if err != nil {
+			return fmt.Errorf("build dir max size: %w", err)
+		}
+		buildDirs.MaxSize = uint64(maxSize)
+	}
+	if buildDirs.Root != "" && w.Executor != "host" {
+		return fmt.Errorf("build paths aren't supported by the %s executor", w.Executor)
+	}
+
+	logs := worker.LogConfig{
+		Dir:       w.LogDir,
+		UploadAll: w.LogUploadAll,
+		S3Bucket:  w.LogS3Bucket,
+		S3Prefix:  w.LogS3Prefix,
+		S3Region:  w.ECSRegion,
+	}
+	if w.LogUpload != "none" {
+		logs.Upload = w.LogUpload
+	}
+	if logs.Upload == "s3" && (logs.S3Bucket == "" || logs.S3Region == "") {
+		return fmt.Errorf("s3 log uploads need a bucket and an AWS region")
+	}
+
+	var executor worker.Executor
+	switch w.Executor {
+	case "docker":
+		executor = worker.NewDockerExecutor(worker.DockerConfig{
+			DockerPath: w.DockerPath,
+			Image:      w.DockerImage,
+			Volumes:    w.DockerVolumes,
+			Env:        w.DockerEnv,
+			Limits:     limits,
+		})
+	case "kubernetes":
+		executor, err = worker.NewKubernetesExecutor(worker.KubernetesConfig{
+			APIServer:         w.K8sAPIServer,
+			Namespace:         w.K8sNamespace,
+			PodTemplate:       w.K8sPodTemplate,
+			Image:             w.K8sImage,
+			ServiceAccount:    w.K8sServiceAccount,
+			NodeSelectorRules: w.K8sNodeSelector,
+			TokenSecret:       w.K8sTokenSecret,
+			Limits:            limits,
+		})
+		if err != nil {
+			return err
+		}
+	case "ecs":
+		executor, err = worker.NewECSExecutor(worker.ECSConfig{
+			Region:         w.ECSRegion,
+			Cluster:        w.ECSCluster,
+			TaskDefinition: w.ECSTaskDefinition,
+			Container:      w.ECSContainer,
+			LaunchType:     w.ECSLaunchType,
+			Subnets:        w.ECSSubnets,
+			SecurityGroups: w.ECSSecurityGroups,
+			AssignPublicIP: w.ECSAssignPublicIP,
+		})
+		if err != nil {
+			return err
+		}
+	case "nomad":
+		executor, err = worker.NewNomadExecutor(worker.NomadConfig{
+			Address:   w.NomadAddr,
+			Token:     w.NomadToken,
+			Namespace: w.NomadNamespace,
+			Job:       w.NomadJob,
+		})
+		if err != nil {
+			return err
+		}
+	case "microvm":
+		executor = worker.NewMicroVMExecutor(worker.MicroVMConfig{
+			IgnitePath:  w.IgnitePath,
+			Image:       w.VMImage,
+			KernelImage: w.VMKernelImage,
+			CPUs:        w.VMCPUs,
+			Memory:      w.VMMemory,
+			DiskSize:    w.VMDiskSize,
+		})
+	default:
+		executor, err = worker.NewHostExecutor(w.AgentPath, w.JobCgroup, limits)
+		if err != nil {
+			return err
+		}
+	}
+
+	workerID := w.WorkerID
+	if w.StateFile != "" {
+		savedID, err := worker.StateWorkerID(w.StateFile)
+		if err != nil {
+			return err
+		}
+		// The saved jobs were claimed as the saved ID, so can't be resumed as
+		// another.
+		if savedID != "" && workerID != "" && savedID != workerID {
+			return fmt.Errorf("state file %s is for worker %s, not --worker-id %s", w.StateFile, savedID, workerID)
+		}
+		if workerID == "" {
+			workerID = savedID
+		}
+	}
+	if workerID == "" {
+		workerID = uuid.New().String()
+	}
 	logger := log.With().Str("worker_id", workerID).Logger()
 
 	logger.Info().Msg("Starting worker...")
@@ -41,38 +298,163 @@ func (w *WorkerCmd) Run() error {
 	logger.Info().Strs("query_rules", w.AgentQueryRules).Msg("Query rules")
 	logger.Info().Strs("tags", w.Tags).Msg("Additional tags")
 	logger.Info().Str("queue", w.Queue).Msg("Queue")
-	logger.Info().Str("agent_path", w.AgentPath).Msg("Agent path")
+	logger.Info().Str("executor", w.Executor).Msg("Executor")
+	switch w.Executor {
+	case "docker":
+		logger.Info().Str("image", w.DockerImage).Msg("Docker image")
+	case "kubernetes":
+		logger.Info().Str("namespace", w.K8sNamespace).Str("image", w.K8sImage).Msg("Kubernetes pods")
+	case "ecs":
+		logger.Info().Str("cluster", w.ECSCluster).Str("task_definition", w.ECSTaskDefinition).Msg("ECS tasks")
+	case "nomad":
+		logger.Info().Str("nomad_addr", w.NomadAddr).Str("job", w.NomadJob).Msg("Nomad job")
+	case "microvm":
+		logger.Info().Str("image", w.VMImage).Int("cpus", w.VMCPUs).Str("memory", w.VMMemory).Msg("MicroVMs")
+	default:
+		logger.Info().Str("agent_path", w.AgentPath).Msg("Agent path")
+	}
 	logger.Info().Dur("poll_interval", pollInterval).Msg("Poll interval")
+	logger.Info().Dur("claim_wait", claimWait).Msg("Claim wait")
+	logger.Info().Bool("stream", w.Stream).Msg("Job stream")
+	logger.Info().Int("concurrency", w.Concurrency).Msg("Concurrency")
+	logger.Info().Int("retries", w.AcquireRetries).Dur("window", acquireWindow).Msg("Acquire retries")
+	if preflight.MinFreeDisk > 0 || preflight.DockerPath != "" || preflight.MaxClockSkew > 0 {
+		logger.Info().Uint64("min_free_disk", preflight.MinFreeDisk).Str("disk_path", preflight.DiskPath).Bool("docker", w.PreflightDocker).Dur("max_clock_skew", preflight.MaxClockSkew).Msg("Preflight checks")
+	}
+	if buildDirs.Root != "" {
+		logger.Info().Str("root", buildDirs.Root).Bool("keep", buildDirs.Keep).Dur("max_age", buildDirs.MaxAge).Uint64("max_size", buildDirs.MaxSize).Msg("Build directories")
+	}
+	if logs.Dir != "" || logs.Upload != "" {
+		logger.Info().Str("dir", logs.Dir).Str("upload", logs.Upload).Bool("upload_all", logs.UploadAll).Msg("Job logs")
+	}
+	if idleTimeout > 0 {
+		logger.Info().Dur("idle_timeout", idleTimeout).Bool("deregister", w.IdleDeregister).Msg("Idle timeout")
+	}
+	if len(w.Resources) > 0 {
+		logger.Info().Strs("resources", w.Resources).Msg("Resources")
+	}
+	if limits.CPU > 0 || limits.Memory > 0 {
+		logger.Info().Float64("cpu", limits.CPU).Int64("memory", limits.Memory).Msg("Job limits")
+	}
 
-	ctx, cancel := context.WithCancel(context.Background())
+	terminationNotices := w.TerminationNotices
+	if terminationNotices == "none" {
+		terminationNotices = ""
+	} else {
+		logger.Info().Str("provider", terminationNotices).Msg("Watching for termination notices")
+	}
+
+	ctx, cancel := context.WithCancel(parent)
 	defer cancel()
 
-	runner := worker.NewRunner(
-		w.APIServer,
-		w.AgentQueryRules,
-		w.Tags,
-		w.Queue,
-		w.AgentPath,
-		w.AgentToken,
-		pollInterval,
-		workerID,
-		logger,
-	)
+	if w.MetricsListen != "" {
+		if err := serveMetrics(ctx, w.MetricsListen); err != nil {
+			return err
+		}
+	}
+
+	runner := worker.NewRunner(worker.Config{
+		APIServer:          w.APIServer,
+		ServerToken:        w.ServerToken,
+		AgentQueryRules:    w.AgentQueryRules,
+		Tags:               w.Tags,
+		Queue:              w.Queue,
+		Executor:           executor,
+		ExecutorType:       w.Executor,
+		BuildkiteToken:     w.AgentToken,
+		PollInterval:       pollInterval,
+		WorkerID:           workerID,
+		Resources:          w.Resources,
+		HeartbeatInterval:  heartbeatInterval,
+		StateFile:          w.StateFile,
+		Concurrency:        w.Concurrency,
+		Subscriptions:      subscriptions,
+		ClaimWait:          claimWait,
+		Stream:             w.Stream,
+		MaxBackoff:         max(maxBackoff, pollInterval),
+		DrainTimeout:       drainTimeout,
+		PreJobHook:         w.PreJobHook,
+		PostJobHook:        w.PostJobHook,
+		TerminationNotices: terminationNotices,
+		IdleTimeout:        idleTimeout,
+		DeregisterOnIdle:   w.IdleDeregister,
+		Preflight:          preflight,
+		BuildDirs:          buildDirs,
+		AcquireRetries:     w.AcquireRetries,
+		AcquireWindow:      acquireWindow,
+		Logs:               logs,
+	}, logger)
 
+	done := make(chan error, 1)
 	go func() {
-		if err := runner.Start(ctx); err != nil && err != context.Canceled {
-			logger.Error().Err(err).Msg("Runner error")
-		}
+		done <- runner.Start(ctx)
 	}()
 
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-	<-sigChan
+	systemd.Ready()
+	go systemd.Watchdog(ctx, nil)
 
-	logger.Info().Msg("Shutting down gracefully...")
-	cancel()
+	select {
+	case <-parent.Done():
+		logger.Info().Msg("Shutting down gracefully, no longer claiming jobs...")
+		systemd.Stopping()
+		cancel()
+		<-done
+	case err := <-done:
+		if err != nil && err != context.Canceled {
+			return err
+		}
+	}
 
-	time.Sleep(2 * time.Second)
 	logger.Info().Msg("Shutdown complete")
 	return nil
 }
+
+// parseSubscriptions parses subscriptions written as comma-separated query
+// rules, optionally followed by @ and how many slots claim for them.
+func parseSubscriptions(values []string) ([]worker.Subscription, error) {
+	var subs []worker.Subscription
+	for _, value := range values {
+		rules, concurrency, hasConcurrency := strings.Cut(strings.TrimSpace(value), "@")
+		sub := worker.Subscription{}
+		for _, rule := range strings.Split(rules, ",") {
+			if rule = strings.TrimSpace(rule); rule != "" {
+				sub.AgentQueryRules = append(sub.AgentQueryRules, rule)
+			}
+		}
+		if len(sub.AgentQueryRules) == 0 {
+			return nil, fmt.Errorf("subscription %q has no query rules", value)
+		}
+		if hasConcurrency {
+			n, err := strconv.Atoi(concurrency)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("subscription %q: concurrency must be a positive number", value)
+			}
+			sub.Concurrency = n
+		}
+		subs = append(subs, sub)
+	}
+	return subs, nil
+}
+
+// serveMetrics serves the worker's Prometheus metrics on addr at /metrics
+// until ctx is done.
+func serveMetrics(ctx context.Context, addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("metrics listener: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", worker.Metrics.Handler())
+	metricsServer := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		metricsServer.Close()
+	}()
+	go func() {
+		log.Info().Str("listen", listener.Addr().String()).Msg("Serving metrics")
+		if err := metricsServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Error().Err(err).Msg("Metrics server error")
+		}
+	}()
+	return nil
+}
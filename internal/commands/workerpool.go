@@ -0,0 +1,115 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+type WorkerPoolCmd struct {
+	Worker WorkerCmd `embed:""`
+
+	Size            int    `help:"Number of workers to run" default:"2" env:"WORKER_POOL_SIZE"`
+	RestartDelay    string `help:"How long to wait before restarting a worker that failed, doubling while it keeps failing" default:"1s" env:"WORKER_POOL_RESTART_DELAY"`
+	MaxRestartDelay string `help:"Longest to wait between restarts of a failing worker" default:"1m" env:"WORKER_POOL_MAX_RESTART_DELAY"`
+}
+
+// healthyRunTime is how long a worker must run before a failure counts as a
+// fresh one, resetting its restart delay.
+const healthyRunTime = time.Minute
+
+func (c *WorkerPoolCmd) Run() error {
+	if c.Size < 1 {
+		return fmt.Errorf("--size must be at least 1")
+	}
+	// Worker tokens are bound to a single worker, so can't be shared by a
+	// pool's workers either.
+	if c.Worker.WorkerID != "" {
+		return fmt.Errorf("--worker-id can't be set for a pool, as each worker needs its own")
+	}
+	restartDelay, err := time.ParseDuration(c.RestartDelay)
+	if err != nil {
+		return err
+	}
+	maxRestartDelay, err := time.ParseDuration(c.MaxRestartDelay)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// The pool's workers share a process, so one listener serves all their
+	// metrics.
+	if c.Worker.MetricsListen != "" {
+		if err := serveMetrics(ctx, c.Worker.MetricsListen); err != nil {
+			return err
+		}
+	}
+
+	log.Info().Int("size", c.Size).Msg("Starting worker pool")
+	var wg sync.WaitGroup
+	for i := range c.Size {
+		w := c.Worker
+		w.MetricsListen = ""
+		// Each worker resumes its own jobs after a restart, so needs its own
+		// state file.
+		if w.StateFile != "" {
+			w.StateFile = fmt.Sprintf("%s.pool-%d", c.Worker.StateFile, i)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.supervise(ctx, i, &w, restartDelay, maxRestartDelay)
+		}()
+	}
+	wg.Wait()
+	log.Info().Msg("Worker pool stopped")
+	return nil
+}
+
+// supervise runs worker n until ctx is done, restarting it whenever it fails
+// or panics. A worker that exits cleanly, e.g. after its idle timeout, isn't
+// restarted.
+func (c *WorkerPoolCmd) supervise(ctx context.Context, n int, w *WorkerCmd, restartDelay, maxRestartDelay time.Duration) {
+	logger := log.With().Int("pool_worker", n).Logger()
+	delay := restartDelay
+	for {
+		started := time.Now()
+		err := runRecovered(ctx, w)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			logger.Info().Msg("Pool worker exited, not restarting it")
+			return
+		}
+		if time.Since(started) >= healthyRunTime {
+			delay = restartDelay
+		}
+		logger.Error().Err(err).Dur("restart_in", delay).Msg("Pool worker failed, restarting it")
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxRestartDelay)
+	}
+}
+
+// runRecovered runs w, returning a panic as an error so one worker's bug
+// doesn't take down the rest of the pool.
+func runRecovered(ctx context.Context, w *WorkerCmd) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return w.run(ctx)
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/alecthomas/kong"
+)
+
+// Load is a kong.ConfigurationLoader for settings files in either YAML or
+// JSON, telling them apart by whether the file starts with {.
+func Load(r io.Reader) (kong.Resolver, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return JSON(bytes.NewReader(data))
+	}
+	return YAML(bytes.NewReader(data))
+}
+
+// JSON is a kong.ConfigurationLoader for JSON settings files, laid out as
+// for YAML: an object keyed by flag name, optionally grouped into sections.
+func JSON(r io.Reader) (kong.Resolver, error) {
+	var settings map[string]any
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	if err := decoder.Decode(&settings); err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+	values, err := jsonValue(settings)
+	if err != nil {
+		return nil, err
+	}
+	section, _ := values.(map[string]any)
+	if section == nil {
+		section = map[string]any{}
+	}
+	return &resolver{settings: section}, nil
+}
+
+// jsonValue converts decoded JSON to the values the YAML parser produces:
+// scalars as strings for kong to convert, lists of scalars, and sections.
+func jsonValue(value any) (any, error) {
+	switch value := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return value, nil
+	case json.Number, bool:
+		return fmt.Sprint(value), nil
+	case []any:
+		items := make([]any, 0, len(value))
+		for _, item := range value {
+			if _, ok := item.(map[string]any); ok {
+				return nil, fmt.Errorf("lists may only hold plain values")
+			}
+			if _, ok := item.([]any); ok {
+				return nil, fmt.Errorf("lists may only hold plain values")
+			}
+			converted, err := jsonValue(item)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, converted)
+		}
+		return items, nil
+	case map[string]any:
+		section := make(map[string]any, len(value))
+		for key, item := range value {
+			converted, err := jsonValue(item)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			if converted != nil {
+				section[key] = converted
+			}
+		}
+		return section, nil
+	}
+	return nil, fmt.Errorf("unsupported value %v", value)
+}
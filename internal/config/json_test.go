@@ -0,0 +1,40 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadJSON(t *testing.T) {
+	loaded, err := Load(strings.NewReader(`
+		{"queue": "linux", "concurrency": 4, "stream": true, "image": null,
+		 "docker": {"image": "custom:1"}, "tags": ["os=linux", 2]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"queue":       "linux",
+		"concurrency": "4",
+		"stream":      "true",
+		"docker":      map[string]any{"image": "custom:1"},
+		"tags":        []any{"os=linux", "2"},
+	}
+	if got := loaded.(*resolver).settings; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+
+	// Anything else is YAML.
+	if loaded, err = Load(strings.NewReader("queue: linux\n")); err != nil {
+		t.Fatal(err)
+	}
+	if got := loaded.(*resolver).settings; !reflect.DeepEqual(got, map[string]any{"queue": "linux"}) {
+		t.Errorf("got %#v from YAML", got)
+	}
+
+	for _, settings := range []string{`{"tags": [{"os": "linux"}]}`, `{"tags": [["a"]]}`, `{"queue": `} {
+		if _, err := Load(strings.NewReader(settings)); err == nil {
+			t.Errorf("loaded %s", settings)
+		}
+	}
+}
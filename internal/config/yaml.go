@@ -0,0 +1,340 @@
+// Package config loads command settings from files, as a kong resolver that
+// sits underneath env vars and flags.
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/kong"
+)
+
+// YAML is a kong.ConfigurationLoader for YAML settings files. Keys are flag
+// names, with underscores or hyphens, and may be grouped into sections that
+// prefix them, so these are the same:
+//
+//	docker-image: buildkite/agent:3
+//
+//	docker:
+//	  image: buildkite/agent:3
+//
+// Only the parts of YAML that settings files need are supported: mappings,
+// lists of scalars, and plain or quoted scalars.
+func YAML(r io.Reader) (kong.Resolver, error) {
+	lines, err := readLines(r)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{lines: lines}
+	settings := map[string]any{}
+	if len(lines) > 0 {
+		if lines[0].indent != 0 {
+			return nil, fmt.Errorf("line %d: unexpected indentation", lines[0].number)
+		}
+		if settings, err = p.mapping(0); err != nil {
+			return nil, err
+		}
+	}
+	return &resolver{settings: settings}, nil
+}
+
+type resolver struct {
+	settings map[string]any
+}
+
+// Validate rejects settings that don't match a flag, so typos don't go
+// unnoticed.
+func (r *resolver) Validate(app *kong.Application) error {
+	flags := map[string]bool{}
+	var collect func(node *kong.Node)
+	collect = func(node *kong.Node) {
+		for _, flag := range node.Flags {
+			flags[flag.Name] = true
+		}
+		for _, child := range node.Children {
+			collect(child)
+		}
+	}
+	collect(app.Node)
+	return validate(r.settings, "", flags)
+}
+
+func validate(settings map[string]any, prefix string, flags map[string]bool) error {
+	for key, value := range settings {
+		name := prefix + flagName(key)
+		if flags[name] {
+			continue
+		}
+		section, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("unknown setting %q", name)
+		}
+		if err := validate(section, name+"-", flags); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Resolve returns the file's value for a flag, unless one of the flag's env
+// vars is set, since those take precedence over the file.
+func (r *resolver) Resolve(_ *kong.Context, _ *kong.Path, flag *kong.Flag) (any, error) {
+	for _, env := range flag.Envs {
+		if _, ok := os.LookupEnv(env); ok {
+			return nil, nil
+		}
+	}
+	return lookup(r.settings, flag.Name), nil
+}
+
+// lookup finds name in settings, either as a key or split across sections.
+func lookup(settings map[string]any, name string) any {
+	for key, value := range settings {
+		key = flagName(key)
+		if key == name {
+			return value
+		}
+		if section, ok := value.(map[string]any); ok && strings.HasPrefix(name, key+"-") {
+			if value := lookup(section, strings.TrimPrefix(name, key+"-")); value != nil {
+				return value
+			}
+		}
+	}
+	return nil
+}
+
+// flagName converts a settings key to flag name form. Keys are otherwise kept
+// as written, since sections can also be the values of map flags.
+func flagName(key string) string {
+	return strings.ReplaceAll(strings.ToLower(key), "_", "-")
+}
+
+type line struct {
+	number  int
+	indent  int
+	content string
+}
+
+// readLines returns the non-blank lines of r with comments removed.
+func readLines(r io.Reader) ([]line, error) {
+	var lines []line
+	scanner := bufio.NewScanner(r)
+	for number := 1; scanner.Scan(); number++ {
+		text := scanner.Text()
+		content := strings.TrimLeft(text, " ")
+		if strings.HasPrefix(content, "\t") {
+			return nil, fmt.Errorf("line %d: tabs aren't allowed for indentation", number)
+		}
+		content = strings.TrimSpace(stripComment(content))
+		if content == "" || content == "---" {
+			continue
+		}
+		lines = append(lines, line{number: number, indent: len(text) - len(strings.TrimLeft(text, " ")), content: content})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+	return lines, nil
+}
+
+// stripComment removes a # comment that isn't inside quotes.
+func stripComment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' {
+				i++
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || s[i-1] == ' '):
+			return s[:i]
+		}
+	}
+	return s
+}
+
+type parser struct {
+	lines []line
+	pos   int
+}
+
+// mapping parses key: value lines at indent.
+func (p *parser) mapping(indent int) (map[string]any, error) {
+	values := map[string]any{}
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.indent < indent {
+			break
+		}
+		if l.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", l.number)
+		}
+		if strings.HasPrefix(l.content, "- ") || l.content == "-" {
+			return nil, fmt.Errorf("line %d: expected a key, got a list item", l.number)
+		}
+
+		key, rest, ok := cutKey(l.content)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key: value", l.number)
+		}
+		if _, ok := values[key]; ok {
+			return nil, fmt.Errorf("line %d: duplicate key %q", l.number, key)
+		}
+		p.pos++
+
+		if rest != "" {
+			value, err := scalarOrList(rest)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", l.number, err)
+			}
+			if value != nil {
+				values[key] = value
+			}
+			continue
+		}
+
+		// A key on its own introduces a nested section or list, if anything
+		// follows it.
+		if p.pos == len(p.lines) {
+			continue
+		}
+		next := p.lines[p.pos]
+		switch {
+		case next.indent > indent && !strings.HasPrefix(next.content, "-"):
+			section, err := p.mapping(next.indent)
+			if err != nil {
+				return nil, err
+			}
+			values[key] = section
+		case next.indent > indent || (next.indent == indent && strings.HasPrefix(next.content, "-")):
+			list, err := p.list(next.indent)
+			if err != nil {
+				return nil, err
+			}
+			values[key] = list
+		}
+	}
+	return values, nil
+}
+
+// list parses - item lines at indent.
+func (p *parser) list(indent int) ([]any, error) {
+	var items []any
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.indent != indent || !(strings.HasPrefix(l.content, "- ") || l.content == "-") {
+			break
+		}
+		item := strings.TrimSpace(strings.TrimPrefix(l.content, "-"))
+		if _, _, ok := cutKey(item); ok || strings.HasPrefix(item, "[") {
+			return nil, fmt.Errorf("line %d: lists may only hold plain values", l.number)
+		}
+		value, err := scalar(item)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", l.number, err)
+		}
+		items = append(items, value)
+		p.pos++
+	}
+	if p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+		return nil, fmt.Errorf("line %d: unexpected indentation", p.lines[p.pos].number)
+	}
+	return items, nil
+}
+
+// cutKey splits "key: value", where the colon is outside quotes and followed
+// by a space or the end of the line.
+func cutKey(s string) (key, rest string, ok bool) {
+	if s != "" && (s[0] == '"' || s[0] == '\'') {
+		end := strings.IndexByte(s[1:], s[0])
+		if end < 0 || !strings.HasPrefix(s[end+2:], ":") {
+			return "", "", false
+		}
+		after := s[end+3:]
+		if after != "" && after[0] != ' ' {
+			return "", "", false
+		}
+		return s[1 : end+1], strings.TrimSpace(after), true
+	}
+	for i := range len(s) {
+		if s[i] == ':' && (i == len(s)-1 || s[i+1] == ' ') {
+			return strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+// scalarOrList parses an inline value, which may be a [a, b] list.
+func scalarOrList(s string) (any, error) {
+	if !strings.HasPrefix(s, "[") {
+		return scalar(s)
+	}
+	if !strings.HasSuffix(s, "]") {
+		return nil, fmt.Errorf("unterminated list")
+	}
+	items := []any{}
+	inner := strings.TrimSpace(s[1 : len(s)-1])
+	if inner == "" {
+		return items, nil
+	}
+	for _, item := range splitList(inner) {
+		value, err := scalar(strings.TrimSpace(item))
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, value)
+	}
+	return items, nil
+}
+
+// splitList splits on commas outside quotes.
+func splitList(s string) []string {
+	var items []string
+	var quote byte
+	start := 0
+	for i := range len(s) {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			items = append(items, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(items, s[start:])
+}
+
+// scalar parses a plain or quoted value. Everything is returned as a string,
+// for kong to convert to the flag's type; null and ~ return nil.
+func scalar(s string) (any, error) {
+	switch {
+	case s == "" || s == "~" || s == "null":
+		return nil, nil
+	case s[0] == '"':
+		value, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quoted string %s", s)
+		}
+		return value, nil
+	case s[0] == '\'':
+		if len(s) < 2 || s[len(s)-1] != '\'' {
+			return nil, fmt.Errorf("invalid quoted string %s", s)
+		}
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	case s[0] == '|' || s[0] == '>' || s[0] == '{' || s[0] == '&' || s[0] == '*':
+		return nil, fmt.Errorf("unsupported value %s", s)
+	}
+	return s, nil
+}
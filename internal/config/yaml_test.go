@@ -0,0 +1,122 @@
+package config
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/kong"
+)
+
+func TestYAML(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		yaml string
+		want map[string]any
+	}{
+		{"empty", "", map[string]any{}},
+		{"scalars", "api-server: http://scheduler:18888\nconcurrency: 4\n", map[string]any{"api-server": "http://scheduler:18888", "concurrency": "4"}},
+		{"comments", "---\n# worker settings\nqueue: linux # the default\ntoken: 'a#b'\n", map[string]any{"queue": "linux", "token": "a#b"}},
+		{"quoted", `name: "say \"hi\""` + "\nother: 'it''s'\n\"quoted key\": x\n", map[string]any{"name": `say "hi"`, "other": "it's", "quoted key": "x"}},
+		{"null", "queue: ~\nimage: null\n", map[string]any{}},
+		{"section", "docker:\n  image: buildkite/agent:3\n  network: host\n", map[string]any{"docker": map[string]any{"image": "buildkite/agent:3", "network": "host"}}},
+		{"inline list", "tags: [os=linux, 'arch=arm64']\nnone: []\n", map[string]any{"tags": []any{"os=linux", "arch=arm64"}, "none": []any{}}},
+		{"block list", "tags:\n  - os=linux\n  - arch=arm64\nrules:\n- queue=default\n", map[string]any{"tags": []any{"os=linux", "arch=arm64"}, "rules": []any{"queue=default"}}},
+		{"empty section", "docker:\n", map[string]any{}},
+	} {
+		loaded, err := YAML(strings.NewReader(tc.yaml))
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if got := loaded.(*resolver).settings; !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %#v, want %#v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestYAMLErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		yaml string
+		want string
+	}{
+		{"tabs", "docker:\n\timage: x\n", "line 2: tabs aren't allowed"},
+		{"indented start", "  queue: x\n", "line 1: unexpected indentation"},
+		{"bad indentation", "queue: x\n  tags: y\n", "line 2: unexpected indentation"},
+		{"not a mapping", "queue\n", "line 1: expected key: value"},
+		{"top-level list", "- queue\n", "line 1: expected a key"},
+		{"duplicate", "queue: a\nqueue: b\n", `line 2: duplicate key "queue"`},
+		{"list of mappings", "tags:\n  - os: linux\n", "line 2: lists may only hold plain values"},
+		{"nested list", "tags:\n  - [a]\n", "line 2: lists may only hold plain values"},
+		{"unterminated list", "tags: [a, b\n", "line 1: unterminated list"},
+		{"bad quotes", "name: \"abc\n", "line 1: invalid quoted string"},
+		{"block scalar", "script: |\n", "line 1: unsupported value"},
+	} {
+		_, err := YAML(strings.NewReader(tc.yaml))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v, want an error containing %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+type testCLI struct {
+	Config      kong.ConfigFlag
+	Queue       string            `default:"default" env:"TEST_CONFIG_QUEUE"`
+	DockerImage string            `default:"buildkite/agent:3"`
+	Tags        []string          `sep:","`
+	Labels      map[string]string `sep:","`
+}
+
+func parse(t *testing.T, loader kong.ConfigurationLoader, settings string, args ...string) (*testCLI, error) {
+	t.Helper()
+	var cli testCLI
+	parser, err := kong.New(&cli, kong.Configuration(loader), kong.Exit(func(int) { t.Fatal("kong exited") }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := t.TempDir() + "/settings"
+	if err := os.WriteFile(path, []byte(settings), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = parser.Parse(append([]string{"--config=" + path}, args...))
+	return &cli, err
+}
+
+func TestYAMLResolver(t *testing.T) {
+	settings := "queue: from-file\ndocker:\n  image: custom:1\ntags: [os=linux, arch=arm64]\nlabels:\n  team: payments\n"
+
+	cli, err := parse(t, YAML, settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cli.Queue != "from-file" || cli.DockerImage != "custom:1" {
+		t.Errorf("got queue %q and image %q, want the file's", cli.Queue, cli.DockerImage)
+	}
+	if !reflect.DeepEqual(cli.Tags, []string{"os=linux", "arch=arm64"}) {
+		t.Errorf("got tags %v, want the file's", cli.Tags)
+	}
+	if !reflect.DeepEqual(cli.Labels, map[string]string{"team": "payments"}) {
+		t.Errorf("got labels %v, want the file's", cli.Labels)
+	}
+
+	// Flags and env vars override the file.
+	if cli, err = parse(t, YAML, settings, "--docker-image=flag:1"); err != nil {
+		t.Fatal(err)
+	}
+	if cli.DockerImage != "flag:1" {
+		t.Errorf("got image %q, want the flag's", cli.DockerImage)
+	}
+	t.Setenv("TEST_CONFIG_QUEUE", "from-env")
+	if cli, err = parse(t, YAML, settings); err != nil {
+		t.Fatal(err)
+	}
+	if cli.Queue != "from-env" {
+		t.Errorf("got queue %q, want the env var's", cli.Queue)
+	}
+
+	if _, err := parse(t, YAML, "docker:\n  imag: typo\n"); err == nil || !strings.Contains(err.Error(), `unknown setting "docker-imag"`) {
+		t.Errorf("got %v, want an unknown setting error", err)
+	}
+}
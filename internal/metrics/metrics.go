@@ -0,0 +1,321 @@
+// Package metrics records counters and histograms and serves them in the
+// Prometheus text exposition format.
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are histogram bucket bounds in seconds, suited to request
+// latencies.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry holds a set of metrics to expose together.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+// Default is the registry metrics are registered with unless another is
+// created.
+var Default = &Registry{}
+
+type metric interface {
+	name() string
+	write(ctx context.Context, w io.Writer)
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.metrics {
+		if existing.name() == m.name() {
+			panic(fmt.Sprintf("metric %s registered twice", m.name()))
+		}
+	}
+	r.metrics = append(r.metrics, m)
+}
+
+// Write writes every metric in the registry, sorted by name.
+func (r *Registry) Write(ctx context.Context, w io.Writer) {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].name() < metrics[j].name() })
+	for _, m := range metrics {
+		m.write(ctx, w)
+	}
+}
+
+// Handler serves the registry's metrics.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(req.Context(), w)
+	})
+}
+
+// family holds the label sets a metric has been recorded with.
+type family[T any] struct {
+	metricName string
+	help       string
+	labels     []string
+	newChild   func() *T
+
+	mu       sync.Mutex
+	children map[string]*T
+	values   map[string][]string
+}
+
+func newFamily[T any](name, help string, labels []string, newChild func() *T) family[T] {
+	return family[T]{
+		metricName: name,
+		help:       help,
+		labels:     labels,
+		newChild:   newChild,
+		children:   map[string]*T{},
+		values:     map[string][]string{},
+	}
+}
+
+func (f *family[T]) name() string { return f.metricName }
+
+func (f *family[T]) with(values []string) *T {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metric %s takes %d label values, got %d", f.metricName, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	child, ok := f.children[key]
+	if !ok {
+		child = f.newChild()
+		f.children[key] = child
+		f.values[key] = append([]string(nil), values...)
+	}
+	return child
+}
+
+// each calls fn for every label set, in a stable order.
+func (f *family[T]) each(fn func(labels string, child *T)) {
+	f.mu.Lock()
+	keys := make([]string, 0, len(f.children))
+	for key := range f.children {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	children := make([]*T, len(keys))
+	labels := make([]string, len(keys))
+	for i, key := range keys {
+		children[i] = f.children[key]
+		labels[i] = formatLabels(f.labels, f.values[key])
+	}
+	f.mu.Unlock()
+	for i := range keys {
+		fn(labels[i], children[i])
+	}
+}
+
+func (f *family[T]) writeHeader(w io.Writer, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.metricName, escapeHelp(f.help), f.metricName, kind)
+}
+
+// Counter is a value that only goes up.
+type Counter struct {
+	mu    sync.Mutex
+	value float64
+}
+
+func (c *Counter) Inc() { c.Add(1) }
+
+func (c *Counter) Add(v float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.value += v
+}
+
+func (c *Counter) get() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.value
+}
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct {
+	family[Counter]
+}
+
+// NewCounterVec registers a counter with the given label names. A counter
+// without labels is With().
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{newFamily(name, help, labels, func() *Counter { return &Counter{} })}
+	if len(labels) == 0 {
+		c.with(nil)
+	}
+	r.register(c)
+	return c
+}
+
+// With returns the counter for a set of label values, in the order the labels
+// were registered.
+func (c *CounterVec) With(values ...string) *Counter {
+	return c.with(values)
+}
+
+func (c *CounterVec) write(_ context.Context, w io.Writer) {
+	c.writeHeader(w, "counter")
+	c.each(func(labels string, counter *Counter) {
+		fmt.Fprintf(w, "%s%s %s\n", c.metricName, labels, formatValue(counter.get()))
+	})
+}
+
+// Histogram counts observations into buckets.
+type Histogram struct {
+	bounds []float64
+
+	mu     sync.Mutex
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// ObserveSince records the seconds elapsed since start.
+func (h *Histogram) ObserveSince(start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+// HistogramVec is a histogram partitioned by labels.
+type HistogramVec struct {
+	family[Histogram]
+}
+
+// NewHistogramVec registers a histogram with the given bucket upper bounds,
+// which must be sorted, and label names.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{newFamily(name, help, labels, func() *Histogram {
+		return &Histogram{bounds: buckets, counts: make([]uint64, len(buckets))}
+	})}
+	if len(labels) == 0 {
+		h.with(nil)
+	}
+	r.register(h)
+	return h
+}
+
+// With returns the histogram for a set of label values.
+func (h *HistogramVec) With(values ...string) *Histogram {
+	return h.with(values)
+}
+
+func (h *HistogramVec) write(_ context.Context, w io.Writer) {
+	h.writeHeader(w, "histogram")
+	h.each(func(labels string, hist *Histogram) {
+		hist.mu.Lock()
+		counts := append([]uint64(nil), hist.counts...)
+		sum, count := hist.sum, hist.count
+		hist.mu.Unlock()
+
+		for i, bound := range hist.bounds {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, withLabel(labels, "le", formatValue(bound)), counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, withLabel(labels, "le", "+Inf"), count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.metricName, labels, formatValue(sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.metricName, labels, count)
+	})
+}
+
+// Sample is one value of a gauge, for the gauge's label values.
+type Sample struct {
+	Labels []string
+	Value  float64
+}
+
+// GaugeFunc is a gauge whose values are collected when metrics are served.
+type GaugeFunc struct {
+	metricName string
+	help       string
+	labels     []string
+	timeout    time.Duration
+	collect    func(ctx context.Context) ([]Sample, error)
+}
+
+// NewGaugeFunc registers a gauge that calls collect for its values each time
+// metrics are served. If collect fails, the gauge is left out.
+func (r *Registry) NewGaugeFunc(name, help string, labels []string, collect func(ctx context.Context) ([]Sample, error)) *GaugeFunc {
+	g := &GaugeFunc{metricName: name, help: help, labels: labels, timeout: 5 * time.Second, collect: collect}
+	r.register(g)
+	return g
+}
+
+func (g *GaugeFunc) name() string { return g.metricName }
+
+func (g *GaugeFunc) write(ctx context.Context, w io.Writer) {
+	ctx, cancel := context.WithTimeout(ctx, g.timeout)
+	defer cancel()
+	samples, err := g.collect(ctx)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.metricName, escapeHelp(g.help), g.metricName)
+	for _, sample := range samples {
+		fmt.Fprintf(w, "%s%s %s\n", g.metricName, formatLabels(g.labels, sample.Labels), formatValue(sample.Value))
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + labelEscaper.Replace(values[i]) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func withLabel(labels, name, value string) string {
+	pair := name + `="` + labelEscaper.Replace(value) + `"`
+	if labels == "" {
+		return "{" + pair + "}"
+	}
+	return labels[:len(labels)-1] + "," + pair + "}"
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
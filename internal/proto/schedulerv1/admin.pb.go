@@ -0,0 +1,1708 @@
+// Admin and stats API for the scheduler, mirroring the operator endpoints of
+// the HTTP API. Timestamps are unset when the HTTP API would omit them.
+//
+// The generated Go code is checked in under internal/proto/schedulerv1;
+// regenerate it with:
+//
+//   protoc -I proto \
+//     --go_out=. --go_opt=module=github.com/buildkite/buildkite-custom-scheduler \
+//     --go-grpc_out=. --go-grpc_opt=module=github.com/buildkite/buildkite-custom-scheduler \
+//     scheduler/v1/admin.proto
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        v29.3.0
+// source: scheduler/v1/admin.proto
+
+package schedulerv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type GetStatsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetStatsRequest) Reset() {
+	*x = GetStatsRequest{}
+	mi := &file_scheduler_v1_admin_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetStatsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetStatsRequest) ProtoMessage() {}
+
+func (x *GetStatsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_scheduler_v1_admin_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetStatsRequest.ProtoReflect.Descriptor instead.
+func (*GetStatsRequest) Descriptor() ([]byte, []int) {
+	return file_scheduler_v1_admin_proto_rawDescGZIP(), []int{0}
+}
+
+type GetStatsResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Pending jobs by query rules.
+	Queues     map[string]int64 `protobuf:"bytes,1,rep,name=queues,proto3" json:"queues,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"varint,2,opt,name=value"`
+	Total      int64            `protobuf:"varint,2,opt,name=total,proto3" json:"total,omitempty"`
+	Delayed    int64            `protobuf:"varint,3,opt,name=delayed,proto3" json:"delayed,omitempty"`
+	DeadLetter int64            `protobuf:"varint,4,opt,name=dead_letter,json=deadLetter,proto3" json:"dead_letter,omitempty"`
+	// Paused queue keys, mapped to whether reserving is paused too.
+	PausedQueues map[string]bool `protobuf:"bytes,5,rep,name=paused_queues,json=pausedQueues,proto3" json:"paused_queues,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"varint,2,opt,name=value"`
+	// Queue keys this server's monitors poll.
+	MonitoredQueues []string `protobuf:"bytes,6,rep,name=monitored_queues,json=monitoredQueues,proto3" json:"monitored_queues,omitempty"`
+	// Divergences from Buildkite the reconciler has fixed, by kind.
+	Drift map[string]int64 `protobuf:"bytes,7,rep,name=drift,proto3" json:"drift,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"varint,2,opt,name=value"`
+	// Workers that have heartbeated within the worker timeout, and the job
+	// slots they have between them.
+	Workers  int64 `protobuf:"varint,8,opt,name=workers,proto3" json:"workers,omitempty"`
+	Capacity int64 `protobuf:"varint,9,opt,name=capacity,proto3" json:"capacity,omitempty"`
+	// Unhealthy worker IDs, mapped to why.
+	UnhealthyWorkers map[string]string `protobuf:"bytes,10,rep,name=unhealthy_workers,json=unhealthyWorkers,proto3" json:"unhealthy_workers,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *GetStatsResponse) Reset() {
+	*x = GetStatsResponse{}
+	mi := &file_scheduler_v1_admin_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetStatsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetStatsResponse) ProtoMessage() {}
+
+func (x *GetStatsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_scheduler_v1_admin_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetStatsResponse.ProtoReflect.Descriptor instead.
+func (*GetStatsResponse) Descriptor() ([]byte, []int) {
+	return file_scheduler_v1_admin_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *GetStatsResponse) GetQueues() map[string]int64 {
+	if x != nil {
+		return x.Queues
+	}
+	return nil
+}
+
+func (x *GetStatsResponse) GetTotal() int64 {
+	if x != nil {
+		return x.Total
+	}
+	return 0
+}
+
+func (x *GetStatsResponse) GetDelayed() int64 {
+	if x != nil {
+		return x.Delayed
+	}
+	return 0
+}
+
+func (x *GetStatsResponse) GetDeadLetter() int64 {
+	if x != nil {
+		return x.DeadLetter
+	}
+	return 0
+}
+
+func (x *GetStatsResponse) GetPausedQueues() map[string]bool {
+	if x != nil {
+		return x.PausedQueues
+	}
+	return nil
+}
+
+func (x *GetStatsResponse) GetMonitoredQueues() []string {
+	if x != nil {
+		return x.MonitoredQueues
+	}
+	return nil
+}
+
+func (x *GetStatsResponse) GetDrift() map[string]int64 {
+	if x != nil {
+		return x.Drift
+	}
+	return nil
+}
+
+func (x *GetStatsResponse) GetWorkers() int64 {
+	if x != nil {
+		return x.Workers
+	}
+	return 0
+}
+
+func (x *GetStatsResponse) GetCapacity() int64 {
+	if x != nil {
+		return x.Capacity
+	}
+	return 0
+}
+
+func (x *GetStatsResponse) GetUnhealthyWorkers() map[string]string {
+	if x != nil {
+		return x.UnhealthyWorkers
+	}
+	return nil
+}
+
+type Job struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	Uuid            string                 `protobuf:"bytes,1,opt,name=uuid,proto3" json:"uuid,omitempty"`
+	QueueKey        string                 `protobuf:"bytes,2,opt,name=queue_key,json=queueKey,proto3" json:"queue_key,omitempty"`
+	BuildUuid       string                 `protobuf:"bytes,3,opt,name=build_uuid,json=buildUuid,proto3" json:"build_uuid,omitempty"`
+	PipelineSlug    string                 `protobuf:"bytes,4,opt,name=pipeline_slug,json=pipelineSlug,proto3" json:"pipeline_slug,omitempty"`
+	AgentQueryRules []string               `protobuf:"bytes,5,rep,name=agent_query_rules,json=agentQueryRules,proto3" json:"agent_query_rules,omitempty"`
+	Priority        int32                  `protobuf:"varint,6,opt,name=priority,proto3" json:"priority,omitempty"`
+	ScheduledAt     *timestamppb.Timestamp `protobuf:"bytes,7,opt,name=scheduled_at,json=scheduledAt,proto3" json:"scheduled_at,omitempty"`
+	ReservedAt      *timestamppb.Timestamp `protobuf:"bytes,8,opt,name=reserved_at,json=reservedAt,proto3" json:"reserved_at,omitempty"`
+	NotBefore       *timestamppb.Timestamp `protobuf:"bytes,9,opt,name=not_before,json=notBefore,proto3" json:"not_before,omitempty"`
+	StackKey        string                 `protobuf:"bytes,10,opt,name=stack_key,json=stackKey,proto3" json:"stack_key,omitempty"`
+	Resources       map[string]float64     `protobuf:"bytes,11,rep,name=resources,proto3" json:"resources,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"fixed64,2,opt,name=value"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *Job) Reset() {
+	*x = Job{}
+	mi := &file_scheduler_v1_admin_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Job) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Job) ProtoMessage() {}
+
+func (x *Job) ProtoReflect() protoreflect.Message {
+	mi := &file_scheduler_v1_admin_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Job.ProtoReflect.Descriptor instead.
+func (*Job) Descriptor() ([]byte, []int) {
+	return file_scheduler_v1_admin_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Job) GetUuid() string {
+	if x != nil {
+		return x.Uuid
+	}
+	return ""
+}
+
+func (x *Job) GetQueueKey() string {
+	if x != nil {
+		return x.QueueKey
+	}
+	return ""
+}
+
+func (x *Job) GetBuildUuid() string {
+	if x != nil {
+		return x.BuildUuid
+	}
+	return ""
+}
+
+func (x *Job) GetPipelineSlug() string {
+	if x != nil {
+		return x.PipelineSlug
+	}
+	return ""
+}
+
+func (x *Job) GetAgentQueryRules() []string {
+	if x != nil {
+		return x.AgentQueryRules
+	}
+	return nil
+}
+
+func (x *Job) GetPriority() int32 {
+	if x != nil {
+		return x.Priority
+	}
+	return 0
+}
+
+func (x *Job) GetScheduledAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ScheduledAt
+	}
+	return nil
+}
+
+func (x *Job) GetReservedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ReservedAt
+	}
+	return nil
+}
+
+func (x *Job) GetNotBefore() *timestamppb.Timestamp {
+	if x != nil {
+		return x.NotBefore
+	}
+	return nil
+}
+
+func (x *Job) GetStackKey() string {
+	if x != nil {
+		return x.StackKey
+	}
+	return ""
+}
+
+func (x *Job) GetResources() map[string]float64 {
+	if x != nil {
+		return x.Resources
+	}
+	return nil
+}
+
+type JobFailure struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Error         string                 `protobuf:"bytes,1,opt,name=error,proto3" json:"error,omitempty"`
+	WorkerId      string                 `protobuf:"bytes,2,opt,name=worker_id,json=workerId,proto3" json:"worker_id,omitempty"`
+	FailedAt      *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=failed_at,json=failedAt,proto3" json:"failed_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *JobFailure) Reset() {
+	*x = JobFailure{}
+	mi := &file_scheduler_v1_admin_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *JobFailure) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*JobFailure) ProtoMessage() {}
+
+func (x *JobFailure) ProtoReflect() protoreflect.Message {
+	mi := &file_scheduler_v1_admin_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use JobFailure.ProtoReflect.Descriptor instead.
+func (*JobFailure) Descriptor() ([]byte, []int) {
+	return file_scheduler_v1_admin_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *JobFailure) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *JobFailure) GetWorkerId() string {
+	if x != nil {
+		return x.WorkerId
+	}
+	return ""
+}
+
+func (x *JobFailure) GetFailedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.FailedAt
+	}
+	return nil
+}
+
+type JobDetail struct {
+	state                protoimpl.MessageState `protogen:"open.v1"`
+	Job                  *Job                   `protobuf:"bytes,1,opt,name=job,proto3" json:"job,omitempty"`
+	Status               string                 `protobuf:"bytes,2,opt,name=status,proto3" json:"status,omitempty"`
+	ClaimedBy            string                 `protobuf:"bytes,3,opt,name=claimed_by,json=claimedBy,proto3" json:"claimed_by,omitempty"`
+	Attempts             int32                  `protobuf:"varint,4,opt,name=attempts,proto3" json:"attempts,omitempty"`
+	ClaimedAt            *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=claimed_at,json=claimedAt,proto3" json:"claimed_at,omitempty"`
+	StartedAt            *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=started_at,json=startedAt,proto3" json:"started_at,omitempty"`
+	CompletedAt          *timestamppb.Timestamp `protobuf:"bytes,7,opt,name=completed_at,json=completedAt,proto3" json:"completed_at,omitempty"`
+	LeaseExpiresAt       *timestamppb.Timestamp `protobuf:"bytes,8,opt,name=lease_expires_at,json=leaseExpiresAt,proto3" json:"lease_expires_at,omitempty"`
+	ReservationExpiresAt *timestamppb.Timestamp `protobuf:"bytes,9,opt,name=reservation_expires_at,json=reservationExpiresAt,proto3" json:"reservation_expires_at,omitempty"`
+	Failures             []*JobFailure          `protobuf:"bytes,10,rep,name=failures,proto3" json:"failures,omitempty"`
+	unknownFields        protoimpl.UnknownFields
+	sizeCache            protoimpl.SizeCache
+}
+
+func (x *JobDetail) Reset() {
+	*x = JobDetail{}
+	mi := &file_scheduler_v1_admin_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *JobDetail) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*JobDetail) ProtoMessage() {}
+
+func (x *JobDetail) ProtoReflect() protoreflect.Message {
+	mi := &file_scheduler_v1_admin_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use JobDetail.ProtoReflect.Descriptor instead.
+func (*JobDetail) Descriptor() ([]byte, []int) {
+	return file_scheduler_v1_admin_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *JobDetail) GetJob() *Job {
+	if x != nil {
+		return x.Job
+	}
+	return nil
+}
+
+func (x *JobDetail) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *JobDetail) GetClaimedBy() string {
+	if x != nil {
+		return x.ClaimedBy
+	}
+	return ""
+}
+
+func (x *JobDetail) GetAttempts() int32 {
+	if x != nil {
+		return x.Attempts
+	}
+	return 0
+}
+
+func (x *JobDetail) GetClaimedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ClaimedAt
+	}
+	return nil
+}
+
+func (x *JobDetail) GetStartedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.StartedAt
+	}
+	return nil
+}
+
+func (x *JobDetail) GetCompletedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CompletedAt
+	}
+	return nil
+}
+
+func (x *JobDetail) GetLeaseExpiresAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.LeaseExpiresAt
+	}
+	return nil
+}
+
+func (x *JobDetail) GetReservationExpiresAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ReservationExpiresAt
+	}
+	return nil
+}
+
+func (x *JobDetail) GetFailures() []*JobFailure {
+	if x != nil {
+		return x.Failures
+	}
+	return nil
+}
+
+type QueueSummary struct {
+	state               protoimpl.MessageState `protogen:"open.v1"`
+	QueryRules          string                 `protobuf:"bytes,1,opt,name=query_rules,json=queryRules,proto3" json:"query_rules,omitempty"`
+	Depth               int64                  `protobuf:"varint,2,opt,name=depth,proto3" json:"depth,omitempty"`
+	OldestJobAgeSeconds float64                `protobuf:"fixed64,3,opt,name=oldest_job_age_seconds,json=oldestJobAgeSeconds,proto3" json:"oldest_job_age_seconds,omitempty"`
+	ClaimsPerMinute     float64                `protobuf:"fixed64,4,opt,name=claims_per_minute,json=claimsPerMinute,proto3" json:"claims_per_minute,omitempty"`
+	unknownFields       protoimpl.UnknownFields
+	sizeCache           protoimpl.SizeCache
+}
+
+func (x *QueueSummary) Reset() {
+	*x = QueueSummary{}
+	mi := &file_scheduler_v1_admin_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *QueueSummary) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*QueueSummary) ProtoMessage() {}
+
+func (x *QueueSummary) ProtoReflect() protoreflect.Message {
+	mi := &file_scheduler_v1_admin_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use QueueSummary.ProtoReflect.Descriptor instead.
+func (*QueueSummary) Descriptor() ([]byte, []int) {
+	return file_scheduler_v1_admin_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *QueueSummary) GetQueryRules() string {
+	if x != nil {
+		return x.QueryRules
+	}
+	return ""
+}
+
+func (x *QueueSummary) GetDepth() int64 {
+	if x != nil {
+		return x.Depth
+	}
+	return 0
+}
+
+func (x *QueueSummary) GetOldestJobAgeSeconds() float64 {
+	if x != nil {
+		return x.OldestJobAgeSeconds
+	}
+	return 0
+}
+
+func (x *QueueSummary) GetClaimsPerMinute() float64 {
+	if x != nil {
+		return x.ClaimsPerMinute
+	}
+	return 0
+}
+
+type ListQueuesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListQueuesRequest) Reset() {
+	*x = ListQueuesRequest{}
+	mi := &file_scheduler_v1_admin_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListQueuesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListQueuesRequest) ProtoMessage() {}
+
+func (x *ListQueuesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_scheduler_v1_admin_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListQueuesRequest.ProtoReflect.Descriptor instead.
+func (*ListQueuesRequest) Descriptor() ([]byte, []int) {
+	return file_scheduler_v1_admin_proto_rawDescGZIP(), []int{6}
+}
+
+type ListQueuesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Queues        []*QueueSummary        `protobuf:"bytes,1,rep,name=queues,proto3" json:"queues,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListQueuesResponse) Reset() {
+	*x = ListQueuesResponse{}
+	mi := &file_scheduler_v1_admin_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListQueuesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListQueuesResponse) ProtoMessage() {}
+
+func (x *ListQueuesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_scheduler_v1_admin_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListQueuesResponse.ProtoReflect.Descriptor instead.
+func (*ListQueuesResponse) Descriptor() ([]byte, []int) {
+	return file_scheduler_v1_admin_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *ListQueuesResponse) GetQueues() []*QueueSummary {
+	if x != nil {
+		return x.Queues
+	}
+	return nil
+}
+
+type QueuedJob struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Job           *Job                   `protobuf:"bytes,1,opt,name=job,proto3" json:"job,omitempty"`
+	Status        string                 `protobuf:"bytes,2,opt,name=status,proto3" json:"status,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *QueuedJob) Reset() {
+	*x = QueuedJob{}
+	mi := &file_scheduler_v1_admin_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *QueuedJob) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*QueuedJob) ProtoMessage() {}
+
+func (x *QueuedJob) ProtoReflect() protoreflect.Message {
+	mi := &file_scheduler_v1_admin_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use QueuedJob.ProtoReflect.Descriptor instead.
+func (*QueuedJob) Descriptor() ([]byte, []int) {
+	return file_scheduler_v1_admin_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *QueuedJob) GetJob() *Job {
+	if x != nil {
+		return x.Job
+	}
+	return nil
+}
+
+func (x *QueuedJob) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+type ListQueueJobsRequest struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	QueueKey string                 `protobuf:"bytes,1,opt,name=queue_key,json=queueKey,proto3" json:"queue_key,omitempty"`
+	// Cursor from a previous page's next_cursor.
+	Cursor string `protobuf:"bytes,2,opt,name=cursor,proto3" json:"cursor,omitempty"`
+	// Defaults to 50, at most 500.
+	Limit         int32 `protobuf:"varint,3,opt,name=limit,proto3" json:"limit,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListQueueJobsRequest) Reset() {
+	*x = ListQueueJobsRequest{}
+	mi := &file_scheduler_v1_admin_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListQueueJobsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListQueueJobsRequest) ProtoMessage() {}
+
+func (x *ListQueueJobsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_scheduler_v1_admin_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListQueueJobsRequest.ProtoReflect.Descriptor instead.
+func (*ListQueueJobsRequest) Descriptor() ([]byte, []int) {
+	return file_scheduler_v1_admin_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *ListQueueJobsRequest) GetQueueKey() string {
+	if x != nil {
+		return x.QueueKey
+	}
+	return ""
+}
+
+func (x *ListQueueJobsRequest) GetCursor() string {
+	if x != nil {
+		return x.Cursor
+	}
+	return ""
+}
+
+func (x *ListQueueJobsRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+type ListQueueJobsResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Jobs  []*QueuedJob           `protobuf:"bytes,1,rep,name=jobs,proto3" json:"jobs,omitempty"`
+	// Empty on the last page.
+	NextCursor    string `protobuf:"bytes,2,opt,name=next_cursor,json=nextCursor,proto3" json:"next_cursor,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListQueueJobsResponse) Reset() {
+	*x = ListQueueJobsResponse{}
+	mi := &file_scheduler_v1_admin_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListQueueJobsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListQueueJobsResponse) ProtoMessage() {}
+
+func (x *ListQueueJobsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_scheduler_v1_admin_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListQueueJobsResponse.ProtoReflect.Descriptor instead.
+func (*ListQueueJobsResponse) Descriptor() ([]byte, []int) {
+	return file_scheduler_v1_admin_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *ListQueueJobsResponse) GetJobs() []*QueuedJob {
+	if x != nil {
+		return x.Jobs
+	}
+	return nil
+}
+
+func (x *ListQueueJobsResponse) GetNextCursor() string {
+	if x != nil {
+		return x.NextCursor
+	}
+	return ""
+}
+
+type PauseQueueRequest struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	QueueKey string                 `protobuf:"bytes,1,opt,name=queue_key,json=queueKey,proto3" json:"queue_key,omitempty"`
+	// Also stop reserving the queue's jobs from Buildkite.
+	StopReserving bool `protobuf:"varint,2,opt,name=stop_reserving,json=stopReserving,proto3" json:"stop_reserving,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PauseQueueRequest) Reset() {
+	*x = PauseQueueRequest{}
+	mi := &file_scheduler_v1_admin_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PauseQueueRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PauseQueueRequest) ProtoMessage() {}
+
+func (x *PauseQueueRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_scheduler_v1_admin_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PauseQueueRequest.ProtoReflect.Descriptor instead.
+func (*PauseQueueRequest) Descriptor() ([]byte, []int) {
+	return file_scheduler_v1_admin_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *PauseQueueRequest) GetQueueKey() string {
+	if x != nil {
+		return x.QueueKey
+	}
+	return ""
+}
+
+func (x *PauseQueueRequest) GetStopReserving() bool {
+	if x != nil {
+		return x.StopReserving
+	}
+	return false
+}
+
+type PauseQueueResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PauseQueueResponse) Reset() {
+	*x = PauseQueueResponse{}
+	mi := &file_scheduler_v1_admin_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PauseQueueResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PauseQueueResponse) ProtoMessage() {}
+
+func (x *PauseQueueResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_scheduler_v1_admin_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PauseQueueResponse.ProtoReflect.Descriptor instead.
+func (*PauseQueueResponse) Descriptor() ([]byte, []int) {
+	return file_scheduler_v1_admin_proto_rawDescGZIP(), []int{12}
+}
+
+type ResumeQueueRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	QueueKey      string                 `protobuf:"bytes,1,opt,name=queue_key,json=queueKey,proto3" json:"queue_key,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ResumeQueueRequest) Reset() {
+	*x = ResumeQueueRequest{}
+	mi := &file_scheduler_v1_admin_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ResumeQueueRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ResumeQueueRequest) ProtoMessage() {}
+
+func (x *ResumeQueueRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_scheduler_v1_admin_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ResumeQueueRequest.ProtoReflect.Descriptor instead.
+func (*ResumeQueueRequest) Descriptor() ([]byte, []int) {
+	return file_scheduler_v1_admin_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *ResumeQueueRequest) GetQueueKey() string {
+	if x != nil {
+		return x.QueueKey
+	}
+	return ""
+}
+
+type ResumeQueueResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ResumeQueueResponse) Reset() {
+	*x = ResumeQueueResponse{}
+	mi := &file_scheduler_v1_admin_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ResumeQueueResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ResumeQueueResponse) ProtoMessage() {}
+
+func (x *ResumeQueueResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_scheduler_v1_admin_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ResumeQueueResponse.ProtoReflect.Descriptor instead.
+func (*ResumeQueueResponse) Descriptor() ([]byte, []int) {
+	return file_scheduler_v1_admin_proto_rawDescGZIP(), []int{14}
+}
+
+type GetJobRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Uuid          string                 `protobuf:"bytes,1,opt,name=uuid,proto3" json:"uuid,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetJobRequest) Reset() {
+	*x = GetJobRequest{}
+	mi := &file_scheduler_v1_admin_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetJobRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetJobRequest) ProtoMessage() {}
+
+func (x *GetJobRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_scheduler_v1_admin_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetJobRequest.ProtoReflect.Descriptor instead.
+func (*GetJobRequest) Descriptor() ([]byte, []int) {
+	return file_scheduler_v1_admin_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *GetJobRequest) GetUuid() string {
+	if x != nil {
+		return x.Uuid
+	}
+	return ""
+}
+
+type RequeueJobRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Uuid          string                 `protobuf:"bytes,1,opt,name=uuid,proto3" json:"uuid,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RequeueJobRequest) Reset() {
+	*x = RequeueJobRequest{}
+	mi := &file_scheduler_v1_admin_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RequeueJobRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RequeueJobRequest) ProtoMessage() {}
+
+func (x *RequeueJobRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_scheduler_v1_admin_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RequeueJobRequest.ProtoReflect.Descriptor instead.
+func (*RequeueJobRequest) Descriptor() ([]byte, []int) {
+	return file_scheduler_v1_admin_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *RequeueJobRequest) GetUuid() string {
+	if x != nil {
+		return x.Uuid
+	}
+	return ""
+}
+
+type RequeueJobResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RequeueJobResponse) Reset() {
+	*x = RequeueJobResponse{}
+	mi := &file_scheduler_v1_admin_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RequeueJobResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RequeueJobResponse) ProtoMessage() {}
+
+func (x *RequeueJobResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_scheduler_v1_admin_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RequeueJobResponse.ProtoReflect.Descriptor instead.
+func (*RequeueJobResponse) Descriptor() ([]byte, []int) {
+	return file_scheduler_v1_admin_proto_rawDescGZIP(), []int{17}
+}
+
+type AbandonJobRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Uuid          string                 `protobuf:"bytes,1,opt,name=uuid,proto3" json:"uuid,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AbandonJobRequest) Reset() {
+	*x = AbandonJobRequest{}
+	mi := &file_scheduler_v1_admin_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AbandonJobRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AbandonJobRequest) ProtoMessage() {}
+
+func (x *AbandonJobRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_scheduler_v1_admin_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AbandonJobRequest.ProtoReflect.Descriptor instead.
+func (*AbandonJobRequest) Descriptor() ([]byte, []int) {
+	return file_scheduler_v1_admin_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *AbandonJobRequest) GetUuid() string {
+	if x != nil {
+		return x.Uuid
+	}
+	return ""
+}
+
+type AbandonJobResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AbandonJobResponse) Reset() {
+	*x = AbandonJobResponse{}
+	mi := &file_scheduler_v1_admin_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AbandonJobResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AbandonJobResponse) ProtoMessage() {}
+
+func (x *AbandonJobResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_scheduler_v1_admin_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AbandonJobResponse.ProtoReflect.Descriptor instead.
+func (*AbandonJobResponse) Descriptor() ([]byte, []int) {
+	return file_scheduler_v1_admin_proto_rawDescGZIP(), []int{19}
+}
+
+type WorkerInfo struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Hostname      string                 `protobuf:"bytes,2,opt,name=hostname,proto3" json:"hostname,omitempty"`
+	QueryRules    []string               `protobuf:"bytes,3,rep,name=query_rules,json=queryRules,proto3" json:"query_rules,omitempty"`
+	Tags          []string               `protobuf:"bytes,4,rep,name=tags,proto3" json:"tags,omitempty"`
+	Executor      string                 `protobuf:"bytes,5,opt,name=executor,proto3" json:"executor,omitempty"`
+	Concurrency   int32                  `protobuf:"varint,6,opt,name=concurrency,proto3" json:"concurrency,omitempty"`
+	Resources     []string               `protobuf:"bytes,7,rep,name=resources,proto3" json:"resources,omitempty"`
+	Status        string                 `protobuf:"bytes,8,opt,name=status,proto3" json:"status,omitempty"`
+	LastSeen      *timestamppb.Timestamp `protobuf:"bytes,9,opt,name=last_seen,json=lastSeen,proto3" json:"last_seen,omitempty"`
+	RegisteredAt  *timestamppb.Timestamp `protobuf:"bytes,10,opt,name=registered_at,json=registeredAt,proto3" json:"registered_at,omitempty"`
+	Health        string                 `protobuf:"bytes,11,opt,name=health,proto3" json:"health,omitempty"`
+	HealthReason  string                 `protobuf:"bytes,12,opt,name=health_reason,json=healthReason,proto3" json:"health_reason,omitempty"`
+	ClaimedJobs   []string               `protobuf:"bytes,13,rep,name=claimed_jobs,json=claimedJobs,proto3" json:"claimed_jobs,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WorkerInfo) Reset() {
+	*x = WorkerInfo{}
+	mi := &file_scheduler_v1_admin_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WorkerInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WorkerInfo) ProtoMessage() {}
+
+func (x *WorkerInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_scheduler_v1_admin_proto_msgTypes[20]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WorkerInfo.ProtoReflect.Descriptor instead.
+func (*WorkerInfo) Descriptor() ([]byte, []int) {
+	return file_scheduler_v1_admin_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *WorkerInfo) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *WorkerInfo) GetHostname() string {
+	if x != nil {
+		return x.Hostname
+	}
+	return ""
+}
+
+func (x *WorkerInfo) GetQueryRules() []string {
+	if x != nil {
+		return x.QueryRules
+	}
+	return nil
+}
+
+func (x *WorkerInfo) GetTags() []string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+func (x *WorkerInfo) GetExecutor() string {
+	if x != nil {
+		return x.Executor
+	}
+	return ""
+}
+
+func (x *WorkerInfo) GetConcurrency() int32 {
+	if x != nil {
+		return x.Concurrency
+	}
+	return 0
+}
+
+func (x *WorkerInfo) GetResources() []string {
+	if x != nil {
+		return x.Resources
+	}
+	return nil
+}
+
+func (x *WorkerInfo) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *WorkerInfo) GetLastSeen() *timestamppb.Timestamp {
+	if x != nil {
+		return x.LastSeen
+	}
+	return nil
+}
+
+func (x *WorkerInfo) GetRegisteredAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.RegisteredAt
+	}
+	return nil
+}
+
+func (x *WorkerInfo) GetHealth() string {
+	if x != nil {
+		return x.Health
+	}
+	return ""
+}
+
+func (x *WorkerInfo) GetHealthReason() string {
+	if x != nil {
+		return x.HealthReason
+	}
+	return ""
+}
+
+func (x *WorkerInfo) GetClaimedJobs() []string {
+	if x != nil {
+		return x.ClaimedJobs
+	}
+	return nil
+}
+
+type ListWorkersRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListWorkersRequest) Reset() {
+	*x = ListWorkersRequest{}
+	mi := &file_scheduler_v1_admin_proto_msgTypes[21]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListWorkersRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListWorkersRequest) ProtoMessage() {}
+
+func (x *ListWorkersRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_scheduler_v1_admin_proto_msgTypes[21]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListWorkersRequest.ProtoReflect.Descriptor instead.
+func (*ListWorkersRequest) Descriptor() ([]byte, []int) {
+	return file_scheduler_v1_admin_proto_rawDescGZIP(), []int{21}
+}
+
+type ListWorkersResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Workers       []*WorkerInfo          `protobuf:"bytes,1,rep,name=workers,proto3" json:"workers,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListWorkersResponse) Reset() {
+	*x = ListWorkersResponse{}
+	mi := &file_scheduler_v1_admin_proto_msgTypes[22]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListWorkersResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListWorkersResponse) ProtoMessage() {}
+
+func (x *ListWorkersResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_scheduler_v1_admin_proto_msgTypes[22]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListWorkersResponse.ProtoReflect.Descriptor instead.
+func (*ListWorkersResponse) Descriptor() ([]byte, []int) {
+	return file_scheduler_v1_admin_proto_rawDescGZIP(), []int{22}
+}
+
+func (x *ListWorkersResponse) GetWorkers() []*WorkerInfo {
+	if x != nil {
+		return x.Workers
+	}
+	return nil
+}
+
+type GetWorkerRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetWorkerRequest) Reset() {
+	*x = GetWorkerRequest{}
+	mi := &file_scheduler_v1_admin_proto_msgTypes[23]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetWorkerRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetWorkerRequest) ProtoMessage() {}
+
+func (x *GetWorkerRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_scheduler_v1_admin_proto_msgTypes[23]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetWorkerRequest.ProtoReflect.Descriptor instead.
+func (*GetWorkerRequest) Descriptor() ([]byte, []int) {
+	return file_scheduler_v1_admin_proto_rawDescGZIP(), []int{23}
+}
+
+func (x *GetWorkerRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+var File_scheduler_v1_admin_proto protoreflect.FileDescriptor
+
+const file_scheduler_v1_admin_proto_rawDesc = "" +
+	"\n" +
+	"\x18scheduler/v1/admin.proto\x12\fscheduler.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\x11\n" +
+	"\x0fGetStatsRequest\"\xfe\x05\n" +
+	"\x10GetStatsResponse\x12B\n" +
+	"\x06queues\x18\x01 \x03(\v2*.scheduler.v1.GetStatsResponse.QueuesEntryR\x06queues\x12\x14\n" +
+	"\x05total\x18\x02 \x01(\x03R\x05total\x12\x18\n" +
+	"\adelayed\x18\x03 \x01(\x03R\adelayed\x12\x1f\n" +
+	"\vdead_letter\x18\x04 \x01(\x03R\n" +
+	"deadLetter\x12U\n" +
+	"\rpaused_queues\x18\x05 \x03(\v20.scheduler.v1.GetStatsResponse.PausedQueuesEntryR\fpausedQueues\x12)\n" +
+	"\x10monitored_queues\x18\x06 \x03(\tR\x0fmonitoredQueues\x12?\n" +
+	"\x05drift\x18\a \x03(\v2).scheduler.v1.GetStatsResponse.DriftEntryR\x05drift\x12\x18\n" +
+	"\aworkers\x18\b \x01(\x03R\aworkers\x12\x1a\n" +
+	"\bcapacity\x18\t \x01(\x03R\bcapacity\x12a\n" +
+	"\x11unhealthy_workers\x18\n" +
+	" \x03(\v24.scheduler.v1.GetStatsResponse.UnhealthyWorkersEntryR\x10unhealthyWorkers\x1a9\n" +
+	"\vQueuesEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\x03R\x05value:\x028\x01\x1a?\n" +
+	"\x11PausedQueuesEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\bR\x05value:\x028\x01\x1a8\n" +
+	"\n" +
+	"DriftEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\x03R\x05value:\x028\x01\x1aC\n" +
+	"\x15UnhealthyWorkersEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\x94\x04\n" +
+	"\x03Job\x12\x12\n" +
+	"\x04uuid\x18\x01 \x01(\tR\x04uuid\x12\x1b\n" +
+	"\tqueue_key\x18\x02 \x01(\tR\bqueueKey\x12\x1d\n" +
+	"\n" +
+	"build_uuid\x18\x03 \x01(\tR\tbuildUuid\x12#\n" +
+	"\rpipeline_slug\x18\x04 \x01(\tR\fpipelineSlug\x12*\n" +
+	"\x11agent_query_rules\x18\x05 \x03(\tR\x0fagentQueryRules\x12\x1a\n" +
+	"\bpriority\x18\x06 \x01(\x05R\bpriority\x12=\n" +
+	"\fscheduled_at\x18\a \x01(\v2\x1a.google.protobuf.TimestampR\vscheduledAt\x12;\n" +
+	"\vreserved_at\x18\b \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"reservedAt\x129\n" +
+	"\n" +
+	"not_before\x18\t \x01(\v2\x1a.google.protobuf.TimestampR\tnotBefore\x12\x1b\n" +
+	"\tstack_key\x18\n" +
+	" \x01(\tR\bstackKey\x12>\n" +
+	"\tresources\x18\v \x03(\v2 .scheduler.v1.Job.ResourcesEntryR\tresources\x1a<\n" +
+	"\x0eResourcesEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\x01R\x05value:\x028\x01\"x\n" +
+	"\n" +
+	"JobFailure\x12\x14\n" +
+	"\x05error\x18\x01 \x01(\tR\x05error\x12\x1b\n" +
+	"\tworker_id\x18\x02 \x01(\tR\bworkerId\x127\n" +
+	"\tfailed_at\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\bfailedAt\"\x86\x04\n" +
+	"\tJobDetail\x12#\n" +
+	"\x03job\x18\x01 \x01(\v2\x11.scheduler.v1.JobR\x03job\x12\x16\n" +
+	"\x06status\x18\x02 \x01(\tR\x06status\x12\x1d\n" +
+	"\n" +
+	"claimed_by\x18\x03 \x01(\tR\tclaimedBy\x12\x1a\n" +
+	"\battempts\x18\x04 \x01(\x05R\battempts\x129\n" +
+	"\n" +
+	"claimed_at\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\tclaimedAt\x129\n" +
+	"\n" +
+	"started_at\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\tstartedAt\x12=\n" +
+	"\fcompleted_at\x18\a \x01(\v2\x1a.google.protobuf.TimestampR\vcompletedAt\x12D\n" +
+	"\x10lease_expires_at\x18\b \x01(\v2\x1a.google.protobuf.TimestampR\x0eleaseExpiresAt\x12P\n" +
+	"\x16reservation_expires_at\x18\t \x01(\v2\x1a.google.protobuf.TimestampR\x14reservationExpiresAt\x124\n" +
+	"\bfailures\x18\n" +
+	" \x03(\v2\x18.scheduler.v1.JobFailureR\bfailures\"\xa6\x01\n" +
+	"\fQueueSummary\x12\x1f\n" +
+	"\vquery_rules\x18\x01 \x01(\tR\n" +
+	"queryRules\x12\x14\n" +
+	"\x05depth\x18\x02 \x01(\x03R\x05depth\x123\n" +
+	"\x16oldest_job_age_seconds\x18\x03 \x01(\x01R\x13oldestJobAgeSeconds\x12*\n" +
+	"\x11claims_per_minute\x18\x04 \x01(\x01R\x0fclaimsPerMinute\"\x13\n" +
+	"\x11ListQueuesRequest\"H\n" +
+	"\x12ListQueuesResponse\x122\n" +
+	"\x06queues\x18\x01 \x03(\v2\x1a.scheduler.v1.QueueSummaryR\x06queues\"H\n" +
+	"\tQueuedJob\x12#\n" +
+	"\x03job\x18\x01 \x01(\v2\x11.scheduler.v1.JobR\x03job\x12\x16\n" +
+	"\x06status\x18\x02 \x01(\tR\x06status\"a\n" +
+	"\x14ListQueueJobsRequest\x12\x1b\n" +
+	"\tqueue_key\x18\x01 \x01(\tR\bqueueKey\x12\x16\n" +
+	"\x06cursor\x18\x02 \x01(\tR\x06cursor\x12\x14\n" +
+	"\x05limit\x18\x03 \x01(\x05R\x05limit\"e\n" +
+	"\x15ListQueueJobsResponse\x12+\n" +
+	"\x04jobs\x18\x01 \x03(\v2\x17.scheduler.v1.QueuedJobR\x04jobs\x12\x1f\n" +
+	"\vnext_cursor\x18\x02 \x01(\tR\n" +
+	"nextCursor\"W\n" +
+	"\x11PauseQueueRequest\x12\x1b\n" +
+	"\tqueue_key\x18\x01 \x01(\tR\bqueueKey\x12%\n" +
+	"\x0estop_reserving\x18\x02 \x01(\bR\rstopReserving\"\x14\n" +
+	"\x12PauseQueueResponse\"1\n" +
+	"\x12ResumeQueueRequest\x12\x1b\n" +
+	"\tqueue_key\x18\x01 \x01(\tR\bqueueKey\"\x15\n" +
+	"\x13ResumeQueueResponse\"#\n" +
+	"\rGetJobRequest\x12\x12\n" +
+	"\x04uuid\x18\x01 \x01(\tR\x04uuid\"'\n" +
+	"\x11RequeueJobRequest\x12\x12\n" +
+	"\x04uuid\x18\x01 \x01(\tR\x04uuid\"\x14\n" +
+	"\x12RequeueJobResponse\"'\n" +
+	"\x11AbandonJobRequest\x12\x12\n" +
+	"\x04uuid\x18\x01 \x01(\tR\x04uuid\"\x14\n" +
+	"\x12AbandonJobResponse\"\xbb\x03\n" +
+	"\n" +
+	"WorkerInfo\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1a\n" +
+	"\bhostname\x18\x02 \x01(\tR\bhostname\x12\x1f\n" +
+	"\vquery_rules\x18\x03 \x03(\tR\n" +
+	"queryRules\x12\x12\n" +
+	"\x04tags\x18\x04 \x03(\tR\x04tags\x12\x1a\n" +
+	"\bexecutor\x18\x05 \x01(\tR\bexecutor\x12 \n" +
+	"\vconcurrency\x18\x06 \x01(\x05R\vconcurrency\x12\x1c\n" +
+	"\tresources\x18\a \x03(\tR\tresources\x12\x16\n" +
+	"\x06status\x18\b \x01(\tR\x06status\x127\n" +
+	"\tlast_seen\x18\t \x01(\v2\x1a.google.protobuf.TimestampR\blastSeen\x12?\n" +
+	"\rregistered_at\x18\n" +
+	" \x01(\v2\x1a.google.protobuf.TimestampR\fregisteredAt\x12\x16\n" +
+	"\x06health\x18\v \x01(\tR\x06health\x12#\n" +
+	"\rhealth_reason\x18\f \x01(\tR\fhealthReason\x12!\n" +
+	"\fclaimed_jobs\x18\r \x03(\tR\vclaimedJobs\"\x14\n" +
+	"\x12ListWorkersRequest\"I\n" +
+	"\x13ListWorkersResponse\x122\n" +
+	"\aworkers\x18\x01 \x03(\v2\x18.scheduler.v1.WorkerInfoR\aworkers\"\"\n" +
+	"\x10GetWorkerRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id2\xa6\x06\n" +
+	"\fAdminService\x12I\n" +
+	"\bGetStats\x12\x1d.scheduler.v1.GetStatsRequest\x1a\x1e.scheduler.v1.GetStatsResponse\x12O\n" +
+	"\n" +
+	"ListQueues\x12\x1f.scheduler.v1.ListQueuesRequest\x1a .scheduler.v1.ListQueuesResponse\x12X\n" +
+	"\rListQueueJobs\x12\".scheduler.v1.ListQueueJobsRequest\x1a#.scheduler.v1.ListQueueJobsResponse\x12O\n" +
+	"\n" +
+	"PauseQueue\x12\x1f.scheduler.v1.PauseQueueRequest\x1a .scheduler.v1.PauseQueueResponse\x12R\n" +
+	"\vResumeQueue\x12 .scheduler.v1.ResumeQueueRequest\x1a!.scheduler.v1.ResumeQueueResponse\x12>\n" +
+	"\x06GetJob\x12\x1b.scheduler.v1.GetJobRequest\x1a\x17.scheduler.v1.JobDetail\x12O\n" +
+	"\n" +
+	"RequeueJob\x12\x1f.scheduler.v1.RequeueJobRequest\x1a .scheduler.v1.RequeueJobResponse\x12O\n" +
+	"\n" +
+	"AbandonJob\x12\x1f.scheduler.v1.AbandonJobRequest\x1a .scheduler.v1.AbandonJobResponse\x12R\n" +
+	"\vListWorkers\x12 .scheduler.v1.ListWorkersRequest\x1a!.scheduler.v1.ListWorkersResponse\x12E\n" +
+	"\tGetWorker\x12\x1e.scheduler.v1.GetWorkerRequest\x1a\x18.scheduler.v1.WorkerInfoBLZJgithub.com/buildkite/buildkite-custom-scheduler/internal/proto/schedulerv1b\x06proto3"
+
+var (
+	file_scheduler_v1_admin_proto_rawDescOnce sync.Once
+	file_scheduler_v1_admin_proto_rawDescData []byte
+)
+
+func file_scheduler_v1_admin_proto_rawDescGZIP() []byte {
+	file_scheduler_v1_admin_proto_rawDescOnce.Do(func() {
+		file_scheduler_v1_admin_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_scheduler_v1_admin_proto_rawDesc), len(file_scheduler_v1_admin_proto_rawDesc)))
+	})
+	return file_scheduler_v1_admin_proto_rawDescData
+}
+
+var file_scheduler_v1_admin_proto_msgTypes = make([]protoimpl.MessageInfo, 29)
+var file_scheduler_v1_admin_proto_goTypes = []any{
+	(*GetStatsRequest)(nil),       // 0: scheduler.v1.GetStatsRequest
+	(*GetStatsResponse)(nil),      // 1: scheduler.v1.GetStatsResponse
+	(*Job)(nil),                   // 2: scheduler.v1.Job
+	(*JobFailure)(nil),            // 3: scheduler.v1.JobFailure
+	(*JobDetail)(nil),             // 4: scheduler.v1.JobDetail
+	(*QueueSummary)(nil),          // 5: scheduler.v1.QueueSummary
+	(*ListQueuesRequest)(nil),     // 6: scheduler.v1.ListQueuesRequest
+	(*ListQueuesResponse)(nil),    // 7: scheduler.v1.ListQueuesResponse
+	(*QueuedJob)(nil),             // 8: scheduler.v1.QueuedJob
+	(*ListQueueJobsRequest)(nil),  // 9: scheduler.v1.ListQueueJobsRequest
+	(*ListQueueJobsResponse)(nil), // 10: scheduler.v1.ListQueueJobsResponse
+	(*PauseQueueRequest)(nil),     // 11: scheduler.v1.PauseQueueRequest
+	(*PauseQueueResponse)(nil),    // 12: scheduler.v1.PauseQueueResponse
+	(*ResumeQueueRequest)(nil),    // 13: scheduler.v1.ResumeQueueRequest
+	(*ResumeQueueResponse)(nil),   // 14: scheduler.v1.ResumeQueueResponse
+	(*GetJobRequest)(nil),         // 15: scheduler.v1.GetJobRequest
+	(*RequeueJobRequest)(nil),     // 16: scheduler.v1.RequeueJobRequest
+	(*RequeueJobResponse)(nil),    // 17: scheduler.v1.RequeueJobResponse
+	(*AbandonJobRequest)(nil),     // 18: scheduler.v1.AbandonJobRequest
+	(*AbandonJobResponse)(nil),    // 19: scheduler.v1.AbandonJobResponse
+	(*WorkerInfo)(nil),            // 20: scheduler.v1.WorkerInfo
+	(*ListWorkersRequest)(nil),    // 21: scheduler.v1.ListWorkersRequest
+	(*ListWorkersResponse)(nil),   // 22: scheduler.v1.ListWorkersResponse
+	(*GetWorkerRequest)(nil),      // 23: scheduler.v1.GetWorkerRequest
+	nil,                           // 24: scheduler.v1.GetStatsResponse.QueuesEntry
+	nil,                           // 25: scheduler.v1.GetStatsResponse.PausedQueuesEntry
+	nil,                           // 26: scheduler.v1.GetStatsResponse.DriftEntry
+	nil,                           // 27: scheduler.v1.GetStatsResponse.UnhealthyWorkersEntry
+	nil,                           // 28: scheduler.v1.Job.ResourcesEntry
+	(*timestamppb.Timestamp)(nil), // 29: google.protobuf.Timestamp
+}
+var file_scheduler_v1_admin_proto_depIdxs = []int32{
+	24, // 0: scheduler.v1.GetStatsResponse.queues:type_name -> scheduler.v1.GetStatsResponse.QueuesEntry
+	25, // 1: scheduler.v1.GetStatsResponse.paused_queues:type_name -> scheduler.v1.GetStatsResponse.PausedQueuesEntry
+	26, // 2: scheduler.v1.GetStatsResponse.drift:type_name -> scheduler.v1.GetStatsResponse.DriftEntry
+	27, // 3: scheduler.v1.GetStatsResponse.unhealthy_workers:type_name -> scheduler.v1.GetStatsResponse.UnhealthyWorkersEntry
+	29, // 4: scheduler.v1.Job.scheduled_at:type_name -> google.protobuf.Timestamp
+	29, // 5: scheduler.v1.Job.reserved_at:type_name -> google.protobuf.Timestamp
+	29, // 6: scheduler.v1.Job.not_before:type_name -> google.protobuf.Timestamp
+	28, // 7: scheduler.v1.Job.resources:type_name -> scheduler.v1.Job.ResourcesEntry
+	29, // 8: scheduler.v1.JobFailure.failed_at:type_name -> google.protobuf.Timestamp
+	2,  // 9: scheduler.v1.JobDetail.job:type_name -> scheduler.v1.Job
+	29, // 10: scheduler.v1.JobDetail.claimed_at:type_name -> google.protobuf.Timestamp
+	29, // 11: scheduler.v1.JobDetail.started_at:type_name -> google.protobuf.Timestamp
+	29, // 12: scheduler.v1.JobDetail.completed_at:type_name -> google.protobuf.Timestamp
+	29, // 13: scheduler.v1.JobDetail.lease_expires_at:type_name -> google.protobuf.Timestamp
+	29, // 14: scheduler.v1.JobDetail.reservation_expires_at:type_name -> google.protobuf.Timestamp
+	3,  // 15: scheduler.v1.JobDetail.failures:type_name -> scheduler.v1.JobFailure
+	5,  // 16: scheduler.v1.ListQueuesResponse.queues:type_name -> scheduler.v1.QueueSummary
+	2,  // 17: scheduler.v1.QueuedJob.job:type_name -> scheduler.v1.Job
+	8,  // 18: scheduler.v1.ListQueueJobsResponse.jobs:type_name -> scheduler.v1.QueuedJob
+	29, // 19: scheduler.v1.WorkerInfo.last_seen:type_name -> google.protobuf.Timestamp
+	29, // 20: scheduler.v1.WorkerInfo.registered_at:type_name -> google.protobuf.Timestamp
+	20, // 21: scheduler.v1.ListWorkersResponse.workers:type_name -> scheduler.v1.WorkerInfo
+	0,  // 22: scheduler.v1.AdminService.GetStats:input_type -> scheduler.v1.GetStatsRequest
+	6,  // 23: scheduler.v1.AdminService.ListQueues:input_type -> scheduler.v1.ListQueuesRequest
+	9,  // 24: scheduler.v1.AdminService.ListQueueJobs:input_type -> scheduler.v1.ListQueueJobsRequest
+	11, // 25: scheduler.v1.AdminService.PauseQueue:input_type -> scheduler.v1.PauseQueueRequest
+	13, // 26: scheduler.v1.AdminService.ResumeQueue:input_type -> scheduler.v1.ResumeQueueRequest
+	15, // 27: scheduler.v1.AdminService.GetJob:input_type -> scheduler.v1.GetJobRequest
+	16, // 28: scheduler.v1.AdminService.RequeueJob:input_type -> scheduler.v1.RequeueJobRequest
+	18, // 29: scheduler.v1.AdminService.AbandonJob:input_type -> scheduler.v1.AbandonJobRequest
+	21, // 30: scheduler.v1.AdminService.ListWorkers:input_type -> scheduler.v1.ListWorkersRequest
+	23, // 31: scheduler.v1.AdminService.GetWorker:input_type -> scheduler.v1.GetWorkerRequest
+	1,  // 32: scheduler.v1.AdminService.GetStats:output_type -> scheduler.v1.GetStatsResponse
+	7,  // 33: scheduler.v1.AdminService.ListQueues:output_type -> scheduler.v1.ListQueuesResponse
+	10, // 34: scheduler.v1.AdminService.ListQueueJobs:output_type -> scheduler.v1.ListQueueJobsResponse
+	12, // 35: scheduler.v1.AdminService.PauseQueue:output_type -> scheduler.v1.PauseQueueResponse
+	14, // 36: scheduler.v1.AdminService.ResumeQueue:output_type -> scheduler.v1.ResumeQueueResponse
+	4,  // 37: scheduler.v1.AdminService.GetJob:output_type -> scheduler.v1.JobDetail
+	17, // 38: scheduler.v1.AdminService.RequeueJob:output_type -> scheduler.v1.RequeueJobResponse
+	19, // 39: scheduler.v1.AdminService.AbandonJob:output_type -> scheduler.v1.AbandonJobResponse
+	22, // 40: scheduler.v1.AdminService.ListWorkers:output_type -> scheduler.v1.ListWorkersResponse
+	20, // 41: scheduler.v1.AdminService.GetWorker:output_type -> scheduler.v1.WorkerInfo
+	32, // [32:42] is the sub-list for method output_type
+	22, // [22:32] is the sub-list for method input_type
+	22, // [22:22] is the sub-list for extension type_name
+	22, // [22:22] is the sub-list for extension extendee
+	0,  // [0:22] is the sub-list for field type_name
+}
+
+func init() { file_scheduler_v1_admin_proto_init() }
+func file_scheduler_v1_admin_proto_init() {
+	if File_scheduler_v1_admin_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_scheduler_v1_admin_proto_rawDesc), len(file_scheduler_v1_admin_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   29,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_scheduler_v1_admin_proto_goTypes,
+		DependencyIndexes: file_scheduler_v1_admin_proto_depIdxs,
+		MessageInfos:      file_scheduler_v1_admin_proto_msgTypes,
+	}.Build()
+	File_scheduler_v1_admin_proto = out.File
+	file_scheduler_v1_admin_proto_goTypes = nil
+	file_scheduler_v1_admin_proto_depIdxs = nil
+}
@@ -0,0 +1,274 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/storage"
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+	"github.com/rs/zerolog/log"
+)
+
+// deadLetterAlertTTL is how long a dead-lettered job's alert is remembered,
+// so only one server sends it.
+const deadLetterAlertTTL = time.Hour
+
+// AlertConfig says where alerts go and what raises them.
+type AlertConfig struct {
+	// URL is posted every alert, as a Slack message if Slack is set and as
+	// a types.Alert otherwise.
+	URL   string
+	Slack bool
+	// Events are the alert events to send.
+	Events []string
+	// QueueDepth is how many jobs may wait in each queue before it alerts.
+	// The "*" entry applies to every polled queue without its own.
+	QueueDepth map[string]int
+	// MinWorkers alerts while fewer workers than this are active, that is
+	// have heartbeated within WorkerTimeout. 0 disables it.
+	MinWorkers    int
+	WorkerTimeout time.Duration
+	// Interval is how often conditions are checked, and Resend how often an
+	// alert that is still firing is sent again (0 for never).
+	Interval time.Duration
+	Resend   time.Duration
+	// ServerID tells apart alerts about this server's own stack
+	// registrations.
+	ServerID string
+}
+
+// Alerter posts alerts to a webhook when queues back up, jobs are
+// dead-lettered, stack registrations are lost or the worker fleet shrinks,
+// and again when they recover. Every server sharing Redis checks, but each
+// alert is sent by only one of them.
+type Alerter struct {
+	store  *storage.RedisStore
+	stacks Stacks
+	cfg    AlertConfig
+	client *http.Client
+}
+
+func NewAlerter(store *storage.RedisStore, stacks Stacks, cfg AlertConfig) *Alerter {
+	return &Alerter{store: store, stacks: stacks, cfg: cfg, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (a *Alerter) Start(ctx context.Context) error {
+	ticker := time.NewTicker(a.cfg.Interval)
+	defer ticker.Stop()
+
+	log.Info().Strs("events", a.cfg.Events).Dur("interval", a.cfg.Interval).Msg("Starting alerter")
+
+	var events <-chan types.JobEvent
+	if a.enabled(types.AlertDeadLettered) {
+		events = a.store.SubscribeJobEvents(ctx)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			if event.Type == types.EventFailed && event.DeadLettered {
+				a.deadLettered(ctx, event)
+			}
+		case <-ticker.C:
+			a.check(ctx)
+		}
+	}
+}
+
+func (a *Alerter) enabled(event string) bool {
+	return slices.Contains(a.cfg.Events, event)
+}
+
+// check raises or resolves the alerts for conditions that come and go.
+func (a *Alerter) check(ctx context.Context) {
+	if a.enabled(types.AlertQueueDepth) {
+		a.checkQueueDepths(ctx)
+	}
+	if a.enabled(types.AlertFleetLow) && a.cfg.MinWorkers > 0 {
+		a.checkFleet(ctx)
+	}
+	if a.enabled(types.AlertStackLost) {
+		for _, stack := range a.stacks {
+			if stack.Liveness == nil {
+				continue
+			}
+			err := stack.Liveness.Check()
+			alert := types.Alert{
+				Event:   types.AlertStackLost,
+				Summary: fmt.Sprintf("Stack %s is no longer registered with Buildkite from %s", stack.Key(), a.cfg.ServerID),
+				Details: map[string]string{"stack_key": stack.Key(), "server_id": a.cfg.ServerID},
+			}
+			if err != nil {
+				alert.Details["error"] = err.Error()
+			} else {
+				alert.Summary = fmt.Sprintf("Stack %s is registered with Buildkite from %s again", stack.Key(), a.cfg.ServerID)
+			}
+			a.update(ctx, types.AlertStackLost+":"+stack.Key()+":"+a.cfg.ServerID, err != nil, alert)
+		}
+	}
+}
+
+func (a *Alerter) checkQueueDepths(ctx context.Context) {
+	thresholds := map[string]int{}
+	if threshold, ok := a.cfg.QueueDepth["*"]; ok {
+		for _, queueKey := range a.stacks.Queues() {
+			thresholds[queueKey] = threshold
+		}
+	}
+	for queueKey, threshold := range a.cfg.QueueDepth {
+		if queueKey != "*" {
+			thresholds[queueKey] = threshold
+		}
+	}
+
+	for _, queueKey := range slices.Sorted(maps.Keys(thresholds)) {
+		threshold := thresholds[queueKey]
+		depth, err := a.store.GetPendingCount(ctx, queueKey)
+		if err != nil {
+			log.Error().Err(err).Str("queue", queueKey).Msg("Error checking queue depth for alerts")
+			continue
+		}
+		firing := depth > int64(threshold)
+		summary := fmt.Sprintf("Queue %s has %d jobs waiting, more than %d", queueKey, depth, threshold)
+		if !firing {
+			summary = fmt.Sprintf("Queue %s is back to %d jobs waiting", queueKey, depth)
+		}
+		a.update(ctx, types.AlertQueueDepth+":"+queueKey, firing, types.Alert{
+			Event:   types.AlertQueueDepth,
+			Summary: summary,
+			Details: map[string]string{
+				"queue_key": queueKey,
+				"depth":     strconv.FormatInt(depth, 10),
+				"threshold": strconv.Itoa(threshold),
+			},
+		})
+	}
+}
+
+func (a *Alerter) checkFleet(ctx context.Context) {
+	workers, err := a.store.ActiveWorkerCount(ctx, time.Now().Add(-a.cfg.WorkerTimeout))
+	if err != nil {
+		log.Error().Err(err).Msg("Error counting workers for alerts")
+		return
+	}
+	firing := workers < int64(a.cfg.MinWorkers)
+	summary := fmt.Sprintf("Only %d workers are active, fewer than %d", workers, a.cfg.MinWorkers)
+	if !firing {
+		summary = fmt.Sprintf("%d workers are active again", workers)
+	}
+	a.update(ctx, types.AlertFleetLow, firing, types.Alert{
+		Event:   types.AlertFleetLow,
+		Summary: summary,
+		Details: map[string]string{
+			"workers":     strconv.FormatInt(workers, 10),
+			"min_workers": strconv.Itoa(a.cfg.MinWorkers),
+		},
+	})
+}
+
+// update sends alert if it has started firing, or is due to be sent again,
+// or has just resolved, unless another server already has.
+func (a *Alerter) update(ctx context.Context, key string, firing bool, alert types.Alert) {
+	var send bool
+	var err error
+	if firing {
+		alert.State = types.AlertFiring
+		send, err = a.store.RaiseAlert(ctx, key, a.cfg.Resend, 0)
+	} else {
+		alert.State = types.AlertResolved
+		send, err = a.store.ResolveAlert(ctx, key)
+	}
+	if err != nil {
+		log.Error().Err(err).Str("alert", key).Msg("Error updating alert")
+		return
+	}
+	if send {
+		a.send(ctx, alert)
+	}
+}
+
+func (a *Alerter) deadLettered(ctx context.Context, event types.JobEvent) {
+	send, err := a.store.RaiseAlert(ctx, types.AlertDeadLettered+":"+event.JobUUID, 0, deadLetterAlertTTL)
+	if err != nil {
+		log.Error().Err(err).Str("uuid", event.JobUUID).Msg("Error updating alert")
+		return
+	}
+	if !send {
+		return
+	}
+	details := map[string]string{"job_uuid": event.JobUUID, "error": event.Error}
+	if queueKeys, err := a.store.JobQueueKeys(ctx, []string{event.JobUUID}); err == nil && queueKeys[event.JobUUID] != "" {
+		details["queue_key"] = queueKeys[event.JobUUID]
+	}
+	a.send(ctx, types.Alert{
+		Event:   types.AlertDeadLettered,
+		State:   types.AlertFiring,
+		Summary: fmt.Sprintf("Job %s was dead-lettered after failing too many times: %s", event.JobUUID, event.Error),
+		Details: details,
+	})
+}
+
+// send posts alert to the webhook. Failures are logged rather than retried;
+// alerts that are still firing are sent again after Resend.
+func (a *Alerter) send(ctx context.Context, alert types.Alert) {
+	alert.Time = time.Now()
+	logger := log.With().Str("event", alert.Event).Str("state", alert.State).Logger()
+
+	var payload any = alert
+	if a.cfg.Slack {
+		payload = slackMessage(alert)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		logger.Error().Err(err).Msg("Error encoding alert")
+		return
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		logger.Error().Err(err).Msg("Error creating alert request")
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			err = fmt.Errorf("unexpected status %d", resp.StatusCode)
+		}
+	}
+	if err != nil {
+		alertsSent.With(alert.Event, "error").Inc()
+		logger.Error().Err(err).Msg("Error sending alert")
+		return
+	}
+	alertsSent.With(alert.Event, "ok").Inc()
+	logger.Info().Str("summary", alert.Summary).Msg("Sent alert")
+}
+
+// slackMessage renders alert for a Slack incoming webhook.
+func slackMessage(alert types.Alert) map[string]string {
+	icon := ":rotating_light:"
+	if alert.State == types.AlertResolved {
+		icon = ":white_check_mark:"
+	}
+	var text strings.Builder
+	fmt.Fprintf(&text, "%s %s", icon, alert.Summary)
+	for _, key := range slices.Sorted(maps.Keys(alert.Details)) {
+		fmt.Fprintf(&text, "\n• %s: `%s`", key, alert.Details[key])
+	}
+	return map[string]string{"text": text.String()}
+}
@@ -1,32 +1,62 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/buildkite/buildkite-custom-scheduler/internal/storage"
+	"github.com/buildkite/buildkite-custom-scheduler/internal/tracing"
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/hlog"
 )
 
 type API struct {
-	store  *storage.RedisStore
-	logger *zerolog.Logger
+	store       *storage.RedisStore
+	stacks      Stacks
+	logger      *zerolog.Logger
+	notifier    *JobNotifier
+	claimOpts   storage.ClaimOptions
+	maxAttempts int
+
+	muxOnce sync.Once
+	mux     *http.ServeMux
+}
+
+const (
+	// maxClaimWait caps how long a long-polling claim request is held open.
+	maxClaimWait = 60 * time.Second
+
+	// claimRecheckInterval is how often a waiting claim retries without a
+	// notification, to pick up delayed jobs coming due and freed rate limits.
+	claimRecheckInterval = 5 * time.Second
+)
+
+// NewAPI creates the worker-facing API. claimOpts holds the claim settings
+// shared by all workers; each request fills in its own WorkerID. Jobs that fail
+// maxAttempts times are moved to the dead-letter queue. Long-polling claims
+// wait on notifier for new jobs. stacks are the registered stacks, whose
+// monitors release jobs and whose heartbeats readiness probes consult.
+func NewAPI(store *storage.RedisStore, stacks Stacks, notifier *JobNotifier, logger *zerolog.Logger, claimOpts storage.ClaimOptions, maxAttempts int) *API {
+	return &API{store: store, stacks: stacks, notifier: notifier, logger: logger, claimOpts: claimOpts, maxAttempts: maxAttempts}
 }
 
-func NewAPI(store *storage.RedisStore, logger *zerolog.Logger) *API {
-	return &API{store: store, logger: logger}
+// workerTimeout is how recently a worker must have heartbeated to count as
+// active.
+func (a *API) workerTimeout() time.Duration {
+	return a.stacks[0].Monitor.cfg.WorkerTimeout
 }
 
 func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	mux := http.NewServeMux()
-	mux.HandleFunc("GET /health", a.handleHealth)
-	mux.HandleFunc("GET /jobs", a.handleGetJob)
-	mux.HandleFunc("POST /jobs/{uuid}/complete", a.handleCompleteJob)
-	mux.HandleFunc("GET /stats", a.handleStats)
-	mux.ServeHTTP(w, r)
+	mux := a.routeMux()
+	a.audit(mux).ServeHTTP(w, r)
 }
 
 func (a *API) handleHealth(w http.ResponseWriter, r *http.Request) {
@@ -37,25 +67,67 @@ func (a *API) handleHealth(w http.ResponseWriter, r *http.Request) {
 func (a *API) handleGetJob(w http.ResponseWriter, r *http.Request) {
 	queryParam := r.URL.Query().Get("query")
 	if queryParam == "" {
-		http.Error(w, "query parameter is required", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, "query parameter is required")
 		return
 	}
 
-	queryRules := strings.Split(queryParam, ",")
-	for i := range queryRules {
-		queryRules[i] = strings.TrimSpace(queryRules[i])
+	queryRules := splitRules(queryParam)
+
+	var resources types.Resources
+	if resourcesParam := r.URL.Query().Get("resources"); resourcesParam != "" {
+		var err error
+		resources, err = types.ParseResources(splitRules(resourcesParam), nil)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, err.Error())
+			return
+		}
+	}
+
+	var wait time.Duration
+	if waitParam := r.URL.Query().Get("wait"); waitParam != "" {
+		var err error
+		wait, err = time.ParseDuration(waitParam)
+		if err != nil || wait < 0 {
+			writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, "invalid wait duration")
+			return
+		}
+		wait = min(wait, maxClaimWait)
 	}
 
 	workerID := r.Header.Get("X-Worker-ID")
+	if workerID == "" {
+		writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, "X-Worker-ID header is required")
+		return
+	}
 	hlog.FromRequest(r).Debug().
 		Strs("query_rules", queryRules).
 		Str("worker_id", workerID).
 		Msg("claiming job")
 
-	job, err := a.store.ClaimJob(r.Context(), queryRules)
+	if err := a.store.WorkerHeartbeat(r.Context(), workerID); err != nil {
+		a.logger.Error().Err(err).Str("worker_id", workerID).Msg("Error recording worker heartbeat")
+	}
+
+	// A paused queue won't hand out jobs however long the worker waits, so
+	// tell it so rather than holding the claim open.
+	paused, err := a.pausedQueue(r.Context(), queryRules)
+	if err != nil {
+		a.logger.Error().Err(err).Msg("Error getting paused queues")
+		writeError(w, http.StatusInternalServerError, types.ErrorInternal, "internal server error")
+		return
+	}
+	if paused != "" {
+		writeError(w, http.StatusConflict, types.ErrorQueuePaused, "queue "+paused+" is paused")
+		return
+	}
+
+	claimOpts := a.claimOpts
+	claimOpts.WorkerID = workerID
+	claimOpts.Resources = resources
+	job, err := a.claimJob(r.Context(), queryRules, claimOpts, wait)
 	if err != nil {
 		a.logger.Error().Err(err).Msg("Error claiming job")
-		http.Error(w, "internal server error", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, types.ErrorInternal, "internal server error")
 		return
 	}
 
@@ -63,23 +135,647 @@ func (a *API) handleGetJob(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
+	jobsClaimed.With(job.QueueKey).Inc()
+	if !job.ReservedAt.IsZero() {
+		claimLatency.With(job.QueueKey).ObserveSince(job.ReservedAt)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(job)
 }
 
+// pausedQueue returns the Buildkite queue named by a queue= rule in
+// queryRules if it is paused.
+func (a *API) pausedQueue(ctx context.Context, queryRules []string) (string, error) {
+	var queueKeys []string
+	for _, rule := range queryRules {
+		if queueKey, ok := strings.CutPrefix(rule, "queue="); ok {
+			queueKeys = append(queueKeys, queueKey)
+		}
+	}
+	if len(queueKeys) == 0 {
+		return "", nil
+	}
+	paused, err := a.store.PausedQueues(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, queueKey := range queueKeys {
+		if _, ok := paused[queueKey]; ok {
+			return queueKey, nil
+		}
+	}
+	return "", nil
+}
+
+// claimJob claims a job for the worker, waiting up to wait for one to become
+// available. It returns nil if none turned up in time.
+func (a *API) claimJob(ctx context.Context, queryRules []string, opts storage.ClaimOptions, wait time.Duration) (*types.Job, error) {
+	deadline := time.NewTimer(wait)
+	defer deadline.Stop()
+	recheck := time.NewTicker(claimRecheckInterval)
+	defer recheck.Stop()
+
+	for {
+		changed := a.notifier.Changed()
+		job, err := a.store.ClaimJob(ctx, queryRules, opts)
+		if err != nil || job != nil || wait == 0 {
+			return job, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, nil
+		case <-a.notifier.Done():
+			return nil, nil
+		case <-deadline.C:
+			return nil, nil
+		case <-changed:
+		case <-recheck.C:
+			// Keep the worker from being marked offline while it waits.
+			if err := a.store.WorkerHeartbeat(ctx, opts.WorkerID); err != nil {
+				a.logger.Error().Err(err).Str("worker_id", opts.WorkerID).Msg("Error recording worker heartbeat")
+			}
+		}
+	}
+}
+
+func splitRules(param string) []string {
+	rules := strings.Split(param, ",")
+	for i := range rules {
+		rules[i] = strings.TrimSpace(rules[i])
+	}
+	return rules
+}
+
+func (a *API) handleGetJobDetail(w http.ResponseWriter, r *http.Request) {
+	uuid := r.PathValue("uuid")
+	if uuid == "" {
+		writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, "job uuid is required")
+		return
+	}
+
+	detail, err := a.store.GetJobDetail(r.Context(), uuid)
+	if errors.Is(err, storage.ErrJobNotFound) {
+		writeError(w, http.StatusNotFound, types.ErrorNotFound, "job not found")
+		return
+	}
+	if err != nil {
+		a.logger.Error().Err(err).Str("uuid", uuid).Msg("Error getting job")
+		writeError(w, http.StatusInternalServerError, types.ErrorInternal, "internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(detail)
+}
+
+// handleListJobs lists jobs matching the status, queue, worker, min_age and
+// max_age query parameters, a page at a time.
+func (a *API) handleListJobs(w http.ResponseWriter, r *http.Request) {
+	cursor, limit, ok := pageParams(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	filter := storage.JobFilter{
+		Status:   query.Get("status"),
+		QueueKey: query.Get("queue"),
+		WorkerID: query.Get("worker"),
+	}
+	for param, age := range map[string]*time.Duration{"min_age": &filter.MinAge, "max_age": &filter.MaxAge} {
+		if value := query.Get(param); value != "" {
+			d, err := time.ParseDuration(value)
+			if err != nil || d < 0 {
+				writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, "invalid "+param)
+				return
+			}
+			*age = d
+		}
+	}
+
+	jobs, next, err := a.store.ListJobs(r.Context(), filter, uint64(cursor), limit)
+	if err != nil {
+		a.logger.Error().Err(err).Msg("Error listing jobs")
+		writeError(w, http.StatusInternalServerError, types.ErrorInternal, "internal server error")
+		return
+	}
+
+	response := map[string]any{"jobs": jobs}
+	if next > 0 {
+		response["next_cursor"] = strconv.FormatUint(next, 10)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (a *API) handleAbandonJob(w http.ResponseWriter, r *http.Request) {
+	uuid := r.PathValue("uuid")
+	if uuid == "" {
+		writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, "job uuid is required")
+		return
+	}
+
+	err := a.stacks.AbandonJob(r.Context(), uuid)
+	if errors.Is(err, storage.ErrJobNotFound) {
+		writeError(w, http.StatusNotFound, types.ErrorNotFound, "job not found")
+		return
+	}
+	if errors.Is(err, storage.ErrJobNotPending) {
+		writeError(w, http.StatusConflict, types.ErrorNotPending, "job is not pending")
+		return
+	}
+	if err != nil {
+		a.logger.Error().Err(err).Str("uuid", uuid).Msg("Error abandoning job")
+		writeError(w, http.StatusInternalServerError, types.ErrorInternal, "internal server error")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
 func (a *API) handleCompleteJob(w http.ResponseWriter, r *http.Request) {
 	uuid := r.PathValue("uuid")
 	if uuid == "" {
-		http.Error(w, "job uuid is required", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, "job uuid is required")
 		return
 	}
 
-	if err := a.store.CompleteJob(r.Context(), uuid); err != nil {
+	err := a.store.CompleteJob(r.Context(), uuid, r.Header.Get("X-Worker-ID"), r.Header.Get("X-Claim-Token"))
+	if errors.Is(err, storage.ErrJobNotFound) {
+		writeError(w, http.StatusNotFound, types.ErrorNotFound, "job not found")
+		return
+	}
+	if errors.Is(err, storage.ErrJobNotOwned) {
+		writeError(w, http.StatusForbidden, types.ErrorNotOwned, "job is claimed by another worker")
+		return
+	}
+	if errors.Is(err, storage.ErrJobAlreadyComplete) {
+		writeError(w, http.StatusConflict, types.ErrorAlreadyCompleted, "job is already complete")
+		return
+	}
+	if errors.Is(err, storage.ErrJobNotClaimed) {
+		writeError(w, http.StatusConflict, types.ErrorNotClaimed, "job is not claimed")
+		return
+	}
+	if errors.Is(err, storage.ErrClaimTokenMismatch) {
+		writeError(w, http.StatusConflict, types.ErrorClaimTokenMismatch, "claim token does not match")
+		return
+	}
+	if err != nil {
 		a.logger.Error().Err(err).Str("uuid", uuid).Msg("Error completing job")
-		http.Error(w, "internal server error", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, types.ErrorInternal, "internal server error")
 		return
 	}
+	jobsCompleted.With().Inc()
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *API) handleStartJob(w http.ResponseWriter, r *http.Request) {
+	uuid := r.PathValue("uuid")
+	if uuid == "" {
+		writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, "job uuid is required")
+		return
+	}
+
+	err := a.store.StartJob(r.Context(), uuid, r.Header.Get("X-Worker-ID"), r.Header.Get("X-Claim-Token"))
+	if errors.Is(err, storage.ErrJobNotFound) {
+		writeError(w, http.StatusNotFound, types.ErrorNotFound, "job not found")
+		return
+	}
+	if errors.Is(err, storage.ErrJobNotOwned) {
+		writeError(w, http.StatusForbidden, types.ErrorNotOwned, "job is claimed by another worker")
+		return
+	}
+	if errors.Is(err, storage.ErrJobCancelled) {
+		writeError(w, http.StatusGone, types.ErrorJobCancelled, "job was cancelled")
+		return
+	}
+	if errors.Is(err, storage.ErrJobNotClaimed) {
+		writeError(w, http.StatusConflict, types.ErrorNotClaimed, "job is not claimed")
+		return
+	}
+	if errors.Is(err, storage.ErrClaimTokenMismatch) {
+		writeError(w, http.StatusConflict, types.ErrorClaimTokenMismatch, "claim token does not match")
+		return
+	}
+	if err != nil {
+		a.logger.Error().Err(err).Str("uuid", uuid).Msg("Error starting job")
+		writeError(w, http.StatusInternalServerError, types.ErrorInternal, "internal server error")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+type workerHeartbeatRequest struct {
+	Healthy *bool  `json:"healthy"`
+	Reason  string `json:"reason"`
+}
+
+func (a *API) handleRegisterWorker(w http.ResponseWriter, r *http.Request) {
+	var worker types.Worker
+	if err := json.NewDecoder(r.Body).Decode(&worker); err != nil {
+		writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, "invalid request body")
+		return
+	}
+	if worker.ID == "" {
+		writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, "worker id is required")
+		return
+	}
+	if !allowWorker(w, r, worker.ID) {
+		return
+	}
+
+	if err := a.store.RegisterWorker(r.Context(), worker); err != nil {
+		a.logger.Error().Err(err).Str("worker_id", worker.ID).Msg("Error registering worker")
+		writeError(w, http.StatusInternalServerError, types.ErrorInternal, "internal server error")
+		return
+	}
+	a.logger.Debug().Str("worker_id", worker.ID).Str("hostname", worker.Hostname).Str("executor", worker.Executor).Int("concurrency", worker.Concurrency).Msg("Worker registered")
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *API) handleWorkerHeartbeat(w http.ResponseWriter, r *http.Request) {
+	workerID := r.PathValue("id")
+	if workerID == "" {
+		writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, "worker id is required")
+		return
+	}
+	if !allowWorker(w, r, workerID) {
+		return
+	}
+
+	// The body is optional; workers that run preflight checks send their
+	// health with each heartbeat.
+	var req workerHeartbeatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, "invalid request body")
+		return
+	}
+
+	if err := a.store.WorkerHeartbeat(r.Context(), workerID); err != nil {
+		a.logger.Error().Err(err).Str("worker_id", workerID).Msg("Error recording worker heartbeat")
+		writeError(w, http.StatusInternalServerError, types.ErrorInternal, "internal server error")
+		return
+	}
+	if req.Healthy != nil {
+		if err := a.store.SetWorkerHealth(r.Context(), workerID, *req.Healthy, req.Reason); err != nil {
+			a.logger.Error().Err(err).Str("worker_id", workerID).Msg("Error recording worker health")
+			writeError(w, http.StatusInternalServerError, types.ErrorInternal, "internal server error")
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *API) handleDeregisterWorker(w http.ResponseWriter, r *http.Request) {
+	workerID := r.PathValue("id")
+	if workerID == "" {
+		writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, "worker id is required")
+		return
+	}
+	if !allowWorker(w, r, workerID) {
+		return
+	}
+
+	requeued, err := a.store.ReapWorker(r.Context(), workerID)
+	if err != nil {
+		a.logger.Error().Err(err).Str("worker_id", workerID).Msg("Error deregistering worker")
+		writeError(w, http.StatusInternalServerError, types.ErrorInternal, "internal server error")
+		return
+	}
+	a.logger.Info().Str("worker_id", workerID).Strs("jobs", requeued).Msg("Worker deregistered")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"requeued": len(requeued)})
+}
+
+func (a *API) handleListWorkers(w http.ResponseWriter, r *http.Request) {
+	workers, err := a.store.ListWorkers(r.Context())
+	if err != nil {
+		a.logger.Error().Err(err).Msg("Error listing workers")
+		writeError(w, http.StatusInternalServerError, types.ErrorInternal, "internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"workers": workers})
+}
+
+func (a *API) handleGetWorker(w http.ResponseWriter, r *http.Request) {
+	workerID := r.PathValue("id")
+	if workerID == "" {
+		writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, "worker id is required")
+		return
+	}
+
+	worker, err := a.store.GetWorker(r.Context(), workerID)
+	if errors.Is(err, storage.ErrWorkerNotFound) {
+		writeError(w, http.StatusNotFound, types.ErrorNotFound, "worker not found")
+		return
+	}
+	if err != nil {
+		a.logger.Error().Err(err).Str("worker_id", workerID).Msg("Error getting worker")
+		writeError(w, http.StatusInternalServerError, types.ErrorInternal, "internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(worker)
+}
+
+func (a *API) handleHeartbeatJob(w http.ResponseWriter, r *http.Request) {
+	uuid := r.PathValue("uuid")
+	if uuid == "" {
+		writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, "job uuid is required")
+		return
+	}
+
+	err := a.store.HeartbeatJob(r.Context(), uuid, r.Header.Get("X-Worker-ID"), a.claimOpts.Lease)
+	if errors.Is(err, storage.ErrJobNotFound) {
+		writeError(w, http.StatusNotFound, types.ErrorNotFound, "job not found")
+		return
+	}
+	if errors.Is(err, storage.ErrJobNotOwned) {
+		writeError(w, http.StatusForbidden, types.ErrorNotOwned, "job is claimed by another worker")
+		return
+	}
+	if errors.Is(err, storage.ErrJobCancelled) {
+		writeError(w, http.StatusGone, types.ErrorJobCancelled, "job was cancelled")
+		return
+	}
+	if errors.Is(err, storage.ErrJobNotClaimed) {
+		writeError(w, http.StatusConflict, types.ErrorNotClaimed, "job is not claimed")
+		return
+	}
+	if err != nil {
+		a.logger.Error().Err(err).Str("uuid", uuid).Msg("Error heartbeating job")
+		writeError(w, http.StatusInternalServerError, types.ErrorInternal, "internal server error")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+type failJobRequest struct {
+	Error     string `json:"error"`
+	Retryable bool   `json:"retryable"`
+}
+
+func (a *API) handleFailJob(w http.ResponseWriter, r *http.Request) {
+	uuid := r.PathValue("uuid")
+	if uuid == "" {
+		writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, "job uuid is required")
+		return
+	}
+
+	var req failJobRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, "invalid request body")
+		return
+	}
+
+	workerID := r.Header.Get("X-Worker-ID")
+	status, err := a.store.FailJob(r.Context(), uuid, workerID, r.Header.Get("X-Claim-Token"), req.Error, req.Retryable, a.maxAttempts)
+	if errors.Is(err, storage.ErrJobNotFound) {
+		writeError(w, http.StatusNotFound, types.ErrorNotFound, "job not found")
+		return
+	}
+	if errors.Is(err, storage.ErrJobNotOwned) {
+		writeError(w, http.StatusForbidden, types.ErrorNotOwned, "job is claimed by another worker")
+		return
+	}
+	if errors.Is(err, storage.ErrJobNotClaimed) {
+		writeError(w, http.StatusConflict, types.ErrorNotClaimed, "job is not claimed")
+		return
+	}
+	if errors.Is(err, storage.ErrClaimTokenMismatch) {
+		writeError(w, http.StatusConflict, types.ErrorClaimTokenMismatch, "claim token does not match")
+		return
+	}
+	if err != nil {
+		a.logger.Error().Err(err).Str("uuid", uuid).Msg("Error failing job")
+		writeError(w, http.StatusInternalServerError, types.ErrorInternal, "internal server error")
+		return
+	}
+
+	switch status {
+	case "dead":
+		a.logger.Warn().Str("uuid", uuid).Str("error", req.Error).Msg("Job moved to dead-letter queue")
+		jobsFailed.With("dead_lettered").Inc()
+	case "failed":
+		jobsFailed.With("finished").Inc()
+	default:
+		jobsFailed.With("retried").Inc()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"dead_lettered": status == "dead", "status": status})
+}
+
+func (a *API) handleListDeadLetter(w http.ResponseWriter, r *http.Request) {
+	offset, limit, ok := pageParams(w, r)
+	if !ok {
+		return
+	}
+
+	jobs, next, err := a.store.ListDeadLetter(r.Context(), offset, limit)
+	if err != nil {
+		a.logger.Error().Err(err).Msg("Error listing dead-letter jobs")
+		writeError(w, http.StatusInternalServerError, types.ErrorInternal, "internal server error")
+		return
+	}
+
+	response := map[string]interface{}{"jobs": jobs}
+	if next > 0 {
+		response["next_cursor"] = strconv.Itoa(next)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (a *API) handleRequeueDeadLetter(w http.ResponseWriter, r *http.Request) {
+	uuid := r.PathValue("uuid")
+	if uuid == "" {
+		writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, "job uuid is required")
+		return
+	}
+
+	err := a.store.RequeueDeadLetter(r.Context(), uuid)
+	if errors.Is(err, storage.ErrJobNotFound) {
+		writeError(w, http.StatusNotFound, types.ErrorNotFound, "job not found in dead-letter queue")
+		return
+	}
+	if err != nil {
+		a.logger.Error().Err(err).Str("uuid", uuid).Msg("Error requeueing dead-letter job")
+		writeError(w, http.StatusInternalServerError, types.ErrorInternal, "internal server error")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *API) handleRequeueJob(w http.ResponseWriter, r *http.Request) {
+	uuid := r.PathValue("uuid")
+	if uuid == "" {
+		writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, "job uuid is required")
+		return
+	}
+
+	err := a.store.RequeueJob(r.Context(), uuid)
+	if errors.Is(err, storage.ErrJobNotFound) {
+		writeError(w, http.StatusNotFound, types.ErrorNotFound, "job not found")
+		return
+	}
+	if errors.Is(err, storage.ErrJobStarted) {
+		writeError(w, http.StatusConflict, types.ErrorJobStarted, "job has started and can't be requeued")
+		return
+	}
+	if errors.Is(err, storage.ErrJobAlreadyComplete) {
+		writeError(w, http.StatusConflict, types.ErrorAlreadyCompleted, "job is already complete")
+		return
+	}
+	if errors.Is(err, storage.ErrJobNotClaimed) {
+		writeError(w, http.StatusConflict, types.ErrorNotClaimed, "job is not claimed")
+		return
+	}
+	if err != nil {
+		a.logger.Error().Err(err).Str("uuid", uuid).Msg("Error requeueing job")
+		writeError(w, http.StatusInternalServerError, types.ErrorInternal, "internal server error")
+		return
+	}
+	a.logger.Info().Str("uuid", uuid).Msg("Requeued job")
+
+	w.WriteHeader(http.StatusOK)
+}
+
+type delayJobRequest struct {
+	NotBefore time.Time `json:"not_before"`
+}
+
+func (a *API) handleDelayJob(w http.ResponseWriter, r *http.Request) {
+	uuid := r.PathValue("uuid")
+	if uuid == "" {
+		writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, "job uuid is required")
+		return
+	}
+
+	var req delayJobRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, "invalid request body")
+		return
+	}
+	if req.NotBefore.IsZero() {
+		writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, "not_before is required")
+		return
+	}
+
+	job, err := a.store.DelayJob(r.Context(), uuid, req.NotBefore)
+	if errors.Is(err, storage.ErrJobNotFound) {
+		writeError(w, http.StatusNotFound, types.ErrorNotFound, "job not found")
+		return
+	}
+	if errors.Is(err, storage.ErrJobNotPending) {
+		writeError(w, http.StatusConflict, types.ErrorNotPending, "job is not pending")
+		return
+	}
+	if err != nil {
+		a.logger.Error().Err(err).Str("uuid", uuid).Msg("Error delaying job")
+		writeError(w, http.StatusInternalServerError, types.ErrorInternal, "internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+func (a *API) handleDrainQueue(w http.ResponseWriter, r *http.Request) {
+	queueKey := r.PathValue("key")
+	if queueKey == "" {
+		writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, "queue key is required")
+		return
+	}
+
+	if err := a.store.SetQueueDrained(r.Context(), queueKey, true); err != nil {
+		a.logger.Error().Err(err).Str("queue", queueKey).Msg("Error marking queue drained")
+		writeError(w, http.StatusInternalServerError, types.ErrorInternal, "internal server error")
+		return
+	}
+
+	released, err := a.stacks.ReleaseQueue(r.Context(), queueKey)
+	if err != nil {
+		a.logger.Error().Err(err).Str("queue", queueKey).Msg("Error draining queue")
+		writeError(w, http.StatusInternalServerError, types.ErrorInternal, "internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"released": released})
+}
+
+func (a *API) handleUndrainQueue(w http.ResponseWriter, r *http.Request) {
+	queueKey := r.PathValue("key")
+	if queueKey == "" {
+		writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, "queue key is required")
+		return
+	}
+
+	if err := a.store.SetQueueDrained(r.Context(), queueKey, false); err != nil {
+		a.logger.Error().Err(err).Str("queue", queueKey).Msg("Error undraining queue")
+		writeError(w, http.StatusInternalServerError, types.ErrorInternal, "internal server error")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// handlePauseQueue stops workers claiming a queue's jobs. With
+// ?stop_reserving=true the monitor stops reserving its jobs as well.
+func (a *API) handlePauseQueue(w http.ResponseWriter, r *http.Request) {
+	queueKey := r.PathValue("key")
+	if queueKey == "" {
+		writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, "queue key is required")
+		return
+	}
+
+	var stopReserving bool
+	if param := r.URL.Query().Get("stop_reserving"); param != "" {
+		var err error
+		stopReserving, err = strconv.ParseBool(param)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, "invalid stop_reserving")
+			return
+		}
+	}
+
+	if err := a.store.SetQueuePaused(r.Context(), queueKey, true, stopReserving); err != nil {
+		a.logger.Error().Err(err).Str("queue", queueKey).Msg("Error pausing queue")
+		writeError(w, http.StatusInternalServerError, types.ErrorInternal, "internal server error")
+		return
+	}
+	a.logger.Info().Str("queue", queueKey).Bool("stop_reserving", stopReserving).Msg("Paused queue")
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *API) handleResumeQueue(w http.ResponseWriter, r *http.Request) {
+	queueKey := r.PathValue("key")
+	if queueKey == "" {
+		writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, "queue key is required")
+		return
+	}
+
+	if err := a.store.SetQueuePaused(r.Context(), queueKey, false, false); err != nil {
+		a.logger.Error().Err(err).Str("queue", queueKey).Msg("Error resuming queue")
+		writeError(w, http.StatusInternalServerError, types.ErrorInternal, "internal server error")
+		return
+	}
+	a.logger.Info().Str("queue", queueKey).Msg("Resumed queue")
 
 	w.WriteHeader(http.StatusOK)
 }
@@ -88,7 +784,7 @@ func (a *API) handleStats(w http.ResponseWriter, r *http.Request) {
 	stats, err := a.store.GetAllStats(r.Context())
 	if err != nil {
 		a.logger.Error().Err(err).Msg("Error getting stats")
-		http.Error(w, "internal server error", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, types.ErrorInternal, "internal server error")
 		return
 	}
 
@@ -101,18 +797,71 @@ func (a *API) handleStats(w http.ResponseWriter, r *http.Request) {
 	}
 	response["total"] = total
 
+	delayed, err := a.store.GetDelayedCount(r.Context())
+	if err != nil {
+		a.logger.Error().Err(err).Msg("Error getting delayed count")
+		writeError(w, http.StatusInternalServerError, types.ErrorInternal, "internal server error")
+		return
+	}
+	response["delayed"] = delayed
+
+	paused, err := a.store.PausedQueues(r.Context())
+	if err != nil {
+		a.logger.Error().Err(err).Msg("Error getting paused queues")
+		writeError(w, http.StatusInternalServerError, types.ErrorInternal, "internal server error")
+		return
+	}
+	response["paused_queues"] = paused
+	response["monitored_queues"] = a.stacks.Queues()
+
+	deadLetter, err := a.store.GetDeadLetterCount(r.Context())
+	if err != nil {
+		a.logger.Error().Err(err).Msg("Error getting dead-letter count")
+		writeError(w, http.StatusInternalServerError, types.ErrorInternal, "internal server error")
+		return
+	}
+	response["dead_letter"] = deadLetter
+
+	drift, err := a.store.GetDrift(r.Context())
+	if err != nil {
+		a.logger.Error().Err(err).Msg("Error getting drift")
+		writeError(w, http.StatusInternalServerError, types.ErrorInternal, "internal server error")
+		return
+	}
+	response["drift"] = drift
+
+	since := time.Now().Add(-a.workerTimeout())
+	workers, err := a.store.ActiveWorkerCount(r.Context(), since)
+	if err != nil {
+		a.logger.Error().Err(err).Msg("Error counting workers")
+		writeError(w, http.StatusInternalServerError, types.ErrorInternal, "internal server error")
+		return
+	}
+	response["workers"] = workers
+
+	capacity, err := a.store.ActiveCapacity(r.Context(), since)
+	if err != nil {
+		a.logger.Error().Err(err).Msg("Error getting worker capacity")
+		writeError(w, http.StatusInternalServerError, types.ErrorInternal, "internal server error")
+		return
+	}
+	response["capacity"] = capacity
+
+	unhealthy, err := a.store.UnhealthyWorkers(r.Context())
+	if err != nil {
+		a.logger.Error().Err(err).Msg("Error getting unhealthy workers")
+		writeError(w, http.StatusInternalServerError, types.ErrorInternal, "internal server error")
+		return
+	}
+	response["unhealthy_workers"] = unhealthy
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
 func (a *API) Handler() http.Handler {
-	mux := http.NewServeMux()
-	mux.HandleFunc("GET /health", a.handleHealth)
-	mux.HandleFunc("GET /jobs", a.handleGetJob)
-	mux.HandleFunc("POST /jobs/{uuid}/complete", a.handleCompleteJob)
-	mux.HandleFunc("GET /stats", a.handleStats)
-
-	handler := hlog.RequestIDHandler("request_id", "Request-Id")(mux)
+	mux := a.routeMux()
+	handler := hlog.RequestIDHandler("request_id", "Request-Id")(tracing.Route(mux, a.audit(mux)))
 	handler = hlog.AccessHandler(func(r *http.Request, status, size int, duration time.Duration) {
 		hlog.FromRequest(r).Info().
 			Str("method", r.Method).
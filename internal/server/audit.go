@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/storage"
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+	"github.com/rs/zerolog/hlog"
+)
+
+// audit records requests to the routes in auditedActions in the audit log
+// once mux has handled them. Claims are only recorded if they got a job.
+func (a *API) audit(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := mux.Handler(r)
+		action, ok := auditedActions[pattern]
+		if !ok {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		mux.ServeHTTP(rec, r)
+		if action == "claim" && rec.status == http.StatusNoContent {
+			return
+		}
+
+		entry := &types.AuditEntry{
+			Time:       time.Now(),
+			Action:     action,
+			Actor:      auditActor(r),
+			RemoteAddr: r.RemoteAddr,
+			Status:     rec.status,
+			Outcome:    "ok",
+		}
+		if id, ok := hlog.IDFromRequest(r); ok {
+			entry.RequestID = id.String()
+		}
+		// Serving the request filled in its path values.
+		for _, name := range []string{"uuid", "key", "id"} {
+			if value := r.PathValue(name); value != "" {
+				entry.Target = value
+			}
+		}
+		switch {
+		case action == "claim" && rec.status == http.StatusOK:
+			var job types.Job
+			if json.Unmarshal(rec.body.Bytes(), &job) == nil {
+				entry.Target = job.UUID
+			}
+		case action == "register_worker":
+			entry.Target = r.Header.Get("X-Worker-ID")
+		case action == "create_token" && rec.status == http.StatusOK:
+			var token types.APIToken
+			if json.Unmarshal(rec.body.Bytes(), &token) == nil {
+				entry.Target = token.ID
+			}
+		}
+		if rec.status >= 400 {
+			entry.Outcome = http.StatusText(rec.status)
+			var apiErr types.APIErrorResponse
+			if json.Unmarshal(rec.body.Bytes(), &apiErr) == nil && apiErr.Error.Code != "" {
+				entry.Outcome = apiErr.Error.Code
+			}
+		}
+
+		if err := a.store.AppendAudit(context.WithoutCancel(r.Context()), entry); err != nil {
+			a.logger.Error().Err(err).Str("action", action).Msg("Error recording audit entry")
+		}
+	})
+}
+
+// auditActor identifies who made a request: workers by their X-Worker-ID,
+// and operators as "ui", "admin", or "admin:" and the name of their token.
+func auditActor(r *http.Request) string {
+	if isUIPath(r.URL.Path) {
+		return "ui"
+	}
+	if workerID := r.Header.Get("X-Worker-ID"); workerID != "" {
+		return workerID
+	}
+	if token := requestToken(r); token != nil {
+		return "admin:" + token.Name
+	}
+	return "admin"
+}
+
+func (a *API) handleListAudit(w http.ResponseWriter, r *http.Request) {
+	limit, ok := pageLimit(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	cursor := query.Get("cursor")
+	if cursor != "" && !isStreamID(cursor) {
+		writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, "invalid cursor")
+		return
+	}
+	filter := storage.AuditFilter{
+		Actor:  query.Get("actor"),
+		Action: query.Get("action"),
+		Target: query.Get("target"),
+	}
+
+	entries, next, err := a.store.ListAudit(r.Context(), filter, cursor, limit)
+	if err != nil {
+		a.logger.Error().Err(err).Msg("Error listing audit log")
+		writeError(w, http.StatusInternalServerError, types.ErrorInternal, "internal server error")
+		return
+	}
+
+	response := map[string]any{"entries": entries}
+	if next != "" {
+		response["next_cursor"] = next
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// isStreamID reports whether s is a Redis stream ID, as audit cursors are.
+func isStreamID(s string) bool {
+	ms, seq, ok := strings.Cut(s, "-")
+	if !ok {
+		return false
+	}
+	_, msErr := strconv.ParseUint(ms, 10, 64)
+	_, seqErr := strconv.ParseUint(seq, 10, 64)
+	return msErr == nil && seqErr == nil
+}
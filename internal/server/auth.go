@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/storage"
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+	"github.com/rs/zerolog"
+)
+
+func isWorkerRoute(r *http.Request) bool {
+	_, pattern := workerRoutes.Handler(r)
+	return pattern != ""
+}
+
+type tokenContextKey struct{}
+
+// requestToken returns the minted token a request was authenticated with, or
+// nil if it used the shared token or none.
+func requestToken(r *http.Request) *types.APIToken {
+	token, _ := r.Context().Value(tokenContextKey{}).(*types.APIToken)
+	return token
+}
+
+// RequireToken rejects requests that don't carry token, or a token minted in
+// store, as an "Authorization: Bearer" header with 401. The shared token and
+// admin tokens may use every route; worker tokens only those workers need,
+// and only as the worker they're bound to, or get 403. The health
+// checks stay open for load balancers and probes. Browsers can't send bearer
+// tokens, so the web UI also takes the token as a basic auth password.
+// Rejections are logged to logger, since they never reach the API's request
+// logging.
+func RequireToken(token string, store *storage.RedisStore, logger *zerolog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && isProbePath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ui := isUIPath(r.URL.Path)
+		reject := func(status int, code, message string) {
+			logger.Warn().
+				Str("remote_addr", r.RemoteAddr).
+				Str("path", r.URL.Path).
+				Msg("Rejected unauthenticated request")
+			if status == http.StatusUnauthorized {
+				if ui {
+					w.Header().Set("WWW-Authenticate", `Basic realm="buildkite-custom-scheduler"`)
+				} else {
+					w.Header().Set("WWW-Authenticate", `Bearer realm="buildkite-custom-scheduler"`)
+				}
+			}
+			writeError(w, status, code, message)
+		}
+
+		presented, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok && ui {
+			_, presented, ok = r.BasicAuth()
+		}
+		if !ok || presented == "" {
+			reject(http.StatusUnauthorized, types.ErrorUnauthorized, "unauthorized")
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(presented), []byte(token)) == 1 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		minted, err := store.LookupAPIToken(r.Context(), presented)
+		if errors.Is(err, storage.ErrTokenNotFound) {
+			reject(http.StatusUnauthorized, types.ErrorUnauthorized, "unauthorized")
+			return
+		}
+		if err != nil {
+			logger.Error().Err(err).Msg("Error looking up API token")
+			writeError(w, http.StatusInternalServerError, types.ErrorInternal, "internal server error")
+			return
+		}
+		if minted.Scope != types.TokenScopeAdmin {
+			if !isWorkerRoute(r) {
+				reject(http.StatusForbidden, types.ErrorInsufficientScope, "token can't be used for this route")
+				return
+			}
+			// Tokens minted before worker tokens had to be bound could act as
+			// any worker, so they're refused.
+			if minted.WorkerID == "" {
+				reject(http.StatusForbidden, types.ErrorInsufficientScope, "worker token isn't bound to a worker")
+				return
+			}
+			if r.Header.Get("X-Worker-ID") != minted.WorkerID {
+				reject(http.StatusForbidden, types.ErrorInsufficientScope, "token is for worker "+minted.WorkerID)
+				return
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tokenContextKey{}, minted)))
+	})
+}
+
+// allowWorker reports whether r's token may act on workerID, writing 403 if
+// not. Worker tokens may only register, heartbeat and deregister the worker
+// they're bound to.
+func allowWorker(w http.ResponseWriter, r *http.Request, workerID string) bool {
+	token := requestToken(r)
+	if token == nil || token.Scope == types.TokenScopeAdmin || token.WorkerID == workerID {
+		return true
+	}
+	writeError(w, http.StatusForbidden, types.ErrorInsufficientScope, "token is for worker "+token.WorkerID)
+	return false
+}
+
+// RefuseMinting rejects requests to mint tokens with 403, for servers left
+// open without a shared token, which would never check what they minted.
+func RefuseMinting(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && r.URL.Path == "/tokens" {
+			writeError(w, http.StatusForbidden, types.ErrorAuthDisabled, "set API_TOKEN to mint tokens")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isProbePath(path string) bool {
+	return path == "/health" || path == "/livez" || path == "/readyz"
+}
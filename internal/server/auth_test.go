@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/storage"
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+	"github.com/rs/zerolog"
+)
+
+func TestRequireTokenWorkerBinding(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	logger := zerolog.Nop()
+	handler := RequireToken("shared", store, &logger, NewAPI(store, nil, nil, &logger, storage.ClaimOptions{}, 0))
+
+	workerA, _, err := store.CreateAPIToken(ctx, "a", types.TokenScopeWorker, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unbound, _, err := store.CreateAPIToken(ctx, "legacy", types.TokenScopeWorker, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, _, err := store.CreateAPIToken(ctx, "ops", types.TokenScopeAdmin, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		token    string
+		workerID string
+		method   string
+		path     string
+		body     string
+		want     int
+	}{
+		{"register", workerA, "a", http.MethodPost, "/v1/workers", `{"id":"a"}`, http.StatusOK},
+		{"register as another worker", workerA, "a", http.MethodPost, "/v1/workers", `{"id":"b"}`, http.StatusForbidden},
+		{"heartbeat", workerA, "a", http.MethodPost, "/v1/workers/a/heartbeat", "", http.StatusOK},
+		{"heartbeat another worker", workerA, "a", http.MethodPost, "/v1/workers/b/heartbeat", "", http.StatusForbidden},
+		{"deregister another worker", workerA, "a", http.MethodDelete, "/v1/workers/b", "", http.StatusForbidden},
+		{"deregister another worker unversioned", workerA, "a", http.MethodDelete, "/workers/b", "", http.StatusForbidden},
+		{"other X-Worker-ID", workerA, "b", http.MethodPost, "/v1/workers/b/heartbeat", "", http.StatusForbidden},
+		{"no X-Worker-ID", workerA, "", http.MethodPost, "/v1/workers/a/heartbeat", "", http.StatusForbidden},
+		{"unbound token", unbound, "b", http.MethodPost, "/v1/workers/b/heartbeat", "", http.StatusForbidden},
+		{"admin route", workerA, "a", http.MethodGet, "/workers", "", http.StatusForbidden},
+		{"deregister", workerA, "a", http.MethodDelete, "/v1/workers/a", "", http.StatusOK},
+		{"admin token", admin, "", http.MethodDelete, "/v1/workers/b", "", http.StatusOK},
+		{"shared token", "shared", "", http.MethodPost, "/v1/workers", `{"id":"b"}`, http.StatusOK},
+	} {
+		r := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		r.Header.Set("Authorization", "Bearer "+tc.token)
+		if tc.workerID != "" {
+			r.Header.Set("X-Worker-ID", tc.workerID)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tc.want {
+			t.Errorf("%s: got %d, want %d: %s", tc.name, w.Code, tc.want, w.Body)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ErrCircuitOpen is returned instead of calling the Stacks API while its
+// circuit breaker is open.
+var ErrCircuitOpen = errors.New("stacks API circuit breaker is open")
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitOpen:
+		return "open"
+	case circuitHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// CircuitBreaker stops a stack's calls to the Stacks API after threshold
+// failures in a row (errors and 5xx responses; throttling is handled
+// separately), so a persistent outage doesn't cost a timeout per queue per
+// poll. After cooldown it lets one call through to probe for recovery,
+// closing again if it succeeds and staying open for another cooldown if not.
+type CircuitBreaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    circuitState
+	failures int
+	openedAt time.Time
+}
+
+func NewCircuitBreaker(name string, threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{name: name, threshold: threshold, cooldown: cooldown}
+}
+
+// Open reports whether calls are being refused: the breaker is open and not
+// yet due a probe, or a probe is in flight. A nil breaker never opens.
+func (b *CircuitBreaker) Open() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case circuitOpen:
+		return time.Since(b.openedAt) < b.cooldown
+	case circuitHalfOpen:
+		return true
+	}
+	return false
+}
+
+// allow reports whether a call may go ahead, making it the probe if the
+// breaker is due one.
+func (b *CircuitBreaker) allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case circuitOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.setState(circuitHalfOpen)
+		return true
+	case circuitHalfOpen:
+		return false
+	}
+	return true
+}
+
+// record notes the outcome of a call allow let through.
+func (b *CircuitBreaker) record(failed bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !failed {
+		b.failures = 0
+		if b.state != circuitClosed {
+			b.setState(circuitClosed)
+		}
+		return
+	}
+	b.failures++
+	if b.state == circuitHalfOpen || b.failures >= b.threshold {
+		b.openedAt = time.Now()
+		if b.state != circuitOpen {
+			b.setState(circuitOpen)
+		}
+	}
+}
+
+func (b *CircuitBreaker) setState(state circuitState) {
+	b.state = state
+	event := log.Info()
+	if state == circuitOpen {
+		event = log.Warn().Int("failures", b.failures).Dur("cooldown", b.cooldown)
+	}
+	event.Str("stack_key", b.name).Str("state", state.String()).Msg("Stacks API circuit breaker changed state")
+}
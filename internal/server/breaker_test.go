@@ -0,0 +1,69 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerOpensAfterThreshold(t *testing.T) {
+	b := NewCircuitBreaker("stack", 3, time.Hour)
+	for i := range 2 {
+		if !b.allow() {
+			t.Fatalf("call %d refused before the threshold", i+1)
+		}
+		b.record(true)
+	}
+	// A success resets the count.
+	b.record(false)
+	for range 2 {
+		b.record(true)
+	}
+	if b.Open() {
+		t.Fatal("opened on failures that weren't in a row")
+	}
+	b.record(true)
+	if !b.Open() || b.allow() {
+		t.Fatal("still letting calls through after 3 failures in a row")
+	}
+}
+
+func TestCircuitBreakerProbes(t *testing.T) {
+	b := NewCircuitBreaker("stack", 1, 10*time.Millisecond)
+	b.record(true)
+	if !b.Open() {
+		t.Fatal("didn't open")
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	if b.Open() {
+		t.Fatal("still open once due a probe")
+	}
+	if !b.allow() {
+		t.Fatal("refused the probe")
+	}
+	if !b.Open() || b.allow() {
+		t.Fatal("let a second call through while the probe was in flight")
+	}
+
+	// A failed probe reopens the breaker for another cooldown.
+	b.record(true)
+	if !b.Open() {
+		t.Fatal("didn't reopen after a failed probe")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if !b.allow() {
+		t.Fatal("refused the second probe")
+	}
+	b.record(false)
+	if b.Open() || !b.allow() {
+		t.Fatal("didn't close after a successful probe")
+	}
+}
+
+func TestNilCircuitBreaker(t *testing.T) {
+	var b *CircuitBreaker
+	b.record(true)
+	if b.Open() || !b.allow() {
+		t.Fatal("a nil breaker refused a call")
+	}
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// corsAllowedHeaders are the request headers browsers may send cross-origin.
+const corsAllowedHeaders = "Authorization, Content-Type, X-Worker-ID, X-Claim-Token, Idempotency-Key, Scheduler-API-Version"
+
+// CORS lets pages served from origins call the API from the browser with the
+// given methods. "*" allows any origin. Preflight requests are answered here,
+// before authentication, since browsers send them without credentials.
+func CORS(origins, methods []string, next http.Handler) http.Handler {
+	allowMethods := strings.Join(methods, ", ")
+	anyOrigin := slices.Contains(origins, "*")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		if !anyOrigin && !slices.Contains(origins, origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", allowMethods)
+			w.Header().Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Access-Control-Expose-Headers", "Retry-After, Request-Id, Idempotent-Replayed, Scheduler-API-Version, Deprecation, Link")
+		next.ServeHTTP(w, r)
+	})
+}
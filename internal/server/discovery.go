@@ -0,0 +1,142 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// buildkiteAPIURL is Buildkite's REST API, which lists a cluster's queues;
+// the Stacks API can't.
+const buildkiteAPIURL = "https://api.buildkite.com/v2"
+
+// QueueDiscoveryConfig says where to discover queues and which to poll.
+type QueueDiscoveryConfig struct {
+	// APIToken is a Buildkite API access token with the read_clusters scope.
+	APIToken  string
+	Org       string
+	ClusterID string
+
+	// Patterns are path.Match patterns, e.g. linux-*; discovered queues
+	// matching any of them are polled.
+	Patterns []string
+	Interval time.Duration
+}
+
+// QueueDiscovery periodically lists the cluster's queues and has the monitor
+// poll those matching its patterns, alongside the configured queues. Queues
+// that disappear or stop matching are no longer polled. If listing fails, the
+// monitor keeps polling the queues it has.
+type QueueDiscovery struct {
+	cfg        QueueDiscoveryConfig
+	monitor    *Monitor
+	httpClient *http.Client
+}
+
+func NewQueueDiscovery(cfg QueueDiscoveryConfig, monitor *Monitor) (*QueueDiscovery, error) {
+	if cfg.APIToken == "" || cfg.Org == "" || cfg.ClusterID == "" {
+		return nil, fmt.Errorf("queue discovery needs a Buildkite API token, organization and cluster ID")
+	}
+	for _, pattern := range cfg.Patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("queue pattern %q: %w", pattern, err)
+		}
+	}
+	return &QueueDiscovery{cfg: cfg, monitor: monitor, httpClient: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+func (d *QueueDiscovery) Start(ctx context.Context) error {
+	ticker := time.NewTicker(d.cfg.Interval)
+	defer ticker.Stop()
+
+	log.Info().Strs("patterns", d.cfg.Patterns).Dur("interval", d.cfg.Interval).Msg("Starting queue discovery")
+
+	for {
+		if err := d.discover(ctx); err != nil {
+			log.Error().Err(err).Msg("Error discovering queues")
+		}
+		select {
+		case <-ctx.Done():
+			log.Info().Msg("Queue discovery shutting down")
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *QueueDiscovery) discover(ctx context.Context) error {
+	keys, err := d.listQueues(ctx)
+	if err != nil {
+		return err
+	}
+	var matched []string
+	for _, key := range keys {
+		if d.matches(key) {
+			matched = append(matched, key)
+		}
+	}
+	log.Debug().Int("queues", len(keys)).Strs("matched", matched).Msg("Discovered queues")
+	d.monitor.SetQueues(matched)
+	return nil
+}
+
+func (d *QueueDiscovery) matches(key string) bool {
+	for _, pattern := range d.cfg.Patterns {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// nextLink finds the next page's URL in a Link header.
+var nextLink = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// listQueues returns the keys of every queue in the cluster.
+func (d *QueueDiscovery) listQueues(ctx context.Context) ([]string, error) {
+	next := fmt.Sprintf("%s/organizations/%s/clusters/%s/queues?per_page=100",
+		buildkiteAPIURL, url.PathEscape(d.cfg.Org), url.PathEscape(d.cfg.ClusterID))
+	var keys []string
+	for next != "" {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, next, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+d.cfg.APIToken)
+
+		resp, err := d.httpClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("listing cluster queues: %w", err)
+		}
+		var queues []struct {
+			Key string `json:"key"`
+		}
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+			resp.Body.Close()
+			return nil, fmt.Errorf("listing cluster queues: %s: %s", resp.Status, body)
+		}
+		err = json.NewDecoder(resp.Body).Decode(&queues)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("decoding cluster queues: %w", err)
+		}
+		for _, queue := range queues {
+			keys = append(keys, queue.Key)
+		}
+
+		next = ""
+		if m := nextLink.FindStringSubmatch(resp.Header.Get("Link")); m != nil {
+			next = m[1]
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/storage"
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+)
+
+type envRequest struct {
+	Env map[string]string `json:"env"`
+}
+
+// decodeEnvRequest reads and validates an env request body, writing an error
+// response and returning false if it is invalid.
+func decodeEnvRequest(w http.ResponseWriter, r *http.Request) (map[string]string, bool) {
+	var req envRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, "invalid request body")
+		return nil, false
+	}
+	if err := types.ValidateEnv(req.Env); err != nil {
+		writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, err.Error())
+		return nil, false
+	}
+	return req.Env, true
+}
+
+func (a *API) handleGetQueueEnv(w http.ResponseWriter, r *http.Request) {
+	queueKey := r.PathValue("key")
+	if queueKey == "" {
+		writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, "queue key is required")
+		return
+	}
+
+	env, err := a.store.GetQueueEnv(r.Context(), queueKey)
+	if err != nil {
+		a.logger.Error().Err(err).Str("queue", queueKey).Msg("Error getting queue env")
+		writeError(w, http.StatusInternalServerError, types.ErrorInternal, "internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(envRequest{Env: env})
+}
+
+func (a *API) handleSetQueueEnv(w http.ResponseWriter, r *http.Request) {
+	queueKey := r.PathValue("key")
+	if queueKey == "" {
+		writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, "queue key is required")
+		return
+	}
+
+	env, ok := decodeEnvRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if err := a.store.SetQueueEnv(r.Context(), queueKey, env); err != nil {
+		a.logger.Error().Err(err).Str("queue", queueKey).Msg("Error setting queue env")
+		writeError(w, http.StatusInternalServerError, types.ErrorInternal, "internal server error")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *API) handleClearQueueEnv(w http.ResponseWriter, r *http.Request) {
+	queueKey := r.PathValue("key")
+	if queueKey == "" {
+		writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, "queue key is required")
+		return
+	}
+
+	if err := a.store.SetQueueEnv(r.Context(), queueKey, nil); err != nil {
+		a.logger.Error().Err(err).Str("queue", queueKey).Msg("Error clearing queue env")
+		writeError(w, http.StatusInternalServerError, types.ErrorInternal, "internal server error")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *API) handleSetJobEnv(w http.ResponseWriter, r *http.Request) {
+	uuid := r.PathValue("uuid")
+	if uuid == "" {
+		writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, "job uuid is required")
+		return
+	}
+
+	env, ok := decodeEnvRequest(w, r)
+	if !ok {
+		return
+	}
+
+	err := a.store.SetJobEnv(r.Context(), uuid, env)
+	if errors.Is(err, storage.ErrJobNotFound) {
+		writeError(w, http.StatusNotFound, types.ErrorNotFound, "job not found")
+		return
+	}
+	if errors.Is(err, storage.ErrJobNotPending) {
+		writeError(w, http.StatusConflict, types.ErrorNotPending, "job is not pending")
+		return
+	}
+	if err != nil {
+		a.logger.Error().Err(err).Str("uuid", uuid).Msg("Error setting job env")
+		writeError(w, http.StatusInternalServerError, types.ErrorInternal, "internal server error")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
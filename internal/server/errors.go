@@ -0,0 +1,17 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+)
+
+// writeError replies with a JSON error response carrying one of the
+// types.Error* codes.
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(types.APIErrorResponse{Error: types.APIError{Code: code, Message: message}})
+}
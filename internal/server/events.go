@@ -0,0 +1,63 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// eventPingInterval is how often idle event streams are pinged, so proxies
+// don't time them out.
+const eventPingInterval = 30 * time.Second
+
+// handleEventsWebSocket streams job lifecycle events to the client as JSON
+// text frames. ?types=claimed,failed limits the stream to those event types.
+func (a *API) handleEventsWebSocket(w http.ResponseWriter, r *http.Request) {
+	var wanted map[string]bool
+	if typesParam := r.URL.Query().Get("types"); typesParam != "" {
+		wanted = map[string]bool{}
+		for _, t := range splitRules(typesParam) {
+			wanted[t] = true
+		}
+	}
+
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		a.logger.Debug().Err(err).Msg("Rejected event stream")
+		return
+	}
+	defer conn.Close()
+
+	// Once hijacked, the request's context isn't cancelled when the client
+	// goes away, so watch the connection and the server instead.
+	events := a.store.SubscribeJobEvents(r.Context())
+	ping := time.NewTicker(eventPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-conn.Closed():
+			return
+		case <-a.notifier.Done():
+			return
+		case <-ping.C:
+			if conn.writeFrame(wsOpPing, nil) != nil {
+				return
+			}
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if wanted != nil && !wanted[event.Type] {
+				continue
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			if conn.WriteText(data) != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,436 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/proto/schedulerv1"
+	"github.com/buildkite/buildkite-custom-scheduler/internal/storage"
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// auditedMethods names the AdminService methods recorded in the audit log,
+// with the same actions as their HTTP routes.
+var auditedMethods = map[string]string{
+	schedulerv1.AdminService_PauseQueue_FullMethodName:  "pause",
+	schedulerv1.AdminService_ResumeQueue_FullMethodName: "resume",
+	schedulerv1.AdminService_RequeueJob_FullMethodName:  "requeue",
+	schedulerv1.AdminService_AbandonJob_FullMethodName:  "abandon",
+}
+
+// NewGRPCServer serves the AdminService from api's store and stacks. If
+// token isn't empty, calls must carry it, or an admin token minted in the
+// store, as "authorization: Bearer" metadata; worker tokens are refused, as
+// every AdminService method is an operator one. Like the HTTP API, it's left
+// open without token, which servers only allow while no tokens are minted.
+func NewGRPCServer(api *API, token string, opts ...grpc.ServerOption) *grpc.Server {
+	interceptors := []grpc.UnaryServerInterceptor{api.auditRPC}
+	if token != "" {
+		interceptors = append([]grpc.UnaryServerInterceptor{api.requireTokenRPC(token)}, interceptors...)
+	}
+	opts = append(opts, grpc.ChainUnaryInterceptor(interceptors...))
+	server := grpc.NewServer(opts...)
+	schedulerv1.RegisterAdminServiceServer(server, &adminServer{api: api})
+	return server
+}
+
+// rpcError is the gRPC counterpart of writeError, keeping the HTTP status
+// and types.Error* code the HTTP API would have replied with for the audit
+// log.
+type rpcError struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *rpcError) Error() string {
+	return e.message
+}
+
+func (e *rpcError) GRPCStatus() *status.Status {
+	code := codes.Internal
+	switch e.status {
+	case http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case http.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case http.StatusForbidden:
+		code = codes.PermissionDenied
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusConflict:
+		code = codes.FailedPrecondition
+	}
+	return status.New(code, e.code+": "+e.message)
+}
+
+func rpcErrorf(status int, code, message string) error {
+	return &rpcError{status: status, code: code, message: message}
+}
+
+var errRPCInternal = rpcErrorf(http.StatusInternalServerError, types.ErrorInternal, "internal server error")
+
+// requireTokenRPC is RequireToken for the AdminService.
+func (a *API) requireTokenRPC(token string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		reject := func(err error) (any, error) {
+			a.logger.Warn().Str("remote_addr", peerAddr(ctx)).Str("method", info.FullMethod).Msg("Rejected unauthenticated request")
+			return nil, err
+		}
+
+		var presented string
+		md, _ := metadata.FromIncomingContext(ctx)
+		if values := md.Get("authorization"); len(values) > 0 {
+			presented, _ = strings.CutPrefix(values[0], "Bearer ")
+		}
+		if presented == "" {
+			return reject(rpcErrorf(http.StatusUnauthorized, types.ErrorUnauthorized, "unauthorized"))
+		}
+		if subtle.ConstantTimeCompare([]byte(presented), []byte(token)) == 1 {
+			return handler(ctx, req)
+		}
+
+		minted, err := a.store.LookupAPIToken(ctx, presented)
+		if errors.Is(err, storage.ErrTokenNotFound) {
+			return reject(rpcErrorf(http.StatusUnauthorized, types.ErrorUnauthorized, "unauthorized"))
+		}
+		if err != nil {
+			a.logger.Error().Err(err).Msg("Error looking up API token")
+			return nil, errRPCInternal
+		}
+		if minted.Scope != types.TokenScopeAdmin {
+			return reject(rpcErrorf(http.StatusForbidden, types.ErrorInsufficientScope, "token can't be used for this method"))
+		}
+		return handler(context.WithValue(ctx, tokenContextKey{}, minted), req)
+	}
+}
+
+// auditRPC records calls to the methods in auditedMethods in the audit log.
+func (a *API) auditRPC(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	action, ok := auditedMethods[info.FullMethod]
+	if !ok {
+		return handler(ctx, req)
+	}
+	resp, err := handler(ctx, req)
+
+	entry := &types.AuditEntry{
+		Time:       time.Now(),
+		Action:     action,
+		Actor:      "admin",
+		RemoteAddr: peerAddr(ctx),
+		Status:     http.StatusOK,
+		Outcome:    "ok",
+	}
+	if token, _ := ctx.Value(tokenContextKey{}).(*types.APIToken); token != nil {
+		entry.Actor = "admin:" + token.Name
+	}
+	switch req := req.(type) {
+	case interface{ GetQueueKey() string }:
+		entry.Target = req.GetQueueKey()
+	case interface{ GetUuid() string }:
+		entry.Target = req.GetUuid()
+	}
+	if err != nil {
+		entry.Status, entry.Outcome = http.StatusInternalServerError, types.ErrorInternal
+		var rpcErr *rpcError
+		if errors.As(err, &rpcErr) {
+			entry.Status, entry.Outcome = rpcErr.status, rpcErr.code
+		}
+	}
+
+	if err := a.store.AppendAudit(context.WithoutCancel(ctx), entry); err != nil {
+		a.logger.Error().Err(err).Str("action", action).Msg("Error recording audit entry")
+	}
+	return resp, err
+}
+
+func peerAddr(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		return p.Addr.String()
+	}
+	return ""
+}
+
+// adminServer implements the AdminService's methods on top of the same
+// store calls as their HTTP routes.
+type adminServer struct {
+	schedulerv1.UnimplementedAdminServiceServer
+	api *API
+}
+
+func (s *adminServer) GetStats(ctx context.Context, _ *schedulerv1.GetStatsRequest) (*schedulerv1.GetStatsResponse, error) {
+	a := s.api
+	resp := &schedulerv1.GetStatsResponse{MonitoredQueues: a.stacks.Queues()}
+	var err error
+	if resp.Queues, err = a.store.GetAllStats(ctx); err != nil {
+		a.logger.Error().Err(err).Msg("Error getting stats")
+		return nil, errRPCInternal
+	}
+	for _, count := range resp.Queues {
+		resp.Total += count
+	}
+	if resp.Delayed, err = a.store.GetDelayedCount(ctx); err != nil {
+		a.logger.Error().Err(err).Msg("Error getting delayed count")
+		return nil, errRPCInternal
+	}
+	if resp.PausedQueues, err = a.store.PausedQueues(ctx); err != nil {
+		a.logger.Error().Err(err).Msg("Error getting paused queues")
+		return nil, errRPCInternal
+	}
+	if resp.DeadLetter, err = a.store.GetDeadLetterCount(ctx); err != nil {
+		a.logger.Error().Err(err).Msg("Error getting dead-letter count")
+		return nil, errRPCInternal
+	}
+	if resp.Drift, err = a.store.GetDrift(ctx); err != nil {
+		a.logger.Error().Err(err).Msg("Error getting drift")
+		return nil, errRPCInternal
+	}
+	since := time.Now().Add(-a.workerTimeout())
+	if resp.Workers, err = a.store.ActiveWorkerCount(ctx, since); err != nil {
+		a.logger.Error().Err(err).Msg("Error counting workers")
+		return nil, errRPCInternal
+	}
+	if resp.Capacity, err = a.store.ActiveCapacity(ctx, since); err != nil {
+		a.logger.Error().Err(err).Msg("Error getting worker capacity")
+		return nil, errRPCInternal
+	}
+	if resp.UnhealthyWorkers, err = a.store.UnhealthyWorkers(ctx); err != nil {
+		a.logger.Error().Err(err).Msg("Error getting unhealthy workers")
+		return nil, errRPCInternal
+	}
+	return resp, nil
+}
+
+func (s *adminServer) ListQueues(ctx context.Context, _ *schedulerv1.ListQueuesRequest) (*schedulerv1.ListQueuesResponse, error) {
+	queues, err := s.api.store.ListQueues(ctx)
+	if err != nil {
+		s.api.logger.Error().Err(err).Msg("Error listing queues")
+		return nil, errRPCInternal
+	}
+	resp := &schedulerv1.ListQueuesResponse{}
+	for _, queue := range queues {
+		resp.Queues = append(resp.Queues, &schedulerv1.QueueSummary{
+			QueryRules:          queue.QueryRules,
+			Depth:               queue.Depth,
+			OldestJobAgeSeconds: queue.OldestJobAge,
+			ClaimsPerMinute:     queue.ClaimRate,
+		})
+	}
+	return resp, nil
+}
+
+func (s *adminServer) ListQueueJobs(ctx context.Context, req *schedulerv1.ListQueueJobsRequest) (*schedulerv1.ListQueueJobsResponse, error) {
+	if req.QueueKey == "" {
+		return nil, rpcErrorf(http.StatusBadRequest, types.ErrorInvalidRequest, "queue key is required")
+	}
+	limit := defaultPageSize
+	if req.Limit < 0 {
+		return nil, rpcErrorf(http.StatusBadRequest, types.ErrorInvalidRequest, "invalid limit")
+	}
+	if req.Limit > 0 {
+		limit = min(int(req.Limit), maxPageSize)
+	}
+	offset := 0
+	if req.Cursor != "" {
+		var err error
+		if offset, err = strconv.Atoi(req.Cursor); err != nil || offset < 0 {
+			return nil, rpcErrorf(http.StatusBadRequest, types.ErrorInvalidRequest, "invalid cursor")
+		}
+	}
+
+	jobs, next, err := s.api.store.ListQueueJobs(ctx, req.QueueKey, offset, limit)
+	if err != nil {
+		s.api.logger.Error().Err(err).Str("queue", req.QueueKey).Msg("Error listing queue jobs")
+		return nil, errRPCInternal
+	}
+	resp := &schedulerv1.ListQueueJobsResponse{}
+	for _, job := range jobs {
+		resp.Jobs = append(resp.Jobs, &schedulerv1.QueuedJob{Job: jobProto(job.Job), Status: job.Status})
+	}
+	if next > 0 {
+		resp.NextCursor = strconv.Itoa(next)
+	}
+	return resp, nil
+}
+
+func (s *adminServer) PauseQueue(ctx context.Context, req *schedulerv1.PauseQueueRequest) (*schedulerv1.PauseQueueResponse, error) {
+	if req.QueueKey == "" {
+		return nil, rpcErrorf(http.StatusBadRequest, types.ErrorInvalidRequest, "queue key is required")
+	}
+	if err := s.api.store.SetQueuePaused(ctx, req.QueueKey, true, req.StopReserving); err != nil {
+		s.api.logger.Error().Err(err).Str("queue", req.QueueKey).Msg("Error pausing queue")
+		return nil, errRPCInternal
+	}
+	s.api.logger.Info().Str("queue", req.QueueKey).Bool("stop_reserving", req.StopReserving).Msg("Paused queue")
+	return &schedulerv1.PauseQueueResponse{}, nil
+}
+
+func (s *adminServer) ResumeQueue(ctx context.Context, req *schedulerv1.ResumeQueueRequest) (*schedulerv1.ResumeQueueResponse, error) {
+	if req.QueueKey == "" {
+		return nil, rpcErrorf(http.StatusBadRequest, types.ErrorInvalidRequest, "queue key is required")
+	}
+	if err := s.api.store.SetQueuePaused(ctx, req.QueueKey, false, false); err != nil {
+		s.api.logger.Error().Err(err).Str("queue", req.QueueKey).Msg("Error resuming queue")
+		return nil, errRPCInternal
+	}
+	s.api.logger.Info().Str("queue", req.QueueKey).Msg("Resumed queue")
+	return &schedulerv1.ResumeQueueResponse{}, nil
+}
+
+func (s *adminServer) GetJob(ctx context.Context, req *schedulerv1.GetJobRequest) (*schedulerv1.JobDetail, error) {
+	if req.Uuid == "" {
+		return nil, rpcErrorf(http.StatusBadRequest, types.ErrorInvalidRequest, "job uuid is required")
+	}
+	detail, err := s.api.store.GetJobDetail(ctx, req.Uuid)
+	if errors.Is(err, storage.ErrJobNotFound) {
+		return nil, rpcErrorf(http.StatusNotFound, types.ErrorNotFound, "job not found")
+	}
+	if err != nil {
+		s.api.logger.Error().Err(err).Str("uuid", req.Uuid).Msg("Error getting job")
+		return nil, errRPCInternal
+	}
+
+	resp := &schedulerv1.JobDetail{
+		Job:                  jobProto(detail.Job),
+		Status:               detail.Status,
+		ClaimedBy:            detail.ClaimedBy,
+		Attempts:             int32(detail.Attempts),
+		ClaimedAt:            timestamp(detail.ClaimedAt),
+		StartedAt:            timestamp(detail.StartedAt),
+		CompletedAt:          timestamp(detail.CompletedAt),
+		LeaseExpiresAt:       timestamp(detail.LeaseExpiresAt),
+		ReservationExpiresAt: timestamp(detail.ReservationExpiresAt),
+	}
+	for _, failure := range detail.Failures {
+		resp.Failures = append(resp.Failures, &schedulerv1.JobFailure{
+			Error:    failure.Error,
+			WorkerId: failure.WorkerID,
+			FailedAt: timestamp(failure.FailedAt),
+		})
+	}
+	return resp, nil
+}
+
+func (s *adminServer) RequeueJob(ctx context.Context, req *schedulerv1.RequeueJobRequest) (*schedulerv1.RequeueJobResponse, error) {
+	if req.Uuid == "" {
+		return nil, rpcErrorf(http.StatusBadRequest, types.ErrorInvalidRequest, "job uuid is required")
+	}
+	err := s.api.store.RequeueJob(ctx, req.Uuid)
+	switch {
+	case errors.Is(err, storage.ErrJobNotFound):
+		return nil, rpcErrorf(http.StatusNotFound, types.ErrorNotFound, "job not found")
+	case errors.Is(err, storage.ErrJobStarted):
+		return nil, rpcErrorf(http.StatusConflict, types.ErrorJobStarted, "job has started and can't be requeued")
+	case errors.Is(err, storage.ErrJobAlreadyComplete):
+		return nil, rpcErrorf(http.StatusConflict, types.ErrorAlreadyCompleted, "job is already complete")
+	case errors.Is(err, storage.ErrJobNotClaimed):
+		return nil, rpcErrorf(http.StatusConflict, types.ErrorNotClaimed, "job is not claimed")
+	case err != nil:
+		s.api.logger.Error().Err(err).Str("uuid", req.Uuid).Msg("Error requeueing job")
+		return nil, errRPCInternal
+	}
+	s.api.logger.Info().Str("uuid", req.Uuid).Msg("Requeued job")
+	return &schedulerv1.RequeueJobResponse{}, nil
+}
+
+func (s *adminServer) AbandonJob(ctx context.Context, req *schedulerv1.AbandonJobRequest) (*schedulerv1.AbandonJobResponse, error) {
+	if req.Uuid == "" {
+		return nil, rpcErrorf(http.StatusBadRequest, types.ErrorInvalidRequest, "job uuid is required")
+	}
+	err := s.api.stacks.AbandonJob(ctx, req.Uuid)
+	switch {
+	case errors.Is(err, storage.ErrJobNotFound):
+		return nil, rpcErrorf(http.StatusNotFound, types.ErrorNotFound, "job not found")
+	case errors.Is(err, storage.ErrJobNotPending):
+		return nil, rpcErrorf(http.StatusConflict, types.ErrorNotPending, "job is not pending")
+	case err != nil:
+		s.api.logger.Error().Err(err).Str("uuid", req.Uuid).Msg("Error abandoning job")
+		return nil, errRPCInternal
+	}
+	return &schedulerv1.AbandonJobResponse{}, nil
+}
+
+func (s *adminServer) ListWorkers(ctx context.Context, _ *schedulerv1.ListWorkersRequest) (*schedulerv1.ListWorkersResponse, error) {
+	workers, err := s.api.store.ListWorkers(ctx)
+	if err != nil {
+		s.api.logger.Error().Err(err).Msg("Error listing workers")
+		return nil, errRPCInternal
+	}
+	resp := &schedulerv1.ListWorkersResponse{}
+	for _, worker := range workers {
+		resp.Workers = append(resp.Workers, workerProto(&worker))
+	}
+	return resp, nil
+}
+
+func (s *adminServer) GetWorker(ctx context.Context, req *schedulerv1.GetWorkerRequest) (*schedulerv1.WorkerInfo, error) {
+	if req.Id == "" {
+		return nil, rpcErrorf(http.StatusBadRequest, types.ErrorInvalidRequest, "worker id is required")
+	}
+	worker, err := s.api.store.GetWorker(ctx, req.Id)
+	if errors.Is(err, storage.ErrWorkerNotFound) {
+		return nil, rpcErrorf(http.StatusNotFound, types.ErrorNotFound, "worker not found")
+	}
+	if err != nil {
+		s.api.logger.Error().Err(err).Str("worker_id", req.Id).Msg("Error getting worker")
+		return nil, errRPCInternal
+	}
+	return workerProto(worker), nil
+}
+
+func jobProto(job *types.Job) *schedulerv1.Job {
+	if job == nil {
+		return nil
+	}
+	return &schedulerv1.Job{
+		Uuid:            job.UUID,
+		QueueKey:        job.QueueKey,
+		BuildUuid:       job.BuildUUID,
+		PipelineSlug:    job.PipelineSlug,
+		AgentQueryRules: job.AgentQueryRules,
+		Priority:        int32(job.Priority),
+		ScheduledAt:     timestamp(job.ScheduledAt),
+		ReservedAt:      timestamp(job.ReservedAt),
+		NotBefore:       timestamp(job.NotBefore),
+		StackKey:        job.StackKey,
+		Resources:       job.Resources,
+	}
+}
+
+func workerProto(worker *types.WorkerInfo) *schedulerv1.WorkerInfo {
+	return &schedulerv1.WorkerInfo{
+		Id:           worker.ID,
+		Hostname:     worker.Hostname,
+		QueryRules:   worker.QueryRules,
+		Tags:         worker.Tags,
+		Executor:     worker.Executor,
+		Concurrency:  int32(worker.Concurrency),
+		Resources:    worker.Resources,
+		Status:       worker.Status,
+		LastSeen:     timestamp(worker.LastSeen),
+		RegisteredAt: timestamp(worker.RegisteredAt),
+		Health:       worker.Health,
+		HealthReason: worker.HealthReason,
+		ClaimedJobs:  worker.ClaimedJobs,
+	}
+}
+
+// timestamp converts t, leaving it unset if it's zero, as the HTTP API
+// would omit it.
+func timestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
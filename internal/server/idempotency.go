@@ -0,0 +1,109 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/storage"
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+)
+
+// maxIdempotentBodySize caps the body of a request with an Idempotency-Key,
+// which is read whole to tell replays from different requests.
+const maxIdempotentBodySize = 1 << 20
+
+// idempotent lets clients retry next safely by sending an Idempotency-Key
+// header: the first response for a key is recorded and replayed to later
+// requests with the same key, rather than running next again. Keys are scoped
+// to the sending worker. Server errors aren't recorded, so those can be
+// retried for real.
+func (a *API) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next(w, r)
+			return
+		}
+		key = r.Header.Get("X-Worker-ID") + ":" + key
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBodySize))
+		if err != nil {
+			writeError(w, http.StatusRequestEntityTooLarge, types.ErrorTooLarge, "request body too large")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(body)
+		request := r.Method + " " + r.URL.Path + " " + hex.EncodeToString(sum[:])
+
+		replay, err := a.store.BeginIdempotentRequest(r.Context(), key, request)
+		if errors.Is(err, storage.ErrIdempotencyKeyInUse) {
+			writeError(w, http.StatusConflict, types.ErrorIdempotencyKeyInUse, "a request with this idempotency key is in progress")
+			return
+		}
+		if errors.Is(err, storage.ErrIdempotencyKeyReused) {
+			writeError(w, http.StatusUnprocessableEntity, types.ErrorIdempotencyKeyReused, "idempotency key was used for a different request")
+			return
+		}
+		if err != nil {
+			a.logger.Error().Err(err).Msg("Error checking idempotency key")
+			writeError(w, http.StatusInternalServerError, types.ErrorInternal, "internal server error")
+			return
+		}
+		if replay != nil {
+			if replay.ContentType != "" {
+				w.Header().Set("Content-Type", replay.ContentType)
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(replay.Status)
+			w.Write(replay.Body)
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+
+		// The client may have given up by now, but its retry still needs to
+		// find the outcome.
+		ctx := context.WithoutCancel(r.Context())
+		if rec.status >= 500 {
+			err = a.store.CancelIdempotentRequest(ctx, key)
+		} else {
+			err = a.store.FinishIdempotentRequest(ctx, key, storage.IdempotentResponse{
+				Request:     request,
+				Status:      rec.status,
+				ContentType: rec.Header().Get("Content-Type"),
+				Body:        rec.body.Bytes(),
+			})
+		}
+		if err != nil {
+			a.logger.Error().Err(err).Msg("Error recording idempotent response")
+		}
+	}
+}
+
+// responseRecorder passes a response through while keeping a copy.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	r.wroteHeader = true
+	r.body.Write(p)
+	return r.ResponseWriter.Write(p)
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/storage"
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+)
+
+// maxJobLogSize caps uploaded job output. Workers send the most recent output,
+// so this only trims uploads from other clients.
+const maxJobLogSize = 1 << 20
+
+func (a *API) handleUploadJobLog(w http.ResponseWriter, r *http.Request) {
+	uuid := r.PathValue("uuid")
+	if uuid == "" {
+		writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, "job uuid is required")
+		return
+	}
+
+	output, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxJobLogSize))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, types.ErrorTooLarge, "job log too large")
+		return
+	}
+
+	if err := a.store.SetJobLog(r.Context(), uuid, output); err != nil {
+		a.logger.Error().Err(err).Str("uuid", uuid).Msg("Error storing job log")
+		writeError(w, http.StatusInternalServerError, types.ErrorInternal, "internal server error")
+		return
+	}
+	a.logger.Debug().Str("uuid", uuid).Str("worker_id", r.Header.Get("X-Worker-ID")).Int("bytes", len(output)).Msg("Stored job log")
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *API) handleGetJobLog(w http.ResponseWriter, r *http.Request) {
+	uuid := r.PathValue("uuid")
+	if uuid == "" {
+		writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, "job uuid is required")
+		return
+	}
+
+	output, err := a.store.GetJobLog(r.Context(), uuid)
+	if errors.Is(err, storage.ErrJobNotFound) {
+		writeError(w, http.StatusNotFound, types.ErrorNotFound, "job log not found")
+		return
+	}
+	if err != nil {
+		a.logger.Error().Err(err).Str("uuid", uuid).Msg("Error getting job log")
+		writeError(w, http.StatusInternalServerError, types.ErrorInternal, "internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(output)
+}
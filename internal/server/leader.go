@@ -0,0 +1,73 @@
+package server
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/storage"
+	"github.com/rs/zerolog/log"
+)
+
+// LeaderElector elects one of several server replicas sharing Redis to poll
+// and reserve jobs, so they don't reserve the same jobs from Buildkite. The
+// leader holds a key in Redis for ttl, renewing it every third of that; if it
+// stops, another replica takes over once the key expires, or straight away if
+// the leader resigned on shutdown. Every replica serves the API either way.
+type LeaderElector struct {
+	store *storage.RedisStore
+	id    string
+	ttl   time.Duration
+
+	leader atomic.Bool
+}
+
+func NewLeaderElector(store *storage.RedisStore, id string, ttl time.Duration) *LeaderElector {
+	return &LeaderElector{store: store, id: id, ttl: ttl}
+}
+
+// IsLeader reports whether this server leads. Without an elector, there is
+// only one server, so it always does.
+func (e *LeaderElector) IsLeader() bool {
+	return e == nil || e.leader.Load()
+}
+
+func (e *LeaderElector) Start(ctx context.Context) error {
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+
+	log.Info().Str("id", e.id).Dur("ttl", e.ttl).Msg("Starting leader election")
+
+	for {
+		e.campaign(ctx)
+		select {
+		case <-ctx.Done():
+			if e.leader.Swap(false) {
+				if err := e.store.ResignLeadership(context.WithoutCancel(ctx), e.id); err != nil {
+					log.Error().Err(err).Msg("Error resigning leadership")
+				} else {
+					log.Info().Str("id", e.id).Msg("Resigned leadership")
+				}
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// campaign takes or renews the lead. If Redis can't be reached, the lead is
+// given up, since another replica may take it once the key expires.
+func (e *LeaderElector) campaign(ctx context.Context) {
+	leads, err := e.store.AcquireLeadership(ctx, e.id, e.ttl)
+	if err != nil {
+		log.Error().Err(err).Msg("Error renewing leadership")
+		leads = false
+	}
+	if was := e.leader.Swap(leads); was != leads {
+		if leads {
+			log.Info().Str("id", e.id).Msg("Became leader")
+		} else {
+			log.Warn().Str("id", e.id).Msg("Lost leadership")
+		}
+	}
+}
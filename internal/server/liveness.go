@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/buildkite/stacksapi"
+	"github.com/rs/zerolog/log"
+)
+
+// Liveness periodically re-registers the stack so Buildkite knows the scheduler
+// is still alive. The Stacks API has no dedicated heartbeat, but registering is
+// idempotent and refreshes the stack's last connection time, so a crashed
+// scheduler shows up as a stale stack rather than a healthy one.
+type Liveness struct {
+	client   *stacksapi.Client
+	req      stacksapi.RegisterStackRequest
+	interval time.Duration
+
+	mu      sync.Mutex
+	lastOK  time.Time
+	state   stacksapi.StackState
+	lastErr error
+}
+
+// NewLiveness creates a heartbeat for a stack that has just been registered.
+func NewLiveness(client *stacksapi.Client, req stacksapi.RegisterStackRequest, interval time.Duration) *Liveness {
+	return &Liveness{client: client, req: req, interval: interval, lastOK: time.Now(), state: stacksapi.StackStateConnected}
+}
+
+// Check returns an error if Buildkite doesn't show the stack as connected, or
+// heartbeats have been failing for the last few intervals.
+func (l *Liveness) Check() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if since := time.Since(l.lastOK); since > 3*l.interval {
+		if l.lastErr != nil {
+			return fmt.Errorf("no successful stack heartbeat for %s: %w", since.Round(time.Second), l.lastErr)
+		}
+		return fmt.Errorf("no successful stack heartbeat for %s", since.Round(time.Second))
+	}
+	if l.state != stacksapi.StackStateConnected {
+		return fmt.Errorf("stack is %s", l.state)
+	}
+	return nil
+}
+
+func (l *Liveness) Start(ctx context.Context) error {
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+
+	log.Info().Dur("interval", l.interval).Msg("Starting stack heartbeat")
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			start := time.Now()
+			stack, _, err := l.client.RegisterStack(ctx, l.req)
+			observeStacksAPI("register_stack", start, err)
+			l.mu.Lock()
+			if err != nil {
+				l.lastErr = err
+			} else {
+				l.lastOK = time.Now()
+				l.state = stack.State
+			}
+			l.mu.Unlock()
+			if err != nil {
+				log.Error().Err(err).Msg("Error sending stack heartbeat")
+				continue
+			}
+			if stack.State != stacksapi.StackStateConnected {
+				log.Warn().Str("state", string(stack.State)).Msg("Stack is not connected")
+			}
+		}
+	}
+}
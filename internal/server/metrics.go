@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/metrics"
+	"github.com/buildkite/buildkite-custom-scheduler/internal/storage"
+)
+
+var (
+	jobsListed = metrics.Default.NewCounterVec("scheduler_jobs_listed_total",
+		"Scheduled jobs listed by the monitor's polls, including ones already reserved.", "queue")
+	jobsReserved = metrics.Default.NewCounterVec("scheduler_jobs_reserved_total",
+		"Jobs reserved from Buildkite.", "queue")
+	reservationFailures = metrics.Default.NewCounterVec("scheduler_reservation_failures_total",
+		"Jobs the monitor tried to reserve that Buildkite didn't grant, or that failed to reserve.", "queue")
+	queuePollDuration = metrics.Default.NewHistogramVec("scheduler_queue_poll_duration_seconds",
+		"Time taken to poll a queue and reserve its jobs.", metrics.DefaultBuckets, "queue", "result")
+	jobsClaimed = metrics.Default.NewCounterVec("scheduler_jobs_claimed_total",
+		"Jobs handed to workers.", "queue")
+	jobsCompleted = metrics.Default.NewCounterVec("scheduler_jobs_completed_total",
+		"Jobs workers reported complete.")
+	jobsFailed = metrics.Default.NewCounterVec("scheduler_jobs_failed_total",
+		"Job runs workers reported failed, by whether the job was retried or dead-lettered.", "outcome")
+	claimLatency = metrics.Default.NewHistogramVec("scheduler_claim_latency_seconds",
+		"Time from a job being reserved to a worker claiming it.",
+		[]float64{.1, .5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600}, "queue")
+	apiRateLimited = metrics.Default.NewCounterVec("scheduler_api_rate_limited_total",
+		"API requests rejected with 429, by which limit they hit.", "limit")
+	deprecatedRequests = metrics.Default.NewCounterVec("scheduler_api_deprecated_requests_total",
+		"Requests to unversioned worker routes, which are aliases of their /v1/ routes.", "route")
+	webhooksReceived = metrics.Default.NewCounterVec("scheduler_webhooks_received_total",
+		"Buildkite webhook deliveries, by event and whether they triggered a poll.", "event", "result")
+	stacksAPIThrottled = metrics.Default.NewCounterVec("scheduler_stacks_api_throttled_total",
+		"Stacks API responses of 429 Too Many Requests, including ones the client retried.")
+	alertsSent = metrics.Default.NewCounterVec("scheduler_alerts_sent_total",
+		"Alerts posted to the alert webhook, by event and whether posting worked.", "event", "result")
+	stacksAPIDuration = metrics.Default.NewHistogramVec("scheduler_stacks_api_request_duration_seconds",
+		"Time taken by Stacks API calls, including retries.", metrics.DefaultBuckets, "operation", "result")
+)
+
+// observeStacksAPI records a Stacks API call that began at start.
+func observeStacksAPI(operation string, start time.Time, err error) {
+	result := "ok"
+	if _, throttled := throttleDelay(err); throttled {
+		result = "throttled"
+	} else if errors.Is(err, ErrCircuitOpen) {
+		result = "circuit_open"
+	} else if err != nil {
+		result = "error"
+	}
+	stacksAPIDuration.With(operation, result).ObserveSince(start)
+}
+
+// RegisterMonitorMetrics adds gauges of when each stack's monitor last
+// polled Buildkite successfully, and whether its circuit breaker is open.
+func RegisterMonitorMetrics(stacks Stacks) {
+	metrics.Default.NewGaugeFunc("scheduler_stacks_api_circuit_open",
+		"1 while the stack's Stacks API circuit breaker is refusing calls, otherwise 0.", []string{"stack"},
+		func(ctx context.Context) ([]metrics.Sample, error) {
+			samples := make([]metrics.Sample, 0, len(stacks))
+			for _, stack := range stacks {
+				value := 0.0
+				if stack.Monitor.cfg.Breaker.Open() {
+					value = 1
+				}
+				samples = append(samples, metrics.Sample{Labels: []string{stack.Key()}, Value: value})
+			}
+			return samples, nil
+		})
+	metrics.Default.NewGaugeFunc("scheduler_monitor_last_successful_poll_timestamp_seconds",
+		"Unix time the monitor last polled Buildkite successfully, by stack, or 0 if it isn't running.", []string{"stack"},
+		func(ctx context.Context) ([]metrics.Sample, error) {
+			samples := make([]metrics.Sample, 0, len(stacks))
+			for _, stack := range stacks {
+				value := 0.0
+				if last := stack.Monitor.LastSuccessfulPoll(); !last.IsZero() {
+					value = float64(last.UnixNano()) / 1e9
+				}
+				samples = append(samples, metrics.Sample{Labels: []string{stack.Key()}, Value: value})
+			}
+			return samples, nil
+		})
+	metrics.Default.NewGaugeFunc("scheduler_observed_scheduled_jobs",
+		"Jobs waiting in Buildkite as of the last observe-only poll, by stack and queue.", []string{"stack", "queue"},
+		func(ctx context.Context) ([]metrics.Sample, error) {
+			var samples []metrics.Sample
+			for _, stack := range stacks {
+				for queueKey, observation := range stack.Monitor.observations() {
+					samples = append(samples, metrics.Sample{Labels: []string{stack.Key(), queueKey}, Value: float64(observation.scheduled)})
+				}
+			}
+			return samples, nil
+		})
+	metrics.Default.NewGaugeFunc("scheduler_observed_oldest_job_age_seconds",
+		"How long the oldest job waiting in Buildkite had been scheduled as of the last observe-only poll, by stack and queue.", []string{"stack", "queue"},
+		func(ctx context.Context) ([]metrics.Sample, error) {
+			var samples []metrics.Sample
+			for _, stack := range stacks {
+				for queueKey, observation := range stack.Monitor.observations() {
+					value := 0.0
+					if !observation.oldest.IsZero() {
+						value = time.Since(observation.oldest).Seconds()
+					}
+					samples = append(samples, metrics.Sample{Labels: []string{stack.Key(), queueKey}, Value: value})
+				}
+			}
+			return samples, nil
+		})
+}
+
+// RegisterLeaderMetrics adds a gauge of whether this server is the leader.
+func RegisterLeaderMetrics(elector *LeaderElector) {
+	metrics.Default.NewGaugeFunc("scheduler_leader",
+		"1 if this server is the leader that polls and reserves jobs, otherwise 0.", nil,
+		func(ctx context.Context) ([]metrics.Sample, error) {
+			value := 0.0
+			if elector.IsLeader() {
+				value = 1
+			}
+			return []metrics.Sample{{Value: value}}, nil
+		})
+}
+
+// RegisterShardMetrics adds a gauge of how many servers share out queues.
+func RegisterShardMetrics(ring *ShardRing) {
+	metrics.Default.NewGaugeFunc("scheduler_shard_members",
+		"Servers sharing out queues to poll, as this server last saw them.", nil,
+		func(ctx context.Context) ([]metrics.Sample, error) {
+			return []metrics.Sample{{Value: float64(len(ring.Members()))}}, nil
+		})
+}
+
+// RegisterStoreMetrics adds gauges read from the store when metrics are
+// served: the depth of each query rule bucket, and the reserved and delayed
+// job counts.
+func RegisterStoreMetrics(store *storage.RedisStore) {
+	metrics.Default.NewGaugeFunc("scheduler_queue_depth",
+		"Jobs waiting to be claimed, by query rule bucket.", []string{"query_rules"},
+		func(ctx context.Context) ([]metrics.Sample, error) {
+			stats, err := store.GetAllStats(ctx)
+			if err != nil {
+				return nil, err
+			}
+			samples := make([]metrics.Sample, 0, len(stats))
+			for rules, count := range stats {
+				samples = append(samples, metrics.Sample{Labels: []string{rules}, Value: float64(count)})
+			}
+			return samples, nil
+		})
+	metrics.Default.NewGaugeFunc("scheduler_reserved_jobs",
+		"Jobs held reserved from Buildkite, including claimed ones not yet finished.", nil,
+		func(ctx context.Context) ([]metrics.Sample, error) {
+			count, err := store.ReservationCount(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return []metrics.Sample{{Value: float64(count)}}, nil
+		})
+	metrics.Default.NewGaugeFunc("scheduler_delayed_jobs",
+		"Jobs held back until their not-before time.", nil,
+		func(ctx context.Context) ([]metrics.Sample, error) {
+			count, err := store.GetDelayedCount(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return []metrics.Sample{{Value: float64(count)}}, nil
+		})
+}
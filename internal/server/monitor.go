@@ -2,7 +2,12 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/buildkite/buildkite-custom-scheduler/internal/storage"
@@ -11,80 +16,708 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+// MonitorConfig controls which queues the monitor polls and how aggressively it
+// reserves jobs from them. Queues, the poll intervals, CapacityFactor,
+// MaxReserved and MaxPendingPerQueue can be changed while the monitor runs,
+// with Reconfigure.
+type MonitorConfig struct {
+	StackKey string
+	Queues   []string
+
+	// Primary is set on the first of the server's stacks, which also owns
+	// jobs stored before jobs recorded their stack.
+	Primary bool
+
+	// Leader, if set, limits polling and renewing reservations to while this
+	// server is the leader.
+	Leader *LeaderElector
+
+	// Shards, if set, limits polling and renewing reservations to the
+	// queues this server owns a share of.
+	Shards *ShardRing
+
+	// PollInterval is how often queues are polled while jobs are flowing.
+	// Each poll that finds nothing to reserve doubles the interval, up to
+	// MaxPollInterval, and one that reserves jobs resets it. A
+	// MaxPollInterval no longer than PollInterval keeps it fixed.
+	PollInterval    time.Duration
+	MaxPollInterval time.Duration
+
+	// QueuePollInterval gives queues their own fixed poll interval instead,
+	// e.g. longer for queues that are rarely used, or shorter for queues
+	// people are waiting on.
+	QueuePollInterval map[string]time.Duration
+
+	// CapacityFactor, when positive, limits each poll to reserving
+	// CapacityFactor jobs per slot of the active workers, less any jobs
+	// already pending in Redis. Zero disables backpressure and reserves every
+	// scheduled job.
+	CapacityFactor float64
+
+	// WorkerTimeout is how recently a worker must have heartbeated to count
+	// towards reservation capacity.
+	WorkerTimeout time.Duration
+
+	// MaxReserved, when positive, caps how many jobs may be held reserved
+	// at once across every queue, stack and server sharing Redis, whatever
+	// the workers' capacity.
+	MaxReserved int
+
+	// MaxPendingPerQueue, when positive, stops reserving jobs for a queue once
+	// that many of its jobs are waiting in Redis.
+	MaxPendingPerQueue int
+
+	// ReservationExpiry is how long Buildkite holds a reserved job for us
+	// before making it available to other stacks again, unless the job's queue
+	// has an entry in QueueReservationExpiry.
+	ReservationExpiry      time.Duration
+	QueueReservationExpiry map[string]time.Duration
+
+	// PageSize is how many scheduled jobs to list per Stacks API request,
+	// unless the queue has an entry in QueuePageSize, and ReserveBatchSize
+	// how many jobs to reserve, renew or release per request. Zero uses the
+	// defaults.
+	PageSize         int
+	QueuePageSize    map[string]int
+	ReserveBatchSize int
+
+	// PausedPollInterval is how often to poll a queue that's paused in
+	// Buildkite, to see whether it has resumed. With PauseDispatch, workers
+	// can't claim its jobs until then either.
+	PausedPollInterval time.Duration
+	PauseDispatch      bool
+
+	// ObserveOnly lists scheduled jobs to record how many are waiting and
+	// for how long, but never reserves them, leaving Buildkite to dispatch
+	// them as it would without the scheduler.
+	ObserveOnly bool
+
+	// Breaker, if set, is the circuit breaker on the stack's Stacks API
+	// client. Polls are skipped while it's open.
+	Breaker *CircuitBreaker
+
+	// ResourceTags, when set, enables resource-aware scheduling: agent query
+	// rules with these keys (e.g. cpu=4) are treated as resource requirements
+	// matched against a worker's free capacity, rather than as tags to match.
+	ResourceTags []string
+}
+
+const (
+	defaultPollPageSize     = 50
+	defaultReserveBatchSize = 100
+)
+
 type Monitor struct {
-	client   *stacksapi.Client
-	stackKey string
-	queues   []string
-	store    *storage.RedisStore
-	interval time.Duration
+	client *stacksapi.Client
+	store  *storage.RedisStore
+	cfg    MonitorConfig
+
+	// lastPoll is when the monitor loop last finished polling, in Unix
+	// nanoseconds, or zero if it isn't running. lastSuccess is when a poll
+	// last reached Buildkite, and pollErr why the polls since have failed.
+	lastPoll    atomic.Int64
+	lastSuccess atomic.Int64
+	pollErr     atomic.Pointer[error]
+
+	// queues are the queues polled: those configured, and the discovered
+	// ones found by queue discovery. triggered holds queues to poll ahead of
+	// the next tick, and wake is signalled when it gains one. mu also guards
+	// the fields of cfg that Reconfigure changes, and reconfigured is
+	// signalled when it does.
+	mu           sync.Mutex
+	queues       []string
+	discovered   []string
+	triggered    map[string]bool
+	wake         chan struct{}
+	reconfigured chan struct{}
+
+	// pausedUntil holds queues found paused in Buildkite, and when to next
+	// poll each to see if it has resumed. throttledUntil is when the Stacks
+	// API said to call it again after throttling us. Both are only used by
+	// the monitor loop.
+	pausedUntil    map[string]time.Time
+	throttledUntil time.Time
+
+	// lastPolled is when each queue's last poll began. It's only used by
+	// the monitor loop.
+	lastPolled map[string]time.Time
+
+	// observed is what observe-only polls last saw of each queue, guarded
+	// by mu.
+	observed map[string]queueObservation
 }
 
-func NewMonitor(client *stacksapi.Client, stackKey string, queues []string, store *storage.RedisStore, interval time.Duration) *Monitor {
+func NewMonitor(client *stacksapi.Client, store *storage.RedisStore, cfg MonitorConfig) *Monitor {
 	return &Monitor{
-		client:   client,
-		stackKey: stackKey,
-		queues:   queues,
-		store:    store,
-		interval: interval,
+		client:       client,
+		store:        store,
+		cfg:          cfg,
+		queues:       cfg.Queues,
+		wake:         make(chan struct{}, 1),
+		reconfigured: make(chan struct{}, 1),
+		pausedUntil:  map[string]time.Time{},
+		lastPolled:   map[string]time.Time{},
+	}
+}
+
+// config returns the monitor's config, as last changed by Reconfigure.
+func (m *Monitor) config() MonitorConfig {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.cfg
+}
+
+// Reconfigure changes the queues, poll intervals and reservation limits the
+// monitor uses to those in cfg, e.g. when the server's settings are reloaded.
+// The rest of cfg is ignored, as it can only be set on startup.
+func (m *Monitor) Reconfigure(cfg MonitorConfig) {
+	m.mu.Lock()
+	m.cfg.Queues = cfg.Queues
+	m.cfg.PollInterval = cfg.PollInterval
+	m.cfg.MaxPollInterval = cfg.MaxPollInterval
+	m.cfg.QueuePollInterval = cfg.QueuePollInterval
+	m.cfg.CapacityFactor = cfg.CapacityFactor
+	m.cfg.MaxReserved = cfg.MaxReserved
+	m.cfg.MaxPendingPerQueue = cfg.MaxPendingPerQueue
+	m.mu.Unlock()
+
+	m.updateQueues()
+	select {
+	case m.reconfigured <- struct{}{}:
+	default:
+	}
+}
+
+// Queues returns the queues the monitor polls.
+func (m *Monitor) Queues() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.queues)
+}
+
+// SetQueues changes the queues the monitor polls to the configured ones plus
+// discovered, from its next poll.
+func (m *Monitor) SetQueues(discovered []string) {
+	m.mu.Lock()
+	m.discovered = discovered
+	m.mu.Unlock()
+	m.updateQueues()
+}
+
+// updateQueues polls the configured and discovered queues from the next
+// poll, logging those added and removed.
+func (m *Monitor) updateQueues() {
+	m.mu.Lock()
+	queues := slices.Clone(m.cfg.Queues)
+	for _, queueKey := range m.discovered {
+		if !slices.Contains(queues, queueKey) {
+			queues = append(queues, queueKey)
+		}
+	}
+	old := m.queues
+	m.queues = queues
+	m.mu.Unlock()
+
+	for _, queueKey := range queues {
+		if !slices.Contains(old, queueKey) {
+			log.Info().Str("queue", queueKey).Msg("Started polling queue")
+		}
+	}
+	for _, queueKey := range old {
+		if !slices.Contains(queues, queueKey) {
+			log.Info().Str("queue", queueKey).Msg("Stopped polling queue")
+		}
+	}
+}
+
+// polls reports whether the monitor polls queueKey.
+func (m *Monitor) polls(queueKey string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Contains(m.queues, queueKey)
+}
+
+// Trigger asks the monitor loop to poll queueKey now rather than waiting for
+// the next tick, e.g. because Buildkite said a job was scheduled on it.
+// Triggers for a queue coalesce until it's polled. It returns false if the
+// monitor doesn't poll queueKey, or another shard owns it.
+func (m *Monitor) Trigger(queueKey string) bool {
+	if !m.cfg.Shards.Owns(queueKey) {
+		return false
+	}
+	m.mu.Lock()
+	if !slices.Contains(m.queues, queueKey) {
+		m.mu.Unlock()
+		return false
+	}
+	if m.triggered == nil {
+		m.triggered = map[string]bool{}
+	}
+	m.triggered[queueKey] = true
+	m.mu.Unlock()
+
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// takeTriggered returns the triggered queues, in polling order, and
+// clears them.
+func (m *Monitor) takeTriggered() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var queues []string
+	for _, queueKey := range m.queues {
+		if m.triggered[queueKey] {
+			queues = append(queues, queueKey)
+		}
 	}
+	clear(m.triggered)
+	return queues
 }
 
 func (m *Monitor) Start(ctx context.Context) error {
-	ticker := time.NewTicker(m.interval)
-	defer ticker.Stop()
+	interval := m.config().PollInterval
+	timer := time.NewTimer(m.tickInterval(interval))
+	defer timer.Stop()
 
-	log.Info().Strs("queues", m.queues).Dur("interval", m.interval).Msg("Starting monitor")
+	renewTicker := time.NewTicker(renewInterval)
+	defer renewTicker.Stop()
+
+	log.Info().Strs("queues", m.Queues()).Dur("interval", interval).Dur("max_interval", m.maxPollInterval()).Msg("Starting monitor")
+	m.lastPoll.Store(time.Now().UnixNano())
+	m.lastSuccess.Store(time.Now().UnixNano())
+	defer m.lastPoll.Store(0)
 
 	for {
 		select {
 		case <-ctx.Done():
 			log.Info().Msg("Monitor shutting down")
 			return ctx.Err()
-		case <-ticker.C:
-			if err := m.pollQueues(ctx); err != nil {
+		case <-timer.C:
+			if !m.cfg.Leader.IsLeader() {
+				// Stay ready to take over at full speed.
+				m.takeTriggered()
+				m.recordPoll(nil)
+				interval = m.config().PollInterval
+				timer.Reset(m.tickInterval(interval))
+				continue
+			}
+			busy, regular, err := m.pollDue(ctx, interval)
+			if err != nil && !errors.Is(err, ErrCircuitOpen) {
 				log.Error().Err(err).Msg("Error polling queues")
 			}
+			m.recordPoll(err)
+			// Queues with their own interval don't change the others'.
+			if err == nil && regular {
+				interval = m.nextPollInterval(interval, busy)
+			}
+			timer.Reset(m.tickInterval(interval))
+		case <-m.wake:
+			if !m.cfg.Leader.IsLeader() {
+				m.takeTriggered()
+				continue
+			}
+			busy, err := m.pollTriggered(ctx)
+			if err != nil && !errors.Is(err, ErrCircuitOpen) {
+				log.Error().Err(err).Msg("Error polling triggered queues")
+			}
+			m.recordPoll(err)
+			if busy && interval > m.config().PollInterval {
+				interval = m.nextPollInterval(interval, true)
+				timer.Reset(m.tickInterval(interval))
+			}
+		case <-m.reconfigured:
+			// Start over at the new shortest interval, rather than waiting
+			// out one that may have been backed off to the old longest.
+			interval = m.config().PollInterval
+			timer.Reset(m.tickInterval(interval))
+			log.Info().Strs("queues", m.Queues()).Dur("interval", interval).Dur("max_interval", m.maxPollInterval()).Msg("Monitor reconfigured")
+		case <-renewTicker.C:
+			if !m.cfg.Leader.IsLeader() || m.cfg.Breaker.Open() || m.cfg.ObserveOnly {
+				continue
+			}
+			if err := m.renewReservations(ctx); err != nil {
+				log.Error().Err(err).Msg("Error renewing reservations")
+			}
 		}
 	}
 }
 
-func (m *Monitor) pollQueues(ctx context.Context) error {
-	for _, queueKey := range m.queues {
-		if err := m.pollQueue(ctx, queueKey); err != nil {
-			log.Error().Err(err).Str("queue", queueKey).Msg("Error polling queue")
+// ownsJob reports whether a job reserved by stackKey is this monitor's to
+// renew and release.
+func (m *Monitor) ownsJob(stackKey string) bool {
+	return ownedByStack(stackKey, m.cfg.StackKey, m.cfg.Primary)
+}
+
+// ownedByStack reports whether a job reserved by jobStack belongs to the
+// stack with stackKey.
+func ownedByStack(jobStack, stackKey string, primary bool) bool {
+	return jobStack == stackKey || jobStack == "" && primary
+}
+
+// nextPollInterval returns the interval to wait after a poll: the shortest
+// if it was busy, otherwise double the last, up to the longest.
+func (m *Monitor) nextPollInterval(last time.Duration, busy bool) time.Duration {
+	next := m.config().PollInterval
+	if !busy {
+		next = min(2*last, m.maxPollInterval())
+	}
+	if next != last {
+		log.Debug().Dur("interval", next).Bool("busy", busy).Msg("Poll interval changed")
+	}
+	return next
+}
+
+func (m *Monitor) maxPollInterval() time.Duration {
+	cfg := m.config()
+	return max(cfg.PollInterval, cfg.MaxPollInterval)
+}
+
+// minPollStaleness is the least time without a finished poll before the
+// monitor is reported stuck, so slow Stacks API calls don't count.
+const minPollStaleness = 30 * time.Second
+
+// recordPoll notes that the monitor loop finished a poll, and whether it
+// failed.
+func (m *Monitor) recordPoll(err error) {
+	now := time.Now().UnixNano()
+	m.lastPoll.Store(now)
+	if err != nil {
+		m.pollErr.Store(&err)
+		return
+	}
+	m.lastSuccess.Store(now)
+	m.pollErr.Store(nil)
+}
+
+// LastSuccessfulPoll returns when a poll last reached Buildkite, or the zero
+// time if the monitor isn't running.
+func (m *Monitor) LastSuccessfulPoll() time.Time {
+	if m.lastPoll.Load() == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, m.lastSuccess.Load())
+}
+
+// Check returns an error if the monitor loop isn't running, hasn't finished
+// a poll in the last few intervals, e.g. because it's stuck, or its polls
+// have been failing for as long.
+func (m *Monitor) Check() error {
+	if err := m.Alive(); err != nil {
+		return err
+	}
+	staleness := max(3*m.maxPollInterval(), minPollStaleness)
+	if since := time.Since(time.Unix(0, m.lastSuccess.Load())); since > staleness {
+		if err := m.pollErr.Load(); err != nil {
+			return fmt.Errorf("monitor last polled successfully %s ago: %w", since.Round(time.Millisecond), *err)
 		}
+		return fmt.Errorf("monitor last polled successfully %s ago", since.Round(time.Millisecond))
+	}
+	return nil
+}
+
+// Alive returns an error if the monitor loop isn't running or hasn't
+// finished a poll in the last few intervals, whether or not its polls reach
+// Buildkite.
+func (m *Monitor) Alive() error {
+	lastPoll := m.lastPoll.Load()
+	if lastPoll == 0 {
+		return fmt.Errorf("monitor is not running")
+	}
+	staleness := max(3*m.maxPollInterval(), minPollStaleness)
+	if since := time.Since(time.Unix(0, lastPoll)); since > staleness {
+		return fmt.Errorf("monitor last polled %s ago", since.Round(time.Millisecond))
 	}
 	return nil
 }
 
-func (m *Monitor) pollQueue(ctx context.Context, queueKey string) error {
+// pollDue polls the queues due a poll, given the adaptive interval, along
+// with any triggered since the last. It reports whether jobs are flowing, and
+// whether any queue on the adaptive interval was polled.
+func (m *Monitor) pollDue(ctx context.Context, interval time.Duration) (bool, bool, error) {
+	now := time.Now()
+	triggered := m.takeTriggered()
+	queuePollInterval := m.config().QueuePollInterval
+	var due []string
+	regular := false
+	for _, queueKey := range m.Queues() {
+		queueInterval, ok := queuePollInterval[queueKey]
+		if !ok {
+			queueInterval = interval
+		}
+		if last, polled := m.lastPolled[queueKey]; polled && now.Sub(last) < queueInterval && !slices.Contains(triggered, queueKey) {
+			continue
+		}
+		due = append(due, queueKey)
+		m.lastPolled[queueKey] = now
+		regular = regular || !ok
+	}
+	busy, err := m.pollQueueKeys(ctx, m.ownedQueues(due))
+	return busy, regular, err
+}
+
+// tickInterval returns how long the monitor loop waits between looking for
+// queues due a poll: the adaptive interval, or a queue's own if shorter.
+func (m *Monitor) tickInterval(interval time.Duration) time.Duration {
+	queuePollInterval := m.config().QueuePollInterval
+	for _, queueKey := range m.Queues() {
+		if queueInterval, ok := queuePollInterval[queueKey]; ok && queueInterval < interval {
+			interval = queueInterval
+		}
+	}
+	return interval
+}
+
+// ownedQueues narrows queues to those this server's shard owns.
+func (m *Monitor) ownedQueues(queues []string) []string {
+	return slices.DeleteFunc(queues, func(queueKey string) bool { return !m.cfg.Shards.Owns(queueKey) })
+}
+
+// pollTriggered polls the queues passed to Trigger since it last ran.
+func (m *Monitor) pollTriggered(ctx context.Context) (bool, error) {
+	queues := m.ownedQueues(m.takeTriggered())
+	if len(queues) == 0 {
+		return false, nil
+	}
+	log.Debug().Strs("queues", queues).Msg("Polling triggered queues")
+	return m.pollQueueKeys(ctx, queues)
+}
+
+// pollQueueKeys polls queues for jobs to reserve. It reports whether jobs
+// are flowing: some were reserved, or there wasn't room to reserve more.
+func (m *Monitor) pollQueueKeys(ctx context.Context, queues []string) (bool, error) {
+	if m.throttled() {
+		log.Debug().Time("until", m.throttledUntil).Msg("Stacks API rate limited, skipping poll")
+		return false, nil
+	}
+	if m.cfg.Breaker.Open() {
+		return false, ErrCircuitOpen
+	}
+	if m.cfg.ObserveOnly {
+		return false, m.observeQueues(ctx, queues)
+	}
+
+	budget, err := m.reservationBudget(ctx)
+	if err != nil {
+		return false, fmt.Errorf("calculating reservation budget: %w", err)
+	}
+	if budget == 0 {
+		log.Debug().Msg("No reservation capacity, skipping poll")
+		return true, nil
+	}
+
+	// The poll fails if every queue it got to did.
+	busy := false
+	polled := 0
+	var errs []error
+	failed := func(queueKey string, err error) {
+		errs = append(errs, fmt.Errorf("queue %s: %w", queueKey, err))
+	}
+
+	for _, queueKey := range queues {
+		if m.throttled() || m.cfg.Breaker.Open() {
+			break
+		}
+		drained, err := m.store.IsQueueDrained(ctx, queueKey)
+		if err != nil {
+			log.Error().Err(err).Str("queue", queueKey).Msg("Error checking if queue is drained")
+			failed(queueKey, err)
+			continue
+		}
+		if drained {
+			polled++
+			continue
+		}
+		paused, err := m.store.IsReservingPaused(ctx, queueKey)
+		if err != nil {
+			log.Error().Err(err).Str("queue", queueKey).Msg("Error checking if queue is paused")
+			failed(queueKey, err)
+			continue
+		}
+		if paused {
+			polled++
+			continue
+		}
+		if until, ok := m.pausedUntil[queueKey]; ok && time.Now().Before(until) {
+			polled++
+			continue
+		}
+
+		limit, err := m.queueLimit(ctx, queueKey, budget)
+		if err != nil {
+			log.Error().Err(err).Str("queue", queueKey).Msg("Error checking queue backlog")
+			failed(queueKey, err)
+			continue
+		}
+		if limit == 0 {
+			log.Debug().Str("queue", queueKey).Msg("Queue backlog full, skipping")
+			busy = true
+			polled++
+			continue
+		}
+
+		start := time.Now()
+		reserved, err := m.pollQueue(ctx, queueKey, limit)
+		result := "ok"
+		if err != nil {
+			log.Error().Err(err).Str("queue", queueKey).Msg("Error polling queue")
+			failed(queueKey, err)
+			result = "error"
+		} else {
+			polled++
+		}
+		queuePollDuration.With(queueKey, result).ObserveSince(start)
+		if reserved > 0 {
+			busy = true
+		}
+		if budget > 0 {
+			budget -= reserved
+			if budget <= 0 {
+				log.Debug().Msg("Reservation capacity exhausted")
+				break
+			}
+		}
+	}
+	if polled == 0 && len(errs) > 0 {
+		return busy, errors.Join(errs...)
+	}
+	return busy, nil
+}
+
+// reservationBudget returns how many jobs may be reserved in this poll, or -1
+// if neither backpressure nor MaxReserved limits it.
+func (m *Monitor) reservationBudget(ctx context.Context) (int, error) {
+	maxReserved := m.config().MaxReserved
+	budget, err := m.capacityBudget(ctx)
+	if err != nil || maxReserved <= 0 {
+		return budget, err
+	}
+
+	held, err := m.store.ReservationCount(ctx)
+	if err != nil {
+		return 0, err
+	}
+	room := max(maxReserved-int(held), 0)
+	if budget < 0 || room < budget {
+		if room == 0 {
+			log.Debug().Int64("reserved", held).Int("max", maxReserved).Msg("Max reserved jobs held")
+		}
+		return room, nil
+	}
+	return budget, nil
+}
+
+// capacityBudget returns how many jobs the active workers have room for,
+// less those already pending from the monitor's queues, or -1 if
+// backpressure is disabled.
+func (m *Monitor) capacityBudget(ctx context.Context) (int, error) {
+	capacityFactor := m.config().CapacityFactor
+	if capacityFactor <= 0 {
+		return -1, nil
+	}
+
+	slots, err := m.store.ActiveCapacity(ctx, time.Now().Add(-m.cfg.WorkerTimeout))
+	if err != nil {
+		return 0, err
+	}
+
+	pending, err := m.store.TotalPendingCount(ctx, m.Queues())
+	if err != nil {
+		return 0, err
+	}
+
+	capacity := int(math.Ceil(float64(slots) * capacityFactor))
+	budget := capacity - int(pending)
+	if budget < 0 {
+		budget = 0
+	}
+
+	log.Debug().Int64("slots", slots).Int64("pending", pending).Int("budget", budget).Msg("Reservation budget")
+	return budget, nil
+}
+
+// queueLimit narrows the poll's reservation budget to the room left in a queue's
+// backlog under MaxPendingPerQueue. As with budget, -1 means unlimited.
+func (m *Monitor) queueLimit(ctx context.Context, queueKey string, budget int) (int, error) {
+	maxPending := m.config().MaxPendingPerQueue
+	if maxPending <= 0 {
+		return budget, nil
+	}
+
+	pending, err := m.store.GetPendingCount(ctx, queueKey)
+	if err != nil {
+		return 0, err
+	}
+
+	room := maxPending - int(pending)
+	if room < 0 {
+		room = 0
+	}
+	if budget >= 0 && budget < room {
+		return budget, nil
+	}
+	return room, nil
+}
+
+// pollQueue lists and reserves scheduled jobs for a queue, reserving at most
+// limit jobs (or all of them if limit is negative). It returns the number of
+// jobs reserved.
+func (m *Monitor) pollQueue(ctx context.Context, queueKey string, limit int) (int, error) {
 	var cursor string
 	jobsProcessed := 0
 
 	for {
-		resp, _, err := m.client.ListScheduledJobs(ctx, stacksapi.ListScheduledJobsRequest{
-			StackKey:        m.stackKey,
-			ClusterQueueKey: queueKey,
-			PageSize:        50,
-			StartCursor:     cursor,
+		var resp *stacksapi.ListScheduledJobsResponse
+		err := m.retryThrottled(ctx, "list_scheduled_jobs", func() error {
+			start := time.Now()
+			var err error
+			resp, _, err = m.client.ListScheduledJobs(ctx, stacksapi.ListScheduledJobsRequest{
+				StackKey:        m.cfg.StackKey,
+				ClusterQueueKey: queueKey,
+				PageSize:        m.pageSize(queueKey),
+				StartCursor:     cursor,
+			})
+			observeStacksAPI("list_scheduled_jobs", start, err)
+			return err
 		})
 		if err != nil {
-			return fmt.Errorf("listing scheduled jobs: %w", err)
+			return jobsProcessed, fmt.Errorf("listing scheduled jobs: %w", err)
 		}
+		jobsListed.With(queueKey).Add(float64(len(resp.Jobs)))
 
 		if resp.ClusterQueue.Paused {
-			log.Info().Str("queue", queueKey).Msg("Queue is paused, skipping")
-			return nil
+			m.setPausedUpstream(ctx, queueKey, true)
+			return jobsProcessed, nil
+		}
+		if cursor == "" {
+			m.setPausedUpstream(ctx, queueKey, false)
+		}
+
+		jobs, err := m.untrackedJobs(ctx, resp.Jobs)
+		if err != nil {
+			return jobsProcessed, err
+		}
+		if skipped := len(resp.Jobs) - len(jobs); skipped > 0 {
+			log.Debug().Int("count", skipped).Str("queue", queueKey).Msg("Skipping jobs already tracked")
+		}
+		if limit >= 0 && len(jobs) > limit-jobsProcessed {
+			jobs = jobs[:limit-jobsProcessed]
 		}
 
-		if len(resp.Jobs) > 0 {
-			if err := m.reserveJobs(ctx, queueKey, resp.Jobs); err != nil {
+		if len(jobs) > 0 {
+			reserved, err := m.reserveJobs(ctx, queueKey, jobs)
+			if err != nil {
 				log.Error().Err(err).Msg("Error reserving jobs")
-			} else {
-				jobsProcessed += len(resp.Jobs)
 			}
+			jobsProcessed += reserved
 		}
 
+		if limit >= 0 && jobsProcessed >= limit {
+			break
+		}
 		if !resp.PageInfo.HasNextPage {
 			break
 		}
@@ -95,26 +728,119 @@ func (m *Monitor) pollQueue(ctx context.Context, queueKey string) error {
 		log.Info().Int("count", jobsProcessed).Str("queue", queueKey).Msg("Processed jobs")
 	}
 
-	return nil
+	return jobsProcessed, nil
+}
+
+// untrackedJobs drops jobs already stored in Redis, which Buildkite still
+// lists as scheduled until a worker starts them. Their reservations are
+// renewed separately.
+func (m *Monitor) untrackedJobs(ctx context.Context, jobs []stacksapi.ScheduledJob) ([]stacksapi.ScheduledJob, error) {
+	uuids := make([]string, len(jobs))
+	for i, job := range jobs {
+		uuids[i] = job.ID
+	}
+	untracked, err := m.store.UntrackedJobs(ctx, uuids)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(jobs, func(job stacksapi.ScheduledJob) bool { return !slices.Contains(untracked, job.ID) }), nil
 }
 
-func (m *Monitor) reserveJobs(ctx context.Context, queueKey string, jobs []stacksapi.ScheduledJob) error {
-	if len(jobs) == 0 {
-		return nil
+// setPausedUpstream records whether a queue is paused in Buildkite. Paused
+// queues are polled every PausedPollInterval rather than every poll. Pauses
+// and resumes are logged by whichever server sharing Redis sees them first.
+func (m *Monitor) setPausedUpstream(ctx context.Context, queueKey string, paused bool) {
+	if paused {
+		m.pausedUntil[queueKey] = time.Now().Add(m.cfg.PausedPollInterval)
+	} else {
+		delete(m.pausedUntil, queueKey)
 	}
 
+	changed, err := m.store.SetQueuePausedUpstream(ctx, queueKey, paused, m.cfg.PauseDispatch)
+	if err != nil {
+		log.Error().Err(err).Str("queue", queueKey).Msg("Error recording queue pause state")
+	}
+	if !changed {
+		return
+	}
+	if paused {
+		log.Info().Str("queue", queueKey).Dur("poll_interval", m.cfg.PausedPollInterval).Bool("dispatch_paused", m.cfg.PauseDispatch).Msg("Queue paused in Buildkite")
+	} else {
+		log.Info().Str("queue", queueKey).Msg("Queue resumed in Buildkite")
+	}
+}
+
+// pageSize returns how many of a queue's scheduled jobs to list at once.
+func (m *Monitor) pageSize(queueKey string) int {
+	if size, ok := m.cfg.QueuePageSize[queueKey]; ok && size > 0 {
+		return size
+	}
+	if m.cfg.PageSize > 0 {
+		return m.cfg.PageSize
+	}
+	return defaultPollPageSize
+}
+
+// batchSize returns how many jobs to send per batch reserve request.
+func (m *Monitor) batchSize() int {
+	if m.cfg.ReserveBatchSize > 0 {
+		return m.cfg.ReserveBatchSize
+	}
+	return defaultReserveBatchSize
+}
+
+// reservationExpiry returns how long to reserve a queue's jobs for.
+func (m *Monitor) reservationExpiry(queueKey string) time.Duration {
+	if expiry, ok := m.cfg.QueueReservationExpiry[queueKey]; ok {
+		return expiry
+	}
+	return m.cfg.ReservationExpiry
+}
+
+// reserveJobs reserves the given jobs in batches and stores the ones
+// Buildkite granted us, returning the number reserved before any error.
+func (m *Monitor) reserveJobs(ctx context.Context, queueKey string, jobs []stacksapi.ScheduledJob) (int, error) {
+	total := 0
+	for start := 0; start < len(jobs); start += m.batchSize() {
+		end := min(start+m.batchSize(), len(jobs))
+		reserved, err := m.reserveBatch(ctx, queueKey, jobs[start:end])
+		total += reserved
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+func (m *Monitor) reserveBatch(ctx context.Context, queueKey string, jobs []stacksapi.ScheduledJob) (int, error) {
 	jobUUIDs := make([]string, len(jobs))
 	for i, job := range jobs {
 		jobUUIDs[i] = job.ID
 	}
 
-	reserved, _, err := m.client.BatchReserveJobs(ctx, stacksapi.BatchReserveJobsRequest{
-		StackKey:                 m.stackKey,
-		JobUUIDs:                 jobUUIDs,
-		ReservationExpirySeconds: 300,
+	expiry := m.reservationExpiry(queueKey)
+	var reserved *stacksapi.BatchReserveJobsResponse
+	err := m.retryThrottled(ctx, "batch_reserve_jobs", func() error {
+		start := time.Now()
+		var err error
+		reserved, _, err = m.client.BatchReserveJobs(ctx, stacksapi.BatchReserveJobsRequest{
+			StackKey:                 m.cfg.StackKey,
+			JobUUIDs:                 jobUUIDs,
+			ReservationExpirySeconds: int(expiry.Seconds()),
+		})
+		observeStacksAPI("batch_reserve_jobs", start, err)
+		return err
 	})
 	if err != nil {
-		return fmt.Errorf("batch reserve jobs: %w", err)
+		reservationFailures.With(queueKey).Add(float64(len(jobUUIDs)))
+		return 0, fmt.Errorf("batch reserve jobs: %w", err)
+	}
+	jobsReserved.With(queueKey).Add(float64(len(reserved.Reserved)))
+	reservationFailures.With(queueKey).Add(float64(len(jobUUIDs) - len(reserved.Reserved)))
+
+	expiresAt := time.Now().Add(expiry)
+	if err := m.store.TrackReservations(ctx, reserved.Reserved, expiresAt); err != nil {
+		log.Error().Err(err).Msg("Error tracking reservations")
 	}
 
 	reservedMap := make(map[string]bool)
@@ -127,21 +853,37 @@ func (m *Monitor) reserveJobs(ctx context.Context, queueKey string, jobs []stack
 			continue
 		}
 
+		var resources types.Resources
+		if len(m.cfg.ResourceTags) > 0 {
+			resources, err = types.ParseResources(job.AgentQueryRules, m.cfg.ResourceTags)
+			if err != nil {
+				log.Warn().Err(err).Str("job_id", job.ID).Msg("Invalid resource requirements, matching on tags only")
+				resources = nil
+			}
+		}
+
 		ourJob := &types.Job{
 			UUID:            job.ID,
+			StackKey:        m.cfg.StackKey,
 			QueueKey:        queueKey,
+			BuildUUID:       job.Build.UUID,
+			PipelineSlug:    job.Pipeline.Slug,
 			AgentQueryRules: job.AgentQueryRules,
+			Resources:       resources,
 			Priority:        job.Priority,
 			ScheduledAt:     job.ScheduledAt,
 			ReservedAt:      time.Now(),
 		}
 
-		if err := m.store.AddJob(ctx, ourJob); err != nil {
+		added, err := m.store.AddJob(ctx, ourJob)
+		if err != nil {
 			log.Error().Err(err).Str("job_id", job.ID).Msg("Error storing job")
+		} else if !added {
+			log.Debug().Str("job_id", job.ID).Msg("Job already tracked, skipping")
 		}
 	}
 
 	log.Info().Int("reserved", len(reserved.Reserved)).Int("total", len(jobs)).Str("queue", queueKey).Msg("Reserved jobs")
 
-	return nil
+	return len(reserved.Reserved), nil
 }
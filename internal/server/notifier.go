@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"sync"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/storage"
+	"github.com/rs/zerolog/log"
+)
+
+// JobNotifier fans out the store's job notifications to long-polling claim
+// requests, so a single Redis subscription serves every waiting worker.
+type JobNotifier struct {
+	store *storage.RedisStore
+
+	mu      sync.Mutex
+	changed chan struct{}
+	done    chan struct{}
+}
+
+func NewJobNotifier(store *storage.RedisStore) *JobNotifier {
+	return &JobNotifier{
+		store:   store,
+		changed: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+func (n *JobNotifier) Start(ctx context.Context) error {
+	defer close(n.done)
+
+	log.Info().Msg("Starting job notifier")
+
+	for range n.store.SubscribeJobsAvailable(ctx) {
+		n.mu.Lock()
+		close(n.changed)
+		n.changed = make(chan struct{})
+		n.mu.Unlock()
+	}
+	return ctx.Err()
+}
+
+// Changed returns a channel that is closed the next time jobs may have become
+// claimable. Take it before checking for jobs so a notification arriving
+// during the check isn't missed.
+func (n *JobNotifier) Changed() <-chan struct{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.changed
+}
+
+// Done is closed once the notifier has stopped, so waiters can give up.
+func (n *JobNotifier) Done() <-chan struct{} {
+	return n.done
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/buildkite/stacksapi"
+	"github.com/rs/zerolog/log"
+)
+
+// maxObservePages caps how many pages of scheduled jobs an observe-only
+// poll lists per queue, so a deep backlog doesn't cost a page per 50 jobs on
+// every tick. Beyond it, the counts are a lower bound.
+const maxObservePages = 10
+
+// queueObservation is what an observe-only poll saw of a queue in Buildkite.
+type queueObservation struct {
+	scheduled int
+	oldest    time.Time
+}
+
+// observeQueues lists the queues' scheduled jobs to record how many are
+// waiting in Buildkite and for how long, without reserving any. Like a
+// regular poll, it fails if every queue it got to did.
+func (m *Monitor) observeQueues(ctx context.Context, queues []string) error {
+	m.pruneObserved()
+	observed := 0
+	var errs []error
+	for _, queueKey := range queues {
+		if m.throttled() || m.cfg.Breaker.Open() {
+			break
+		}
+		start := time.Now()
+		observation, err := m.observeQueue(ctx, queueKey)
+		result := "ok"
+		if err != nil {
+			log.Error().Err(err).Str("queue", queueKey).Msg("Error observing queue")
+			errs = append(errs, fmt.Errorf("queue %s: %w", queueKey, err))
+			result = "error"
+		}
+		queuePollDuration.With(queueKey, result).ObserveSince(start)
+		if err != nil {
+			continue
+		}
+
+		observed++
+		m.mu.Lock()
+		if m.observed == nil {
+			m.observed = map[string]queueObservation{}
+		}
+		m.observed[queueKey] = observation
+		m.mu.Unlock()
+		log.Debug().Str("queue", queueKey).Int("scheduled", observation.scheduled).Msg("Observed queue")
+	}
+	if observed == 0 && len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+func (m *Monitor) observeQueue(ctx context.Context, queueKey string) (queueObservation, error) {
+	var observation queueObservation
+	var cursor string
+	for page := 1; ; page++ {
+		start := time.Now()
+		resp, _, err := m.client.ListScheduledJobs(ctx, stacksapi.ListScheduledJobsRequest{
+			StackKey:        m.cfg.StackKey,
+			ClusterQueueKey: queueKey,
+			PageSize:        m.pageSize(queueKey),
+			StartCursor:     cursor,
+		})
+		observeStacksAPI("list_scheduled_jobs", start, err)
+		if err != nil {
+			m.checkThrottled(err)
+			return observation, fmt.Errorf("listing scheduled jobs: %w", err)
+		}
+		jobsListed.With(queueKey).Add(float64(len(resp.Jobs)))
+
+		observation.scheduled += len(resp.Jobs)
+		for _, job := range resp.Jobs {
+			if observation.oldest.IsZero() || job.ScheduledAt.Before(observation.oldest) {
+				observation.oldest = job.ScheduledAt
+			}
+		}
+		if !resp.PageInfo.HasNextPage {
+			return observation, nil
+		}
+		if page == maxObservePages {
+			log.Debug().Str("queue", queueKey).Int("pages", page).Msg("Observed page limit reached, counts are a lower bound")
+			return observation, nil
+		}
+		cursor = resp.PageInfo.EndCursor
+	}
+}
+
+// pruneObserved forgets queues the monitor no longer polls, such as ones
+// removed by queue discovery or moved to another shard.
+func (m *Monitor) pruneObserved() {
+	queues := m.ownedQueues(m.Queues())
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	maps.DeleteFunc(m.observed, func(queueKey string, _ queueObservation) bool {
+		return !slices.Contains(queues, queueKey)
+	})
+}
+
+// observations returns what observe-only polls last saw of each queue the
+// monitor still polls, or nothing while another server is leading.
+func (m *Monitor) observations() map[string]queueObservation {
+	if !m.cfg.Leader.IsLeader() {
+		return nil
+	}
+	m.pruneObserved()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	observed := make(map[string]queueObservation, len(m.observed))
+	for queueKey, observation := range m.observed {
+		observed[queueKey] = observation
+	}
+	return observed
+}
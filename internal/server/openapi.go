@@ -0,0 +1,17 @@
+package server
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPISpec describes the API's routes. Keep it in step with routes when
+// adding or changing endpoints; TestOpenAPIMatchesRoutes fails if they drift.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+func (a *API) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
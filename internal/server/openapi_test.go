@@ -0,0 +1,81 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestOpenAPIMatchesRoutes checks openapi.json documents every route the API
+// serves, and nothing it doesn't.
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		t.Fatalf("decoding openapi.json: %v", err)
+	}
+	documented := map[string]bool{}
+	for path, item := range spec.Paths {
+		for method := range item {
+			if method == "parameters" {
+				continue
+			}
+			documented[strings.ToUpper(method)+" "+path] = true
+		}
+	}
+
+	served := map[string]bool{
+		// Served by the BuildkiteWebhook middleware rather than the mux.
+		"POST /webhooks/buildkite": true,
+	}
+	for _, rt := range routes {
+		// The web UI is HTML for browsers, not part of the API.
+		if strings.Contains(rt.pattern, " /ui") {
+			continue
+		}
+		served[rt.pattern] = true
+		if rt.worker {
+			served[rt.versioned()] = true
+		}
+	}
+
+	for _, pattern := range sortedKeys(served) {
+		if !documented[pattern] {
+			t.Errorf("%s is served but not in openapi.json", pattern)
+		}
+	}
+	for _, pattern := range sortedKeys(documented) {
+		if !served[pattern] {
+			t.Errorf("%s is in openapi.json but not served", pattern)
+		}
+	}
+}
+
+// TestRoutesRegister checks the route table builds a mux without conflicting
+// patterns, and that requests reach the route they were written for.
+func TestRoutesRegister(t *testing.T) {
+	mux := (&API{}).routeMux()
+	for _, rt := range routes {
+		method, path, _ := strings.Cut(rt.pattern, " ")
+		path = strings.NewReplacer("{uuid}", "u", "{id}", "i", "{key}", "k").Replace(path)
+		req, err := http.NewRequest(method, path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, pattern := mux.Handler(req); pattern != rt.pattern {
+			t.Errorf("%s %s matched %q, want %q", method, path, pattern, rt.pattern)
+		}
+	}
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
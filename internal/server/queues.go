@@ -0,0 +1,88 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+)
+
+const (
+	// defaultPageSize and maxPageSize bound how many items listing endpoints
+	// return at once.
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+func (a *API) handleListQueues(w http.ResponseWriter, r *http.Request) {
+	queues, err := a.store.ListQueues(r.Context())
+	if err != nil {
+		a.logger.Error().Err(err).Msg("Error listing queues")
+		writeError(w, http.StatusInternalServerError, types.ErrorInternal, "internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"queues": queues})
+}
+
+func (a *API) handleListQueueJobs(w http.ResponseWriter, r *http.Request) {
+	queueKey := r.PathValue("key")
+	if queueKey == "" {
+		writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, "queue key is required")
+		return
+	}
+
+	offset, limit, ok := pageParams(w, r)
+	if !ok {
+		return
+	}
+
+	jobs, next, err := a.store.ListQueueJobs(r.Context(), queueKey, offset, limit)
+	if err != nil {
+		a.logger.Error().Err(err).Str("queue", queueKey).Msg("Error listing queue jobs")
+		writeError(w, http.StatusInternalServerError, types.ErrorInternal, "internal server error")
+		return
+	}
+
+	response := map[string]any{"jobs": jobs}
+	if next > 0 {
+		response["next_cursor"] = strconv.Itoa(next)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// pageParams reads the cursor and limit query parameters of a listing
+// request, writing a 400 response if they're invalid.
+func pageParams(w http.ResponseWriter, r *http.Request) (offset, limit int, ok bool) {
+	if limit, ok = pageLimit(w, r); !ok {
+		return 0, 0, false
+	}
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		var err error
+		offset, err = strconv.Atoi(cursor)
+		if err != nil || offset < 0 {
+			writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, "invalid cursor")
+			return 0, 0, false
+		}
+	}
+	return offset, limit, true
+}
+
+// pageLimit reads the limit query parameter of a listing request, writing a
+// 400 response if it's invalid.
+func pageLimit(w http.ResponseWriter, r *http.Request) (int, bool) {
+	limit := defaultPageSize
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		var err error
+		limit, err = strconv.Atoi(limitParam)
+		if err != nil || limit < 1 {
+			writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, "invalid limit")
+			return 0, false
+		}
+		limit = min(limit, maxPageSize)
+	}
+	return limit, true
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+	"github.com/rs/zerolog"
+)
+
+// rateLimitSweepInterval is how often idle buckets are forgotten.
+const rateLimitSweepInterval = time.Minute
+
+// RateLimiter is a set of token buckets, one per client, each holding up to
+// Limit requests and refilling at Limit per Period.
+type RateLimiter struct {
+	limit types.RateLimit
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func NewRateLimiter(limit types.RateLimit) *RateLimiter {
+	return &RateLimiter{limit: limit, buckets: map[string]*tokenBucket{}, lastSweep: time.Now()}
+}
+
+// allow takes a token from key's bucket. If it is empty, allow returns false
+// and how long until the next token.
+func (l *RateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	capacity := float64(l.limit.Limit)
+	perSecond := capacity / l.limit.Period.Seconds()
+	if now.Sub(l.lastSweep) >= rateLimitSweepInterval {
+		l.sweep(now, capacity, perSecond)
+	}
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: capacity, updated: now}
+		l.buckets[key] = bucket
+	}
+	bucket.tokens = min(capacity, bucket.tokens+now.Sub(bucket.updated).Seconds()*perSecond)
+	bucket.updated = now
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / perSecond * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// sweep drops buckets that have refilled, since a new bucket starts full.
+func (l *RateLimiter) sweep(now time.Time, capacity, perSecond float64) {
+	for key, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.updated).Seconds()*perSecond >= capacity {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// RateLimit rejects claims (GET /jobs) and requests that change state with
+// 429 once a client has used up its bucket: perWorker is keyed by the
+// X-Worker-ID header and perIP by the connection's address (not
+// X-Forwarded-For, which clients can set). Either may be nil. Other reads
+// aren't limited.
+func RateLimit(perWorker, perIP *RateLimiter, logger *zerolog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !rateLimited(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		now := time.Now()
+		check := func(limiter *RateLimiter, kind, key string) bool {
+			if limiter == nil || key == "" {
+				return true
+			}
+			ok, retryAfter := limiter.allow(key, now)
+			if ok {
+				return true
+			}
+			apiRateLimited.With(kind).Inc()
+			logger.Debug().Str("limit", kind).Str("key", key).Str("path", r.URL.Path).Msg("Rate limited request")
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			writeError(w, http.StatusTooManyRequests, types.ErrorRateLimited, "rate limit exceeded")
+			return false
+		}
+
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		if !check(perIP, "ip", ip) || !check(perWorker, "worker", r.Header.Get("X-Worker-ID")) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func rateLimited(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return r.Method == http.MethodGet && (r.URL.Path == "/jobs" || r.URL.Path == "/v1/jobs")
+	}
+	return true
+}
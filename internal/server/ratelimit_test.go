@@ -0,0 +1,57 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+)
+
+func TestRateLimiterBucket(t *testing.T) {
+	l := NewRateLimiter(types.RateLimit{Limit: 2, Period: time.Second})
+	now := time.Now()
+
+	for i := range 2 {
+		if ok, _ := l.allow("w1", now); !ok {
+			t.Fatalf("request %d refused within the burst", i+1)
+		}
+	}
+	ok, retryAfter := l.allow("w1", now)
+	if ok {
+		t.Fatal("allowed a request over the burst")
+	}
+	if retryAfter != 500*time.Millisecond {
+		t.Fatalf("retry after %s, want 500ms", retryAfter)
+	}
+
+	// Buckets are per key.
+	if ok, _ := l.allow("w2", now); !ok {
+		t.Fatal("another key's bucket was drained")
+	}
+
+	// A token refills every half second, and the bucket never holds more
+	// than the limit.
+	if ok, _ := l.allow("w1", now.Add(500*time.Millisecond)); !ok {
+		t.Fatal("refused once a token had refilled")
+	}
+	later := now.Add(time.Hour)
+	for i := range 3 {
+		ok, _ := l.allow("w1", later)
+		if want := i < 2; ok != want {
+			t.Fatalf("request %d after idling: allowed %v, want %v", i+1, ok, want)
+		}
+	}
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	l := NewRateLimiter(types.RateLimit{Limit: 1, Period: time.Second})
+	now := time.Now()
+	l.allow("idle", now)
+	l.allow("busy", now.Add(rateLimitSweepInterval))
+	if _, ok := l.buckets["idle"]; ok {
+		t.Fatal("kept a bucket that had refilled")
+	}
+	if _, ok := l.buckets["busy"]; !ok {
+		t.Fatal("dropped a bucket in use")
+	}
+}
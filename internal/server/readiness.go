@@ -0,0 +1,51 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// readinessTimeout bounds how long a readiness probe waits on Redis.
+const readinessTimeout = 2 * time.Second
+
+// handleReadyz reports whether this server can dispatch jobs: Redis is
+// reachable, each stack is registered with Buildkite and its monitor is
+// polling. It returns 503, naming the failing checks, if not. With more than
+// one stack, the stack and monitor checks are suffixed with the stack's key.
+func (a *API) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	checks := map[string]string{}
+	ready := true
+	check := func(name string, err error) {
+		if err != nil {
+			checks[name] = err.Error()
+			ready = false
+			return
+		}
+		checks[name] = "ok"
+	}
+	check("redis", a.store.Ping(ctx))
+	for _, stack := range a.stacks {
+		suffix := ""
+		if len(a.stacks) > 1 {
+			suffix = ":" + stack.Key()
+		}
+		if stack.Liveness != nil {
+			check("stack"+suffix, stack.Liveness.Check())
+		}
+		check("monitor"+suffix, stack.Monitor.Check())
+	}
+
+	status := "ok"
+	w.Header().Set("Content-Type", "application/json")
+	if !ready {
+		status = "unavailable"
+		a.logger.Warn().Interface("checks", checks).Msg("Not ready")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(map[string]any{"status": status, "checks": checks})
+}
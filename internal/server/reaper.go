@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/storage"
+	"github.com/rs/zerolog/log"
+)
+
+// Reaper periodically requeues claimed jobs whose lease has expired, and marks
+// workers that have stopped heartbeating offline, requeueing the jobs they had
+// claimed but not started.
+type Reaper struct {
+	store         *storage.RedisStore
+	interval      time.Duration
+	workerTimeout time.Duration
+}
+
+func NewReaper(store *storage.RedisStore, interval, workerTimeout time.Duration) *Reaper {
+	return &Reaper{store: store, interval: interval, workerTimeout: workerTimeout}
+}
+
+func (r *Reaper) Start(ctx context.Context) error {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	log.Info().Dur("interval", r.interval).Msg("Starting reaper")
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info().Msg("Reaper shutting down")
+			return ctx.Err()
+		case <-ticker.C:
+			r.reapClaims(ctx)
+			r.reapWorkers(ctx)
+		}
+	}
+}
+
+func (r *Reaper) reapClaims(ctx context.Context) {
+	requeued, err := r.store.RequeueExpiredClaims(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Error requeueing expired claims")
+		return
+	}
+	if len(requeued) > 0 {
+		log.Warn().Strs("jobs", requeued).Msg("Requeued jobs with expired claims")
+	}
+}
+
+func (r *Reaper) reapWorkers(ctx context.Context) {
+	stale, err := r.store.StaleWorkers(ctx, time.Now().Add(-r.workerTimeout))
+	if err != nil {
+		log.Error().Err(err).Msg("Error listing stale workers")
+		return
+	}
+
+	for _, workerID := range stale {
+		requeued, err := r.store.ReapWorker(ctx, workerID)
+		if err != nil {
+			log.Error().Err(err).Str("worker_id", workerID).Msg("Error reaping worker")
+			continue
+		}
+		log.Warn().Str("worker_id", workerID).Strs("jobs", requeued).Msg("Worker stopped heartbeating, marked offline")
+	}
+}
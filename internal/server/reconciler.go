@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/storage"
+	"github.com/buildkite/stacksapi"
+	"github.com/rs/zerolog/log"
+)
+
+// reconcileBatchSize is how many jobs to ask Buildkite about at once.
+const reconcileBatchSize = 100
+
+// Reconciler periodically cross-checks the jobs held in Redis against their
+// state in Buildkite and cleans up any that have diverged:
+//
+//   - cancelled jobs are removed straight away, and workers running them are
+//     told to stop on their next heartbeat;
+//   - jobs Buildkite has finished without a worker completing them are marked
+//     finished;
+//   - jobs we haven't started that are assigned or running in Buildkite went
+//     to another stack, and are marked reassigned.
+//
+// Finished and reassigned jobs must be seen twice in a row before they are
+// removed, so a worker that is about to report the job isn't raced. Each
+// stack's reconciler checks the jobs it reserved; the primary stack's also
+// checks jobs stored before stacks were recorded. With leader election, only
+// the leader reconciles; with sharding, each server reconciles the jobs of
+// the queues it owns.
+type Reconciler struct {
+	client   *stacksapi.Client
+	store    *storage.RedisStore
+	stackKey string
+	primary  bool
+	leader   *LeaderElector
+	shards   *ShardRing
+	interval time.Duration
+
+	// suspects holds the drift seen for each job on the previous pass.
+	suspects map[string]string
+}
+
+func NewReconciler(client *stacksapi.Client, store *storage.RedisStore, stackKey string, primary bool, leader *LeaderElector, shards *ShardRing, interval time.Duration) *Reconciler {
+	return &Reconciler{
+		client:   client,
+		store:    store,
+		stackKey: stackKey,
+		primary:  primary,
+		leader:   leader,
+		shards:   shards,
+		interval: interval,
+		suspects: make(map[string]string),
+	}
+}
+
+func (r *Reconciler) Start(ctx context.Context) error {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	log.Info().Dur("interval", r.interval).Msg("Starting reconciler")
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info().Msg("Reconciler shutting down")
+			return ctx.Err()
+		case <-ticker.C:
+			if !r.leader.IsLeader() {
+				continue
+			}
+			if err := r.reconcile(ctx); err != nil {
+				log.Error().Err(err).Msg("Error reconciling jobs")
+			}
+		}
+	}
+}
+
+func (r *Reconciler) reconcile(ctx context.Context) error {
+	uuids, err := r.store.TrackedJobs(ctx)
+	if err != nil {
+		return fmt.Errorf("listing tracked jobs: %w", err)
+	}
+	stackKeys, err := r.store.JobStackKeys(ctx, uuids)
+	if err != nil {
+		return err
+	}
+	queueKeys, err := r.store.JobQueueKeys(ctx, uuids)
+	if err != nil {
+		return err
+	}
+	uuids = slices.DeleteFunc(uuids, func(uuid string) bool {
+		return !ownedByStack(stackKeys[uuid], r.stackKey, r.primary) || !r.shards.Owns(queueKeys[uuid])
+	})
+
+	suspects := make(map[string]string)
+	for start := 0; start < len(uuids); start += reconcileBatchSize {
+		end := min(start+reconcileBatchSize, len(uuids))
+		if err := r.reconcileBatch(ctx, uuids[start:end], suspects); err != nil {
+			log.Error().Err(err).Msg("Error reconciling job batch")
+		}
+	}
+	r.suspects = suspects
+	return nil
+}
+
+func (r *Reconciler) reconcileBatch(ctx context.Context, uuids []string, suspects map[string]string) error {
+	start := time.Now()
+	resp, _, err := r.client.GetJobStates(ctx, stacksapi.GetJobStatesRequest{
+		StackKey: r.stackKey,
+		JobUUIDs: uuids,
+	})
+	observeStacksAPI("get_job_states", start, err)
+	if err != nil {
+		return fmt.Errorf("get job states: %w", err)
+	}
+
+	statuses, err := r.store.JobStatuses(ctx, uuids)
+	if err != nil {
+		return fmt.Errorf("getting job statuses: %w", err)
+	}
+
+	for uuid, state := range resp.States {
+		drift := jobDrift(statuses[uuid], state)
+		if drift == "" {
+			continue
+		}
+		if drift != "cancelled" && r.suspects[uuid] != drift {
+			suspects[uuid] = drift
+			continue
+		}
+
+		previous, err := r.store.RemoveJob(ctx, uuid, drift)
+		if err != nil {
+			log.Error().Err(err).Str("uuid", uuid).Msg("Error removing diverged job")
+			continue
+		}
+		if previous != statuses[uuid] {
+			// Changed under us, e.g. completed by its worker since we looked.
+			continue
+		}
+		if err := r.store.RecordDrift(ctx, drift); err != nil {
+			log.Error().Err(err).Msg("Error recording drift")
+		}
+		log.Warn().Str("uuid", uuid).Str("status", previous).Str("buildkite_state", state).Str("drift", drift).Msg("Reconciled job with Buildkite")
+	}
+	return nil
+}
+
+// jobDrift compares a job's status in Redis with its state in Buildkite and
+// returns the final status it should be given, or an empty string if the two
+// agree.
+func jobDrift(status, state string) string {
+	switch status {
+	case "reserved", "delayed", "claimed", "running", "dead":
+	default:
+		return ""
+	}
+
+	switch state {
+	case "canceling", "canceled":
+		return "cancelled"
+	case "finished", "timed_out", "skipped", "broken", "expired":
+		return "finished"
+	case "assigned", "accepted", "running", "timing_out":
+		if status == "reserved" || status == "delayed" || status == "dead" {
+			return "reassigned"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,194 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/buildkite/stacksapi"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// renewInterval is how often the monitor looks for reservations to renew,
+	// and renewBefore is how close to expiry a reservation must be to renew it.
+	renewInterval = 30 * time.Second
+	renewBefore   = 2 * time.Minute
+
+	// releaseExpiry is the reservation length used to hand jobs back. The Stacks
+	// API has no explicit release, so we re-reserve them for as short a time as
+	// it allows and let the reservation lapse.
+	releaseExpiry = 1 * time.Second
+)
+
+// renewReservations re-reserves jobs whose reservations are about to expire
+// while they are still pending or claimed in Redis, so a long wait doesn't let
+// Buildkite hand them to another stack and run them twice. Dead-lettered jobs
+// are held too, until an operator requeues them.
+func (m *Monitor) renewReservations(ctx context.Context) error {
+	uuids, err := m.store.ReservationsExpiringBefore(ctx, time.Now().Add(renewBefore))
+	if err != nil {
+		return fmt.Errorf("listing expiring reservations: %w", err)
+	}
+	if len(uuids) == 0 {
+		return nil
+	}
+
+	// Other stacks' monitors renew their own jobs.
+	stackKeys, err := m.store.JobStackKeys(ctx, uuids)
+	if err != nil {
+		return err
+	}
+	uuids = slices.DeleteFunc(uuids, func(uuid string) bool { return !m.ownsJob(stackKeys[uuid]) })
+	if len(uuids) == 0 {
+		return nil
+	}
+
+	statuses, err := m.store.JobStatuses(ctx, uuids)
+	if err != nil {
+		return fmt.Errorf("getting job statuses: %w", err)
+	}
+
+	queueKeys, err := m.store.JobQueueKeys(ctx, uuids)
+	if err != nil {
+		return fmt.Errorf("getting job queues: %w", err)
+	}
+	// So do other shards'.
+	uuids = slices.DeleteFunc(uuids, func(uuid string) bool { return !m.cfg.Shards.Owns(queueKeys[uuid]) })
+
+	// Reservations are renewed per queue, since queues can have different
+	// reservation lengths.
+	var finished []string
+	renew := make(map[string][]string)
+	for _, uuid := range uuids {
+		switch statuses[uuid] {
+		case "reserved", "delayed", "claimed", "dead":
+			renew[queueKeys[uuid]] = append(renew[queueKeys[uuid]], uuid)
+		default:
+			finished = append(finished, uuid)
+		}
+	}
+
+	if err := m.store.UntrackReservations(ctx, finished); err != nil {
+		return fmt.Errorf("untracking finished reservations: %w", err)
+	}
+
+	// Renewals wait out throttling too. They start well before reservations
+	// expire, so a later round catches up.
+	for queueKey, queueUUIDs := range renew {
+		for start := 0; start < len(queueUUIDs) && !m.throttled(); start += m.batchSize() {
+			end := min(start+m.batchSize(), len(queueUUIDs))
+			if err := m.renewBatch(ctx, queueKey, queueUUIDs[start:end]); err != nil {
+				log.Error().Err(err).Str("queue", queueKey).Msg("Error renewing reservation batch")
+			}
+		}
+	}
+
+	return nil
+}
+
+func (m *Monitor) renewBatch(ctx context.Context, queueKey string, uuids []string) error {
+	expiry := m.reservationExpiry(queueKey)
+	start := time.Now()
+	resp, _, err := m.client.BatchReserveJobs(ctx, stacksapi.BatchReserveJobsRequest{
+		StackKey:                 m.cfg.StackKey,
+		JobUUIDs:                 uuids,
+		ReservationExpirySeconds: int(expiry.Seconds()),
+	})
+	observeStacksAPI("batch_reserve_jobs", start, err)
+	if err != nil {
+		m.checkThrottled(err)
+		return fmt.Errorf("batch reserve jobs: %w", err)
+	}
+
+	if err := m.store.TrackReservations(ctx, resp.Reserved, time.Now().Add(expiry)); err != nil {
+		return fmt.Errorf("tracking renewed reservations: %w", err)
+	}
+
+	// Jobs we can no longer reserve have started, been cancelled, or been taken
+	// by another stack; there's nothing further to renew.
+	if len(resp.NotReserved) > 0 {
+		log.Warn().Strs("jobs", resp.NotReserved).Msg("Could not renew reservations")
+		if err := m.store.UntrackReservations(ctx, resp.NotReserved); err != nil {
+			return fmt.Errorf("untracking lost reservations: %w", err)
+		}
+	}
+
+	log.Info().Int("renewed", len(resp.Reserved)).Int("lost", len(resp.NotReserved)).Msg("Renewed reservations")
+	return nil
+}
+
+// ReleaseQueue removes a queue's pending jobs from Redis and releases their
+// reservations so Buildkite can dispatch them elsewhere straight away, rather
+// than after the reservation expires. Jobs already claimed by a worker are
+// left alone. It returns the number of jobs released.
+func (m *Monitor) ReleaseQueue(ctx context.Context, queueKey string) (int, error) {
+	uuids, err := m.store.RemovePendingJobs(ctx, queueKey)
+	if err != nil {
+		return 0, err
+	}
+
+	if released, err := m.release(ctx, uuids); err != nil {
+		return released, err
+	}
+	if len(uuids) > 0 {
+		log.Info().Str("queue", queueKey).Int("count", len(uuids)).Msg("Released reservations")
+	}
+	return len(uuids), nil
+}
+
+// AbandonJob stops scheduling a pending job and releases its reservation, so
+// Buildkite can dispatch it elsewhere. Jobs a worker has claimed return
+// storage.ErrJobNotPending.
+func (m *Monitor) AbandonJob(ctx context.Context, uuid string) error {
+	if err := m.store.AbandonJob(ctx, uuid); err != nil {
+		return err
+	}
+	// The job is no longer ours either way; an unreleased reservation just
+	// holds it back until it expires.
+	if _, err := m.release(ctx, []string{uuid}); err != nil {
+		log.Warn().Err(err).Str("job_id", uuid).Msg("Error releasing abandoned job's reservation")
+	}
+	log.Info().Str("job_id", uuid).Msg("Abandoned job")
+	return nil
+}
+
+// release hands reservations back to Buildkite, returning how many were
+// released before any error.
+func (m *Monitor) release(ctx context.Context, uuids []string) (int, error) {
+	return ReleaseReservations(ctx, m.client, m.cfg.StackKey, uuids, m.batchSize())
+}
+
+// ReleaseReservations hands stackKey's reservations of uuids back to
+// Buildkite, batchSize at a time, returning how many were released before
+// any error.
+func ReleaseReservations(ctx context.Context, client *stacksapi.Client, stackKey string, uuids []string, batchSize int) (int, error) {
+	for start := 0; start < len(uuids); start += batchSize {
+		end := min(start+batchSize, len(uuids))
+		called := time.Now()
+		_, _, err := client.BatchReserveJobs(ctx, stacksapi.BatchReserveJobsRequest{
+			StackKey:                 stackKey,
+			JobUUIDs:                 uuids[start:end],
+			ReservationExpirySeconds: int(releaseExpiry.Seconds()),
+		})
+		observeStacksAPI("batch_reserve_jobs", called, err)
+		if err != nil {
+			return start, fmt.Errorf("releasing reservations: %w", err)
+		}
+	}
+	return len(uuids), nil
+}
+
+// ReleaseAll releases the pending reservations for every monitored queue
+// this server's shard owns.
+func (m *Monitor) ReleaseAll(ctx context.Context) error {
+	var errs []error
+	for _, queueKey := range m.ownedQueues(m.Queues()) {
+		if _, err := m.ReleaseQueue(ctx, queueKey); err != nil {
+			errs = append(errs, fmt.Errorf("queue %s: %w", queueKey, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/metrics"
+)
+
+// route is one of the API's routes, and what the middleware needs to know
+// about it.
+type route struct {
+	pattern string
+	handle  func(*API, http.ResponseWriter, *http.Request)
+	// worker routes are the ones workers use. They're served under /v1/ and,
+	// as deprecated aliases, unversioned, and are the only routes worker
+	// tokens may use.
+	worker bool
+	// idempotent routes replay their first response to retries carrying the
+	// same Idempotency-Key.
+	idempotent bool
+	// action names the route in the audit log. Routes without one aren't
+	// recorded; heartbeats change state too, but would drown everything else.
+	action string
+}
+
+var routes = []route{
+	{pattern: "GET /health", handle: (*API).handleHealth},
+	{pattern: "GET /livez", handle: (*API).handleHealth},
+	{pattern: "GET /readyz", handle: (*API).handleReadyz},
+	{pattern: "GET /jobs", handle: (*API).handleGetJob, worker: true, action: "claim"},
+	{pattern: "GET /jobs/stream", handle: (*API).handleJobStream, worker: true},
+	{pattern: "POST /jobs/{uuid}/complete", handle: (*API).handleCompleteJob, worker: true, idempotent: true, action: "complete"},
+	{pattern: "POST /jobs/{uuid}/fail", handle: (*API).handleFailJob, worker: true, idempotent: true, action: "fail"},
+	{pattern: "POST /jobs/{uuid}/start", handle: (*API).handleStartJob, worker: true, action: "start"},
+	{pattern: "POST /jobs/{uuid}/heartbeat", handle: (*API).handleHeartbeatJob, worker: true},
+	{pattern: "POST /jobs/{uuid}/log", handle: (*API).handleUploadJobLog, worker: true, action: "upload_log"},
+	{pattern: "POST /workers", handle: (*API).handleRegisterWorker, worker: true, action: "register_worker"},
+	{pattern: "POST /workers/{id}/heartbeat", handle: (*API).handleWorkerHeartbeat, worker: true},
+	{pattern: "DELETE /workers/{id}", handle: (*API).handleDeregisterWorker, worker: true, action: "deregister_worker"},
+	{pattern: "GET /jobs/search", handle: (*API).handleListJobs},
+	{pattern: "GET /events/ws", handle: (*API).handleEventsWebSocket},
+	{pattern: "GET /jobs/{uuid}", handle: (*API).handleGetJobDetail},
+	{pattern: "DELETE /jobs/{uuid}", handle: (*API).handleAbandonJob, action: "abandon"},
+	{pattern: "POST /jobs/{uuid}/requeue", handle: (*API).handleRequeueJob, idempotent: true, action: "requeue"},
+	{pattern: "POST /jobs/{uuid}/delay", handle: (*API).handleDelayJob, action: "delay"},
+	{pattern: "PUT /jobs/{uuid}/env", handle: (*API).handleSetJobEnv, action: "set_job_env"},
+	{pattern: "GET /jobs/{uuid}/log", handle: (*API).handleGetJobLog},
+	{pattern: "GET /workers", handle: (*API).handleListWorkers},
+	{pattern: "GET /workers/{id}", handle: (*API).handleGetWorker},
+	{pattern: "GET /stats", handle: (*API).handleStats},
+	{pattern: "GET /stats/stream", handle: (*API).handleStatsStream},
+	{pattern: "GET /audit", handle: (*API).handleListAudit},
+	{pattern: "POST /tokens", handle: (*API).handleCreateToken, action: "create_token"},
+	{pattern: "GET /tokens", handle: (*API).handleListTokens},
+	{pattern: "DELETE /tokens/{id}", handle: (*API).handleRevokeToken, action: "revoke_token"},
+	{pattern: "GET /metrics", handle: (*API).handleMetrics},
+	{pattern: "GET /openapi.json", handle: (*API).handleOpenAPI},
+	{pattern: "GET /ui", handle: (*API).handleUI},
+	{pattern: "GET /ui/queues/{key}", handle: (*API).handleUIQueue},
+	{pattern: "POST /ui/queues/{key}/pause", handle: (*API).handleUIPauseQueue, action: "pause"},
+	{pattern: "POST /ui/queues/{key}/resume", handle: (*API).handleUIResumeQueue, action: "resume"},
+	{pattern: "POST /ui/jobs/{uuid}/requeue", handle: (*API).handleUIRequeueJob, action: "requeue"},
+	{pattern: "POST /ui/jobs/{uuid}/abandon", handle: (*API).handleUIAbandonJob, action: "abandon"},
+	{pattern: "GET /deadletter", handle: (*API).handleListDeadLetter},
+	{pattern: "POST /deadletter/{uuid}/requeue", handle: (*API).handleRequeueDeadLetter, idempotent: true, action: "requeue_dead_letter"},
+	{pattern: "GET /queues", handle: (*API).handleListQueues},
+	{pattern: "GET /queues/{key}/jobs", handle: (*API).handleListQueueJobs},
+	{pattern: "POST /queues/{key}/drain", handle: (*API).handleDrainQueue, action: "drain"},
+	{pattern: "DELETE /queues/{key}/drain", handle: (*API).handleUndrainQueue, action: "undrain"},
+	{pattern: "POST /queues/{key}/pause", handle: (*API).handlePauseQueue, action: "pause"},
+	{pattern: "POST /queues/{key}/resume", handle: (*API).handleResumeQueue, action: "resume"},
+	{pattern: "GET /queues/{key}/env", handle: (*API).handleGetQueueEnv},
+	{pattern: "PUT /queues/{key}/env", handle: (*API).handleSetQueueEnv, action: "set_queue_env"},
+	{pattern: "DELETE /queues/{key}/env", handle: (*API).handleClearQueueEnv, action: "clear_queue_env"},
+}
+
+// versioned returns a worker route's /v1/ pattern.
+func (rt route) versioned() string {
+	method, path, _ := strings.Cut(rt.pattern, " ")
+	return method + " /v1" + path
+}
+
+// auditedActions maps the patterns of the routes recorded in the audit log,
+// both of a worker route's among them, to their actions.
+var auditedActions = func() map[string]string {
+	actions := map[string]string{}
+	for _, rt := range routes {
+		if rt.action == "" {
+			continue
+		}
+		actions[rt.pattern] = rt.action
+		if rt.worker {
+			actions[rt.versioned()] = rt.action
+		}
+	}
+	return actions
+}()
+
+// workerRoutes matches the routes worker-scoped tokens may use.
+var workerRoutes = func() *http.ServeMux {
+	mux := http.NewServeMux()
+	for _, rt := range routes {
+		if rt.worker {
+			mux.Handle(rt.pattern, http.NotFoundHandler())
+			mux.Handle(rt.versioned(), http.NotFoundHandler())
+		}
+	}
+	return mux
+}()
+
+// routeMux returns the mux serving routes with a's handlers, building it on
+// first use.
+func (a *API) routeMux() *http.ServeMux {
+	a.muxOnce.Do(func() {
+		a.mux = http.NewServeMux()
+		for _, rt := range routes {
+			handler := func(w http.ResponseWriter, r *http.Request) { rt.handle(a, w, r) }
+			if rt.idempotent {
+				handler = a.idempotent(handler)
+			}
+			if rt.worker {
+				a.mux.HandleFunc(rt.versioned(), v1(handler))
+				a.mux.HandleFunc(rt.pattern, deprecated(handler))
+			} else {
+				a.mux.HandleFunc(rt.pattern, handler)
+			}
+		}
+	})
+	return a.mux
+}
+
+func (a *API) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	metrics.Default.Handler().ServeHTTP(w, r)
+}
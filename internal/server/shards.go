@@ -0,0 +1,126 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/storage"
+	"github.com/rs/zerolog/log"
+)
+
+// shardVirtualNodes is how many points each server has on the hash ring, so
+// queues spread evenly and only about 1/n of them move when a server joins
+// or leaves.
+const shardVirtualNodes = 128
+
+// ShardRing shares queues out among server replicas sharing Redis by
+// consistent hashing on the queue key, so each polls, reserves and
+// reconciles only its share. Servers heartbeat their membership into Redis
+// every third of ttl; one that stops is dropped once ttl passes, or straight
+// away if it left on shutdown. Every replica serves the API either way.
+type ShardRing struct {
+	store *storage.RedisStore
+	id    string
+	ttl   time.Duration
+
+	mu      sync.RWMutex
+	members []string
+	points  []shardPoint
+}
+
+type shardPoint struct {
+	hash   uint64
+	member string
+}
+
+func NewShardRing(store *storage.RedisStore, id string, ttl time.Duration) *ShardRing {
+	return &ShardRing{store: store, id: id, ttl: ttl}
+}
+
+// Owns reports whether this server polls queueKey. Without a ring, there is
+// only one server, so it owns every queue. Until it has joined the ring, or
+// after it loses touch with Redis, it owns none.
+func (r *ShardRing) Owns(queueKey string) bool {
+	if r == nil {
+		return true
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.points) == 0 {
+		return false
+	}
+	hash := shardHash(queueKey)
+	i, _ := slices.BinarySearchFunc(r.points, hash, func(p shardPoint, h uint64) int { return cmp.Compare(p.hash, h) })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.points[i].member == r.id
+}
+
+// Members returns the servers on the ring.
+func (r *ShardRing) Members() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return slices.Clone(r.members)
+}
+
+func (r *ShardRing) Start(ctx context.Context) error {
+	ticker := time.NewTicker(r.ttl / 3)
+	defer ticker.Stop()
+
+	log.Info().Str("id", r.id).Dur("ttl", r.ttl).Msg("Starting queue sharding")
+
+	for {
+		r.heartbeat(ctx)
+		select {
+		case <-ctx.Done():
+			// The ring is left as it was, so shutdown can still release
+			// this server's queues.
+			if err := r.store.LeaveShards(context.WithoutCancel(ctx), r.id); err != nil {
+				log.Error().Err(err).Msg("Error leaving shards")
+			} else {
+				log.Info().Str("id", r.id).Msg("Left shards")
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// heartbeat renews this server's membership and rebuilds the ring from the
+// live members. If Redis can't be reached, the server gives up its queues,
+// since the others take them over once its membership lapses.
+func (r *ShardRing) heartbeat(ctx context.Context) {
+	members, err := r.store.JoinShards(ctx, r.id, r.ttl)
+	if err != nil {
+		log.Error().Err(err).Msg("Error renewing shard membership")
+		members = nil
+	}
+	slices.Sort(members)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if slices.Equal(members, r.members) {
+		return
+	}
+	r.members = members
+	r.points = make([]shardPoint, 0, len(members)*shardVirtualNodes)
+	for _, member := range members {
+		for i := range shardVirtualNodes {
+			r.points = append(r.points, shardPoint{hash: shardHash(member + "#" + strconv.Itoa(i)), member: member})
+		}
+	}
+	slices.SortFunc(r.points, func(a, b shardPoint) int { return cmp.Compare(a.hash, b.hash) })
+	log.Info().Strs("members", members).Msg("Shard members changed")
+}
+
+func shardHash(key string) uint64 {
+	sum := sha256.Sum256([]byte(key))
+	return binary.BigEndian.Uint64(sum[:8])
+}
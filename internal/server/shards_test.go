@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/buildkite/buildkite-custom-scheduler/internal/storage"
+)
+
+func newTestStore(t *testing.T) *storage.RedisStore {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	store, err := storage.NewRedisStore(mr.Addr(), storage.DispatchOrder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestShardRingSharesQueues(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	rings := []*ShardRing{
+		NewShardRing(store, "a", time.Minute),
+		NewShardRing(store, "b", time.Minute),
+		NewShardRing(store, "c", time.Minute),
+	}
+	if rings[0].Owns("q") {
+		t.Fatal("owned a queue before joining the ring")
+	}
+	// Each ring only sees the servers that joined before it until its next
+	// heartbeat.
+	for range 2 {
+		for _, ring := range rings {
+			ring.heartbeat(ctx)
+		}
+	}
+
+	const queues = 3000
+	owned := map[string]int{}
+	for i := range queues {
+		queueKey := fmt.Sprintf("queue-%d", i)
+		owners := 0
+		for _, ring := range rings {
+			if ring.Owns(queueKey) {
+				owners++
+				owned[ring.id]++
+			}
+		}
+		if owners != 1 {
+			t.Fatalf("%s has %d owners, want 1", queueKey, owners)
+		}
+	}
+	for _, ring := range rings {
+		if share := float64(owned[ring.id]) / queues; share < 0.2 || share > 0.47 {
+			t.Errorf("%s owns %.0f%% of queues, want about a third", ring.id, share*100)
+		}
+	}
+
+	// When c leaves, only its queues move.
+	before := map[string]bool{}
+	for i := range queues {
+		queueKey := fmt.Sprintf("queue-%d", i)
+		before[queueKey] = rings[0].Owns(queueKey)
+	}
+	if err := store.LeaveShards(ctx, "c"); err != nil {
+		t.Fatal(err)
+	}
+	rings[0].heartbeat(ctx)
+	rings[1].heartbeat(ctx)
+	for i := range queues {
+		queueKey := fmt.Sprintf("queue-%d", i)
+		if before[queueKey] && !rings[0].Owns(queueKey) {
+			t.Fatalf("%s moved off a, which stayed", queueKey)
+		}
+		if rings[0].Owns(queueKey) == rings[1].Owns(queueKey) {
+			t.Fatalf("%s isn't owned by exactly one of a and b", queueKey)
+		}
+	}
+}
+
+func TestNilShardRingOwnsEverything(t *testing.T) {
+	var ring *ShardRing
+	if !ring.Owns("q") {
+		t.Fatal("a server without sharding should own every queue")
+	}
+}
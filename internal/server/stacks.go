@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Stack is one stack the server has registered: the monitor reserving its
+// jobs, and its heartbeat, which is nil if heartbeats are disabled.
+type Stack struct {
+	Monitor  *Monitor
+	Liveness *Liveness
+}
+
+// Key returns the stack's key.
+func (s Stack) Key() string {
+	return s.Monitor.cfg.StackKey
+}
+
+// Stacks are the stacks the server has registered, primary first. Each polls
+// its own queues, so requests about a queue or a job go to the stack that
+// reserved it.
+type Stacks []Stack
+
+// forQueue returns the monitor polling queueKey, or the primary stack's if
+// none is.
+func (s Stacks) forQueue(queueKey string) *Monitor {
+	for _, stack := range s {
+		if stack.Monitor.polls(queueKey) {
+			return stack.Monitor
+		}
+	}
+	return s[0].Monitor
+}
+
+// forJob returns the monitor of the stack that reserved a job.
+func (s Stacks) forJob(ctx context.Context, uuid string) (*Monitor, error) {
+	if len(s) == 1 {
+		return s[0].Monitor, nil
+	}
+	stackKeys, err := s[0].Monitor.store.JobStackKeys(ctx, []string{uuid})
+	if err != nil {
+		return nil, err
+	}
+	for _, stack := range s {
+		if stack.Monitor.ownsJob(stackKeys[uuid]) {
+			return stack.Monitor, nil
+		}
+	}
+	return s[0].Monitor, nil
+}
+
+// Trigger asks the stack polling queueKey to poll it now, returning false if
+// none does.
+func (s Stacks) Trigger(queueKey string) bool {
+	for _, stack := range s {
+		if stack.Monitor.Trigger(queueKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// Queues returns the queues the stacks poll, sorted.
+func (s Stacks) Queues() []string {
+	var queues []string
+	for _, stack := range s {
+		queues = append(queues, stack.Monitor.Queues()...)
+	}
+	slices.Sort(queues)
+	return slices.Compact(queues)
+}
+
+// TriggerAll asks every stack to poll all its queues now.
+func (s Stacks) TriggerAll() bool {
+	triggered := false
+	for _, stack := range s {
+		for _, queueKey := range stack.Monitor.Queues() {
+			triggered = stack.Monitor.Trigger(queueKey) || triggered
+		}
+	}
+	return triggered
+}
+
+// AbandonJob abandons a pending job with the stack that reserved it.
+func (s Stacks) AbandonJob(ctx context.Context, uuid string) error {
+	monitor, err := s.forJob(ctx, uuid)
+	if err != nil {
+		return err
+	}
+	return monitor.AbandonJob(ctx, uuid)
+}
+
+// ReleaseQueue releases a queue's pending jobs with the stack polling it.
+func (s Stacks) ReleaseQueue(ctx context.Context, queueKey string) (int, error) {
+	return s.forQueue(queueKey).ReleaseQueue(ctx, queueKey)
+}
+
+// ReleaseAll releases the pending reservations of every stack.
+func (s Stacks) ReleaseAll(ctx context.Context) error {
+	var errs []error
+	for _, stack := range s {
+		if err := stack.Monitor.ReleaseAll(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("stack %s: %w", stack.Key(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Alive returns an error if any stack's monitor loop is stuck or has
+// stopped. Unlike readiness, it doesn't depend on Buildkite being reachable.
+func (s Stacks) Alive() error {
+	for _, stack := range s {
+		if err := stack.Monitor.Alive(); err != nil {
+			return fmt.Errorf("stack %s: %w", stack.Key(), err)
+		}
+	}
+	return nil
+}
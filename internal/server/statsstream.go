@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+)
+
+const (
+	defaultStatsInterval = 5 * time.Second
+	minStatsInterval     = time.Second
+)
+
+// handleStatsStream holds open a server-sent event stream of scheduler stats,
+// sending a "stats" event every ?interval= (5s by default) with queue depth
+// changes and how many jobs were reserved, claimed, completed, failed and
+// requeued since the last one.
+func (a *API) handleStatsStream(w http.ResponseWriter, r *http.Request) {
+	interval := defaultStatsInterval
+	if intervalParam := r.URL.Query().Get("interval"); intervalParam != "" {
+		var err error
+		interval, err = time.ParseDuration(intervalParam)
+		if err != nil || interval < minStatsInterval {
+			writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, "interval must be a duration of at least 1s")
+			return
+		}
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, types.ErrorInternal, "streaming is not supported")
+		return
+	}
+
+	ctx := r.Context()
+	events := a.store.SubscribeJobEvents(ctx)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var depths map[string]int64
+	throughput := map[string]int{}
+	send := func() bool {
+		update, err := a.statsUpdate(ctx, depths, throughput)
+		if err != nil {
+			a.logger.Error().Err(err).Msg("Error getting stats")
+			return true
+		}
+		update.IntervalSeconds = interval.Seconds()
+		data, err := json.Marshal(update)
+		if err != nil {
+			return true
+		}
+		if _, err := fmt.Fprintf(w, "event: stats\ndata: %s\n\n", data); err != nil {
+			return false
+		}
+		flusher.Flush()
+		depths = update.depths
+		throughput = map[string]int{}
+		return true
+	}
+	if !send() {
+		return
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-a.notifier.Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			throughput[event.Type]++
+		case <-ticker.C:
+			if !send() {
+				return
+			}
+		}
+	}
+}
+
+// statsSnapshot is a stats update along with the queue depths it was taken
+// from, which the next update's deltas are against.
+type statsSnapshot struct {
+	types.StatsUpdate
+	depths map[string]int64
+}
+
+// statsUpdate gathers the current stats. The depths of queues that changed
+// since prev are sent as deltas, or all of them if prev is nil.
+func (a *API) statsUpdate(ctx context.Context, prev map[string]int64, throughput map[string]int) (*statsSnapshot, error) {
+	depths, err := a.store.GetAllStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	update := &statsSnapshot{
+		StatsUpdate: types.StatsUpdate{Time: time.Now(), Throughput: throughput},
+		depths:      depths,
+	}
+	for _, count := range depths {
+		update.Total += count
+	}
+	if update.Delayed, err = a.store.GetDelayedCount(ctx); err != nil {
+		return nil, err
+	}
+	if update.DeadLetter, err = a.store.GetDeadLetterCount(ctx); err != nil {
+		return nil, err
+	}
+	if update.Workers, err = a.store.ActiveWorkerCount(ctx, time.Now().Add(-a.workerTimeout())); err != nil {
+		return nil, err
+	}
+
+	if prev == nil {
+		update.Queues = depths
+		return update, nil
+	}
+	update.QueueDeltas = map[string]int64{}
+	for rules, count := range depths {
+		if delta := count - prev[rules]; delta != 0 {
+			update.QueueDeltas[rules] = delta
+		}
+	}
+	for rules, count := range prev {
+		if _, ok := depths[rules]; !ok && count != 0 {
+			update.QueueDeltas[rules] = -count
+		}
+	}
+	return update, nil
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+	"github.com/rs/zerolog/hlog"
+)
+
+// handleJobStream holds open a server-sent event stream that offers jobs to a
+// worker: an "offer" event is sent whenever jobs matching the query rules are
+// waiting, and the worker claims them through GET /jobs as it has room. Offers
+// don't claim anything, so a busy worker can ignore them.
+func (a *API) handleJobStream(w http.ResponseWriter, r *http.Request) {
+	queryParam := r.URL.Query().Get("query")
+	if queryParam == "" {
+		writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, "query parameter is required")
+		return
+	}
+	queryRules := splitRules(queryParam)
+
+	workerID := r.Header.Get("X-Worker-ID")
+	if workerID == "" {
+		writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, "X-Worker-ID header is required")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, types.ErrorInternal, "streaming is not supported")
+		return
+	}
+
+	hlog.FromRequest(r).Debug().
+		Strs("query_rules", queryRules).
+		Str("worker_id", workerID).
+		Msg("streaming job offers")
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ctx := r.Context()
+	recheck := time.NewTicker(claimRecheckInterval)
+	defer recheck.Stop()
+
+	for {
+		changed := a.notifier.Changed()
+
+		pending, err := a.store.HasPendingJobs(ctx, queryRules)
+		if err != nil {
+			a.logger.Error().Err(err).Msg("Error checking for pending jobs")
+		}
+		if pending {
+			if _, err := fmt.Fprint(w, "event: offer\ndata: {}\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-a.notifier.Done():
+			return
+		case <-changed:
+		case <-recheck.C:
+			// A comment line keeps proxies from timing out the stream and
+			// tells us when the worker has gone away.
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+			if err := a.store.WorkerHeartbeat(ctx, workerID); err != nil {
+				a.logger.Error().Err(err).Str("worker_id", workerID).Msg("Error recording worker heartbeat")
+			}
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/storage"
+	"github.com/rs/zerolog/log"
+)
+
+// Sweeper finds orphaned jobs, which Redis says are waiting or claimed but which
+// no worker can pick up or is working on, and requeues or expunges them.
+type Sweeper struct {
+	store         *storage.RedisStore
+	workerTimeout time.Duration
+}
+
+func NewSweeper(store *storage.RedisStore, workerTimeout time.Duration) *Sweeper {
+	return &Sweeper{store: store, workerTimeout: workerTimeout}
+}
+
+// Start sweeps every interval, requeueing any orphans found.
+func (s *Sweeper) Start(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.Info().Dur("interval", interval).Msg("Starting orphan sweeper")
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if _, err := s.Sweep(ctx, false, false); err != nil {
+				log.Error().Err(err).Msg("Error sweeping orphaned jobs")
+			}
+		}
+	}
+}
+
+// Sweep finds orphaned jobs and, unless dryRun is set, requeues them, or
+// deletes them if expunge is set. It returns the orphans found.
+func (s *Sweeper) Sweep(ctx context.Context, dryRun, expunge bool) ([]storage.Orphan, error) {
+	orphans, err := s.store.FindOrphans(ctx, time.Now().Add(-s.workerTimeout))
+	if err != nil {
+		return nil, fmt.Errorf("finding orphaned jobs: %w", err)
+	}
+
+	for _, orphan := range orphans {
+		logger := log.With().Str("uuid", orphan.UUID).Str("status", orphan.Status).Str("reason", orphan.Reason).Logger()
+		switch {
+		case dryRun:
+			logger.Info().Msg("Found orphaned job")
+		case expunge:
+			if err := s.store.ExpungeJob(ctx, orphan.UUID); err != nil {
+				logger.Error().Err(err).Msg("Error expunging orphaned job")
+				continue
+			}
+			logger.Warn().Msg("Expunged orphaned job")
+		default:
+			requeued, err := s.store.RequeueOrphan(ctx, orphan)
+			if err != nil {
+				logger.Error().Err(err).Msg("Error requeueing orphaned job")
+				continue
+			}
+			if requeued {
+				logger.Warn().Msg("Requeued orphaned job")
+			}
+		}
+	}
+	return orphans, nil
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/tracing"
+	"github.com/buildkite/stacksapi"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// defaultThrottleBackoff is how long to back off when the Stacks API
+	// throttles us without saying for how long, and maxThrottleBackoff the
+	// longest Retry-After honoured.
+	defaultThrottleBackoff = 10 * time.Second
+	maxThrottleBackoff     = 5 * time.Minute
+
+	// A throttled poll or reservation is retried up to maxThrottleRetries
+	// times, waiting from throttleRetryBase, doubling up to
+	// maxThrottleRetryWait, but never less than the Retry-After. Longer
+	// Retry-Afters back the whole monitor off instead of holding up the poll.
+	maxThrottleRetries   = 3
+	throttleRetryBase    = time.Second
+	maxThrottleRetryWait = 30 * time.Second
+)
+
+// stacksAPITransport counts every 429 the Stacks API sends, including those
+// the client retries itself, and puts calls through a circuit breaker.
+type stacksAPITransport struct {
+	next    http.RoundTripper
+	breaker *CircuitBreaker
+}
+
+func (t stacksAPITransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.breaker.allow() {
+		return nil, ErrCircuitOpen
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err == nil && resp.StatusCode == http.StatusTooManyRequests {
+		stacksAPIThrottled.With().Inc()
+	}
+	t.breaker.record(err != nil || resp.StatusCode >= 500)
+	return resp, err
+}
+
+// StacksAPIHTTPClient returns the HTTP client for a stack's Stacks API
+// client, which spans each call, counts throttled responses and, if breaker
+// isn't nil, stops calling the API while it's failing.
+func StacksAPIHTTPClient(breaker *CircuitBreaker) *http.Client {
+	return &http.Client{Transport: tracing.Transport("stacks-api", stacksAPITransport{next: http.DefaultTransport, breaker: breaker})}
+}
+
+// throttleDelay reports whether err is the Stacks API still throttling us
+// once the client has run out of retries, and how long it asked us to wait.
+func throttleDelay(err error) (time.Duration, bool) {
+	var errResp *stacksapi.ErrorResponse
+	if !errors.As(err, &errResp) || errResp.Response == nil || errResp.Response.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+	seconds, err := strconv.Atoi(errResp.Response.Header.Get("Retry-After"))
+	if err != nil || seconds <= 0 {
+		return defaultThrottleBackoff, true
+	}
+	return min(time.Duration(seconds)*time.Second, maxThrottleBackoff), true
+}
+
+// retryThrottled calls f, retrying as above while the Stacks API throttles it.
+// If it's still throttled after that, the monitor backs off until the
+// Retry-After has passed.
+func (m *Monitor) retryThrottled(ctx context.Context, operation string, f func() error) error {
+	backoff := throttleRetryBase
+	for attempt := 1; ; attempt++ {
+		err := f()
+		delay, throttled := throttleDelay(err)
+		if !throttled {
+			return err
+		}
+		wait := max(backoff, delay)
+		if attempt > maxThrottleRetries || wait > maxThrottleRetryWait {
+			m.checkThrottled(err)
+			return err
+		}
+		log.Debug().Str("operation", operation).Int("attempt", attempt).Dur("retry_in", wait).Msg("Stacks API rate limited, retrying")
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		backoff = min(backoff*2, maxThrottleRetryWait)
+	}
+}
+
+// checkThrottled backs the monitor off if err is the Stacks API throttling
+// it, until the Retry-After has passed.
+func (m *Monitor) checkThrottled(err error) {
+	delay, ok := throttleDelay(err)
+	if !ok {
+		return
+	}
+	if !m.throttled() {
+		log.Warn().Dur("retry_after", delay).Msg("Stacks API is rate limiting us, backing off")
+	}
+	if until := time.Now().Add(delay); until.After(m.throttledUntil) {
+		m.throttledUntil = until
+	}
+}
+
+// throttled reports whether the monitor is backing off from the Stacks API.
+func (m *Monitor) throttled() bool {
+	return time.Now().Before(m.throttledUntil)
+}
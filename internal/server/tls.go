@@ -0,0 +1,110 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// certReloadInterval is how often the certificate files are checked for
+// changes, so renewed certificates are picked up without a restart.
+const certReloadInterval = time.Minute
+
+// CertLoader serves a TLS certificate from files, reloading it when they
+// change.
+type CertLoader struct {
+	certFile string
+	keyFile  string
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+// NewCertLoader loads the certificate and key, failing if they can't be used.
+func NewCertLoader(certFile, keyFile string) (*CertLoader, error) {
+	l := &CertLoader{certFile: certFile, keyFile: keyFile}
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// TLSConfig returns a server config that uses the loader's certificate.
+func (l *CertLoader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: l.GetCertificate,
+	}
+}
+
+// AutocertConfig returns a server config that obtains and renews
+// certificates for domains from Let's Encrypt, agreeing to its terms, cached
+// with the ACME account key in cacheDir. It answers the TLS-ALPN-01
+// challenge, so the listener must be reachable on port 443 at each domain.
+func AutocertConfig(domains []string, email, cacheDir string) *tls.Config {
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Cache:      autocert.DirCache(cacheDir),
+		Email:      email,
+	}
+	config := manager.TLSConfig()
+	config.MinVersion = tls.VersionTLS12
+	return config
+}
+
+// GetCertificate is a tls.Config GetCertificate callback. If a changed
+// certificate can't be loaded, the previous one is kept.
+func (l *CertLoader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if time.Since(l.checkedAt) >= certReloadInterval {
+		l.checkedAt = time.Now()
+		if modTime, err := l.latestModTime(); err == nil && modTime.After(l.modTime) {
+			l.reload()
+		}
+	}
+	return l.cert, nil
+}
+
+func (l *CertLoader) load() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.reload()
+}
+
+// reload reads the files; callers hold mu.
+func (l *CertLoader) reload() error {
+	modTime, err := l.latestModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(l.certFile, l.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading TLS certificate: %w", err)
+	}
+	l.cert = &cert
+	l.modTime = modTime
+	l.checkedAt = time.Now()
+	return nil
+}
+
+func (l *CertLoader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{l.certFile, l.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("reading TLS certificate: %w", err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
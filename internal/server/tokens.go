@@ -0,0 +1,85 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/storage"
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+)
+
+type createTokenRequest struct {
+	Name     string `json:"name"`
+	Scope    string `json:"scope"`
+	WorkerID string `json:"worker_id"`
+}
+
+// handleCreateToken mints a token. Only admins reach it, since worker tokens
+// are limited to the worker routes.
+func (a *API) handleCreateToken(w http.ResponseWriter, r *http.Request) {
+	var req createTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, "invalid request body")
+		return
+	}
+	if req.Name == "" {
+		writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, "name is required")
+		return
+	}
+	if req.Scope == "" {
+		req.Scope = types.TokenScopeWorker
+	}
+	if req.Scope != types.TokenScopeWorker && req.Scope != types.TokenScopeAdmin {
+		writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, `scope must be "worker" or "admin"`)
+		return
+	}
+	if req.Scope == types.TokenScopeAdmin && req.WorkerID != "" {
+		writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, "admin tokens can't be bound to a worker")
+		return
+	}
+	if req.Scope == types.TokenScopeWorker && req.WorkerID == "" {
+		writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, "worker tokens must be bound to a worker_id")
+		return
+	}
+
+	secret, token, err := a.store.CreateAPIToken(r.Context(), req.Name, req.Scope, req.WorkerID)
+	if err != nil {
+		a.logger.Error().Err(err).Msg("Error creating API token")
+		writeError(w, http.StatusInternalServerError, types.ErrorInternal, "internal server error")
+		return
+	}
+	a.logger.Info().Str("id", token.ID).Str("name", token.Name).Str("scope", token.Scope).Msg("Created API token")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(types.NewAPIToken{APIToken: *token, Token: secret})
+}
+
+func (a *API) handleListTokens(w http.ResponseWriter, r *http.Request) {
+	tokens, err := a.store.ListAPITokens(r.Context())
+	if err != nil {
+		a.logger.Error().Err(err).Msg("Error listing API tokens")
+		writeError(w, http.StatusInternalServerError, types.ErrorInternal, "internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+func (a *API) handleRevokeToken(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	err := a.store.RevokeAPIToken(r.Context(), id)
+	if errors.Is(err, storage.ErrTokenNotFound) {
+		writeError(w, http.StatusNotFound, types.ErrorNotFound, "token not found")
+		return
+	}
+	if err != nil {
+		a.logger.Error().Err(err).Str("id", id).Msg("Error revoking API token")
+		writeError(w, http.StatusInternalServerError, types.ErrorInternal, "internal server error")
+		return
+	}
+	a.logger.Info().Str("id", id).Msg("Revoked API token")
+
+	w.WriteHeader(http.StatusOK)
+}
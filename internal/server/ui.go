@@ -0,0 +1,234 @@
+package server
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/storage"
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+)
+
+//go:embed ui/*.html
+var uiFiles embed.FS
+
+var uiTemplates = template.Must(template.New("").Funcs(template.FuncMap{
+	"ago": func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return time.Since(t).Round(time.Second).String() + " ago"
+	},
+	"seconds": func(s float64) string {
+		return (time.Duration(s) * time.Second).String()
+	},
+	"rate": func(r float64) string {
+		return strconv.FormatFloat(r, 'f', 1, 64)
+	},
+	"lastError": func(failures []types.JobFailure) string {
+		if len(failures) == 0 {
+			return ""
+		}
+		return failures[len(failures)-1].Error
+	},
+}).ParseFS(uiFiles, "ui/*.html"))
+
+func isUIPath(path string) bool {
+	return path == "/ui" || strings.HasPrefix(path, "/ui/")
+}
+
+type uiQueue struct {
+	Key           string
+	Paused        bool
+	StopReserving bool
+}
+
+type uiIndexPage struct {
+	Pending         int64
+	Delayed         int64
+	DeadLetterCount int64
+	Buckets         []types.QueueSummary
+	Queues          []uiQueue
+	Workers         []types.WorkerInfo
+	DeadLetter      []types.DeadLetterJob
+}
+
+type uiQueuePage struct {
+	Queue      uiQueue
+	Jobs       []types.QueuedJob
+	NextCursor string
+}
+
+func (a *API) handleUI(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var page uiIndexPage
+	err := func() error {
+		stats, err := a.store.GetAllStats(ctx)
+		if err != nil {
+			return err
+		}
+		for _, count := range stats {
+			page.Pending += count
+		}
+		if page.Delayed, err = a.store.GetDelayedCount(ctx); err != nil {
+			return err
+		}
+		if page.Buckets, err = a.store.ListQueues(ctx); err != nil {
+			return err
+		}
+		if page.Queues, err = a.uiQueues(ctx); err != nil {
+			return err
+		}
+		if page.Workers, err = a.store.ListWorkers(ctx); err != nil {
+			return err
+		}
+		if page.DeadLetterCount, err = a.store.GetDeadLetterCount(ctx); err != nil {
+			return err
+		}
+		page.DeadLetter, _, err = a.store.ListDeadLetter(ctx, 0, defaultPageSize)
+		return err
+	}()
+	if err != nil {
+		a.logger.Error().Err(err).Msg("Error rendering UI")
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	a.renderUI(w, "index.html", page)
+}
+
+func (a *API) handleUIQueue(w http.ResponseWriter, r *http.Request) {
+	queueKey := r.PathValue("key")
+	offset, limit, ok := pageParams(w, r)
+	if !ok {
+		return
+	}
+
+	paused, err := a.store.PausedQueues(r.Context())
+	if err != nil {
+		a.logger.Error().Err(err).Msg("Error rendering UI")
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	stopReserving, isPaused := paused[queueKey]
+	page := uiQueuePage{Queue: uiQueue{Key: queueKey, Paused: isPaused, StopReserving: stopReserving}}
+
+	jobs, next, err := a.store.ListQueueJobs(r.Context(), queueKey, offset, limit)
+	if err != nil {
+		a.logger.Error().Err(err).Str("queue", queueKey).Msg("Error rendering UI")
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	page.Jobs = jobs
+	if next > 0 {
+		page.NextCursor = strconv.Itoa(next)
+	}
+	a.renderUI(w, "queue.html", page)
+}
+
+// uiQueues returns the Buildkite queues worth showing: those with jobs waiting
+// and those paused.
+func (a *API) uiQueues(ctx context.Context) ([]uiQueue, error) {
+	keys, err := a.store.QueueKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	paused, err := a.store.PausedQueues(ctx)
+	if err != nil {
+		return nil, err
+	}
+	queues := make([]uiQueue, len(keys))
+	for i, key := range keys {
+		stopReserving, isPaused := paused[key]
+		queues[i] = uiQueue{Key: key, Paused: isPaused, StopReserving: stopReserving}
+	}
+	return queues, nil
+}
+
+func (a *API) renderUI(w http.ResponseWriter, name string, data any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := uiTemplates.ExecuteTemplate(w, name, data); err != nil {
+		a.logger.Error().Err(err).Str("template", name).Msg("Error rendering UI")
+	}
+}
+
+func (a *API) handleUIPauseQueue(w http.ResponseWriter, r *http.Request) {
+	queueKey := r.PathValue("key")
+	stopReserving := r.FormValue("stop_reserving") != ""
+	a.uiAction(w, r, "pause queue "+queueKey, func(ctx context.Context) error {
+		return a.store.SetQueuePaused(ctx, queueKey, true, stopReserving)
+	})
+}
+
+func (a *API) handleUIResumeQueue(w http.ResponseWriter, r *http.Request) {
+	queueKey := r.PathValue("key")
+	a.uiAction(w, r, "resume queue "+queueKey, func(ctx context.Context) error {
+		return a.store.SetQueuePaused(ctx, queueKey, false, false)
+	})
+}
+
+func (a *API) handleUIRequeueJob(w http.ResponseWriter, r *http.Request) {
+	uuid := r.PathValue("uuid")
+	a.uiAction(w, r, "requeue job "+uuid, func(ctx context.Context) error {
+		return a.store.RequeueJob(ctx, uuid)
+	})
+}
+
+func (a *API) handleUIAbandonJob(w http.ResponseWriter, r *http.Request) {
+	uuid := r.PathValue("uuid")
+	a.uiAction(w, r, "abandon job "+uuid, func(ctx context.Context) error {
+		return a.stacks.AbandonJob(ctx, uuid)
+	})
+}
+
+// uiAction runs a button's action and sends the browser back to the UI page
+// it came from. Failures the operator can act on are shown as they are.
+func (a *API) uiAction(w http.ResponseWriter, r *http.Request, action string, fn func(ctx context.Context) error) {
+	if !sameOrigin(r) {
+		http.Error(w, "cross-origin request refused", http.StatusForbidden)
+		return
+	}
+
+	err := fn(r.Context())
+	switch {
+	case errors.Is(err, storage.ErrJobNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, storage.ErrJobNotPending), errors.Is(err, storage.ErrJobNotClaimed),
+		errors.Is(err, storage.ErrJobStarted), errors.Is(err, storage.ErrJobAlreadyComplete):
+		http.Error(w, fmt.Sprintf("can't %s: %s", action, err), http.StatusConflict)
+		return
+	case err != nil:
+		a.logger.Error().Err(err).Str("action", action).Msg("Error running UI action")
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	a.logger.Info().Str("action", action).Msg("UI action")
+
+	back := "/ui"
+	if referer, err := url.Parse(r.Referer()); err == nil && referer.Host == r.Host && isUIPath(referer.Path) {
+		back = referer.RequestURI()
+	}
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// sameOrigin reports whether a form post came from the UI's own pages.
+// Browsers send basic auth credentials along with cross-site posts too, so
+// those are refused. Requests without either header aren't from a browser.
+func sameOrigin(r *http.Request) bool {
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" {
+		return site == "same-origin" || site == "none"
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
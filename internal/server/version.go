@@ -0,0 +1,49 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+)
+
+// apiVersion is the version of the worker protocol served under /v1/.
+const apiVersion = "1"
+
+// apiVersionHeader carries the protocol versions a worker can speak, most
+// preferred first, and in responses the version the server used.
+const apiVersionHeader = "Scheduler-API-Version"
+
+// v1 serves next as a /v1/ worker route. Workers that ask only for versions
+// this server doesn't speak are refused rather than being answered in a
+// protocol they may misread.
+func v1(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if requested := r.Header.Get(apiVersionHeader); requested != "" && !offersVersion(requested, apiVersion) {
+			writeError(w, http.StatusBadRequest, types.ErrorUnsupportedVersion, "unsupported API version "+requested+"; this server speaks "+apiVersion)
+			return
+		}
+		w.Header().Set(apiVersionHeader, apiVersion)
+		next(w, r)
+	}
+}
+
+// deprecated serves next at an unversioned worker route, kept as an alias of
+// its /v1/ route until deployed workers have moved over.
+func deprecated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		deprecatedRequests.With(r.Pattern).Inc()
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "</v1"+r.URL.Path+`>; rel="successor-version"`)
+		v1(next)(w, r)
+	}
+}
+
+func offersVersion(requested, version string) bool {
+	for _, v := range strings.Split(requested, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(v), "v") == version {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+	"github.com/rs/zerolog"
+)
+
+// webhookPath is where Buildkite delivers webhooks.
+const webhookPath = "/webhooks/buildkite"
+
+// maxWebhookBodySize bounds webhook payloads, which describe a single job.
+const maxWebhookBodySize = 1 << 20
+
+// webhookSignatureTolerance is how old a signed webhook may be, so captured
+// deliveries can't be replayed later.
+const webhookSignatureTolerance = 5 * time.Minute
+
+type webhookPayload struct {
+	Event string `json:"event"`
+	Job   struct {
+		ID              string   `json:"id"`
+		AgentQueryRules []string `json:"agent_query_rules"`
+	} `json:"job"`
+}
+
+// BuildkiteWebhook serves Buildkite's webhooks at /webhooks/buildkite,
+// passing other requests to next. A job.scheduled event triggers an
+// immediate poll of the job's queue by the stack polling it, or of every
+// queue if the job doesn't name one. Buildkite can't send bearer tokens, so deliveries are checked
+// against token instead, sent either as X-Buildkite-Token or as the key of
+// an X-Buildkite-Signature.
+func BuildkiteWebhook(token string, stacks Stacks, logger *zerolog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != webhookPath {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeError(w, http.StatusMethodNotAllowed, types.ErrorInvalidRequest, "method not allowed")
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBodySize))
+		if err != nil {
+			writeError(w, http.StatusRequestEntityTooLarge, types.ErrorTooLarge, "webhook body too large")
+			return
+		}
+		if err := verifyWebhook(r, body, token, time.Now()); err != nil {
+			webhooksReceived.With("unknown", "unauthorized").Inc()
+			logger.Warn().Err(err).Str("remote_addr", r.RemoteAddr).Msg("Rejected webhook")
+			writeError(w, http.StatusUnauthorized, types.ErrorUnauthorized, "unauthorized")
+			return
+		}
+
+		var payload webhookPayload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			webhooksReceived.With("unknown", "invalid").Inc()
+			writeError(w, http.StatusBadRequest, types.ErrorInvalidRequest, "invalid webhook body")
+			return
+		}
+		event := r.Header.Get("X-Buildkite-Event")
+		if event == "" {
+			event = payload.Event
+		}
+		if event != "job.scheduled" {
+			// Other events, including the ping sent when the webhook is
+			// set up, are acknowledged and ignored.
+			webhooksReceived.With(event, "ignored").Inc()
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		queueKey := webhookQueue(payload.Job.AgentQueryRules)
+		var triggered bool
+		if queueKey == "" {
+			triggered = stacks.TriggerAll()
+		} else {
+			triggered = stacks.Trigger(queueKey)
+		}
+		result := "triggered"
+		if !triggered {
+			result = "ignored"
+		}
+		webhooksReceived.With(event, result).Inc()
+		logger.Debug().Str("job", payload.Job.ID).Str("queue", queueKey).Bool("triggered", triggered).Msg("Received job.scheduled webhook")
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// webhookQueue returns the queue a job's agent query rules target, or "" for
+// the cluster's default queue.
+func webhookQueue(rules []string) string {
+	for _, rule := range rules {
+		if queueKey, ok := strings.CutPrefix(rule, "queue="); ok {
+			return queueKey
+		}
+	}
+	return ""
+}
+
+// verifyWebhook checks a delivery carries token, either as X-Buildkite-Token
+// or as the HMAC key of a recent X-Buildkite-Signature over body.
+func verifyWebhook(r *http.Request, body []byte, token string, now time.Time) error {
+	if presented := r.Header.Get("X-Buildkite-Token"); presented != "" {
+		if subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+			return errors.New("wrong X-Buildkite-Token")
+		}
+		return nil
+	}
+
+	header := r.Header.Get("X-Buildkite-Signature")
+	if header == "" {
+		return errors.New("no X-Buildkite-Token or X-Buildkite-Signature")
+	}
+	var timestamp, signature string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "timestamp":
+			timestamp = value
+		case "signature":
+			signature = value
+		}
+	}
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("malformed X-Buildkite-Signature")
+	}
+	if age := now.Sub(time.Unix(sent, 0)); age > webhookSignatureTolerance || age < -webhookSignatureTolerance {
+		return errors.New("X-Buildkite-Signature timestamp out of range")
+	}
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return errors.New("malformed X-Buildkite-Signature")
+	}
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return errors.New("wrong X-Buildkite-Signature")
+	}
+	return nil
+}
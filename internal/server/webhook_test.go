@@ -0,0 +1,50 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func sign(token, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyWebhook(t *testing.T) {
+	now := time.Now()
+	body := []byte(`{"event":"job.scheduled"}`)
+	ts := strconv.FormatInt(now.Unix(), 10)
+	stale := strconv.FormatInt(now.Add(-10*time.Minute).Unix(), 10)
+
+	for _, tc := range []struct {
+		name    string
+		headers map[string]string
+		ok      bool
+	}{
+		{"token", map[string]string{"X-Buildkite-Token": "secret"}, true},
+		{"wrong token", map[string]string{"X-Buildkite-Token": "guess"}, false},
+		{"signature", map[string]string{"X-Buildkite-Signature": "timestamp=" + ts + ",signature=" + sign("secret", ts, body)}, true},
+		{"signature with spaces", map[string]string{"X-Buildkite-Signature": "timestamp=" + ts + ", signature=" + sign("secret", ts, body)}, true},
+		{"wrong key", map[string]string{"X-Buildkite-Signature": "timestamp=" + ts + ",signature=" + sign("guess", ts, body)}, false},
+		{"other body", map[string]string{"X-Buildkite-Signature": "timestamp=" + ts + ",signature=" + sign("secret", ts, []byte(`{}`))}, false},
+		{"stale", map[string]string{"X-Buildkite-Signature": "timestamp=" + stale + ",signature=" + sign("secret", stale, body)}, false},
+		{"malformed timestamp", map[string]string{"X-Buildkite-Signature": "timestamp=soon,signature=" + sign("secret", "soon", body)}, false},
+		{"malformed signature", map[string]string{"X-Buildkite-Signature": "timestamp=" + ts + ",signature=zz"}, false},
+		{"unsigned", nil, false},
+	} {
+		r, _ := http.NewRequest(http.MethodPost, webhookPath, nil)
+		for key, value := range tc.headers {
+			r.Header.Set(key, value)
+		}
+		if err := verifyWebhook(r, body, "secret", now); (err == nil) != tc.ok {
+			t.Errorf("%s: got %v, want ok %v", tc.name, err, tc.ok)
+		}
+	}
+}
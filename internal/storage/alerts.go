@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func alertKey(key string) string {
+	return fmt.Sprintf("alert:%s", key)
+}
+
+// raiseAlertScript records that the alert KEYS[1] is firing as of ARGV[1]
+// (milliseconds), returning 1 if it wasn't already, or if it was last sent at
+// least ARGV[2] milliseconds ago (0 to never resend). If ARGV[3] isn't 0 the
+// record expires after that many milliseconds.
+var raiseAlertScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local resend = tonumber(ARGV[2])
+local last = redis.call('GET', KEYS[1])
+if last and (resend == 0 or now - tonumber(last) < resend) then
+	return 0
+end
+if tonumber(ARGV[3]) > 0 then
+	redis.call('SET', KEYS[1], now, 'PX', ARGV[3])
+else
+	redis.call('SET', KEYS[1], now)
+end
+return 1
+`)
+
+// RaiseAlert records that the alert key is firing and reports whether the
+// caller should send it: only one of the servers sharing this Redis is told
+// to, the first time and then every resend (0 for never) while it keeps
+// firing. A non-zero ttl forgets the alert after that long, for one-off
+// alerts that never resolve.
+func (s *RedisStore) RaiseAlert(ctx context.Context, key string, resend, ttl time.Duration) (bool, error) {
+	raised, err := raiseAlertScript.Run(ctx, s.client, []string{alertKey(key)},
+		time.Now().UnixMilli(), resend.Milliseconds(), ttl.Milliseconds(),
+	).Int()
+	if err != nil {
+		return false, fmt.Errorf("raising alert: %w", err)
+	}
+	return raised == 1, nil
+}
+
+// ResolveAlert records that the alert key has stopped firing, reporting
+// whether the caller should send the resolution: that is, whether it was
+// firing and no other server has already resolved it.
+func (s *RedisStore) ResolveAlert(ctx context.Context, key string) (bool, error) {
+	deleted, err := s.client.Del(ctx, alertKey(key)).Result()
+	if err != nil {
+		return false, fmt.Errorf("resolving alert: %w", err)
+	}
+	return deleted == 1, nil
+}
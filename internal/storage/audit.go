@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+	"github.com/redis/go-redis/v9"
+)
+
+const auditLogKey = "audit_log"
+
+// auditLogMaxLen is roughly how many entries the audit log keeps; older ones
+// are trimmed as new ones are added.
+const auditLogMaxLen = 100000
+
+// maxAuditScan caps how many entries one ListAudit call reads, so a filter
+// matching little doesn't walk the whole log in one request.
+const maxAuditScan = 5000
+
+// AuditFilter narrows ListAudit to matching entries. Zero fields match
+// everything.
+type AuditFilter struct {
+	Actor  string
+	Action string
+	Target string
+}
+
+func (f AuditFilter) matches(entry *types.AuditEntry) bool {
+	return (f.Actor == "" || entry.Actor == f.Actor) &&
+		(f.Action == "" || entry.Action == f.Action) &&
+		(f.Target == "" || entry.Target == f.Target)
+}
+
+// AppendAudit adds an entry to the audit log, filling in its ID.
+func (s *RedisStore) AppendAudit(ctx context.Context, entry *types.AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("marshaling audit entry: %w", err)
+	}
+	id, err := s.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: auditLogKey,
+		MaxLen: auditLogMaxLen,
+		Approx: true,
+		Values: []any{"entry", data},
+	}).Result()
+	if err != nil {
+		return fmt.Errorf("appending audit entry: %w", err)
+	}
+	entry.ID = id
+	return nil
+}
+
+// ListAudit returns a page of the audit log entries matching filter, newest
+// first. Start with an empty cursor and pass back the returned cursor for the
+// next page; it is empty after the last. Pages may be short before the last
+// if few entries match.
+func (s *RedisStore) ListAudit(ctx context.Context, filter AuditFilter, cursor string, limit int) ([]types.AuditEntry, string, error) {
+	entries := []types.AuditEntry{}
+	end := "+"
+	if cursor != "" {
+		end = "(" + cursor
+	}
+	for scanned := 0; scanned < maxAuditScan; {
+		messages, err := s.client.XRevRangeN(ctx, auditLogKey, end, "-", int64(limit)).Result()
+		if err != nil {
+			return nil, "", fmt.Errorf("reading audit log: %w", err)
+		}
+		for _, message := range messages {
+			scanned++
+			end = "(" + message.ID
+			data, _ := message.Values["entry"].(string)
+			var entry types.AuditEntry
+			if err := json.Unmarshal([]byte(data), &entry); err != nil {
+				continue
+			}
+			entry.ID = message.ID
+			if !filter.matches(&entry) {
+				continue
+			}
+			entries = append(entries, entry)
+			if len(entries) == limit {
+				return entries, message.ID, nil
+			}
+		}
+		if len(messages) < limit {
+			return entries, "", nil
+		}
+	}
+	return entries, end[1:], nil
+}
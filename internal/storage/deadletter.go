@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+	"github.com/redis/go-redis/v9"
+)
+
+const deadLetterKey = "dead_letter"
+
+func failuresKey(uuid string) string {
+	return fmt.Sprintf("job_failures:%s", uuid)
+}
+
+// FailJob records that a worker failed to run a claimed job, returning the
+// job's new status. Once it has failed maxAttempts times (0 for no limit) it
+// moves to the dead-letter queue ("dead"). Otherwise a job that hadn't started
+// is requeued for another attempt ("reserved"), and one that had is finished
+// ("failed"), as its agent may already hold it in Buildkite and running it
+// again would run it twice. workerID must match the worker that claimed the
+// job, if the claim recorded one, and claimToken must be the token handed out
+// with it. Retryable failures, such as the worker's instance going away, are
+// recorded but don't count towards maxAttempts.
+func (s *RedisStore) FailJob(ctx context.Context, uuid, workerID, claimToken, reason string, retryable bool, maxAttempts int) (string, error) {
+	failure, err := json.Marshal(types.JobFailure{
+		Error:    reason,
+		WorkerID: workerID,
+		FailedAt: time.Now(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("marshaling failure: %w", err)
+	}
+
+	result, err := failJobScript.Run(ctx, s.client,
+		[]string{fmt.Sprintf("job:%s", uuid), claimLeasesKey, deadLetterKey, trackedJobsKey, failuresKey(uuid), reservationsKey},
+		uuid, time.Now().UnixMilli(), failure, maxAttempts, claimToken, workerID, retryable, time.Now().Format(time.RFC3339),
+	).Int()
+	if err != nil {
+		return "", fmt.Errorf("failing job: %w", err)
+	}
+
+	switch result {
+	case -4:
+		return "", ErrJobNotOwned
+	case -3:
+		return "", ErrClaimTokenMismatch
+	case -2:
+		return "", ErrJobNotFound
+	case -1:
+		return "", ErrJobNotClaimed
+	}
+
+	status := "reserved"
+	switch result {
+	case 1:
+		status = "dead"
+	case 2:
+		status = "failed"
+	}
+	s.publishJobEvent(ctx, types.JobEvent{Type: types.EventFailed, JobUUID: uuid, WorkerID: workerID, Status: status, Error: reason, DeadLettered: result == 1})
+	// Either the job is back in its pending set or its quotas are free.
+	s.notifyJobsAvailable(ctx)
+	return status, nil
+}
+
+// ListDeadLetter returns a page of the jobs in the dead-letter queue, oldest
+// first, starting at offset, along with the offset of the next page, or 0 if
+// this is the last.
+func (s *RedisStore) ListDeadLetter(ctx context.Context, offset, limit int) ([]types.DeadLetterJob, int, error) {
+	// Fetch one extra to tell whether there's another page.
+	entries, err := s.client.ZRangeWithScores(ctx, deadLetterKey, int64(offset), int64(offset+limit)).Result()
+	if err != nil {
+		return nil, 0, fmt.Errorf("listing dead-letter jobs: %w", err)
+	}
+	next := 0
+	if len(entries) > limit {
+		entries = entries[:limit]
+		next = offset + limit
+	}
+
+	jobs := make([]types.DeadLetterJob, 0, len(entries))
+	for _, entry := range entries {
+		uuid := entry.Member.(string)
+		fields, err := s.client.HMGet(ctx, fmt.Sprintf("job:%s", uuid), "data", "attempts").Result()
+		if err != nil {
+			return nil, 0, fmt.Errorf("getting job %s: %w", uuid, err)
+		}
+		data, _ := fields[0].(string)
+		if data == "" {
+			continue
+		}
+
+		var job types.Job
+		if err := json.Unmarshal([]byte(data), &job); err != nil {
+			return nil, 0, fmt.Errorf("unmarshaling job %s: %w", uuid, err)
+		}
+		attemptsStr, _ := fields[1].(string)
+		attempts, _ := strconv.Atoi(attemptsStr)
+
+		failures, err := s.jobFailures(ctx, uuid)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		jobs = append(jobs, types.DeadLetterJob{
+			Job:      &job,
+			Attempts: attempts,
+			DeadAt:   time.UnixMilli(int64(entry.Score)),
+			Failures: failures,
+		})
+	}
+	return jobs, next, nil
+}
+
+func (s *RedisStore) jobFailures(ctx context.Context, uuid string) ([]types.JobFailure, error) {
+	raw, err := s.client.LRange(ctx, failuresKey(uuid), 0, -1).Result()
+	if err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("getting failures for %s: %w", uuid, err)
+	}
+
+	failures := make([]types.JobFailure, 0, len(raw))
+	for _, item := range raw {
+		var failure types.JobFailure
+		if err := json.Unmarshal([]byte(item), &failure); err != nil {
+			return nil, fmt.Errorf("unmarshaling failure for %s: %w", uuid, err)
+		}
+		failures = append(failures, failure)
+	}
+	return failures, nil
+}
+
+// RequeueDeadLetter moves a job out of the dead-letter queue and back into its
+// pending set with a fresh attempt count. Its failure history is kept.
+func (s *RedisStore) RequeueDeadLetter(ctx context.Context, uuid string) error {
+	expiresAt := time.Now().Add(1 * time.Hour).Unix()
+	requeued, err := requeueDeadLetterScript.Run(ctx, s.client,
+		[]string{deadLetterKey, fmt.Sprintf("job:%s", uuid), trackedJobsKey, failuresKey(uuid)},
+		uuid, expiresAt,
+	).Int()
+	if err != nil {
+		return fmt.Errorf("requeueing dead-letter job: %w", err)
+	}
+	if requeued == 0 {
+		return ErrJobNotFound
+	}
+	s.publishJobEvent(ctx, types.JobEvent{Type: types.EventRequeued, JobUUID: uuid, Status: "reserved"})
+	s.notifyJobsAvailable(ctx)
+	return nil
+}
+
+// GetDeadLetterCount returns the number of jobs in the dead-letter queue.
+func (s *RedisStore) GetDeadLetterCount(ctx context.Context) (int64, error) {
+	return s.client.ZCard(ctx, deadLetterKey).Result()
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+	"github.com/redis/go-redis/v9"
+)
+
+func queueEnvKey(queueKey string) string {
+	return fmt.Sprintf("queue_env:%s", queueKey)
+}
+
+// SetQueueEnv replaces the environment variables attached to every job from a
+// Buildkite queue. An empty env clears them.
+func (s *RedisStore) SetQueueEnv(ctx context.Context, queueKey string, env map[string]string) error {
+	key := queueEnvKey(queueKey)
+	pipe := s.client.TxPipeline()
+	pipe.Del(ctx, key)
+	if len(env) > 0 {
+		pipe.HSet(ctx, key, env)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("setting queue env: %w", err)
+	}
+	return nil
+}
+
+// GetQueueEnv returns the environment variables attached to a Buildkite
+// queue's jobs.
+func (s *RedisStore) GetQueueEnv(ctx context.Context, queueKey string) (map[string]string, error) {
+	env, err := s.client.HGetAll(ctx, queueEnvKey(queueKey)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("getting queue env: %w", err)
+	}
+	return env, nil
+}
+
+// SetJobEnv replaces the environment variables attached to a single job, on
+// top of its queue's. Only jobs that haven't been claimed yet can be changed;
+// others return ErrJobNotPending.
+func (s *RedisStore) SetJobEnv(ctx context.Context, uuid string, env map[string]string) error {
+	metaKey := fmt.Sprintf("job:%s", uuid)
+	status, err := s.client.HGet(ctx, metaKey, "status").Result()
+	if err == redis.Nil {
+		return ErrJobNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("getting job status: %w", err)
+	}
+	if status != "reserved" && status != "delayed" {
+		return ErrJobNotPending
+	}
+
+	if len(env) == 0 {
+		err = s.client.HDel(ctx, metaKey, "env").Err()
+	} else {
+		var data []byte
+		data, err = json.Marshal(env)
+		if err != nil {
+			return fmt.Errorf("marshaling env: %w", err)
+		}
+		err = s.client.HSet(ctx, metaKey, "env", data).Err()
+	}
+	if err != nil {
+		return fmt.Errorf("setting job env: %w", err)
+	}
+	return nil
+}
+
+// attachEnv fills in a claimed job's environment from its queue's and its own,
+// with the job's taking precedence.
+func (s *RedisStore) attachEnv(ctx context.Context, job *types.Job) error {
+	queueEnv, err := s.GetQueueEnv(ctx, job.QueueKey)
+	if err != nil {
+		return err
+	}
+	jobEnv, err := s.client.HGet(ctx, fmt.Sprintf("job:%s", job.UUID), "env").Result()
+	if err != nil && err != redis.Nil {
+		return fmt.Errorf("getting job env: %w", err)
+	}
+
+	env := queueEnv
+	if jobEnv != "" {
+		if err := json.Unmarshal([]byte(jobEnv), &env); err != nil {
+			return fmt.Errorf("unmarshaling job env: %w", err)
+		}
+	}
+	if len(env) > 0 {
+		job.Env = env
+	}
+	return nil
+}
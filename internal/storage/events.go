@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+	"github.com/redis/go-redis/v9"
+)
+
+const jobEventsChannel = "job_events"
+
+// jobEventBuffer is how many events a subscriber can fall behind by before
+// events are dropped.
+const jobEventBuffer = 256
+
+// eventStreamKey holds the settings of the event stream, so that every
+// process using this Redis, the CLI included, appends to it.
+const eventStreamKey = "event_stream"
+
+// eventStreamSchema is the version of the stream entries' layout, bumped if
+// it changes incompatibly.
+const eventStreamSchema = "1"
+
+// eventStreamRefresh is how often the event stream settings are read again,
+// to pick up changes made by other servers.
+const eventStreamRefresh = 30 * time.Second
+
+// eventStream is a Redis stream every job event is appended to.
+type eventStream struct {
+	name   string
+	maxLen int64
+}
+
+// publishJobEvent announces a job lifecycle event to every subscriber, and
+// appends it to the event stream if there is one. Like job notifications,
+// events are best-effort and errors are ignored.
+func (s *RedisStore) publishJobEvent(ctx context.Context, event types.JobEvent) {
+	event.Time = time.Now()
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	s.client.Publish(ctx, jobEventsChannel, data)
+
+	if time.Since(time.Unix(0, s.eventStreamLoaded.Load())) > eventStreamRefresh {
+		_ = s.loadEventStream(ctx)
+	}
+	if stream := s.eventStream.Load(); stream != nil {
+		s.client.XAdd(ctx, &redis.XAddArgs{
+			Stream: stream.name,
+			MaxLen: stream.maxLen,
+			Approx: true,
+			Values: []any{"schema", eventStreamSchema, "type", event.Type, "event", data},
+		})
+	}
+}
+
+// SetEventStream appends every job event, from this and every other process
+// using this Redis, to the Redis stream name, trimmed to about maxLen entries
+// (0 for no limit). An empty name stops appending them.
+func (s *RedisStore) SetEventStream(ctx context.Context, name string, maxLen int64) error {
+	if name == "" {
+		if err := s.client.Del(ctx, eventStreamKey).Err(); err != nil {
+			return fmt.Errorf("clearing event stream: %w", err)
+		}
+		s.eventStream.Store(nil)
+		s.eventStreamLoaded.Store(time.Now().UnixNano())
+		return nil
+	}
+	if err := s.client.HSet(ctx, eventStreamKey, "name", name, "max_len", maxLen).Err(); err != nil {
+		return fmt.Errorf("setting event stream: %w", err)
+	}
+	s.eventStream.Store(&eventStream{name: name, maxLen: maxLen})
+	s.eventStreamLoaded.Store(time.Now().UnixNano())
+	return nil
+}
+
+// loadEventStream picks up the event stream a server set, or its removal.
+func (s *RedisStore) loadEventStream(ctx context.Context) error {
+	fields, err := s.client.HMGet(ctx, eventStreamKey, "name", "max_len").Result()
+	if err != nil {
+		return fmt.Errorf("getting event stream: %w", err)
+	}
+	s.eventStreamLoaded.Store(time.Now().UnixNano())
+	name, _ := fields[0].(string)
+	if name == "" {
+		s.eventStream.Store(nil)
+		return nil
+	}
+	maxLen, _ := fields[1].(string)
+	stream := &eventStream{name: name}
+	stream.maxLen, _ = strconv.ParseInt(maxLen, 10, 64)
+	s.eventStream.Store(stream)
+	return nil
+}
+
+// SubscribeJobEvents returns a channel of job lifecycle events published by
+// every server using this Redis. Events are dropped if the channel isn't read
+// quickly enough. The channel is closed once ctx is done.
+func (s *RedisStore) SubscribeJobEvents(ctx context.Context) <-chan types.JobEvent {
+	pubsub := s.client.Subscribe(ctx, jobEventsChannel)
+	events := make(chan types.JobEvent, jobEventBuffer)
+
+	go func() {
+		defer close(events)
+		defer pubsub.Close()
+
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case message, ok := <-messages:
+				if !ok {
+					return
+				}
+				var event types.JobEvent
+				if err := json.Unmarshal([]byte(message.Payload), &event); err != nil {
+					continue
+				}
+				select {
+				case events <- event:
+				default:
+				}
+			}
+		}
+	}()
+
+	return events
+}
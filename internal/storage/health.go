@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+)
+
+const unhealthyWorkersKey = "unhealthy_workers"
+
+// SetWorkerHealth records whether a worker's host passed its preflight checks.
+// An unhealthy worker has stopped claiming jobs, so it isn't counted as
+// capacity until it reports that it has recovered. reason is ignored for
+// healthy workers.
+func (s *RedisStore) SetWorkerHealth(ctx context.Context, workerID string, healthy bool, reason string) error {
+	pipe := s.client.TxPipeline()
+	if healthy {
+		pipe.HDel(ctx, unhealthyWorkersKey, workerID)
+		pipe.HSet(ctx, workerKey(workerID), "health", "healthy")
+		pipe.HDel(ctx, workerKey(workerID), "health_reason")
+	} else {
+		pipe.HSet(ctx, unhealthyWorkersKey, workerID, reason)
+		pipe.HSet(ctx, workerKey(workerID), "health", "unhealthy", "health_reason", reason)
+	}
+	pipe.Expire(ctx, workerKey(workerID), workerRegistryTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("setting worker health: %w", err)
+	}
+	return nil
+}
+
+// UnhealthyWorkers returns the workers that have reported failing preflight
+// checks, with the reasons they gave.
+func (s *RedisStore) UnhealthyWorkers(ctx context.Context) (map[string]string, error) {
+	workers, err := s.client.HGetAll(ctx, unhealthyWorkersKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("listing unhealthy workers: %w", err)
+	}
+	return workers, nil
+}
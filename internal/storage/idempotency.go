@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// idempotencyTTL is how long a response is kept for replay, which bounds
+	// how late a retry can be and still be recognised.
+	idempotencyTTL = 24 * time.Hour
+	// idempotencyLockTTL is how long an idempotency key stays locked by a
+	// request that never finishes, e.g. because its server died.
+	idempotencyLockTTL = time.Minute
+)
+
+var (
+	ErrIdempotencyKeyInUse  = fmt.Errorf("idempotency key is in use by another request")
+	ErrIdempotencyKeyReused = fmt.Errorf("idempotency key was used for a different request")
+)
+
+// IdempotentResponse is the recorded reply to a request made with an
+// idempotency key, replayed to retries of the request.
+type IdempotentResponse struct {
+	// Request identifies the request the key was first used for.
+	Request     string `json:"request"`
+	Status      int    `json:"status,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+}
+
+func idempotencyKey(key string) string {
+	return fmt.Sprintf("idempotency:%s", key)
+}
+
+// BeginIdempotentRequest locks key for request. If key was used before for
+// the same request, it returns the response to replay instead. If that
+// request is still being handled it returns ErrIdempotencyKeyInUse, and if
+// the key was used for a different request, ErrIdempotencyKeyReused.
+func (s *RedisStore) BeginIdempotentRequest(ctx context.Context, key, request string) (*IdempotentResponse, error) {
+	lock, err := json.Marshal(IdempotentResponse{Request: request})
+	if err != nil {
+		return nil, fmt.Errorf("marshaling idempotency lock: %w", err)
+	}
+	ok, err := s.client.SetNX(ctx, idempotencyKey(key), lock, idempotencyLockTTL).Result()
+	if err != nil {
+		return nil, fmt.Errorf("locking idempotency key: %w", err)
+	}
+	if ok {
+		return nil, nil
+	}
+
+	data, err := s.client.Get(ctx, idempotencyKey(key)).Bytes()
+	if err == redis.Nil {
+		// The lock expired in between; treat it as still in use so the
+		// client retries rather than racing another request.
+		return nil, ErrIdempotencyKeyInUse
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting idempotent response: %w", err)
+	}
+	var resp IdempotentResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("unmarshaling idempotent response: %w", err)
+	}
+	if resp.Request != request {
+		return nil, ErrIdempotencyKeyReused
+	}
+	if resp.Status == 0 {
+		return nil, ErrIdempotencyKeyInUse
+	}
+	return &resp, nil
+}
+
+// FinishIdempotentRequest records the response to replay for key.
+func (s *RedisStore) FinishIdempotentRequest(ctx context.Context, key string, resp IdempotentResponse) error {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return fmt.Errorf("marshaling idempotent response: %w", err)
+	}
+	if err := s.client.Set(ctx, idempotencyKey(key), data, idempotencyTTL).Err(); err != nil {
+		return fmt.Errorf("setting idempotent response: %w", err)
+	}
+	return nil
+}
+
+// CancelIdempotentRequest unlocks key without recording a response, so the
+// request can be retried for real.
+func (s *RedisStore) CancelIdempotentRequest(ctx context.Context, key string) error {
+	if err := s.client.Del(ctx, idempotencyKey(key)).Err(); err != nil {
+		return fmt.Errorf("unlocking idempotency key: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+	"github.com/redis/go-redis/v9"
+)
+
+// GetJobDetail assembles what is known about a job from its metadata, or
+// returns ErrJobNotFound if it isn't tracked. The job's env is filled in as a
+// worker claiming it would see it.
+func (s *RedisStore) GetJobDetail(ctx context.Context, uuid string) (*types.JobDetail, error) {
+	fields, err := s.client.HMGet(ctx, fmt.Sprintf("job:%s", uuid),
+		"data", "status", "claimed_by", "attempts", "claimed_at", "started_at", "completed_at").Result()
+	if err != nil {
+		return nil, fmt.Errorf("getting job %s: %w", uuid, err)
+	}
+	data, _ := fields[0].(string)
+	if data == "" {
+		return nil, ErrJobNotFound
+	}
+
+	var job types.Job
+	if err := json.Unmarshal([]byte(data), &job); err != nil {
+		return nil, fmt.Errorf("unmarshaling job %s: %w", uuid, err)
+	}
+	if err := s.attachEnv(ctx, &job); err != nil {
+		return nil, err
+	}
+
+	detail := &types.JobDetail{Job: &job}
+	detail.Status, _ = fields[1].(string)
+	detail.ClaimedBy, _ = fields[2].(string)
+	attempts, _ := fields[3].(string)
+	detail.Attempts, _ = strconv.Atoi(attempts)
+	detail.ClaimedAt = parseTimeField(fields[4])
+	detail.StartedAt = parseTimeField(fields[5])
+	detail.CompletedAt = parseTimeField(fields[6])
+
+	lease, err := s.client.ZScore(ctx, claimLeasesKey, uuid).Result()
+	if err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("getting claim lease: %w", err)
+	}
+	if err == nil {
+		detail.LeaseExpiresAt = time.UnixMilli(int64(lease))
+	}
+	reservation, err := s.client.ZScore(ctx, reservationsKey, uuid).Result()
+	if err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("getting reservation expiry: %w", err)
+	}
+	if err == nil {
+		detail.ReservationExpiresAt = time.Unix(int64(reservation), 0)
+	}
+
+	if detail.Failures, err = s.jobFailures(ctx, uuid); err != nil {
+		return nil, err
+	}
+	return detail, nil
+}
+
+// parseTimeField parses an RFC 3339 timestamp from a metadata field, returning
+// the zero time if it is missing or malformed.
+func parseTimeField(field any) time.Time {
+	value, _ := field.(string)
+	t, _ := time.Parse(time.RFC3339, value)
+	return t
+}
@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+	"github.com/redis/go-redis/v9"
+)
+
+// maxListScans caps how many batches one ListJobs call scans, so a filter
+// matching little doesn't walk the whole keyspace in one request.
+const maxListScans = 50
+
+// JobFilter narrows ListJobs to matching jobs. Zero fields match everything.
+type JobFilter struct {
+	Status   string
+	QueueKey string
+	// WorkerID matches jobs claimed by the worker.
+	WorkerID string
+	// MinAge and MaxAge bound how long ago jobs were reserved.
+	MinAge time.Duration
+	MaxAge time.Duration
+}
+
+func (f JobFilter) matches(job *types.Job, status, queueKey, claimedBy string, now time.Time) bool {
+	if f.Status != "" && status != f.Status {
+		return false
+	}
+	if f.QueueKey != "" && queueKey != f.QueueKey {
+		return false
+	}
+	if f.WorkerID != "" && claimedBy != f.WorkerID {
+		return false
+	}
+	age := now.Sub(job.ReservedAt)
+	if f.MinAge > 0 && age < f.MinAge {
+		return false
+	}
+	if f.MaxAge > 0 && age > f.MaxAge {
+		return false
+	}
+	return true
+}
+
+// ListJobs returns a page of the jobs the scheduler has metadata for that
+// match filter, walking the keyspace a batch at a time rather than loading
+// every job. Start with cursor 0 and pass back the returned cursor for the
+// next page; it is 0 after the last. Pages hold about limit jobs, but may be
+// short or even empty before the last if few jobs match. A job changing while
+// it is listed may be missed or listed twice.
+func (s *RedisStore) ListJobs(ctx context.Context, filter JobFilter, cursor uint64, limit int) ([]types.JobSummary, uint64, error) {
+	jobs := []types.JobSummary{}
+	now := time.Now()
+	for scans := 1; ; scans++ {
+		keys, next, err := s.client.Scan(ctx, cursor, "job:*", int64(limit)).Result()
+		if err != nil {
+			return nil, 0, fmt.Errorf("scanning jobs: %w", err)
+		}
+		cursor = next
+
+		pipe := s.client.Pipeline()
+		cmds := make([]*redis.SliceCmd, len(keys))
+		for i, key := range keys {
+			cmds[i] = pipe.HMGet(ctx, key, "data", "status", "queue_key", "claimed_by", "attempts", "claimed_at", "started_at", "completed_at")
+		}
+		if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+			return nil, 0, fmt.Errorf("getting jobs: %w", err)
+		}
+
+		for i, cmd := range cmds {
+			fields := cmd.Val()
+			data, _ := fields[0].(string)
+			if data == "" {
+				// Expired or removed since the scan.
+				continue
+			}
+			var job types.Job
+			if err := json.Unmarshal([]byte(data), &job); err != nil {
+				return nil, 0, fmt.Errorf("unmarshaling job %s: %w", strings.TrimPrefix(keys[i], "job:"), err)
+			}
+			status, _ := fields[1].(string)
+			queueKey, _ := fields[2].(string)
+			claimedBy, _ := fields[3].(string)
+			if !filter.matches(&job, status, queueKey, claimedBy, now) {
+				continue
+			}
+			attempts, _ := fields[4].(string)
+			summary := types.JobSummary{
+				Job:         &job,
+				Status:      status,
+				ClaimedBy:   claimedBy,
+				ClaimedAt:   parseTimeField(fields[5]),
+				StartedAt:   parseTimeField(fields[6]),
+				CompletedAt: parseTimeField(fields[7]),
+			}
+			summary.Attempts, _ = strconv.Atoi(attempts)
+			jobs = append(jobs, summary)
+		}
+
+		if cursor == 0 || len(jobs) >= limit || scans == maxListScans {
+			return jobs, cursor, nil
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// jobLogTTL is how long uploaded job output is kept.
+const jobLogTTL = 7 * 24 * time.Hour
+
+func jobLogKey(uuid string) string {
+	return fmt.Sprintf("job_log:%s", uuid)
+}
+
+// SetJobLog stores a job's output as uploaded by the worker that ran it,
+// replacing any earlier upload.
+func (s *RedisStore) SetJobLog(ctx context.Context, uuid string, output []byte) error {
+	if err := s.client.Set(ctx, jobLogKey(uuid), output, jobLogTTL).Err(); err != nil {
+		return fmt.Errorf("setting job log: %w", err)
+	}
+	return nil
+}
+
+// GetJobLog returns a job's uploaded output, or ErrJobNotFound if there is
+// none.
+func (s *RedisStore) GetJobLog(ctx context.Context, uuid string) ([]byte, error) {
+	output, err := s.client.Get(ctx, jobLogKey(uuid)).Bytes()
+	if err == redis.Nil {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting job log: %w", err)
+	}
+	return output, nil
+}
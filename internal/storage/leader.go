@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// leaderKey holds the ID of the server that currently leads, expiring unless
+// the leader renews it.
+const leaderKey = "leader"
+
+// acquireLeaderScript takes the lead for ARGV[1] if no one holds it, or
+// renews it if ARGV[1] already does, for ARGV[2] milliseconds.
+var acquireLeaderScript = redis.NewScript(`
+local holder = redis.call('GET', KEYS[1])
+if holder == ARGV[1] then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	return 1
+end
+if holder then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+return 1
+`)
+
+// resignLeaderScript gives up the lead if ARGV[1] holds it.
+var resignLeaderScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// AcquireLeadership makes id the leader for ttl if there is none, or extends
+// its lead if it already is. It reports whether id leads.
+func (s *RedisStore) AcquireLeadership(ctx context.Context, id string, ttl time.Duration) (bool, error) {
+	leads, err := acquireLeaderScript.Run(ctx, s.client, []string{leaderKey}, id, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, fmt.Errorf("acquiring leadership: %w", err)
+	}
+	return leads == 1, nil
+}
+
+// ResignLeadership gives up the lead if id holds it, so another server can
+// take over without waiting for it to expire.
+func (s *RedisStore) ResignLeadership(ctx context.Context, id string) error {
+	if err := resignLeaderScript.Run(ctx, s.client, []string{leaderKey}, id).Err(); err != nil {
+		return fmt.Errorf("resigning leadership: %w", err)
+	}
+	return nil
+}
+
+// Leader returns the ID of the current leader, or "" if there is none.
+func (s *RedisStore) Leader(ctx context.Context) (string, error) {
+	id, err := s.client.Get(ctx, leaderKey).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("getting leader: %w", err)
+	}
+	return id, nil
+}
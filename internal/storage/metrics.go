@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/metrics"
+	"github.com/redis/go-redis/v9"
+)
+
+var redisErrors = metrics.Default.NewCounterVec("scheduler_redis_errors_total",
+	"Redis commands that failed, by command.", "command")
+
+// metricsHook counts failed Redis commands. Missing keys, cancelled requests
+// and scripts that need loading before they can run aren't failures.
+type metricsHook struct{}
+
+func (metricsHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := next(ctx, network, addr)
+		if err != nil && ctx.Err() == nil {
+			redisErrors.With("dial").Inc()
+		}
+		return conn, err
+	}
+}
+
+func (metricsHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		err := next(ctx, cmd)
+		countRedisError(ctx, cmd, err)
+		return err
+	}
+}
+
+func (metricsHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		err := next(ctx, cmds)
+		for _, cmd := range cmds {
+			countRedisError(ctx, cmd, cmd.Err())
+		}
+		return err
+	}
+}
+
+func countRedisError(ctx context.Context, cmd redis.Cmder, err error) {
+	if err == nil || errors.Is(err, redis.Nil) || ctx.Err() != nil || strings.HasPrefix(err.Error(), "NOSCRIPT") {
+		return
+	}
+	redisErrors.With(cmd.Name()).Inc()
+}
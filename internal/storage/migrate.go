@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+	"github.com/redis/go-redis/v9"
+)
+
+// legacyPendingJobsPrefix starts the keys pending jobs were kept under before
+// pendingJobsPrefix: lists in the oldest releases, then sorted sets once
+// priority dispatch came in.
+const legacyPendingJobsPrefix = "jobs:"
+
+// MigratePendingSets moves pending jobs left under legacy keys by older
+// releases into their pending_jobs: sorted sets, scoring jobs from the old
+// lists by the store's dispatch order. It returns how many keys it migrated.
+// Run it before dispatching, with every server upgraded, as older servers go
+// on writing the legacy keys.
+func (s *RedisStore) MigratePendingSets(ctx context.Context) (int, error) {
+	var keys []string
+	iter := s.client.Scan(ctx, 0, legacyPendingJobsPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return 0, fmt.Errorf("scanning legacy pending jobs: %w", err)
+	}
+
+	migrated := 0
+	for _, key := range keys {
+		dest := pendingJobsKey(strings.TrimPrefix(key, legacyPendingJobsPrefix))
+		kind, err := s.client.Type(ctx, key).Result()
+		if err != nil {
+			return migrated, fmt.Errorf("migrating %s: %w", key, err)
+		}
+		switch kind {
+		case "zset":
+			_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.ZUnionStore(ctx, dest, &redis.ZStore{Keys: []string{dest, key}, Aggregate: "MIN"})
+				pipe.Del(ctx, key)
+				return nil
+			})
+		case "list":
+			err = s.migratePendingList(ctx, key, dest)
+		default:
+			continue
+		}
+		if err != nil {
+			return migrated, fmt.Errorf("migrating %s: %w", key, err)
+		}
+		migrated++
+	}
+	return migrated, nil
+}
+
+// migratePendingList moves the still-reserved jobs in the list key into the
+// sorted set dest, recording their scores as the sorted sets' jobs have.
+// Lists kept entries for jobs already claimed, which are dropped.
+func (s *RedisStore) migratePendingList(ctx context.Context, key, dest string) error {
+	entries, err := s.client.LRange(ctx, key, 0, -1).Result()
+	if err != nil {
+		return err
+	}
+	var jobs []types.Job
+	var data []string
+	for _, entry := range entries {
+		var job types.Job
+		if err := json.Unmarshal([]byte(entry), &job); err != nil {
+			continue
+		}
+		status, err := s.client.HGet(ctx, fmt.Sprintf("job:%s", job.UUID), "status").Result()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		if status == "reserved" {
+			jobs = append(jobs, job)
+			data = append(data, entry)
+		}
+	}
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i := range jobs {
+			job := &jobs[i]
+			score := s.order.score(job)
+			pipe.ZAddNX(ctx, dest, redis.Z{Score: score, Member: data[i]})
+			pipe.HSetNX(ctx, fmt.Sprintf("job:%s", job.UUID), "score", score)
+		}
+		pipe.Del(ctx, key)
+		return nil
+	})
+	return err
+}
@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+)
+
+func TestMigratePendingSets(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	store, err := NewRedisStore(mr.Addr(), DispatchOrder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	encode := func(uuid string) string {
+		data, _ := json.Marshal(types.Job{UUID: uuid, QueueKey: "q", AgentQueryRules: []string{"queue=q"}, ReservedAt: time.Now()})
+		return string(data)
+	}
+	// The oldest releases kept lists, which held on to claimed jobs.
+	mr.RPush(legacyPendingJobsPrefix+"queue=q", encode("listed"), encode("claimed"))
+	mr.HSet("job:listed", "status", "reserved")
+	mr.HSet("job:claimed", "status", "claimed")
+	mr.ZAdd(legacyPendingJobsPrefix+"queue=z", 1, encode("sorted"))
+
+	migrated, err := store.MigratePendingSets(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrated != 2 {
+		t.Fatalf("migrated %d keys, want 2", migrated)
+	}
+	for _, key := range mr.Keys() {
+		if key == legacyPendingJobsPrefix+"queue=q" || key == legacyPendingJobsPrefix+"queue=z" {
+			t.Fatalf("left legacy key %s behind", key)
+		}
+	}
+
+	for rules, want := range map[string]string{"queue=q": "listed", "queue=z": "sorted"} {
+		members, err := mr.ZMembers(pendingJobsKey(rules))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var job types.Job
+		if len(members) != 1 || json.Unmarshal([]byte(members[0]), &job) != nil || job.UUID != want {
+			t.Fatalf("%s holds %v, want just %s", pendingJobsKey(rules), members, want)
+		}
+	}
+	if mr.HGet("job:listed", "score") == "" {
+		t.Fatal("didn't record the migrated job's score")
+	}
+
+	if migrated, err = store.MigratePendingSets(ctx); err != nil || migrated != 0 {
+		t.Fatalf("second run migrated %d keys, %v, want none", migrated, err)
+	}
+}
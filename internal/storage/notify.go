@@ -0,0 +1,44 @@
+package storage
+
+import "context"
+
+const jobsAvailableChannel = "jobs_available"
+
+// notifyJobsAvailable tells long-polling workers that jobs may have become
+// claimable. Errors are ignored: waiters also recheck on their own, so a lost
+// notification only delays a claim.
+func (s *RedisStore) notifyJobsAvailable(ctx context.Context) {
+	s.client.Publish(ctx, jobsAvailableChannel, "")
+}
+
+// SubscribeJobsAvailable returns a channel that receives a value whenever jobs
+// may have become claimable. The channel is closed once ctx is done.
+func (s *RedisStore) SubscribeJobsAvailable(ctx context.Context) <-chan struct{} {
+	pubsub := s.client.Subscribe(ctx, jobsAvailableChannel)
+	notifications := make(chan struct{}, 1)
+
+	go func() {
+		defer close(notifications)
+		defer pubsub.Close()
+
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case _, ok := <-messages:
+				if !ok {
+					return
+				}
+				// Notifications carry no data, so coalesce any that arrive
+				// before the last one is read.
+				select {
+				case notifications <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	return notifications
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+	"github.com/redis/go-redis/v9"
+)
+
+// Orphan is a job whose metadata says it is waiting or claimed, but which no
+// worker can pick up or is working on.
+type Orphan struct {
+	UUID   string `json:"uuid"`
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+// FindOrphans scans job metadata for reserved jobs missing from their pending
+// set and claimed jobs whose worker hasn't been seen since the given time and
+// whose claim has no lease for the reaper to expire.
+func (s *RedisStore) FindOrphans(ctx context.Context, workerSince time.Time) ([]Orphan, error) {
+	var orphans []Orphan
+	iter := s.client.Scan(ctx, 0, "job:*", 100).Iterator()
+	for iter.Next(ctx) {
+		metaKey := iter.Val()
+		uuid := strings.TrimPrefix(metaKey, "job:")
+
+		reason, status, err := s.orphanReason(ctx, metaKey, uuid, workerSince)
+		if err != nil {
+			return nil, err
+		}
+		if reason != "" {
+			orphans = append(orphans, Orphan{UUID: uuid, Status: status, Reason: reason})
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("scanning job metadata: %w", err)
+	}
+	return orphans, nil
+}
+
+func (s *RedisStore) orphanReason(ctx context.Context, metaKey, uuid string, workerSince time.Time) (string, string, error) {
+	fields, err := s.client.HMGet(ctx, metaKey, "status", "data", "query_rules", "claimed_by").Result()
+	if err != nil {
+		return "", "", fmt.Errorf("getting job %s: %w", uuid, err)
+	}
+	status, _ := fields[0].(string)
+	data, _ := fields[1].(string)
+	rules, _ := fields[2].(string)
+	workerID, _ := fields[3].(string)
+
+	switch status {
+	case "reserved":
+		if data == "" {
+			return "missing job data", status, nil
+		}
+		_, err := s.client.ZScore(ctx, pendingJobsKey(rules), data).Result()
+		if err == redis.Nil {
+			return "not in pending set", status, nil
+		}
+		if err != nil {
+			return "", "", fmt.Errorf("checking pending set for %s: %w", uuid, err)
+		}
+
+	case "claimed":
+		if _, err := s.client.ZScore(ctx, claimLeasesKey, uuid).Result(); err == nil {
+			return "", "", nil
+		} else if err != redis.Nil {
+			return "", "", fmt.Errorf("checking claim lease for %s: %w", uuid, err)
+		}
+		if workerID == "" {
+			return "claimed by unknown worker", status, nil
+		}
+		lastSeen, err := s.client.ZScore(ctx, activeWorkersKey, workerID).Result()
+		if err != nil && err != redis.Nil {
+			return "", "", fmt.Errorf("checking worker %s: %w", workerID, err)
+		}
+		if err == redis.Nil || int64(lastSeen) < workerSince.Unix() {
+			return fmt.Sprintf("worker %s is not live", workerID), status, nil
+		}
+	}
+	return "", "", nil
+}
+
+// RequeueOrphan puts an orphaned job back into its pending set. It returns false
+// if the job is no longer orphaned in a way that can be requeued.
+func (s *RedisStore) RequeueOrphan(ctx context.Context, orphan Orphan) (bool, error) {
+	now := time.Now().UnixMilli()
+	switch orphan.Status {
+	case "reserved":
+		requeued, err := requeueOrphanScript.Run(ctx, s.client, []string{fmt.Sprintf("job:%s", orphan.UUID)}, orphan.UUID, now).Int()
+		if err != nil {
+			return false, fmt.Errorf("requeueing orphan: %w", err)
+		}
+		if requeued == 1 {
+			s.publishJobEvent(ctx, types.JobEvent{Type: types.EventRequeued, JobUUID: orphan.UUID, Status: "reserved"})
+			s.notifyJobsAvailable(ctx)
+		}
+		return requeued == 1, nil
+	case "claimed":
+		requeued, err := s.requeueClaims(ctx, []string{orphan.UUID}, now, true)
+		if err != nil {
+			return false, err
+		}
+		return len(requeued) == 1, nil
+	}
+	return false, nil
+}
+
+// ExpungeJob stops scheduling a job and deletes everything stored about it.
+func (s *RedisStore) ExpungeJob(ctx context.Context, uuid string) error {
+	if _, err := s.RemoveJob(ctx, uuid, "expunged"); err != nil && err != ErrJobNotFound {
+		return err
+	}
+	if err := s.client.Del(ctx, fmt.Sprintf("job:%s", uuid), failuresKey(uuid), jobLogKey(uuid)).Err(); err != nil {
+		return fmt.Errorf("deleting job metadata: %w", err)
+	}
+	return nil
+}
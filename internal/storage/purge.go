@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+)
+
+// schedulerKeys are the fixed keys the scheduler keeps in Redis, and
+// schedulerKeyPatterns match the rest.
+var (
+	schedulerKeys = []string{
+		activeWorkersKey, delayedJobsKey, rateLimitsKey, quotasKey, reservationsKey,
+		claimLeasesKey, drainedQueuesKey, pausedQueuesKey, trackedJobsKey, driftKey,
+		auditLogKey, deadLetterKey, unhealthyWorkersKey, leaderKey, shardMembersKey,
+		apiTokensKey, upstreamPausedKey, eventStreamKey,
+	}
+	schedulerKeyPatterns = []string{
+		"job:*", "pending_jobs:*", legacyPendingJobsPrefix + "*", "pending:*", "claimed:*", "build_owner:*", "ratelimit:*",
+		"job_failures:*", "job_log:*", "claim_count:*", "queue_env:*", "idempotency:*",
+		"worker:*", "worker_jobs:*", "alert:*",
+	}
+)
+
+// PurgeAll deletes every key the scheduler keeps in Redis: jobs, workers,
+// settings, tokens and the audit log alike. Other keys are left alone. It
+// returns how many keys were deleted.
+func (s *RedisStore) PurgeAll(ctx context.Context) (int, error) {
+	deleted := 0
+	del := func(keys []string) error {
+		if len(keys) == 0 {
+			return nil
+		}
+		n, err := s.client.Del(ctx, keys...).Result()
+		if err != nil {
+			return fmt.Errorf("deleting keys: %w", err)
+		}
+		deleted += int(n)
+		return nil
+	}
+
+	if err := del(schedulerKeys); err != nil {
+		return deleted, err
+	}
+	for _, pattern := range schedulerKeyPatterns {
+		var cursor uint64
+		for {
+			keys, next, err := s.client.Scan(ctx, cursor, pattern, 500).Result()
+			if err != nil {
+				return deleted, fmt.Errorf("scanning %s: %w", pattern, err)
+			}
+			if err := del(keys); err != nil {
+				return deleted, err
+			}
+			if cursor = next; cursor == 0 {
+				break
+			}
+		}
+	}
+	return deleted, nil
+}
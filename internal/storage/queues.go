@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+	"github.com/redis/go-redis/v9"
+)
+
+// claimRateWindow is how many minutes of claims ListQueues averages over.
+const claimRateWindow = 5
+
+func claimCountKey(queryRules string, minute int64) string {
+	return fmt.Sprintf("claim_count:%s:%d", queryRules, minute)
+}
+
+// recordClaim counts a claim from a bucket towards its claim rate. Counts are
+// kept per minute, for just longer than the rate is averaged over. They're
+// only informational, so failures are ignored rather than failing the claim.
+func (s *RedisStore) recordClaim(ctx context.Context, queryRules string) {
+	key := claimCountKey(queryRules, time.Now().Unix()/60)
+	pipe := s.client.TxPipeline()
+	pipe.Incr(ctx, key)
+	pipe.Expire(ctx, key, (claimRateWindow+1)*time.Minute)
+	pipe.Exec(ctx)
+}
+
+// ListQueues summarizes every bucket of pending jobs, sorted by query rules.
+func (s *RedisStore) ListQueues(ctx context.Context) ([]types.QueueSummary, error) {
+	stats, err := s.GetAllStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	queues := make([]types.QueueSummary, 0, len(stats))
+	for queryRules, depth := range stats {
+		queue := types.QueueSummary{QueryRules: queryRules, Depth: depth}
+		if queue.OldestJobAge, err = s.oldestJobAge(ctx, queryRules); err != nil {
+			return nil, err
+		}
+		if queue.ClaimRate, err = s.claimRate(ctx, queryRules); err != nil {
+			return nil, err
+		}
+		queues = append(queues, queue)
+	}
+	sort.Slice(queues, func(i, j int) bool { return queues[i].QueryRules < queues[j].QueryRules })
+	return queues, nil
+}
+
+// oldestJobAge returns how long the longest waiting job in a bucket has been
+// reserved, in seconds. In FIFO order that is the first job; in priority order
+// every job has to be looked at.
+func (s *RedisStore) oldestJobAge(ctx context.Context, queryRules string) (float64, error) {
+	stop := int64(-1)
+	if !s.order.Priority {
+		stop = 0
+	}
+	members, err := s.client.ZRange(ctx, pendingJobsKey(queryRules), 0, stop).Result()
+	if err != nil {
+		return 0, fmt.Errorf("listing jobs for %s: %w", queryRules, err)
+	}
+
+	var oldest time.Time
+	for _, data := range members {
+		var job types.Job
+		if err := json.Unmarshal([]byte(data), &job); err != nil {
+			continue
+		}
+		if oldest.IsZero() || job.ReservedAt.Before(oldest) {
+			oldest = job.ReservedAt
+		}
+	}
+	if oldest.IsZero() {
+		return 0, nil
+	}
+	return time.Since(oldest).Seconds(), nil
+}
+
+func (s *RedisStore) claimRate(ctx context.Context, queryRules string) (float64, error) {
+	minute := time.Now().Unix() / 60
+	keys := make([]string, claimRateWindow)
+	for i := range keys {
+		keys[i] = claimCountKey(queryRules, minute-int64(i))
+	}
+	counts, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return 0, fmt.Errorf("getting claim counts for %s: %w", queryRules, err)
+	}
+
+	total := 0
+	for _, count := range counts {
+		if count, ok := count.(string); ok {
+			n, _ := strconv.Atoi(count)
+			total += n
+		}
+	}
+	return float64(total) / claimRateWindow, nil
+}
+
+// ListQueueJobs returns a page of the jobs from a Buildkite queue that are
+// waiting to be claimed, starting at offset, along with the offset of the next
+// page, or 0 if this is the last.
+func (s *RedisStore) ListQueueJobs(ctx context.Context, queueKey string, offset, limit int) ([]types.QueuedJob, int, error) {
+	key := pendingKey(queueKey)
+	now := fmt.Sprintf("(%d", time.Now().Unix())
+	if err := s.client.ZRemRangeByScore(ctx, key, "-inf", now).Err(); err != nil {
+		return nil, 0, fmt.Errorf("pruning expired pending jobs: %w", err)
+	}
+	// Fetch one extra to tell whether there's another page.
+	uuids, err := s.client.ZRange(ctx, key, int64(offset), int64(offset+limit)).Result()
+	if err != nil {
+		return nil, 0, fmt.Errorf("listing pending jobs: %w", err)
+	}
+	next := 0
+	if len(uuids) > limit {
+		uuids = uuids[:limit]
+		next = offset + limit
+	}
+
+	pipe := s.client.Pipeline()
+	cmds := make([]*redis.SliceCmd, len(uuids))
+	for i, uuid := range uuids {
+		cmds[i] = pipe.HMGet(ctx, fmt.Sprintf("job:%s", uuid), "data", "status")
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, 0, fmt.Errorf("getting pending jobs: %w", err)
+	}
+
+	jobs := make([]types.QueuedJob, 0, len(uuids))
+	for i, cmd := range cmds {
+		fields := cmd.Val()
+		data, _ := fields[0].(string)
+		if data == "" {
+			continue
+		}
+		var job types.Job
+		if err := json.Unmarshal([]byte(data), &job); err != nil {
+			return nil, 0, fmt.Errorf("unmarshaling job %s: %w", uuids[i], err)
+		}
+		status, _ := fields[1].(string)
+		jobs = append(jobs, types.QueuedJob{Job: &job, Status: status})
+	}
+	return jobs, next, nil
+}
+
+// QueueKeys returns the Buildkite queues with jobs waiting to be claimed or
+// paused, sorted.
+func (s *RedisStore) QueueKeys(ctx context.Context) ([]string, error) {
+	keys := map[string]bool{}
+	iter := s.client.Scan(ctx, 0, pendingKey("*"), 100).Iterator()
+	for iter.Next(ctx) {
+		keys[strings.TrimPrefix(iter.Val(), pendingKey(""))] = true
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("scanning pending jobs: %w", err)
+	}
+
+	paused, err := s.PausedQueues(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for key := range paused {
+		keys[key] = true
+	}
+
+	sorted := make([]string, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+	return sorted, nil
+}
@@ -2,22 +2,91 @@ package storage
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
 	"github.com/redis/go-redis/v9"
 )
 
+// workerRegistryTTL is how long an offline worker stays in the registry.
+const workerRegistryTTL = 24 * time.Hour
+
+const (
+	activeWorkersKey = "workers:active"
+	delayedJobsKey   = "delayed_jobs"
+	rateLimitsKey    = "dispatch_rate_limits"
+	quotasKey        = "concurrency_quotas"
+	reservationsKey  = "reservations"
+	claimLeasesKey   = "claim_leases"
+	drainedQueuesKey = "drained_queues"
+	pausedQueuesKey  = "paused_queues"
+	trackedJobsKey   = "tracked_jobs"
+	driftKey         = "reconcile_drift"
+)
+
+var (
+	ErrJobNotFound   = fmt.Errorf("job not found")
+	ErrJobNotPending = fmt.Errorf("job is not pending")
+	ErrJobNotClaimed = fmt.Errorf("job is not claimed")
+	ErrJobCancelled  = fmt.Errorf("job was cancelled")
+	ErrJobStarted    = fmt.Errorf("job has started")
+
+	ErrJobAlreadyComplete = fmt.Errorf("job is already complete")
+	ErrJobNotOwned        = fmt.Errorf("job is claimed by another worker")
+	ErrClaimTokenMismatch = fmt.Errorf("claim token does not match")
+)
+
+// DispatchOrder determines the order in which pending jobs are claimed.
+type DispatchOrder struct {
+	// Priority claims higher priority jobs first rather than oldest first.
+	Priority bool
+	// AgingRate is how many priority points a job gains for each minute it
+	// waits, so low priority jobs can't be starved indefinitely.
+	AgingRate float64
+}
+
+// score returns the sort key for a pending job; lower scores are claimed first.
+//
+// A job's effective priority is its priority plus AgingRate points per minute
+// since it was enqueued. Ordering by that at any instant is the same as
+// ordering by enqueued*rate - priority, which doesn't change over time, so
+// scores never need recalculating. The last term breaks ties oldest first.
+func (o DispatchOrder) score(job *types.Job) float64 {
+	enqueuedAt := job.ReservedAt
+	if enqueuedAt.IsZero() {
+		enqueuedAt = time.Now()
+	}
+	enqueued := float64(enqueuedAt.UnixMilli()) / 1000
+
+	if !o.Priority {
+		return enqueued
+	}
+	return enqueued*o.AgingRate/60 - float64(job.Priority) + enqueued*1e-10
+}
+
 type RedisStore struct {
 	client *redis.Client
+	order  DispatchOrder
+
+	// eventStream is where job events are appended, if anywhere, as of
+	// eventStreamLoaded, in Unix nanoseconds.
+	eventStream       atomic.Pointer[eventStream]
+	eventStreamLoaded atomic.Int64
 }
 
-func NewRedisStore(addr string) (*RedisStore, error) {
+func NewRedisStore(addr string, order DispatchOrder) (*RedisStore, error) {
 	client := redis.NewClient(&redis.Options{
 		Addr: addr,
 	})
+	client.AddHook(metricsHook{})
+	client.AddHook(tracingHook{})
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -26,98 +95,864 @@ func NewRedisStore(addr string) (*RedisStore, error) {
 		return nil, fmt.Errorf("failed to connect to redis: %w", err)
 	}
 
-	return &RedisStore{client: client}, nil
+	store := &RedisStore{client: client, order: order}
+	if err := store.loadEventStream(ctx); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Ping checks that Redis is reachable.
+func (s *RedisStore) Ping(ctx context.Context) error {
+	if err := s.client.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("pinging Redis: %w", err)
+	}
+	return nil
 }
 
 func (s *RedisStore) Close() error {
 	return s.client.Close()
 }
 
-func (s *RedisStore) AddJob(ctx context.Context, job *types.Job) error {
+// AddJob stores a newly reserved job. If the job is already tracked (for example
+// because it was listed again after a restart) it is left alone and AddJob
+// returns false.
+func (s *RedisStore) AddJob(ctx context.Context, job *types.Job) (bool, error) {
 	data, err := json.Marshal(job)
 	if err != nil {
-		return fmt.Errorf("marshaling job: %w", err)
+		return false, fmt.Errorf("marshaling job: %w", err)
 	}
 
-	normalizedRules := types.NormalizeQueryRules(job.AgentQueryRules)
-	key := fmt.Sprintf("jobs:%s", normalizedRules)
+	// Resource requirements are matched against what the worker has free at
+	// claim time, so they don't form part of the job's bucket.
+	normalizedRules := types.NormalizeQueryRules(types.WithoutResources(job.AgentQueryRules, job.Resources))
+	key := pendingJobsKey(normalizedRules)
+	status := "reserved"
+	notBefore := ""
+	if job.NotBefore.After(time.Now()) {
+		key = delayedJobsKey
+		status = "delayed"
+		notBefore = fmt.Sprintf("%d", job.NotBefore.Unix())
+	}
 
-	if err := s.client.RPush(ctx, key, data).Err(); err != nil {
-		return fmt.Errorf("adding job to redis: %w", err)
+	metaKey := fmt.Sprintf("job:%s", job.UUID)
+	added, err := addJobScript.Run(ctx, s.client,
+		[]string{trackedJobsKey, key, metaKey, pendingKey(job.QueueKey)},
+		job.UUID, data, time.Now().Unix(), metadataExpiry(job).Unix(), notBefore,
+		job.QueueKey, normalizedRules, job.ReservedAt.Format(time.RFC3339), status, s.order.score(job), job.StackKey,
+	).Int()
+	if err != nil {
+		return false, fmt.Errorf("adding job to redis: %w", err)
 	}
 
-	if err := s.client.Expire(ctx, key, 1*time.Hour).Err(); err != nil {
-		return fmt.Errorf("setting expiry: %w", err)
+	if added == 1 {
+		s.publishJobEvent(ctx, types.JobEvent{Type: types.EventReserved, JobUUID: job.UUID, QueueKey: job.QueueKey, Status: status})
 	}
+	if added == 1 && status == "reserved" {
+		s.notifyJobsAvailable(ctx)
+	}
+	return added == 1, nil
+}
 
-	metaKey := fmt.Sprintf("job:%s", job.UUID)
-	if err := s.client.HSet(ctx, metaKey,
-		"queue_key", job.QueueKey,
-		"query_rules", normalizedRules,
-		"reserved_at", job.ReservedAt.Format(time.RFC3339),
-		"status", "reserved",
-	).Err(); err != nil {
-		return fmt.Errorf("setting job metadata: %w", err)
+// metadataExpiry keeps job metadata for an hour past the later of now and the
+// job's not_before time, so delayed jobs don't lose their data while held.
+func metadataExpiry(job *types.Job) time.Time {
+	base := time.Now()
+	if job.NotBefore.After(base) {
+		base = job.NotBefore
+	}
+	return base.Add(1 * time.Hour)
+}
+
+// DelayJob holds a pending job until notBefore, after which it becomes
+// claimable again. Jobs that have already been claimed cannot be delayed.
+func (s *RedisStore) DelayJob(ctx context.Context, uuid string, notBefore time.Time) (*types.Job, error) {
+	metaKey := fmt.Sprintf("job:%s", uuid)
+	oldData, err := s.client.HGet(ctx, metaKey, "data").Result()
+	if err == redis.Nil {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting job data: %w", err)
 	}
 
-	if err := s.client.Expire(ctx, metaKey, 1*time.Hour).Err(); err != nil {
-		return fmt.Errorf("setting metadata expiry: %w", err)
+	var job types.Job
+	if err := json.Unmarshal([]byte(oldData), &job); err != nil {
+		return nil, fmt.Errorf("unmarshaling job: %w", err)
+	}
+	job.NotBefore = notBefore
+
+	newData, err := json.Marshal(&job)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling job: %w", err)
 	}
 
+	moved, err := delayJobScript.Run(ctx, s.client,
+		[]string{metaKey, delayedJobsKey, trackedJobsKey, pendingKey(job.QueueKey)},
+		uuid, oldData, newData, notBefore.Format(time.RFC3339), notBefore.Unix(), metadataExpiry(&job).Unix(),
+	).Int()
+	if err != nil {
+		return nil, fmt.Errorf("delaying job: %w", err)
+	}
+	if moved == 0 {
+		return nil, ErrJobNotPending
+	}
+
+	s.publishJobEvent(ctx, types.JobEvent{Type: types.EventDelayed, JobUUID: uuid, QueueKey: job.QueueKey, Status: "delayed"})
+	return &job, nil
+}
+
+func pendingKey(queueKey string) string {
+	return fmt.Sprintf("pending:%s", queueKey)
+}
+
+// pendingJobsPrefix starts the keys of the sorted sets workers claim from, one
+// per normalized set of query rules.
+const pendingJobsPrefix = "pending_jobs:"
+
+func pendingJobsKey(queryRules string) string {
+	return pendingJobsPrefix + queryRules
+}
+
+// GetPendingCount returns the number of reserved jobs from a Buildkite queue
+// that are waiting to be claimed, including delayed jobs.
+func (s *RedisStore) GetPendingCount(ctx context.Context, queueKey string) (int64, error) {
+	key := pendingKey(queueKey)
+	now := fmt.Sprintf("(%d", time.Now().Unix())
+	if err := s.client.ZRemRangeByScore(ctx, key, "-inf", now).Err(); err != nil {
+		return 0, fmt.Errorf("pruning expired pending jobs: %w", err)
+	}
+	return s.client.ZCard(ctx, key).Result()
+}
+
+// TotalPendingCount returns the number of reserved jobs waiting to be claimed
+// across the given Buildkite queues, including delayed jobs, in one round
+// trip.
+func (s *RedisStore) TotalPendingCount(ctx context.Context, queueKeys []string) (int64, error) {
+	now := fmt.Sprintf("(%d", time.Now().Unix())
+	pipe := s.client.Pipeline()
+	cmds := make([]*redis.IntCmd, len(queueKeys))
+	for i, queueKey := range queueKeys {
+		pipe.ZRemRangeByScore(ctx, pendingKey(queueKey), "-inf", now)
+		cmds[i] = pipe.ZCard(ctx, pendingKey(queueKey))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("counting pending jobs: %w", err)
+	}
+	total := int64(0)
+	for _, cmd := range cmds {
+		total += cmd.Val()
+	}
+	return total, nil
+}
+
+// RemovePendingJobs drops every job from a Buildkite queue that is waiting to be
+// claimed, returning their UUIDs so their reservations can be released.
+func (s *RedisStore) RemovePendingJobs(ctx context.Context, queueKey string) ([]string, error) {
+	uuids, err := removePendingScript.Run(ctx, s.client,
+		[]string{pendingKey(queueKey), delayedJobsKey, trackedJobsKey, reservationsKey},
+	).StringSlice()
+	if err != nil {
+		return nil, fmt.Errorf("removing pending jobs: %w", err)
+	}
+	for _, uuid := range uuids {
+		s.publishJobEvent(ctx, types.JobEvent{Type: types.EventRemoved, JobUUID: uuid, QueueKey: queueKey, Status: "released"})
+	}
+	return uuids, nil
+}
+
+// SetQueueDrained marks a Buildkite queue as drained, so the monitor stops
+// reserving its jobs, or clears the mark.
+func (s *RedisStore) SetQueueDrained(ctx context.Context, queueKey string, drained bool) error {
+	var err error
+	if drained {
+		err = s.client.SAdd(ctx, drainedQueuesKey, queueKey).Err()
+	} else {
+		err = s.client.SRem(ctx, drainedQueuesKey, queueKey).Err()
+	}
+	if err != nil {
+		return fmt.Errorf("updating drained queues: %w", err)
+	}
 	return nil
 }
 
-func (s *RedisStore) ClaimJob(ctx context.Context, queryRules []string) (*types.Job, error) {
-	normalizedRules := types.NormalizeQueryRules(queryRules)
-	key := fmt.Sprintf("jobs:%s", normalizedRules)
+// IsQueueDrained reports whether a Buildkite queue has been drained.
+func (s *RedisStore) IsQueueDrained(ctx context.Context, queueKey string) (bool, error) {
+	return s.client.SIsMember(ctx, drainedQueuesKey, queueKey).Result()
+}
+
+// SetQueuePaused pauses or resumes dispatch for a Buildkite queue. Workers
+// can't claim a paused queue's jobs; with stopReserving, the monitor stops
+// reserving more of them too.
+func (s *RedisStore) SetQueuePaused(ctx context.Context, queueKey string, paused, stopReserving bool) error {
+	var err error
+	if paused {
+		err = s.client.HSet(ctx, pausedQueuesKey, queueKey, strconv.FormatBool(stopReserving)).Err()
+	} else {
+		err = s.client.HDel(ctx, pausedQueuesKey, queueKey).Err()
+	}
+	if err != nil {
+		return fmt.Errorf("updating paused queues: %w", err)
+	}
+	if !paused {
+		s.notifyJobsAvailable(ctx)
+	}
+	return nil
+}
 
-	data, err := s.client.LPop(ctx, key).Result()
+// PausedQueues returns the paused Buildkite queues, each mapped to whether
+// reserving is stopped too.
+func (s *RedisStore) PausedQueues(ctx context.Context) (map[string]bool, error) {
+	values, err := s.client.HGetAll(ctx, pausedQueuesKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("getting paused queues: %w", err)
+	}
+	paused := make(map[string]bool, len(values))
+	for queueKey, stopReserving := range values {
+		paused[queueKey] = stopReserving == "true"
+	}
+	return paused, nil
+}
+
+// IsReservingPaused reports whether a Buildkite queue was paused with
+// reserving stopped.
+func (s *RedisStore) IsReservingPaused(ctx context.Context, queueKey string) (bool, error) {
+	stopReserving, err := s.client.HGet(ctx, pausedQueuesKey, queueKey).Result()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("getting queue pause state: %w", err)
+	}
+	return stopReserving == "true", nil
+}
+
+// GetDelayedCount returns the number of jobs being held until their not_before time.
+func (s *RedisStore) GetDelayedCount(ctx context.Context) (int64, error) {
+	return s.client.ZCard(ctx, delayedJobsKey).Result()
+}
+
+// ClaimOptions controls how ClaimJob picks a job for a worker.
+type ClaimOptions struct {
+	WorkerID string
+	// StickyBuildTTL, when non-zero, routes jobs from a build to the worker that
+	// claimed the build's first job. Ownership lapses if that worker goes
+	// StickyBuildTTL without claiming another job from the build.
+	StickyBuildTTL time.Duration
+	// TeamTag is the agent query rule key that names a job's team for team
+	// quotas, e.g. "team" for jobs targeting team=payments.
+	TeamTag string
+	// Resources is what the worker has free. Jobs requiring more of any
+	// resource than this are skipped.
+	Resources types.Resources
+	// Lease, when non-zero, is how long a claim lasts without a heartbeat
+	// before the job is requeued for another worker.
+	Lease time.Duration
+}
+
+// claimScanLimit is how many pending jobs a claim looks through for one the
+// worker may take, bounding the script's run time when the head of a large
+// pending set is held back by quotas, pauses or rate limits.
+const claimScanLimit = 1000
+
+func (s *RedisStore) ClaimJob(ctx context.Context, queryRules []string, opts ClaimOptions) (*types.Job, error) {
+	if err := s.promoteDelayed(ctx); err != nil {
+		return nil, err
+	}
+
+	key := pendingJobsKey(types.NormalizeQueryRules(queryRules))
+
+	resources := opts.Resources
+	if resources == nil {
+		resources = types.Resources{}
+	}
+	resourcesJSON, err := json.Marshal(resources)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling resources: %w", err)
+	}
+
+	token, err := newClaimToken()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := claimJobScript.Run(ctx, s.client, []string{key, rateLimitsKey, quotasKey, claimLeasesKey, pausedQueuesKey},
+		time.Now().UnixMilli(), opts.WorkerID, opts.StickyBuildTTL.Milliseconds(), opts.TeamTag, resourcesJSON, opts.Lease.Milliseconds(), token, time.Now().Format(time.RFC3339), claimScanLimit,
+	).Text()
 	if err == redis.Nil {
 		return nil, nil
 	}
 	if err != nil {
-		return nil, fmt.Errorf("popping job from redis: %w", err)
+		return nil, fmt.Errorf("claiming job from redis: %w", err)
 	}
 
 	var job types.Job
 	if err := json.Unmarshal([]byte(data), &job); err != nil {
 		return nil, fmt.Errorf("unmarshaling job: %w", err)
 	}
+	job.ClaimToken = token
 
-	metaKey := fmt.Sprintf("job:%s", job.UUID)
-	if err := s.client.HSet(ctx, metaKey, "status", "claimed").Err(); err != nil {
-		return nil, fmt.Errorf("updating job status: %w", err)
+	s.recordClaim(ctx, types.NormalizeQueryRules(queryRules))
+	s.publishJobEvent(ctx, types.JobEvent{Type: types.EventClaimed, JobUUID: job.UUID, QueueKey: job.QueueKey, WorkerID: opts.WorkerID, Status: "claimed"})
+
+	// The job is claimed either way. If this fails it is recovered like any
+	// claim whose worker never starts it.
+	if err := s.attachEnv(ctx, &job); err != nil {
+		return nil, err
 	}
 
 	return &job, nil
 }
 
-func (s *RedisStore) CompleteJob(ctx context.Context, uuid string) error {
-	metaKey := fmt.Sprintf("job:%s", uuid)
-	if err := s.client.HSet(ctx, metaKey, "status", "complete").Err(); err != nil {
-		return fmt.Errorf("updating job status: %w", err)
+// HasPendingJobs reports whether any jobs are waiting to be claimed by workers
+// with these query rules. It doesn't check quotas, rate limits or resources, so
+// a claim may still come back empty. Delayed jobs that have come due are
+// promoted first.
+func (s *RedisStore) HasPendingJobs(ctx context.Context, queryRules []string) (bool, error) {
+	if err := s.promoteDelayed(ctx); err != nil {
+		return false, err
+	}
+
+	count, err := s.client.ZCard(ctx, pendingJobsKey(types.NormalizeQueryRules(queryRules))).Result()
+	if err != nil {
+		return false, fmt.Errorf("counting pending jobs: %w", err)
+	}
+	return count > 0, nil
+}
+
+func (s *RedisStore) promoteDelayed(ctx context.Context) error {
+	promoted, err := promoteDelayedScript.Run(ctx, s.client, []string{delayedJobsKey}, time.Now().Unix()).StringSlice()
+	if err != nil {
+		return fmt.Errorf("promoting delayed jobs: %w", err)
+	}
+	for _, uuid := range promoted {
+		s.publishJobEvent(ctx, types.JobEvent{Type: types.EventPromoted, JobUUID: uuid, Status: "reserved"})
+	}
+	return nil
+}
+
+func newClaimToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating claim token: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// SetDispatchRateLimit caps how quickly jobs from a Buildkite queue are handed
+// out to workers. The limit is shared by every server using this Redis.
+func (s *RedisStore) SetDispatchRateLimit(ctx context.Context, queueKey string, limit types.RateLimit) error {
+	value := fmt.Sprintf("%d/%d", limit.Limit, limit.Period.Milliseconds())
+	if err := s.client.HSet(ctx, rateLimitsKey, queueKey, value).Err(); err != nil {
+		return fmt.Errorf("setting dispatch rate limit: %w", err)
+	}
+	return nil
+}
+
+// CompleteJob marks a claimed or running job complete and releases everything
+// it held. workerID must match the worker that claimed the job, if the claim
+// recorded one, and claimToken must be the token handed out with the claim.
+// Completing a job twice returns ErrJobAlreadyComplete.
+func (s *RedisStore) CompleteJob(ctx context.Context, uuid, workerID, claimToken string) error {
+	result, err := completeJobScript.Run(ctx, s.client,
+		[]string{fmt.Sprintf("job:%s", uuid), trackedJobsKey, reservationsKey, claimLeasesKey},
+		uuid, workerID, time.Now().Format(time.RFC3339), claimToken,
+	).Int()
+	if err != nil {
+		return fmt.Errorf("completing job: %w", err)
+	}
+
+	switch result {
+	case -1:
+		return ErrJobNotClaimed
+	case -2:
+		return ErrJobNotFound
+	case -3:
+		return ErrJobAlreadyComplete
+	case -4:
+		return ErrJobNotOwned
+	case -5:
+		return ErrClaimTokenMismatch
+	}
+
+	s.publishJobEvent(ctx, types.JobEvent{Type: types.EventCompleted, JobUUID: uuid, WorkerID: workerID, Status: "complete"})
+	// Completing a job frees up its concurrency quotas.
+	s.notifyJobsAvailable(ctx)
+	return nil
+}
+
+// CancelJob removes a job cancelled in Buildkite from wherever it is waiting and
+// marks it cancelled, so it won't be claimed and its worker is told to stop on
+// its next heartbeat. It returns the job's previous status; jobs that have
+// already finished are left alone.
+func (s *RedisStore) CancelJob(ctx context.Context, uuid string) (string, error) {
+	return s.RemoveJob(ctx, uuid, "cancelled")
+}
+
+// RemoveJob stops scheduling a job, giving it the final status status (for
+// example "finished" for a job Buildkite finished without us). It returns the
+// job's previous status; jobs that already have a final status are left alone.
+func (s *RedisStore) RemoveJob(ctx context.Context, uuid, status string) (string, error) {
+	previous, err := removeJobScript.Run(ctx, s.client,
+		[]string{fmt.Sprintf("job:%s", uuid), claimLeasesKey, delayedJobsKey, trackedJobsKey, reservationsKey, deadLetterKey},
+		uuid, status, "0",
+	).Text()
+	if err == redis.Nil {
+		return "", ErrJobNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("removing job: %w", err)
+	}
+	if !finalStatuses[previous] {
+		s.publishJobEvent(ctx, types.JobEvent{Type: types.EventRemoved, JobUUID: uuid, Status: status})
+	}
+	return previous, nil
+}
+
+// finalStatuses are the statuses removeJobScript leaves alone.
+var finalStatuses = map[string]bool{"complete": true, "failed": true, "cancelled": true, "finished": true, "reassigned": true, "abandoned": true}
+
+// AbandonJob stops scheduling a job that is waiting to be claimed, giving it
+// the final status "abandoned". Jobs in any other state return
+// ErrJobNotPending.
+func (s *RedisStore) AbandonJob(ctx context.Context, uuid string) error {
+	previous, err := removeJobScript.Run(ctx, s.client,
+		[]string{fmt.Sprintf("job:%s", uuid), claimLeasesKey, delayedJobsKey, trackedJobsKey, reservationsKey, deadLetterKey},
+		uuid, "abandoned", "1",
+	).Text()
+	if err == redis.Nil {
+		return ErrJobNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("abandoning job: %w", err)
+	}
+	if previous != "reserved" && previous != "delayed" {
+		return ErrJobNotPending
+	}
+	s.publishJobEvent(ctx, types.JobEvent{Type: types.EventRemoved, JobUUID: uuid, Status: "abandoned"})
+	return nil
+}
+
+// RecordDrift counts a divergence between Redis and Buildkite found by the
+// reconciler, by kind.
+func (s *RedisStore) RecordDrift(ctx context.Context, kind string) error {
+	if err := s.client.HIncrBy(ctx, driftKey, kind, 1).Err(); err != nil {
+		return fmt.Errorf("recording drift: %w", err)
+	}
+	return nil
+}
+
+// GetDrift returns how many divergences of each kind the reconciler has fixed.
+func (s *RedisStore) GetDrift(ctx context.Context) (map[string]int64, error) {
+	values, err := s.client.HGetAll(ctx, driftKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("getting drift: %w", err)
+	}
+
+	drift := make(map[string]int64, len(values))
+	for kind, value := range values {
+		count, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parsing drift count for %s: %w", kind, err)
+		}
+		drift[kind] = count
+	}
+	return drift, nil
+}
+
+// TrackedJobs returns the UUIDs of all jobs currently tracked in Redis.
+func (s *RedisStore) TrackedJobs(ctx context.Context) ([]string, error) {
+	return s.client.ZRangeByScore(ctx, trackedJobsKey, &redis.ZRangeBy{
+		Min: fmt.Sprintf("%d", time.Now().Unix()),
+		Max: "+inf",
+	}).Result()
+}
+
+// UntrackedJobs returns the UUIDs, in order, that aren't already tracked in
+// Redis, so jobs reserved on an earlier poll aren't reserved again.
+func (s *RedisStore) UntrackedJobs(ctx context.Context, uuids []string) ([]string, error) {
+	if len(uuids) == 0 {
+		return nil, nil
+	}
+	// Scores are when tracking lapses; missing members score 0.
+	scores, err := s.client.ZMScore(ctx, trackedJobsKey, uuids...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("checking tracked jobs: %w", err)
+	}
+	now := float64(time.Now().Unix())
+	var untracked []string
+	for i, uuid := range uuids {
+		if scores[i] < now {
+			untracked = append(untracked, uuid)
+		}
+	}
+	return untracked, nil
+}
+
+// StartJob records that a worker has started the agent for a claimed job. From
+// then on Buildkite owns the job, so it is never requeued. workerID must match
+// the worker that claimed the job, if recorded, and claimToken the token handed
+// out with the claim.
+func (s *RedisStore) StartJob(ctx context.Context, uuid, workerID, claimToken string) error {
+	result, err := startJobScript.Run(ctx, s.client,
+		[]string{fmt.Sprintf("job:%s", uuid), claimLeasesKey},
+		uuid, workerID, claimToken, time.Now().Format(time.RFC3339),
+	).Int()
+	if err != nil {
+		return fmt.Errorf("starting job: %w", err)
+	}
+
+	switch result {
+	case -1:
+		return ErrJobNotClaimed
+	case -2:
+		return ErrJobNotFound
+	case -4:
+		return ErrJobNotOwned
+	case -5:
+		return ErrClaimTokenMismatch
+	case -6:
+		return ErrJobCancelled
+	}
+	s.publishJobEvent(ctx, types.JobEvent{Type: types.EventStarted, JobUUID: uuid, WorkerID: workerID, Status: "running"})
+	return nil
+}
+
+// HeartbeatJob extends a claimed job's lease by lease from now. Running jobs are
+// accepted but have no lease to extend. workerID must match the worker that
+// claimed the job, if recorded. It returns ErrJobCancelled once the job has been
+// cancelled in Buildkite.
+func (s *RedisStore) HeartbeatJob(ctx context.Context, uuid, workerID string, lease time.Duration) error {
+	var expiresAt int64
+	if lease > 0 {
+		expiresAt = time.Now().Add(lease).UnixMilli()
+	}
+	result, err := heartbeatJobScript.Run(ctx, s.client,
+		[]string{fmt.Sprintf("job:%s", uuid), claimLeasesKey},
+		uuid, workerID, expiresAt,
+	).Int()
+	if err != nil {
+		return fmt.Errorf("extending claim lease: %w", err)
+	}
+
+	switch result {
+	case -1:
+		return ErrJobNotClaimed
+	case -2:
+		return ErrJobNotFound
+	case -4:
+		return ErrJobNotOwned
+	case -6:
+		return ErrJobCancelled
+	}
+	return nil
+}
+
+// RequeueExpiredClaims returns claimed jobs whose lease has lapsed to their
+// pending set so another worker can pick them up.
+func (s *RedisStore) RequeueExpiredClaims(ctx context.Context) ([]string, error) {
+	now := time.Now().UnixMilli()
+	expired, err := s.client.ZRangeByScore(ctx, claimLeasesKey, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: fmt.Sprintf("%d", now),
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("listing expired claims: %w", err)
+	}
+	return s.requeueClaims(ctx, expired, now, true)
+}
+
+// RequeueJob puts a claimed or dead-lettered job back into its pending set for
+// another worker, as if its claim had expired or it had been requeued from the
+// dead-letter queue. Jobs whose agent has started can't be handed out again
+// and return ErrJobStarted; jobs that are already pending return
+// ErrJobNotClaimed.
+func (s *RedisStore) RequeueJob(ctx context.Context, uuid string) error {
+	status, err := s.client.HGet(ctx, fmt.Sprintf("job:%s", uuid), "status").Result()
+	if err == redis.Nil {
+		return ErrJobNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("getting job status: %w", err)
+	}
+
+	switch status {
+	case "claimed":
+		requeued, err := s.requeueClaims(ctx, []string{uuid}, time.Now().UnixMilli(), false)
+		if err != nil {
+			return err
+		}
+		if len(requeued) == 0 {
+			// Started or finished since we looked.
+			return ErrJobStarted
+		}
+		return nil
+	case "dead":
+		return s.RequeueDeadLetter(ctx, uuid)
+	case "running", "failed":
+		return ErrJobStarted
+	case "complete":
+		return ErrJobAlreadyComplete
+	}
+	return ErrJobNotClaimed
+}
+
+func (s *RedisStore) requeueClaims(ctx context.Context, uuids []string, now int64, checkLease bool) ([]string, error) {
+	if len(uuids) == 0 {
+		return nil, nil
+	}
+
+	check := "0"
+	if checkLease {
+		check = "1"
+	}
+	args := []interface{}{now, check}
+	for _, uuid := range uuids {
+		args = append(args, uuid)
+	}
+
+	requeued, err := requeueClaimsScript.Run(ctx, s.client, []string{claimLeasesKey}, args...).StringSlice()
+	if err != nil {
+		return nil, fmt.Errorf("requeueing claims: %w", err)
+	}
+	for _, uuid := range requeued {
+		s.publishJobEvent(ctx, types.JobEvent{Type: types.EventRequeued, JobUUID: uuid, Status: "reserved"})
+	}
+	if len(requeued) > 0 {
+		s.notifyJobsAvailable(ctx)
+	}
+	return requeued, nil
+}
+
+// WorkerHeartbeat records that a worker is alive, marking it online in the
+// worker registry. Claiming a job counts as a heartbeat.
+func (s *RedisStore) WorkerHeartbeat(ctx context.Context, workerID string) error {
+	now := time.Now()
+	if err := s.client.ZAdd(ctx, activeWorkersKey, redis.Z{
+		Score:  float64(now.Unix()),
+		Member: workerID,
+	}).Err(); err != nil {
+		return fmt.Errorf("recording worker heartbeat: %w", err)
+	}
+
+	key := workerKey(workerID)
+	if err := s.client.HSet(ctx, key, "status", "online", "last_seen", now.Format(time.RFC3339)).Err(); err != nil {
+		return fmt.Errorf("updating worker registry: %w", err)
+	}
+	if err := s.client.Expire(ctx, key, workerRegistryTTL).Err(); err != nil {
+		return fmt.Errorf("setting worker expiry: %w", err)
+	}
+	return nil
+}
+
+// ActiveWorkerCount returns the number of workers seen since the given time,
+// leaving out those reporting failed preflight checks.
+func (s *RedisStore) ActiveWorkerCount(ctx context.Context, since time.Time) (int64, error) {
+	workers, err := s.activeHealthyWorkers(ctx, since)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(workers)), nil
+}
+
+func (s *RedisStore) activeHealthyWorkers(ctx context.Context, since time.Time) ([]string, error) {
+	active, err := s.client.ZRangeByScore(ctx, activeWorkersKey, &redis.ZRangeBy{
+		Min: fmt.Sprintf("%d", since.Unix()),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("listing active workers: %w", err)
+	}
+	if len(active) == 0 {
+		return nil, nil
+	}
+
+	unhealthy, err := s.client.HMGet(ctx, unhealthyWorkersKey, active...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("checking worker health: %w", err)
+	}
+	healthy := make([]string, 0, len(active))
+	for i, reason := range unhealthy {
+		if reason == nil {
+			healthy = append(healthy, active[i])
+		}
+	}
+	return healthy, nil
+}
+
+// StaleWorkers returns online workers that haven't been seen since the given time.
+func (s *RedisStore) StaleWorkers(ctx context.Context, since time.Time) ([]string, error) {
+	return s.client.ZRangeByScore(ctx, activeWorkersKey, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: fmt.Sprintf("(%d", since.Unix()),
+	}).Result()
+}
+
+// ReapWorker marks a worker offline and requeues any jobs it had claimed but
+// not yet started. It returns the UUIDs of the requeued jobs.
+func (s *RedisStore) ReapWorker(ctx context.Context, workerID string) ([]string, error) {
+	uuids, err := s.client.SMembers(ctx, workerJobsKey(workerID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("listing worker jobs: %w", err)
+	}
+
+	requeued, err := s.requeueClaims(ctx, uuids, time.Now().UnixMilli(), false)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.client.ZRem(ctx, activeWorkersKey, workerID).Err(); err != nil {
+		return nil, fmt.Errorf("removing active worker: %w", err)
+	}
+	if err := s.client.HDel(ctx, unhealthyWorkersKey, workerID).Err(); err != nil {
+		return nil, fmt.Errorf("clearing worker health: %w", err)
+	}
+	if err := s.client.HSet(ctx, workerKey(workerID), "status", "offline").Err(); err != nil {
+		return nil, fmt.Errorf("updating worker registry: %w", err)
+	}
+	return requeued, nil
+}
+
+func workerKey(workerID string) string {
+	return fmt.Sprintf("worker:%s", workerID)
+}
+
+func workerJobsKey(workerID string) string {
+	return fmt.Sprintf("worker_jobs:%s", workerID)
+}
+
+// TrackReservations records when our Buildkite reservations for the given jobs
+// expire, so they can be renewed in time.
+func (s *RedisStore) TrackReservations(ctx context.Context, uuids []string, expiresAt time.Time) error {
+	if len(uuids) == 0 {
+		return nil
+	}
+	members := make([]redis.Z, len(uuids))
+	for i, uuid := range uuids {
+		members[i] = redis.Z{Score: float64(expiresAt.Unix()), Member: uuid}
+	}
+	if err := s.client.ZAdd(ctx, reservationsKey, members...).Err(); err != nil {
+		return fmt.Errorf("tracking reservations: %w", err)
+	}
+	return nil
+}
+
+// ReservationCount returns how many jobs are held reserved from Buildkite,
+// across every stack and server sharing this Redis.
+func (s *RedisStore) ReservationCount(ctx context.Context) (int64, error) {
+	count, err := s.client.ZCard(ctx, reservationsKey).Result()
+	if err != nil {
+		return 0, fmt.Errorf("counting reservations: %w", err)
+	}
+	return count, nil
+}
+
+// UntrackReservations stops tracking reservations for the given jobs.
+func (s *RedisStore) UntrackReservations(ctx context.Context, uuids []string) error {
+	if len(uuids) == 0 {
+		return nil
+	}
+	members := make([]interface{}, len(uuids))
+	for i, uuid := range uuids {
+		members[i] = uuid
+	}
+	if err := s.client.ZRem(ctx, reservationsKey, members...).Err(); err != nil {
+		return fmt.Errorf("untracking reservations: %w", err)
+	}
+	return nil
+}
+
+// ReservationsExpiringBefore returns the jobs whose reservations expire before t.
+func (s *RedisStore) ReservationsExpiringBefore(ctx context.Context, t time.Time) ([]string, error) {
+	return s.client.ZRangeByScore(ctx, reservationsKey, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: fmt.Sprintf("%d", t.Unix()),
+	}).Result()
+}
+
+// JobStatuses returns the status of each job, or an empty string for jobs with
+// no metadata.
+func (s *RedisStore) JobStatuses(ctx context.Context, uuids []string) (map[string]string, error) {
+	statuses, err := s.jobField(ctx, uuids, "status")
+	if err != nil {
+		return nil, fmt.Errorf("getting job statuses: %w", err)
+	}
+	return statuses, nil
+}
+
+// JobQueueKeys returns the queue of each job, or an empty string for jobs with
+// no metadata.
+func (s *RedisStore) JobQueueKeys(ctx context.Context, uuids []string) (map[string]string, error) {
+	queueKeys, err := s.jobField(ctx, uuids, "queue_key")
+	if err != nil {
+		return nil, fmt.Errorf("getting job queues: %w", err)
+	}
+	return queueKeys, nil
+}
+
+// JobStackKeys returns the key of the stack that reserved each job, which is
+// empty for jobs stored before stacks were recorded.
+func (s *RedisStore) JobStackKeys(ctx context.Context, uuids []string) (map[string]string, error) {
+	stackKeys, err := s.jobField(ctx, uuids, "stack_key")
+	if err != nil {
+		return nil, fmt.Errorf("getting job stacks: %w", err)
+	}
+	return stackKeys, nil
+}
+
+func (s *RedisStore) jobField(ctx context.Context, uuids []string, field string) (map[string]string, error) {
+	cmds := make([]*redis.StringCmd, len(uuids))
+	pipe := s.client.Pipeline()
+	for i, uuid := range uuids {
+		cmds[i] = pipe.HGet(ctx, fmt.Sprintf("job:%s", uuid), field)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(uuids))
+	for i, uuid := range uuids {
+		values[uuid] = cmds[i].Val()
+	}
+	return values, nil
+}
+
+// SetPipelineQuota limits how many jobs from a pipeline may be claimed at once.
+func (s *RedisStore) SetPipelineQuota(ctx context.Context, slug string, limit int) error {
+	return s.setQuota(ctx, "pipeline:"+slug, limit)
+}
+
+// SetTeamQuota limits how many jobs for a team may be claimed at once. Jobs
+// belong to a team through the agent tag named by ClaimOptions.TeamTag.
+func (s *RedisStore) SetTeamQuota(ctx context.Context, team string, limit int) error {
+	return s.setQuota(ctx, "team:"+team, limit)
+}
+
+func (s *RedisStore) setQuota(ctx context.Context, scope string, limit int) error {
+	if err := s.client.HSet(ctx, quotasKey, scope, limit).Err(); err != nil {
+		return fmt.Errorf("setting quota: %w", err)
 	}
 	return nil
 }
 
 func (s *RedisStore) GetQueueStats(ctx context.Context, queryRules string) (int64, error) {
-	key := fmt.Sprintf("jobs:%s", queryRules)
-	return s.client.LLen(ctx, key).Result()
+	key := pendingJobsKey(queryRules)
+	return s.client.ZCard(ctx, key).Result()
 }
 
 func (s *RedisStore) GetAllStats(ctx context.Context) (map[string]int64, error) {
-	keys, err := s.client.Keys(ctx, "jobs:*").Result()
+	keys, err := s.client.Keys(ctx, pendingJobsPrefix+"*").Result()
 	if err != nil {
 		return nil, fmt.Errorf("getting keys: %w", err)
 	}
 
 	stats := make(map[string]int64)
 	for _, key := range keys {
-		len, err := s.client.LLen(ctx, key).Result()
+		len, err := s.client.ZCard(ctx, key).Result()
 		if err != nil {
 			continue
 		}
-		queryRules := key[5:]
+		queryRules := strings.TrimPrefix(key, pendingJobsPrefix)
 		stats[queryRules] = len
 	}
 
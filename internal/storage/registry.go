@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+	"github.com/redis/go-redis/v9"
+)
+
+// RegisterWorker records what a worker advertises about itself and marks it
+// online. Workers re-register periodically, so a restarted worker's details
+// replace its old ones.
+func (s *RedisStore) RegisterWorker(ctx context.Context, worker types.Worker) error {
+	data, err := json.Marshal(worker)
+	if err != nil {
+		return fmt.Errorf("encoding worker: %w", err)
+	}
+	if err := s.WorkerHeartbeat(ctx, worker.ID); err != nil {
+		return err
+	}
+	if err := s.client.HSet(ctx, workerKey(worker.ID),
+		"registration", data,
+		"concurrency", max(worker.Concurrency, 1),
+		"registered_at", time.Now().Format(time.RFC3339),
+	).Err(); err != nil {
+		return fmt.Errorf("registering worker: %w", err)
+	}
+	return nil
+}
+
+// ActiveCapacity returns how many jobs the workers seen since the given time
+// can run at once, leaving out those reporting failed preflight checks.
+// Workers that haven't registered their concurrency count as one slot.
+func (s *RedisStore) ActiveCapacity(ctx context.Context, since time.Time) (int64, error) {
+	workers, err := s.activeHealthyWorkers(ctx, since)
+	if err != nil {
+		return 0, err
+	}
+
+	pipe := s.client.Pipeline()
+	cmds := make([]*redis.StringCmd, len(workers))
+	for i, workerID := range workers {
+		cmds[i] = pipe.HGet(ctx, workerKey(workerID), "concurrency")
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return 0, fmt.Errorf("getting worker concurrency: %w", err)
+	}
+
+	capacity := int64(0)
+	for _, cmd := range cmds {
+		concurrency, err := strconv.ParseInt(cmd.Val(), 10, 64)
+		if err != nil || concurrency < 1 {
+			concurrency = 1
+		}
+		capacity += concurrency
+	}
+	return capacity, nil
+}
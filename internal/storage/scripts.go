@@ -0,0 +1,570 @@
+package storage
+
+import "github.com/redis/go-redis/v9"
+
+// addJobScript stores the job with UUID ARGV[1] unless it's already in the
+// tracked set (KEYS[1]), which scores UUIDs by their metadata expiry (ARGV[4])
+// and is pruned of those past ARGV[3]. A delayed job goes in the delayed set
+// (KEYS[2]) at its not_before score (ARGV[5]); with ARGV[5] empty, its data
+// (ARGV[2]) goes in its pending set (KEYS[2]) at the dispatch score in
+// ARGV[10]. Its metadata (KEYS[3]) records the queue key (ARGV[6]), query
+// rules (ARGV[7]), reservation time (ARGV[8]), status (ARGV[9]) and the key of
+// the stack that reserved it (ARGV[11]), and it's counted in its queue's
+// backlog (KEYS[4]) until claimed.
+var addJobScript = redis.NewScript(`
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', '(' .. ARGV[3])
+if redis.call('ZSCORE', KEYS[1], ARGV[1]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], ARGV[4], ARGV[1])
+redis.call('ZADD', KEYS[4], ARGV[4], ARGV[1])
+
+if ARGV[5] ~= '' then
+	redis.call('ZADD', KEYS[2], ARGV[5], ARGV[1])
+else
+	redis.call('ZADD', KEYS[2], ARGV[10], ARGV[2])
+	redis.call('EXPIRE', KEYS[2], 3600)
+end
+
+redis.call('HSET', KEYS[3],
+	'queue_key', ARGV[6],
+	'stack_key', ARGV[11],
+	'query_rules', ARGV[7],
+	'reserved_at', ARGV[8],
+	'status', ARGV[9],
+	'score', ARGV[10],
+	'data', ARGV[2])
+redis.call('EXPIREAT', KEYS[3], ARGV[4])
+return 1
+`)
+
+// promoteDelayedScript moves delayed jobs whose not_before time has passed back
+// into their pending set at their original dispatch score, returning the
+// UUIDs of those moved.
+var promoteDelayedScript = redis.NewScript(`
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+local promoted = {}
+for _, uuid in ipairs(due) do
+	local meta = 'job:' .. uuid
+	local data = redis.call('HGET', meta, 'data')
+	local rules = redis.call('HGET', meta, 'query_rules')
+	local score = redis.call('HGET', meta, 'score')
+	if data and rules and score then
+		redis.call('ZADD', 'pending_jobs:' .. rules, score, data)
+		redis.call('HSET', meta, 'status', 'reserved')
+		table.insert(promoted, uuid)
+	end
+	redis.call('ZREM', KEYS[1], uuid)
+end
+return promoted
+`)
+
+// claimJobScript pops the lowest-scored eligible job from a pending set, marking
+// it claimed. A job is eligible unless:
+//
+//   - its Buildkite queue is paused (has an entry in KEYS[5]), or
+//   - sticky routing is on (ARGV[3], the sticky build TTL in milliseconds, is
+//     non-zero) and its build is owned by a worker other than ARGV[2], or
+//   - its pipeline, or its team (the value of its ARGV[4] agent tag), has a
+//     concurrency quota in KEYS[3] that is already used up, or
+//   - it requires more of a resource than the worker has free (ARGV[5], a JSON
+//     object of resource quantities), or
+//   - its queue has a dispatch rate limit in KEYS[2] and its token bucket is
+//     empty.
+//
+// If ARGV[6] is non-zero, the claim is leased for that many milliseconds and
+// recorded in KEYS[4]; it must be heartbeated before then or it is requeued.
+// ARGV[7] is the claim token the worker must present to act on the job, and
+// ARGV[8] the claim time recorded as claimed_at.
+//
+// Candidates are considered in score order, a page of 100 at a time and at
+// most ARGV[9] of them, so together with the resource check this is a
+// first-fit bin packing of jobs onto workers. A throttled
+// queue's jobs are skipped rather than blocking the jobs of other queues
+// sharing the pending set; the claimed job takes a token from its queue's
+// bucket.
+var claimJobScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local worker = ARGV[2]
+local stickyTTL = tonumber(ARGV[3])
+local teamTag = ARGV[4]
+local free = cjson.decode(ARGV[5])
+local lease = tonumber(ARGV[6])
+
+local function quotaScopes(job)
+	local scopes = {}
+	if job.pipeline_slug then
+		table.insert(scopes, 'pipeline:' .. job.pipeline_slug)
+	end
+	if teamTag ~= '' and type(job.agent_query_rules) == 'table' then
+		for _, rule in ipairs(job.agent_query_rules) do
+			if string.sub(rule, 1, #teamTag + 1) == teamTag .. '=' then
+				table.insert(scopes, 'team:' .. string.sub(rule, #teamTag + 2))
+			end
+		end
+	end
+	return scopes
+end
+
+local function withinQuota(scope)
+	local limit = redis.call('HGET', KEYS[3], scope)
+	if not limit then
+		return true
+	end
+	local key = 'claimed:' .. scope
+	redis.call('ZREMRANGEBYSCORE', key, '-inf', '(' .. now)
+	return redis.call('ZCARD', key) < tonumber(limit)
+end
+
+local function fits(job)
+	if type(job.resources) ~= 'table' then
+		return true
+	end
+	for name, quantity in pairs(job.resources) do
+		if (tonumber(free[name]) or 0) < quantity then
+			return false
+		end
+	end
+	return true
+end
+
+-- buckets caches each queue's refilled token bucket, or false if the queue
+-- isn't rate limited.
+local buckets = {}
+local function bucket(queueKey)
+	if buckets[queueKey] ~= nil then
+		return buckets[queueKey]
+	end
+	local b = false
+	local limit = redis.call('HGET', KEYS[2], queueKey)
+	if limit then
+		local count, period = string.match(limit, '^(%d+)/(%d+)$')
+		count = tonumber(count)
+		period = tonumber(period)
+		local key = 'ratelimit:' .. queueKey
+		local state = redis.call('HMGET', key, 'tokens', 'ts')
+		local tokens = tonumber(state[1]) or count
+		local ts = tonumber(state[2]) or now
+		b = {key = key, period = period, tokens = math.min(count, tokens + (now - ts) * count / period)}
+	end
+	buckets[queueKey] = b
+	return b
+end
+
+local function eligible(job, scopes)
+	if job.queue_key and redis.call('HEXISTS', KEYS[5], job.queue_key) == 1 then
+		return false
+	end
+	if job.queue_key then
+		local b = bucket(job.queue_key)
+		if b and b.tokens < 1 then
+			return false
+		end
+	end
+	if not fits(job) then
+		return false
+	end
+	if stickyTTL > 0 and job.build_uuid then
+		local owner = redis.call('GET', 'build_owner:' .. job.build_uuid)
+		if owner and owner ~= worker then
+			return false
+		end
+	end
+	for _, scope in ipairs(scopes) do
+		if not withinQuota(scope) then
+			return false
+		end
+	end
+	return true
+end
+
+local data, job, scopes
+local scanned, scanLimit = 0, tonumber(ARGV[9])
+while not data and scanned < scanLimit do
+	local page = redis.call('ZRANGE', KEYS[1], scanned, math.min(scanned + 100, scanLimit) - 1)
+	if #page == 0 then
+		break
+	end
+	for _, candidate in ipairs(page) do
+		local decoded = cjson.decode(candidate)
+		local candidateScopes = quotaScopes(decoded)
+		if eligible(decoded, candidateScopes) then
+			data, job, scopes = candidate, decoded, candidateScopes
+			break
+		end
+	end
+	scanned = scanned + #page
+end
+if not data then
+	return false
+end
+
+local b = job.queue_key and bucket(job.queue_key)
+if b then
+	redis.call('HSET', b.key, 'tokens', b.tokens - 1, 'ts', now)
+	redis.call('PEXPIRE', b.key, b.period)
+end
+
+local meta = 'job:' .. job.uuid
+redis.call('ZREM', KEYS[1], data)
+redis.call('HSET', meta, 'status', 'claimed', 'quota_scopes', table.concat(scopes, ','), 'claim_token', ARGV[7], 'claimed_at', ARGV[8])
+if worker ~= '' then
+	redis.call('HSET', meta, 'claimed_by', worker)
+	redis.call('SADD', 'worker_jobs:' .. worker, job.uuid)
+end
+redis.call('ZREM', 'pending:' .. job.queue_key, job.uuid)
+for _, scope in ipairs(scopes) do
+	redis.call('ZADD', 'claimed:' .. scope, now + 3600000, job.uuid)
+end
+if lease > 0 then
+	redis.call('ZADD', KEYS[4], now + lease, job.uuid)
+end
+if stickyTTL > 0 and job.build_uuid and worker ~= '' then
+	redis.call('SET', 'build_owner:' .. job.build_uuid, worker, 'PX', stickyTTL)
+end
+return data
+`)
+
+// delayJobScript atomically moves a pending or delayed job into the delayed set
+// (KEYS[2]), provided its stored data still matches what the caller read. Its
+// metadata (KEYS[1]) is set to expire at ARGV[6], and its entries in the
+// tracked set (KEYS[3]) and its queue's backlog (KEYS[4]) rescored to match.
+var delayJobScript = redis.NewScript(`
+local meta = KEYS[1]
+local status = redis.call('HGET', meta, 'status')
+if status ~= 'reserved' and status ~= 'delayed' then
+	return 0
+end
+if redis.call('HGET', meta, 'data') ~= ARGV[2] then
+	return 0
+end
+redis.call('ZREM', 'pending_jobs:' .. redis.call('HGET', meta, 'query_rules'), ARGV[2])
+redis.call('HSET', meta, 'data', ARGV[3], 'status', 'delayed', 'not_before', ARGV[4])
+redis.call('ZADD', KEYS[2], ARGV[5], ARGV[1])
+redis.call('EXPIREAT', meta, ARGV[6])
+redis.call('ZADD', KEYS[3], ARGV[6], ARGV[1])
+redis.call('ZADD', KEYS[4], ARGV[6], ARGV[1])
+return 1
+`)
+
+// requeueClaimsScript puts the claimed jobs in ARGV[3..] back into their
+// pending set, releasing any quota and worker ownership they held. Jobs that
+// are no longer claimed (for example, because their agent has started) are
+// skipped. If ARGV[2] is "1", jobs whose lease in KEYS[1] is still valid at
+// ARGV[1] (in milliseconds) are skipped too. It returns the requeued UUIDs.
+var requeueClaimsScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local checkLease = ARGV[2] == '1'
+local requeued = {}
+for i = 3, #ARGV do
+	local uuid = ARGV[i]
+	local meta = 'job:' .. uuid
+	local lease = tonumber(redis.call('ZSCORE', KEYS[1], uuid))
+	local fields = redis.call('HMGET', meta, 'status', 'data', 'query_rules', 'score', 'queue_key', 'quota_scopes', 'claimed_by')
+	if checkLease and lease and lease > now then
+		-- heartbeated since we looked
+	elseif fields[1] == 'claimed' and fields[2] and fields[3] and fields[4] then
+		redis.call('ZADD', 'pending_jobs:' .. fields[3], fields[4], fields[2])
+		redis.call('HSET', meta, 'status', 'reserved')
+		if fields[5] then
+			local expiry = math.floor(now / 1000) + redis.call('TTL', meta)
+			redis.call('ZADD', 'pending:' .. fields[5], expiry, uuid)
+		end
+		if fields[6] then
+			for scope in string.gmatch(fields[6], '[^,]+') do
+				redis.call('ZREM', 'claimed:' .. scope, uuid)
+			end
+		end
+		if fields[7] then
+			redis.call('SREM', 'worker_jobs:' .. fields[7], uuid)
+		end
+		redis.call('HDEL', meta, 'quota_scopes', 'claimed_by', 'claim_token', 'claimed_at')
+		redis.call('ZREM', KEYS[1], uuid)
+		table.insert(requeued, uuid)
+	else
+		redis.call('ZREM', KEYS[1], uuid)
+	end
+end
+return requeued
+`)
+
+// removePendingScript removes every pending or delayed job for the queue whose
+// backlog is KEYS[1], deleting its metadata and tracking. Claimed jobs are left
+// alone. It returns the UUIDs of the removed jobs.
+var removePendingScript = redis.NewScript(`
+local removed = {}
+for _, uuid in ipairs(redis.call('ZRANGE', KEYS[1], 0, -1)) do
+	local meta = 'job:' .. uuid
+	local fields = redis.call('HMGET', meta, 'status', 'data', 'query_rules')
+	if fields[1] == 'reserved' or fields[1] == 'delayed' then
+		if fields[2] and fields[3] then
+			redis.call('ZREM', 'pending_jobs:' .. fields[3], fields[2])
+		end
+		redis.call('ZREM', KEYS[2], uuid)
+		redis.call('ZREM', KEYS[3], uuid)
+		redis.call('ZREM', KEYS[4], uuid)
+		redis.call('DEL', meta)
+		table.insert(removed, uuid)
+	end
+	redis.call('ZREM', KEYS[1], uuid)
+end
+return removed
+`)
+
+// failJobScript records a failed attempt at the claimed or running job in
+// KEYS[1], releasing its quota, lease (KEYS[2]) and worker ownership. ARGV[3]
+// is appended to the failure list in KEYS[5]. Once the job has failed ARGV[4]
+// times (0 for no limit) it is moved to the dead-letter set KEYS[3] at ARGV[2]
+// and kept, along with its failures, until requeued. Otherwise a claimed job
+// goes back to its pending set, but a running one, whose agent may already
+// hold it in Buildkite, is finished with the status "failed" at ARGV[8]: it
+// stops being tracked (KEYS[4]) and its reservation (KEYS[6]) renewed, and is
+// left to Buildkite. ARGV[5] must match the job's claim token and ARGV[6] the
+// worker that claimed it, where recorded. If ARGV[7] is 1 the failure wasn't
+// the job's fault and doesn't count as an attempt. Returns 1 if dead-lettered,
+// 0 if requeued, 2 if finished, -1 if the job isn't claimed, -2 if it doesn't
+// exist, -3 if the claim token doesn't match and -4 if another worker claimed
+// it.
+var failJobScript = redis.NewScript(`
+local fields = redis.call('HMGET', KEYS[1], 'status', 'data', 'query_rules', 'score', 'queue_key', 'quota_scopes', 'claimed_by', 'claim_token')
+if not fields[1] then
+	return -2
+end
+if fields[1] ~= 'claimed' and fields[1] ~= 'running' then
+	return -1
+end
+if fields[7] and fields[7] ~= ARGV[6] then
+	return -4
+end
+if fields[8] and fields[8] ~= ARGV[5] then
+	return -3
+end
+
+redis.call('RPUSH', KEYS[5], ARGV[3])
+redis.call('LTRIM', KEYS[5], -20, -1)
+local attempts = 0
+if ARGV[7] ~= '1' then
+	attempts = redis.call('HINCRBY', KEYS[1], 'attempts', 1)
+end
+
+if fields[6] then
+	for scope in string.gmatch(fields[6], '[^,]+') do
+		redis.call('ZREM', 'claimed:' .. scope, ARGV[1])
+	end
+end
+if fields[7] then
+	redis.call('SREM', 'worker_jobs:' .. fields[7], ARGV[1])
+end
+redis.call('HDEL', KEYS[1], 'quota_scopes', 'claimed_by', 'claim_token', 'claimed_at')
+redis.call('ZREM', KEYS[2], ARGV[1])
+
+local maxAttempts = tonumber(ARGV[4])
+if maxAttempts > 0 and attempts >= maxAttempts then
+	redis.call('HSET', KEYS[1], 'status', 'dead')
+	redis.call('ZADD', KEYS[3], ARGV[2], ARGV[1])
+	redis.call('ZADD', KEYS[4], '+inf', ARGV[1])
+	redis.call('PERSIST', KEYS[1])
+	redis.call('PERSIST', KEYS[5])
+	return 1
+end
+
+local ttl = redis.call('TTL', KEYS[1])
+redis.call('EXPIRE', KEYS[5], ttl)
+if fields[1] == 'running' then
+	redis.call('HSET', KEYS[1], 'status', 'failed', 'completed_at', ARGV[8])
+	redis.call('ZREM', KEYS[4], ARGV[1])
+	redis.call('ZREM', KEYS[6], ARGV[1])
+	return 2
+end
+
+redis.call('HSET', KEYS[1], 'status', 'reserved')
+redis.call('ZADD', 'pending_jobs:' .. fields[3], fields[4], fields[2])
+if fields[5] then
+	redis.call('ZADD', 'pending:' .. fields[5], math.floor(tonumber(ARGV[2]) / 1000) + ttl, ARGV[1])
+end
+return 0
+`)
+
+// requeueDeadLetterScript moves the job ARGV[1] out of the dead-letter set
+// KEYS[1] and back into its pending set, resetting its attempt count. Its
+// metadata (KEYS[2]) and failures (KEYS[4]) expire again at ARGV[2], which is
+// also its new score in the tracked set KEYS[3]. Returns 0 if the job isn't
+// dead-lettered.
+var requeueDeadLetterScript = redis.NewScript(`
+if redis.call('ZREM', KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+local fields = redis.call('HMGET', KEYS[2], 'data', 'query_rules', 'score', 'queue_key')
+redis.call('HSET', KEYS[2], 'status', 'reserved', 'attempts', 0)
+redis.call('ZADD', 'pending_jobs:' .. fields[2], fields[3], fields[1])
+if fields[4] then
+	redis.call('ZADD', 'pending:' .. fields[4], ARGV[2], ARGV[1])
+end
+redis.call('ZADD', KEYS[3], ARGV[2], ARGV[1])
+redis.call('EXPIREAT', KEYS[2], ARGV[2])
+redis.call('EXPIREAT', KEYS[4], ARGV[2])
+return 1
+`)
+
+// removeJobScript gives the job in KEYS[1] the final status ARGV[2], removing
+// it from its pending set, the delayed set KEYS[3] or the dead-letter set
+// KEYS[6], and releasing any quota, lease (KEYS[2]) and worker ownership it
+// holds. It stops tracking the job (KEYS[4]) and its reservation (KEYS[5]).
+// Jobs that already have a final status are left alone, as are jobs that aren't
+// reserved or delayed if ARGV[3] is "1". Returns the job's previous status, or
+// nil if it doesn't exist.
+var removeJobScript = redis.NewScript(`
+local fields = redis.call('HMGET', KEYS[1], 'status', 'data', 'query_rules', 'queue_key', 'quota_scopes', 'claimed_by')
+local status = fields[1]
+if not status then
+	return false
+end
+if status == 'complete' or status == 'failed' or status == 'cancelled' or status == 'finished' or status == 'reassigned' or status == 'abandoned' then
+	return status
+end
+if ARGV[3] == '1' and status ~= 'reserved' and status ~= 'delayed' then
+	return status
+end
+
+if status == 'reserved' then
+	redis.call('ZREM', 'pending_jobs:' .. fields[3], fields[2])
+elseif status == 'delayed' then
+	redis.call('ZREM', KEYS[3], ARGV[1])
+elseif status == 'dead' then
+	redis.call('ZREM', KEYS[6], ARGV[1])
+	redis.call('EXPIRE', KEYS[1], 3600)
+end
+if fields[4] then
+	redis.call('ZREM', 'pending:' .. fields[4], ARGV[1])
+end
+if fields[5] then
+	for scope in string.gmatch(fields[5], '[^,]+') do
+		redis.call('ZREM', 'claimed:' .. scope, ARGV[1])
+	end
+end
+if fields[6] then
+	redis.call('SREM', 'worker_jobs:' .. fields[6], ARGV[1])
+end
+
+redis.call('HSET', KEYS[1], 'status', ARGV[2])
+redis.call('HDEL', KEYS[1], 'quota_scopes')
+redis.call('ZREM', KEYS[2], ARGV[1])
+redis.call('ZREM', KEYS[4], ARGV[1])
+redis.call('ZREM', KEYS[5], ARGV[1])
+return status
+`)
+
+// completeJobScript marks the claimed or running job in KEYS[1] complete,
+// releasing its quota, lease (KEYS[4]) and worker ownership, and stops tracking
+// it (KEYS[2]) and its reservation (KEYS[3]). If the claim recorded a worker,
+// ARGV[2] must match it, and ARGV[4] must match its claim token. ARGV[3] is
+// recorded as the completion time. Returns 1 on success, -1 if the job isn't
+// claimed, -2 if it doesn't exist, -3 if it is already complete, -4 if another
+// worker claimed it and -5 if the claim token doesn't match.
+var completeJobScript = redis.NewScript(`
+local fields = redis.call('HMGET', KEYS[1], 'status', 'quota_scopes', 'claimed_by', 'claim_token')
+local status = fields[1]
+if not status then
+	return -2
+end
+if status == 'complete' then
+	return -3
+end
+if status ~= 'claimed' and status ~= 'running' then
+	return -1
+end
+if fields[3] and fields[3] ~= ARGV[2] then
+	return -4
+end
+if fields[4] and fields[4] ~= ARGV[4] then
+	return -5
+end
+
+if fields[2] then
+	for scope in string.gmatch(fields[2], '[^,]+') do
+		redis.call('ZREM', 'claimed:' .. scope, ARGV[1])
+	end
+end
+if fields[3] then
+	redis.call('SREM', 'worker_jobs:' .. fields[3], ARGV[1])
+end
+
+redis.call('HSET', KEYS[1], 'status', 'complete', 'completed_at', ARGV[3])
+redis.call('HDEL', KEYS[1], 'quota_scopes')
+redis.call('ZREM', KEYS[2], ARGV[1])
+redis.call('ZREM', KEYS[3], ARGV[1])
+redis.call('ZREM', KEYS[4], ARGV[1])
+return 1
+`)
+
+// startJobScript marks the claimed job in KEYS[1] running as of ARGV[4] and
+// drops its claim lease from KEYS[2], since a running job can't be handed out
+// again. If the claim recorded a worker, ARGV[2] must match it, and ARGV[3]
+// must match its claim token. Returns 1 on success, -1 if the job isn't
+// claimed, -2 if it doesn't exist, -4 if another worker claimed it, -5 if the
+// claim token doesn't match and -6 if it has been cancelled.
+var startJobScript = redis.NewScript(`
+local fields = redis.call('HMGET', KEYS[1], 'status', 'claimed_by', 'claim_token')
+local status = fields[1]
+if not status then
+	return -2
+end
+if status == 'cancelled' then
+	return -6
+end
+if status ~= 'claimed' then
+	return -1
+end
+if fields[2] and fields[2] ~= ARGV[2] then
+	return -4
+end
+if fields[3] and fields[3] ~= ARGV[3] then
+	return -5
+end
+redis.call('HSET', KEYS[1], 'status', 'running', 'started_at', ARGV[4])
+redis.call('ZREM', KEYS[2], ARGV[1])
+return 1
+`)
+
+// heartbeatJobScript extends the lease in KEYS[2] of the claimed job in
+// KEYS[1] to ARGV[3] (milliseconds), if ARGV[3] isn't 0. Running jobs are
+// accepted but have no lease to extend. If the claim recorded a worker,
+// ARGV[2] must match it. Returns 1 on success, -1 if the job isn't claimed or
+// running, -2 if it doesn't exist, -4 if another worker claimed it and -6 if
+// it has been cancelled.
+var heartbeatJobScript = redis.NewScript(`
+local fields = redis.call('HMGET', KEYS[1], 'status', 'claimed_by')
+local status = fields[1]
+if not status then
+	return -2
+end
+if status == 'cancelled' then
+	return -6
+end
+if status ~= 'claimed' and status ~= 'running' then
+	return -1
+end
+if fields[2] and fields[2] ~= ARGV[2] then
+	return -4
+end
+if status == 'claimed' and ARGV[3] ~= '0' then
+	redis.call('ZADD', KEYS[2], 'XX', ARGV[3], ARGV[1])
+end
+return 1
+`)
+
+// requeueOrphanScript puts the reserved job in KEYS[1] back into its pending set
+// and queue backlog, for jobs whose pending entry was lost. ARGV[2] is the
+// current time in milliseconds. Returns 0 if the job is no longer reserved.
+var requeueOrphanScript = redis.NewScript(`
+local fields = redis.call('HMGET', KEYS[1], 'status', 'data', 'query_rules', 'score', 'queue_key')
+if fields[1] ~= 'reserved' or not fields[2] or not fields[3] or not fields[4] then
+	return 0
+end
+redis.call('ZADD', 'pending_jobs:' .. fields[3], fields[4], fields[2])
+if fields[5] then
+	local expiry = math.floor(tonumber(ARGV[2]) / 1000) + redis.call('TTL', KEYS[1])
+	redis.call('ZADD', 'pending:' .. fields[5], expiry, ARGV[1])
+end
+return 1
+`)
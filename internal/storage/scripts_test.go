@@ -0,0 +1,400 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+)
+
+func newTestStore(t *testing.T) *RedisStore {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	store, err := NewRedisStore(mr.Addr(), DispatchOrder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// addJob stores job, on queue q unless it names another, reserved age ago
+// so the order jobs are claimed in is up to the test.
+func addJob(t *testing.T, store *RedisStore, job types.Job, age time.Duration) {
+	t.Helper()
+	if job.QueueKey == "" {
+		job.QueueKey = "q"
+	}
+	job.AgentQueryRules = append([]string{"queue=" + job.QueueKey}, job.AgentQueryRules...)
+	job.ReservedAt = time.Now().Add(-age)
+	added, err := store.AddJob(context.Background(), &job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !added {
+		t.Fatalf("job %s wasn't added", job.UUID)
+	}
+}
+
+// claim claims a job from queue q, returning nil if there's none.
+func claim(t *testing.T, store *RedisStore, opts ClaimOptions) *types.Job {
+	t.Helper()
+	if opts.WorkerID == "" {
+		opts.WorkerID = "w1"
+	}
+	job, err := store.ClaimJob(context.Background(), []string{"queue=q"}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func claimedUUID(job *types.Job) string {
+	if job == nil {
+		return ""
+	}
+	return job.UUID
+}
+
+func TestClaimJobOldestFirst(t *testing.T) {
+	store := newTestStore(t)
+	addJob(t, store, types.Job{UUID: "new"}, time.Minute)
+	addJob(t, store, types.Job{UUID: "old"}, time.Hour)
+
+	for _, want := range []string{"old", "new", ""} {
+		if got := claimedUUID(claim(t, store, ClaimOptions{})); got != want {
+			t.Fatalf("claimed %q, want %q", got, want)
+		}
+	}
+}
+
+func TestClaimJobPipelineQuota(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	if err := store.SetPipelineQuota(ctx, "p", 1); err != nil {
+		t.Fatal(err)
+	}
+	addJob(t, store, types.Job{UUID: "a", PipelineSlug: "p"}, 3*time.Minute)
+	addJob(t, store, types.Job{UUID: "b", PipelineSlug: "p"}, 2*time.Minute)
+	addJob(t, store, types.Job{UUID: "c", PipelineSlug: "other"}, time.Minute)
+
+	first := claim(t, store, ClaimOptions{})
+	if got := claimedUUID(first); got != "a" {
+		t.Fatalf("claimed %q, want a", got)
+	}
+	// b is held back by the quota, so c is next.
+	if got := claimedUUID(claim(t, store, ClaimOptions{})); got != "c" {
+		t.Fatalf("claimed %q, want c", got)
+	}
+	if got := claim(t, store, ClaimOptions{}); got != nil {
+		t.Fatalf("claimed %s over the pipeline's quota", got.UUID)
+	}
+
+	// Completing a frees the quota for b.
+	if err := store.CompleteJob(ctx, "a", "w1", first.ClaimToken); err != nil {
+		t.Fatal(err)
+	}
+	if got := claimedUUID(claim(t, store, ClaimOptions{})); got != "b" {
+		t.Fatalf("claimed %q, want b", got)
+	}
+}
+
+func TestClaimJobTeamQuota(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	if err := store.SetTeamQuota(ctx, "payments", 1); err != nil {
+		t.Fatal(err)
+	}
+	addJob(t, store, types.Job{UUID: "a", AgentQueryRules: []string{"team=payments"}}, 2*time.Minute)
+	addJob(t, store, types.Job{UUID: "b", AgentQueryRules: []string{"team=payments"}}, time.Minute)
+
+	opts := ClaimOptions{WorkerID: "w1", TeamTag: "team"}
+	rules := []string{"queue=q", "team=payments"}
+	job, err := store.ClaimJob(ctx, rules, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claimedUUID(job) != "a" {
+		t.Fatalf("claimed %q, want a", claimedUUID(job))
+	}
+	if job, err = store.ClaimJob(ctx, rules, opts); err != nil {
+		t.Fatal(err)
+	}
+	if job != nil {
+		t.Fatalf("claimed %s over the team's quota", job.UUID)
+	}
+}
+
+func TestClaimJobStickyBuild(t *testing.T) {
+	store := newTestStore(t)
+	addJob(t, store, types.Job{UUID: "a", BuildUUID: "build"}, 3*time.Minute)
+	addJob(t, store, types.Job{UUID: "b", BuildUUID: "build"}, 2*time.Minute)
+	addJob(t, store, types.Job{UUID: "c", BuildUUID: "other"}, time.Minute)
+
+	sticky := func(worker string) ClaimOptions {
+		return ClaimOptions{WorkerID: worker, StickyBuildTTL: time.Minute}
+	}
+	if got := claimedUUID(claim(t, store, sticky("w1"))); got != "a" {
+		t.Fatalf("w1 claimed %q, want a", got)
+	}
+	// b belongs to w1 now, so w2 skips it.
+	if got := claimedUUID(claim(t, store, sticky("w2"))); got != "c" {
+		t.Fatalf("w2 claimed %q, want c", got)
+	}
+	if got := claim(t, store, sticky("w2")); got != nil {
+		t.Fatalf("w2 claimed %s from w1's build", got.UUID)
+	}
+	if got := claimedUUID(claim(t, store, sticky("w1"))); got != "b" {
+		t.Fatalf("w1 claimed %q, want b", got)
+	}
+}
+
+func TestClaimJobRateLimit(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	if err := store.SetDispatchRateLimit(ctx, "q", types.RateLimit{Limit: 1, Period: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	addJob(t, store, types.Job{UUID: "a"}, 2*time.Minute)
+	addJob(t, store, types.Job{UUID: "b"}, time.Minute)
+	addJob(t, store, types.Job{UUID: "other", QueueKey: "unlimited"}, 3*time.Minute)
+
+	if got := claimedUUID(claim(t, store, ClaimOptions{})); got != "a" {
+		t.Fatalf("claimed %q, want a", got)
+	}
+	if got := claim(t, store, ClaimOptions{}); got != nil {
+		t.Fatalf("claimed %s over the rate limit", got.UUID)
+	}
+	// Other queues' jobs aren't held back.
+	job, err := store.ClaimJob(ctx, []string{"queue=unlimited"}, ClaimOptions{WorkerID: "w1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claimedUUID(job) != "other" {
+		t.Fatalf("claimed %q, want other", claimedUUID(job))
+	}
+}
+
+func TestClaimJobResources(t *testing.T) {
+	store := newTestStore(t)
+	addJob(t, store, types.Job{UUID: "big", Resources: types.Resources{"gpu": 2}}, 2*time.Minute)
+	addJob(t, store, types.Job{UUID: "small", Resources: types.Resources{"gpu": 1}}, time.Minute)
+
+	if got := claimedUUID(claim(t, store, ClaimOptions{Resources: types.Resources{"gpu": 1}})); got != "small" {
+		t.Fatalf("claimed %q, want small", got)
+	}
+	if got := claim(t, store, ClaimOptions{Resources: types.Resources{"gpu": 1}}); got != nil {
+		t.Fatalf("claimed %s without the resources it needs", got.UUID)
+	}
+	if got := claimedUUID(claim(t, store, ClaimOptions{Resources: types.Resources{"gpu": 2}})); got != "big" {
+		t.Fatalf("claimed %q, want big", got)
+	}
+}
+
+func TestClaimJobPausedQueue(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	addJob(t, store, types.Job{UUID: "a"}, time.Minute)
+	if err := store.SetQueuePaused(ctx, "q", true, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := claim(t, store, ClaimOptions{}); got != nil {
+		t.Fatalf("claimed %s from a paused queue", got.UUID)
+	}
+}
+
+func TestDelayJob(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	addJob(t, store, types.Job{UUID: "a"}, time.Minute)
+	addJob(t, store, types.Job{UUID: "b"}, 2*time.Minute)
+
+	notBefore := time.Now().Add(24 * time.Hour)
+	if _, err := store.DelayJob(ctx, "a", notBefore); err != nil {
+		t.Fatal(err)
+	}
+	// The job's metadata and tracking outlive the delay.
+	expiry := float64(notBefore.Add(time.Hour).Unix())
+	if ttl := store.client.TTL(ctx, "job:a").Val(); ttl < 24*time.Hour {
+		t.Errorf("delayed job's metadata expires in %s, want after its delay", ttl)
+	}
+	for _, key := range []string{trackedJobsKey, pendingKey("q")} {
+		if score := store.client.ZScore(ctx, key, "a").Val(); score < expiry-1 {
+			t.Errorf("%s scores the delayed job %v, want %v", key, score, expiry)
+		}
+	}
+
+	job := claim(t, store, ClaimOptions{})
+	if claimedUUID(job) != "b" {
+		t.Fatalf("claimed %q, want b", claimedUUID(job))
+	}
+	if got := claim(t, store, ClaimOptions{}); got != nil {
+		t.Fatalf("claimed delayed job %s", got.UUID)
+	}
+	if _, err := store.DelayJob(ctx, "b", notBefore); !errors.Is(err, ErrJobNotPending) {
+		t.Errorf("delaying a claimed job: got %v, want %v", err, ErrJobNotPending)
+	}
+	if _, err := store.DelayJob(ctx, "missing", notBefore); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("delaying a missing job: got %v, want %v", err, ErrJobNotFound)
+	}
+}
+
+func TestCompleteJob(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	addJob(t, store, types.Job{UUID: "a"}, time.Minute)
+	job := claim(t, store, ClaimOptions{})
+
+	for _, tc := range []struct {
+		name   string
+		worker string
+		token  string
+		want   error
+	}{
+		{"other worker", "w2", job.ClaimToken, ErrJobNotOwned},
+		{"stale token", "w1", "stale", ErrClaimTokenMismatch},
+		{"owner", "w1", job.ClaimToken, nil},
+		{"again", "w1", job.ClaimToken, ErrJobAlreadyComplete},
+	} {
+		if err := store.CompleteJob(ctx, "a", tc.worker, tc.token); !errors.Is(err, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, err, tc.want)
+		}
+	}
+	if err := store.CompleteJob(ctx, "missing", "w1", ""); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("missing job: got %v, want %v", err, ErrJobNotFound)
+	}
+}
+
+func TestFailJobRequeuesThenDeadLetters(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	addJob(t, store, types.Job{UUID: "a"}, time.Minute)
+
+	job := claim(t, store, ClaimOptions{})
+	if _, err := store.FailJob(ctx, "a", "w1", "stale", "boom", false, 2); !errors.Is(err, ErrClaimTokenMismatch) {
+		t.Fatalf("failing with a stale token: got %v, want %v", err, ErrClaimTokenMismatch)
+	}
+	status, err := store.FailJob(ctx, "a", "w1", job.ClaimToken, "boom", false, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != "reserved" {
+		t.Fatalf("first failure left the job %s, want reserved", status)
+	}
+
+	// Retryable failures don't count towards the attempts.
+	job = claim(t, store, ClaimOptions{})
+	if status, err = store.FailJob(ctx, "a", "w1", job.ClaimToken, "instance went away", true, 2); err != nil || status != "reserved" {
+		t.Fatalf("retryable failure: got %s, %v, want reserved", status, err)
+	}
+
+	job = claim(t, store, ClaimOptions{})
+	if status, err = store.FailJob(ctx, "a", "w1", job.ClaimToken, "boom", false, 2); err != nil || status != "dead" {
+		t.Fatalf("second failure: got %s, %v, want dead", status, err)
+	}
+	if got := claim(t, store, ClaimOptions{}); got != nil {
+		t.Fatalf("claimed dead-lettered job %s", got.UUID)
+	}
+	dead, _, err := store.ListDeadLetter(ctx, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 1 || dead[0].Job.UUID != "a" {
+		t.Fatalf("dead letter queue holds %+v, want just a", dead)
+	}
+
+	if err := store.RequeueDeadLetter(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if got := claimedUUID(claim(t, store, ClaimOptions{})); got != "a" {
+		t.Fatalf("claimed %q after requeueing from the dead letter queue, want a", got)
+	}
+}
+
+func TestFailJobAfterStartDoesNotRequeue(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	addJob(t, store, types.Job{UUID: "a"}, time.Minute)
+
+	job := claim(t, store, ClaimOptions{})
+	if err := store.StartJob(ctx, "a", "w1", job.ClaimToken); err != nil {
+		t.Fatal(err)
+	}
+	status, err := store.FailJob(ctx, "a", "w1", job.ClaimToken, "boom", false, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != "failed" {
+		t.Fatalf("failing a running job left it %s, want failed", status)
+	}
+	if got := claim(t, store, ClaimOptions{}); got != nil {
+		t.Fatalf("claimed %s again after its agent had run", got.UUID)
+	}
+	if err := store.RequeueJob(ctx, "a"); !errors.Is(err, ErrJobStarted) {
+		t.Fatalf("requeueing a failed job: got %v, want %v", err, ErrJobStarted)
+	}
+}
+
+func TestRequeueExpiredClaims(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	if err := store.SetPipelineQuota(ctx, "p", 1); err != nil {
+		t.Fatal(err)
+	}
+	addJob(t, store, types.Job{UUID: "lapsed", PipelineSlug: "p"}, 2*time.Minute)
+	addJob(t, store, types.Job{UUID: "leased"}, time.Minute)
+
+	claim(t, store, ClaimOptions{Lease: time.Millisecond})
+	claim(t, store, ClaimOptions{Lease: time.Hour})
+	time.Sleep(5 * time.Millisecond)
+
+	requeued, err := store.RequeueExpiredClaims(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requeued) != 1 || requeued[0] != "lapsed" {
+		t.Fatalf("requeued %v, want just lapsed", requeued)
+	}
+	// The requeue released the pipeline quota the claim held.
+	job := claim(t, store, ClaimOptions{WorkerID: "w2"})
+	if claimedUUID(job) != "lapsed" {
+		t.Fatalf("claimed %q, want lapsed", claimedUUID(job))
+	}
+	// The first claim's token no longer completes the job.
+	if err := store.CompleteJob(ctx, "lapsed", "w1", "stale"); err == nil {
+		t.Fatal("completed a requeued job with its old claim")
+	}
+}
+
+func TestDispatchOrderScore(t *testing.T) {
+	now := time.Now()
+	job := func(priority int, age time.Duration) *types.Job {
+		return &types.Job{Priority: priority, ReservedAt: now.Add(-age)}
+	}
+
+	fifo := DispatchOrder{}
+	if fifo.score(job(10, time.Minute)) <= fifo.score(job(0, time.Hour)) {
+		t.Error("without priority dispatch, older jobs should go first whatever their priority")
+	}
+
+	strict := DispatchOrder{Priority: true}
+	if strict.score(job(10, time.Minute)) >= strict.score(job(0, time.Hour)) {
+		t.Error("higher priority jobs should go first")
+	}
+	if strict.score(job(5, time.Hour)) >= strict.score(job(5, time.Minute)) {
+		t.Error("jobs of equal priority should go oldest first")
+	}
+
+	// At a point per minute, a job 10 points behind catches up after 10
+	// minutes' wait.
+	aging := DispatchOrder{Priority: true, AgingRate: 1}
+	if aging.score(job(10, time.Minute)) >= aging.score(job(0, 9*time.Minute)) {
+		t.Error("a low priority job waiting 8 minutes longer shouldn't overtake one 10 points higher")
+	}
+	if aging.score(job(10, time.Minute)) <= aging.score(job(0, 12*time.Minute)) {
+		t.Error("a low priority job waiting 11 minutes longer should overtake one 10 points higher")
+	}
+}
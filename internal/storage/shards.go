@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// shardMembersKey is a sorted set of the servers sharing out queues, scored
+// by when each one's membership lapses unless it heartbeats, in Unix ms.
+const shardMembersKey = "shard_members"
+
+// joinShardsScript renews ARGV[1]'s membership until ARGV[3], drops members
+// that have lapsed by ARGV[2], and returns the remaining members.
+var joinShardsScript = redis.NewScript(`
+redis.call('ZADD', KEYS[1], ARGV[3], ARGV[1])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[2])
+return redis.call('ZRANGE', KEYS[1], 0, -1)
+`)
+
+// JoinShards adds id to the servers sharing out queues for ttl, or extends
+// its membership if it's already one, and returns the live members.
+func (s *RedisStore) JoinShards(ctx context.Context, id string, ttl time.Duration) ([]string, error) {
+	now := time.Now()
+	members, err := joinShardsScript.Run(ctx, s.client, []string{shardMembersKey},
+		id, now.UnixMilli(), now.Add(ttl).UnixMilli()).StringSlice()
+	if err != nil {
+		return nil, fmt.Errorf("joining shards: %w", err)
+	}
+	return members, nil
+}
+
+// LeaveShards removes id from the servers sharing out queues, so its queues
+// move to the others without waiting for its membership to lapse.
+func (s *RedisStore) LeaveShards(ctx context.Context, id string) error {
+	if err := s.client.ZRem(ctx, shardMembersKey, id).Err(); err != nil {
+		return fmt.Errorf("leaving shards: %w", err)
+	}
+	return nil
+}
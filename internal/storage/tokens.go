@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+	"github.com/redis/go-redis/v9"
+)
+
+// apiTokensKey maps the SHA-256 of each minted token's secret to the token,
+// so secrets aren't stored.
+const apiTokensKey = "api_tokens"
+
+// apiTokenPrefix marks scheduler tokens, so they're easy to spot in configs
+// and secret scanners.
+const apiTokenPrefix = "bkcs_"
+
+var ErrTokenNotFound = fmt.Errorf("token not found")
+
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIToken mints a token with scope, optionally bound to workerID, and
+// returns its secret along with it.
+func (s *RedisStore) CreateAPIToken(ctx context.Context, name, scope, workerID string) (string, *types.APIToken, error) {
+	if scope != types.TokenScopeWorker && scope != types.TokenScopeAdmin {
+		return "", nil, fmt.Errorf("unknown token scope %q", scope)
+	}
+	b := make([]byte, 30)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, fmt.Errorf("generating token: %w", err)
+	}
+	secret := apiTokenPrefix + hex.EncodeToString(b[6:])
+	token := &types.APIToken{
+		ID:        hex.EncodeToString(b[:6]),
+		Name:      name,
+		Scope:     scope,
+		WorkerID:  workerID,
+		CreatedAt: time.Now(),
+	}
+	data, err := json.Marshal(token)
+	if err != nil {
+		return "", nil, fmt.Errorf("marshaling token: %w", err)
+	}
+	if err := s.client.HSet(ctx, apiTokensKey, hashToken(secret), data).Err(); err != nil {
+		return "", nil, fmt.Errorf("storing token: %w", err)
+	}
+	return secret, token, nil
+}
+
+// LookupAPIToken returns the token with secret, or ErrTokenNotFound if there
+// is none or it was revoked.
+func (s *RedisStore) LookupAPIToken(ctx context.Context, secret string) (*types.APIToken, error) {
+	data, err := s.client.HGet(ctx, apiTokensKey, hashToken(secret)).Bytes()
+	if err == redis.Nil {
+		return nil, ErrTokenNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting token: %w", err)
+	}
+	var token types.APIToken
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, fmt.Errorf("unmarshaling token: %w", err)
+	}
+	return &token, nil
+}
+
+// ListAPITokens returns every token that hasn't been revoked, oldest first.
+func (s *RedisStore) ListAPITokens(ctx context.Context) ([]types.APIToken, error) {
+	byHash, err := s.apiTokens(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tokens := make([]types.APIToken, 0, len(byHash))
+	for _, token := range byHash {
+		tokens = append(tokens, token)
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].CreatedAt.Before(tokens[j].CreatedAt) })
+	return tokens, nil
+}
+
+// RevokeAPIToken stops the token with id from working.
+func (s *RedisStore) RevokeAPIToken(ctx context.Context, id string) error {
+	byHash, err := s.apiTokens(ctx)
+	if err != nil {
+		return err
+	}
+	for hash, token := range byHash {
+		if token.ID == id {
+			if err := s.client.HDel(ctx, apiTokensKey, hash).Err(); err != nil {
+				return fmt.Errorf("revoking token: %w", err)
+			}
+			return nil
+		}
+	}
+	return ErrTokenNotFound
+}
+
+// apiTokens returns the tokens by their secrets' hashes.
+func (s *RedisStore) apiTokens(ctx context.Context) (map[string]types.APIToken, error) {
+	values, err := s.client.HGetAll(ctx, apiTokensKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("getting tokens: %w", err)
+	}
+	tokens := make(map[string]types.APIToken, len(values))
+	for hash, data := range values {
+		var token types.APIToken
+		if err := json.Unmarshal([]byte(data), &token); err != nil {
+			continue
+		}
+		tokens[hash] = token
+	}
+	return tokens, nil
+}
@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/tracing"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracingHook spans Redis commands and pipelines. As with metricsHook,
+// missing keys and scripts that need loading aren't errors.
+type tracingHook struct{}
+
+func (tracingHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return next(ctx, network, addr)
+	}
+}
+
+func (tracingHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		ctx, span := tracing.Start(ctx, "redis "+cmd.Name(), trace.SpanKindClient,
+			attribute.String("db.system.name", "redis"),
+			attribute.String("db.operation.name", cmd.Name()),
+		)
+		err := next(ctx, cmd)
+		tracing.End(span, redisSpanError(err))
+		return err
+	}
+}
+
+func (tracingHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		ctx, span := tracing.Start(ctx, "redis pipeline", trace.SpanKindClient,
+			attribute.String("db.system.name", "redis"),
+			attribute.Int("db.operation.batch.size", len(cmds)),
+		)
+		err := next(ctx, cmds)
+		tracing.End(span, redisSpanError(err))
+		return err
+	}
+}
+
+func redisSpanError(err error) error {
+	if errors.Is(err, redis.Nil) || (err != nil && strings.HasPrefix(err.Error(), "NOSCRIPT")) {
+		return nil
+	}
+	return err
+}
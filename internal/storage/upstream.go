@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+	"github.com/redis/go-redis/v9"
+)
+
+// upstreamPausedKey maps each queue paused in Buildkite to "1" if its
+// dispatch was paused along with it, or "0" if not.
+const upstreamPausedKey = "upstream_paused_queues"
+
+// pauseUpstreamScript records that queue ARGV[1] is paused in Buildkite,
+// pausing its dispatch too if ARGV[2] is 1 and an operator hasn't already.
+// Returns 0 if it was already recorded.
+var pauseUpstreamScript = redis.NewScript(`
+if redis.call('HEXISTS', KEYS[1], ARGV[1]) == 1 then
+	return 0
+end
+local dispatch = '0'
+if ARGV[2] == '1' and redis.call('HEXISTS', KEYS[2], ARGV[1]) == 0 then
+	redis.call('HSET', KEYS[2], ARGV[1], 'false')
+	dispatch = '1'
+end
+redis.call('HSET', KEYS[1], ARGV[1], dispatch)
+return 1
+`)
+
+// resumeUpstreamScript records that queue ARGV[1] was resumed in Buildkite,
+// resuming its dispatch if it was paused along with it. Returns 0 if it
+// wasn't recorded as paused, 1 if it was, and 2 if dispatch was resumed.
+var resumeUpstreamScript = redis.NewScript(`
+local dispatch = redis.call('HGET', KEYS[1], ARGV[1])
+if not dispatch then
+	return 0
+end
+redis.call('HDEL', KEYS[1], ARGV[1])
+if dispatch == '1' and redis.call('HDEL', KEYS[2], ARGV[1]) == 1 then
+	return 2
+end
+return 1
+`)
+
+// SetQueuePausedUpstream records whether a queue is paused in Buildkite,
+// reporting whether that changed. Servers sharing Redis see the change once
+// between them, which publishes a queue_paused or queue_resumed event. With
+// pauseDispatch, workers stop claiming the queue's jobs until it resumes,
+// unless an operator had already paused it, in which case it's left to them.
+func (s *RedisStore) SetQueuePausedUpstream(ctx context.Context, queueKey string, paused, pauseDispatch bool) (bool, error) {
+	keys := []string{upstreamPausedKey, pausedQueuesKey}
+	var result int
+	var err error
+	if paused {
+		dispatch := "0"
+		if pauseDispatch {
+			dispatch = "1"
+		}
+		result, err = pauseUpstreamScript.Run(ctx, s.client, keys, queueKey, dispatch).Int()
+	} else {
+		result, err = resumeUpstreamScript.Run(ctx, s.client, keys, queueKey).Int()
+	}
+	if err != nil {
+		return false, fmt.Errorf("updating upstream paused queues: %w", err)
+	}
+	if result == 0 {
+		return false, nil
+	}
+
+	event := types.EventQueueResumed
+	if paused {
+		event = types.EventQueuePaused
+	}
+	s.publishJobEvent(ctx, types.JobEvent{Type: event, QueueKey: queueKey})
+	if result == 2 {
+		s.notifyJobsAvailable(ctx)
+	}
+	return true, nil
+}
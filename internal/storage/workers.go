@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+)
+
+// ErrWorkerNotFound is returned for workers the registry has no record of.
+var ErrWorkerNotFound = fmt.Errorf("worker not found")
+
+// ListWorkers returns every worker in the registry, online or not, sorted by
+// ID. Offline workers drop out of the registry a day after they were last
+// seen.
+func (s *RedisStore) ListWorkers(ctx context.Context) ([]types.WorkerInfo, error) {
+	var ids []string
+	iter := s.client.Scan(ctx, 0, workerKey("*"), 100).Iterator()
+	for iter.Next(ctx) {
+		ids = append(ids, strings.TrimPrefix(iter.Val(), workerKey("")))
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("scanning worker registry: %w", err)
+	}
+	sort.Strings(ids)
+
+	workers := make([]types.WorkerInfo, 0, len(ids))
+	for _, id := range ids {
+		worker, err := s.GetWorker(ctx, id)
+		if err == ErrWorkerNotFound {
+			// Expired since the scan.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		workers = append(workers, *worker)
+	}
+	return workers, nil
+}
+
+// GetWorker returns a worker's registry entry, or ErrWorkerNotFound.
+func (s *RedisStore) GetWorker(ctx context.Context, workerID string) (*types.WorkerInfo, error) {
+	fields, err := s.client.HGetAll(ctx, workerKey(workerID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("getting worker %s: %w", workerID, err)
+	}
+	if len(fields) == 0 {
+		return nil, ErrWorkerNotFound
+	}
+
+	info := &types.WorkerInfo{
+		Status:       fields["status"],
+		LastSeen:     parseTimeField(fields["last_seen"]),
+		RegisteredAt: parseTimeField(fields["registered_at"]),
+		Health:       fields["health"],
+		HealthReason: fields["health_reason"],
+	}
+	if data := fields["registration"]; data != "" {
+		if err := json.Unmarshal([]byte(data), &info.Worker); err != nil {
+			return nil, fmt.Errorf("unmarshaling worker %s: %w", workerID, err)
+		}
+	}
+	info.ID = workerID
+
+	jobs, err := s.client.SMembers(ctx, workerJobsKey(workerID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("listing worker jobs: %w", err)
+	}
+	sort.Strings(jobs)
+	info.ClaimedJobs = jobs
+	return info, nil
+}
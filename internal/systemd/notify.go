@@ -0,0 +1,101 @@
+// Package systemd tells systemd how the process is doing, for units with
+// Type=notify and WatchdogSec=. Outside systemd it does nothing.
+package systemd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Notify sends systemd a state change, such as "READY=1", over the socket in
+// $NOTIFY_SOCKET. It does nothing if that isn't set.
+func Notify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// A leading @ names a socket in the abstract namespace.
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("connecting to systemd: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("notifying systemd: %w", err)
+	}
+	return nil
+}
+
+// Ready tells systemd the process has started up, and Stopping that it's
+// shutting down. Failures are logged, since there's nothing else to do
+// about them.
+func Ready() {
+	if err := Notify("READY=1"); err != nil {
+		log.Warn().Err(err).Msg("Couldn't notify systemd")
+	}
+}
+
+// Reloading tells systemd the process is reloading its settings; Ready says
+// when it has finished.
+func Reloading() {
+	if err := Notify("RELOADING=1"); err != nil {
+		log.Warn().Err(err).Msg("Couldn't notify systemd")
+	}
+}
+
+func Stopping() {
+	if err := Notify("STOPPING=1"); err != nil {
+		log.Warn().Err(err).Msg("Couldn't notify systemd")
+	}
+}
+
+// watchdogInterval returns how often systemd expects a watchdog ping, if
+// it's watching this process.
+func watchdogInterval() (time.Duration, bool) {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0, false
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, false
+	}
+	return time.Duration(usec) * time.Microsecond, true
+}
+
+// Watchdog pings systemd's watchdog at half its interval until ctx is done,
+// as long as healthy passes, so systemd restarts the process if it hangs.
+// healthy may be nil. It returns at once if systemd isn't watching.
+func Watchdog(ctx context.Context, healthy func() error) {
+	interval, ok := watchdogInterval()
+	if !ok {
+		return
+	}
+	log.Info().Dur("interval", interval).Msg("Pinging the systemd watchdog")
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if healthy != nil {
+			if err := healthy(); err != nil {
+				log.Warn().Err(err).Msg("Unhealthy, not pinging the systemd watchdog")
+				continue
+			}
+		}
+		if err := Notify("WATCHDOG=1"); err != nil {
+			log.Warn().Err(err).Msg("Couldn't ping the systemd watchdog")
+		}
+	}
+}
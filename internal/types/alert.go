@@ -0,0 +1,30 @@
+package types
+
+import "time"
+
+// Alert events.
+const (
+	AlertQueueDepth   = "queue_depth"
+	AlertDeadLettered = "dead_lettered"
+	AlertStackLost    = "stack_lost"
+	AlertFleetLow     = "fleet_low"
+)
+
+// Alert states. One-off alerts, such as a job being dead-lettered, only
+// fire.
+const (
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+)
+
+// Alert is what the server posts to the alert webhook, unless it's posting
+// Slack messages.
+type Alert struct {
+	Event   string `json:"event"`
+	State   string `json:"state"`
+	Summary string `json:"summary"`
+	// Details depend on the event, e.g. queue_key, depth and threshold for
+	// queue_depth.
+	Details map[string]string `json:"details,omitempty"`
+	Time    time.Time         `json:"time"`
+}
@@ -0,0 +1,41 @@
+package types
+
+// Codes in API error responses, for clients to branch on rather than matching
+// messages.
+const (
+	ErrorInvalidRequest       = "invalid_request"
+	ErrorUnsupportedVersion   = "unsupported_version"
+	ErrorUnauthorized         = "unauthorized"
+	ErrorInsufficientScope    = "insufficient_scope"
+	ErrorAuthDisabled         = "auth_disabled"
+	ErrorNotFound             = "not_found"
+	ErrorNotOwned             = "not_owned"
+	ErrorNotClaimed           = "not_claimed"
+	ErrorNotPending           = "not_pending"
+	ErrorAlreadyCompleted     = "already_completed"
+	ErrorJobStarted           = "job_started"
+	ErrorJobCancelled         = "job_cancelled"
+	ErrorClaimTokenMismatch   = "claim_token_mismatch"
+	ErrorQueuePaused          = "queue_paused"
+	ErrorIdempotencyKeyInUse  = "idempotency_key_in_use"
+	ErrorIdempotencyKeyReused = "idempotency_key_reused"
+	ErrorTooLarge             = "too_large"
+	ErrorRateLimited          = "rate_limited"
+	ErrorInternal             = "internal"
+)
+
+// APIError describes a failed API request. Responses carry it as
+// {"error": {"code": ..., "message": ...}}.
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// APIErrorResponse is the body of an API error response.
+type APIErrorResponse struct {
+	Error APIError `json:"error"`
+}
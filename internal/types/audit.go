@@ -0,0 +1,21 @@
+package types
+
+import "time"
+
+// AuditEntry records one call to the API that changed scheduler state.
+type AuditEntry struct {
+	// ID orders entries; it is the entry's Redis stream ID.
+	ID     string    `json:"id"`
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	// Actor is the worker ID the request was made as, or "admin" or "ui"
+	// for operator requests.
+	Actor string `json:"actor"`
+	// Target is the job UUID, queue key or worker ID acted on.
+	Target     string `json:"target,omitempty"`
+	RemoteAddr string `json:"remote_addr,omitempty"`
+	RequestID  string `json:"request_id,omitempty"`
+	Status     int    `json:"status"`
+	// Outcome is "ok" for successful requests, or else the error code.
+	Outcome string `json:"outcome"`
+}
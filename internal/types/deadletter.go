@@ -0,0 +1,50 @@
+package types
+
+import "time"
+
+// JobFailure records one failed attempt to run a job.
+type JobFailure struct {
+	Error    string    `json:"error"`
+	WorkerID string    `json:"worker_id,omitempty"`
+	FailedAt time.Time `json:"failed_at"`
+}
+
+// DeadLetterJob is a job that failed too many times to keep retrying, along
+// with its failure history.
+type DeadLetterJob struct {
+	Job      *Job         `json:"job"`
+	Attempts int          `json:"attempts"`
+	DeadAt   time.Time    `json:"dead_at"`
+	Failures []JobFailure `json:"failures"`
+}
+
+// JobDetail is everything the scheduler knows about a job: the job itself,
+// where it is in its lifecycle, and who has it.
+type JobDetail struct {
+	Job       *Job   `json:"job"`
+	Status    string `json:"status"`
+	ClaimedBy string `json:"claimed_by,omitempty"`
+	// Attempts counts failed runs towards the dead-letter limit.
+	Attempts    int       `json:"attempts"`
+	ClaimedAt   time.Time `json:"claimed_at,omitzero"`
+	StartedAt   time.Time `json:"started_at,omitzero"`
+	CompletedAt time.Time `json:"completed_at,omitzero"`
+	// LeaseExpiresAt is when the claim is requeued without a heartbeat, and
+	// ReservationExpiresAt when Buildkite's reservation for us lapses unless
+	// renewed.
+	LeaseExpiresAt       time.Time    `json:"lease_expires_at,omitzero"`
+	ReservationExpiresAt time.Time    `json:"reservation_expires_at,omitzero"`
+	Failures             []JobFailure `json:"failures"`
+}
+
+// JobSummary is a job as it appears in listings: the job and where it is in
+// its lifecycle, without its failure history.
+type JobSummary struct {
+	Job         *Job      `json:"job"`
+	Status      string    `json:"status"`
+	ClaimedBy   string    `json:"claimed_by,omitempty"`
+	Attempts    int       `json:"attempts"`
+	ClaimedAt   time.Time `json:"claimed_at,omitzero"`
+	StartedAt   time.Time `json:"started_at,omitzero"`
+	CompletedAt time.Time `json:"completed_at,omitzero"`
+}
@@ -0,0 +1,51 @@
+package types
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ParseEnv parses comma-separated NAME=value pairs, e.g.
+// "AWS_REGION=us-east-1,LOG_LEVEL=debug".
+func ParseEnv(s string) (map[string]string, error) {
+	env := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid environment variable %q: expected NAME=value", pair)
+		}
+		env[strings.TrimSpace(name)] = value
+	}
+	if err := ValidateEnv(env); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// ValidateEnv checks that every name in env is a valid environment variable
+// name.
+func ValidateEnv(env map[string]string) error {
+	for name := range env {
+		if !envNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid environment variable name %q", name)
+		}
+	}
+	return nil
+}
+
+// EnvList returns env as sorted NAME=value strings.
+func EnvList(env map[string]string) []string {
+	list := make([]string, 0, len(env))
+	for name, value := range env {
+		list = append(list, name+"="+value)
+	}
+	sort.Strings(list)
+	return list
+}
@@ -0,0 +1,54 @@
+package types
+
+import "time"
+
+// Job lifecycle event types.
+const (
+	EventReserved  = "reserved"
+	EventDelayed   = "delayed"
+	EventPromoted  = "promoted"
+	EventClaimed   = "claimed"
+	EventStarted   = "started"
+	EventCompleted = "completed"
+	EventFailed    = "failed"
+	EventRequeued  = "requeued"
+	EventRemoved   = "removed"
+)
+
+// Queue event types, for queues paused or resumed in Buildkite. They carry
+// a queue key but no job.
+const (
+	EventQueuePaused  = "queue_paused"
+	EventQueueResumed = "queue_resumed"
+)
+
+// JobEvent is a change in a job's lifecycle, or a queue's pause state,
+// published for dashboards and bots to follow.
+type JobEvent struct {
+	Type     string `json:"type"`
+	JobUUID  string `json:"job_uuid,omitempty"`
+	QueueKey string `json:"queue_key,omitempty"`
+	WorkerID string `json:"worker_id,omitempty"`
+	// Status is the job's status once the event has happened.
+	Status string `json:"status,omitempty"`
+	// Error and DeadLettered are set on failed events.
+	Error        string    `json:"error,omitempty"`
+	DeadLettered bool      `json:"dead_lettered,omitempty"`
+	Time         time.Time `json:"time"`
+}
+
+// StatsUpdate is one event of the live stats stream. The first update of a
+// stream carries every queue's depth in Queues; later ones carry only the
+// queues whose depth changed in QueueDeltas. Throughput counts the job events
+// of each type seen over the interval, across all servers.
+type StatsUpdate struct {
+	Time            time.Time        `json:"time"`
+	IntervalSeconds float64          `json:"interval_seconds"`
+	Total           int64            `json:"total"`
+	Delayed         int64            `json:"delayed"`
+	DeadLetter      int64            `json:"dead_letter"`
+	Workers         int64            `json:"workers"`
+	Queues          map[string]int64 `json:"queues,omitempty"`
+	QueueDeltas     map[string]int64 `json:"queue_deltas,omitempty"`
+	Throughput      map[string]int   `json:"throughput"`
+}
@@ -8,11 +8,24 @@ import (
 
 type Job struct {
 	UUID            string    `json:"uuid"`
+	StackKey        string    `json:"stack_key,omitempty"`
 	QueueKey        string    `json:"queue_key"`
+	BuildUUID       string    `json:"build_uuid,omitempty"`
+	PipelineSlug    string    `json:"pipeline_slug,omitempty"`
 	AgentQueryRules []string  `json:"agent_query_rules"`
+	Resources       Resources `json:"resources,omitempty"`
 	Priority        int       `json:"priority"`
 	ScheduledAt     time.Time `json:"scheduled_at"`
 	ReservedAt      time.Time `json:"reserved_at"`
+	NotBefore       time.Time `json:"not_before,omitzero"`
+
+	// Env holds environment variables attached to the job by its queue or an
+	// admin, for the worker to export to the agent. It is filled in on claim.
+	Env map[string]string `json:"env,omitempty"`
+
+	// ClaimToken is set on jobs handed to a worker, which must present it to
+	// start, complete or fail the job. Each claim gets a new token.
+	ClaimToken string `json:"claim_token,omitempty"`
 }
 
 func NormalizeQueryRules(rules []string) string {
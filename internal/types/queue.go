@@ -0,0 +1,20 @@
+package types
+
+// QueueSummary describes one bucket of jobs waiting for workers with the same
+// query rules.
+type QueueSummary struct {
+	QueryRules string `json:"query_rules"`
+	Depth      int64  `json:"depth"`
+	// OldestJobAge is how long the longest waiting job has been reserved, in
+	// seconds.
+	OldestJobAge float64 `json:"oldest_job_age_seconds"`
+	// ClaimRate is claims per minute, averaged over the last few minutes.
+	ClaimRate float64 `json:"claims_per_minute"`
+}
+
+// QueuedJob is a job waiting to be claimed, with its status: "reserved", or
+// "delayed" until its not_before time.
+type QueuedJob struct {
+	Job    *Job   `json:"job"`
+	Status string `json:"status"`
+}
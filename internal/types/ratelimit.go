@@ -0,0 +1,42 @@
+package types
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RateLimit caps how many jobs may be dispatched within a period.
+type RateLimit struct {
+	Limit  int
+	Period time.Duration
+}
+
+// ParseRateLimit parses a limit in the form "5/1m" (five per minute). A bare
+// unit such as "5/m" or "5/h" is also accepted.
+func ParseRateLimit(s string) (RateLimit, error) {
+	countStr, periodStr, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok {
+		return RateLimit{}, fmt.Errorf("invalid rate limit %q: expected <count>/<period>", s)
+	}
+
+	count, err := strconv.Atoi(countStr)
+	if err != nil || count <= 0 {
+		return RateLimit{}, fmt.Errorf("invalid rate limit %q: count must be a positive integer", s)
+	}
+
+	if periodStr != "" && (periodStr[0] < '0' || periodStr[0] > '9') {
+		periodStr = "1" + periodStr
+	}
+	period, err := time.ParseDuration(periodStr)
+	if err != nil || period <= 0 {
+		return RateLimit{}, fmt.Errorf("invalid rate limit %q: period must be a positive duration", s)
+	}
+
+	return RateLimit{Limit: count, Period: period}, nil
+}
+
+func (r RateLimit) String() string {
+	return fmt.Sprintf("%d/%s", r.Limit, r.Period)
+}
@@ -0,0 +1,88 @@
+package types
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Resources maps resource names (e.g. "cpu", "mem") to quantities. Memory-style
+// quantities are stored in bytes.
+type Resources map[string]float64
+
+var quantitySuffixes = map[string]float64{
+	"k":  1e3,
+	"m":  1e6,
+	"g":  1e9,
+	"t":  1e12,
+	"ki": 1 << 10,
+	"mi": 1 << 20,
+	"gi": 1 << 30,
+	"ti": 1 << 40,
+}
+
+// ParseQuantity parses a resource quantity such as "4", "0.5", "512m", "8g" or
+// "16Gi". Suffixes are case-insensitive.
+func ParseQuantity(s string) (float64, error) {
+	lower := strings.ToLower(strings.TrimSpace(s))
+	number := strings.TrimRight(lower, "abcdefghijklmnopqrstuvwxyz")
+	multiplier := 1.0
+	if suffix := lower[len(number):]; suffix != "" {
+		m, ok := quantitySuffixes[strings.TrimSuffix(suffix, "b")]
+		if !ok {
+			return 0, fmt.Errorf("invalid quantity %q: unknown suffix %q", s, suffix)
+		}
+		multiplier = m
+	}
+
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid quantity %q", s)
+	}
+	return value * multiplier, nil
+}
+
+// ParseResources extracts resource quantities from key=value rules. If names is
+// non-nil, only those keys are considered and other rules are ignored.
+func ParseResources(rules []string, names []string) (Resources, error) {
+	resources := Resources{}
+	for _, rule := range rules {
+		key, value, ok := strings.Cut(rule, "=")
+		if !ok || (names != nil && !slices.Contains(names, key)) {
+			continue
+		}
+		quantity, err := ParseQuantity(value)
+		if err != nil {
+			return nil, fmt.Errorf("resource %s: %w", key, err)
+		}
+		resources[key] = quantity
+	}
+	return resources, nil
+}
+
+// WithoutResources returns rules with any rule for a resource in r removed.
+func WithoutResources(rules []string, r Resources) []string {
+	if len(r) == 0 {
+		return rules
+	}
+	remaining := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		key, _, _ := strings.Cut(rule, "=")
+		if _, ok := r[key]; !ok {
+			remaining = append(remaining, rule)
+		}
+	}
+	return remaining
+}
+
+// Rules formats resources as sorted key=value rules, the inverse of
+// ParseResources.
+func (r Resources) Rules() []string {
+	rules := make([]string, 0, len(r))
+	for key, quantity := range r {
+		rules = append(rules, fmt.Sprintf("%s=%s", key, strconv.FormatFloat(quantity, 'f', -1, 64)))
+	}
+	slices.Sort(rules)
+	return rules
+}
@@ -0,0 +1,27 @@
+package types
+
+import "time"
+
+// API token scopes. Worker tokens may only use the routes workers need to
+// claim and run jobs; admin tokens may use everything.
+const (
+	TokenScopeWorker = "worker"
+	TokenScopeAdmin  = "admin"
+)
+
+// APIToken describes a minted API token. The secret itself is only shown
+// when the token is created.
+type APIToken struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Scope string `json:"scope"`
+	// WorkerID, if set, is the only X-Worker-ID the token may act as.
+	WorkerID  string    `json:"worker_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// NewAPIToken is a token as it's returned on creation, with its secret.
+type NewAPIToken struct {
+	APIToken
+	Token string `json:"token"`
+}
@@ -0,0 +1,32 @@
+package types
+
+import "time"
+
+// Worker is what a worker advertises about itself when it registers.
+type Worker struct {
+	ID       string `json:"id"`
+	Hostname string `json:"hostname"`
+	// QueryRules holds each rule set the worker claims jobs for, normalized.
+	QueryRules  []string `json:"query_rules"`
+	Tags        []string `json:"tags,omitempty"`
+	Executor    string   `json:"executor"`
+	Concurrency int      `json:"concurrency"`
+	Resources   []string `json:"resources,omitempty"`
+}
+
+// WorkerInfo is the registry's view of a worker: what it registered with, if
+// it has, and what it has been doing since.
+type WorkerInfo struct {
+	// Worker is the worker's registration. Workers that haven't registered
+	// only have an ID.
+	Worker
+	// Status is "online" while the worker heartbeats and "offline" once it has
+	// timed out or deregistered.
+	Status       string    `json:"status"`
+	LastSeen     time.Time `json:"last_seen,omitzero"`
+	RegisteredAt time.Time `json:"registered_at,omitzero"`
+	// Health is "healthy" or "unhealthy" for workers running preflight checks.
+	Health       string   `json:"health,omitempty"`
+	HealthReason string   `json:"health_reason,omitempty"`
+	ClaimedJobs  []string `json:"claimed_jobs"`
+}
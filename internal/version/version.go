@@ -0,0 +1,50 @@
+// Package version describes the build, as set at link time with:
+//
+//	go build -ldflags "-X github.com/buildkite/buildkite-custom-scheduler/internal/version.Version=v1.2.3 -X github.com/buildkite/buildkite-custom-scheduler/internal/version.Commit=$(git rev-parse HEAD)"
+package version
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+var (
+	// Version is the release's semver, or "dev" for builds that didn't set it.
+	Version = "dev"
+
+	// Commit is the git SHA built from. If it isn't set, the one go build
+	// records from a checkout is used instead.
+	Commit = ""
+)
+
+// String describes the build as "<version> (<commit>, <go version>)".
+func String() string {
+	return fmt.Sprintf("%s (%s, %s)", Version, commit(), runtime.Version())
+}
+
+func commit() string {
+	if Commit != "" {
+		return Commit
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	revision, modified := "", false
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+	if revision == "" {
+		return "unknown"
+	}
+	if modified {
+		revision += "-dirty"
+	}
+	return revision
+}
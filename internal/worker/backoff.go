@@ -0,0 +1,50 @@
+package worker
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+// backoff spaces out retries after repeated errors, doubling the delay from
+// base up to max and randomising it so a fleet of workers doesn't retry in
+// lockstep when the API server comes back.
+type backoff struct {
+	base     time.Duration
+	max      time.Duration
+	failures int
+}
+
+// next returns how long to wait after another failure: a random duration
+// between half and all of the current delay.
+func (b *backoff) next() time.Duration {
+	delay := b.base
+	for range b.failures {
+		if delay >= b.max {
+			break
+		}
+		delay *= 2
+	}
+	delay = min(delay, b.max)
+	b.failures++
+
+	half := delay / 2
+	return half + rand.N(delay-half+1)
+}
+
+func (b *backoff) reset() {
+	b.failures = 0
+}
+
+// sleep waits for d, returning false if ctx is done first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,53 @@
+package worker
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	b := &backoff{base: time.Second, max: 10 * time.Second}
+	// Each delay is jittered between half and all of the doubling delay,
+	// which stops at max.
+	for i, want := range []time.Duration{
+		time.Second,
+		2 * time.Second,
+		4 * time.Second,
+		8 * time.Second,
+		10 * time.Second,
+		10 * time.Second,
+	} {
+		if got := b.next(); got < want/2 || got > want {
+			t.Errorf("failure %d: waited %s, want between %s and %s", i+1, got, want/2, want)
+		}
+	}
+
+	b.reset()
+	if got := b.next(); got < time.Second/2 || got > time.Second {
+		t.Errorf("after reset: waited %s, want between 500ms and 1s", got)
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	b := &backoff{base: time.Second, max: time.Second}
+	seen := map[time.Duration]bool{}
+	for range 20 {
+		seen[b.next()] = true
+	}
+	if len(seen) < 2 {
+		t.Error("delays weren't randomised")
+	}
+}
+
+func TestSleep(t *testing.T) {
+	if !sleep(context.Background(), time.Millisecond) {
+		t.Error("sleep returned false without being cancelled")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if sleep(ctx, time.Hour) {
+		t.Error("sleep returned true after its context was done")
+	}
+}
@@ -0,0 +1,187 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// buildDirSweepInterval is how often old build directories are cleaned up.
+const buildDirSweepInterval = 10 * time.Minute
+
+// BuildDirConfig describes the per-job build directories a worker gives its
+// agents.
+type BuildDirConfig struct {
+	// Root, if set, gets a directory per job, passed to the agent as its
+	// build path.
+	Root string
+	// Keep leaves each job's directory behind when it finishes, e.g. for
+	// debugging or reusing checkouts, rather than removing it.
+	Keep bool
+	// MaxAge and MaxSize bound what is left under Root: directories not used
+	// for MaxAge are removed, then the least recently used ones until the rest
+	// fit in MaxSize bytes. Zero means no limit.
+	MaxAge  time.Duration
+	MaxSize uint64
+}
+
+// buildDirs tracks which build directories running jobs are using, shared by
+// all slots, so sweeps leave them alone.
+type buildDirs struct {
+	mu    sync.Mutex
+	inUse map[string]bool
+}
+
+func newBuildDirs() *buildDirs {
+	return &buildDirs{inUse: map[string]bool{}}
+}
+
+// createBuildDir makes a fresh build directory for a job, returning its path.
+func (r *Runner) createBuildDir(jobUUID string) (string, error) {
+	path := filepath.Join(r.cfg.BuildDirs.Root, jobUUID)
+	// Claim the directory first so a sweep doesn't remove it from under us.
+	r.buildDirs.use(jobUUID)
+	// A directory left by an earlier attempt at the job may be in any state.
+	if err := os.RemoveAll(path); err != nil {
+		r.buildDirs.release(jobUUID)
+		return "", fmt.Errorf("removing stale build directory: %w", err)
+	}
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		r.buildDirs.release(jobUUID)
+		return "", fmt.Errorf("creating build directory: %w", err)
+	}
+	return path, nil
+}
+
+// releaseBuildDir is called once a job has finished with its build directory,
+// removing it unless directories are kept.
+func (r *Runner) releaseBuildDir(jobUUID string) {
+	defer r.buildDirs.release(jobUUID)
+	if r.cfg.BuildDirs.Keep {
+		// Sweeps go by modification time, so count the job's end as a use.
+		now := time.Now()
+		os.Chtimes(filepath.Join(r.cfg.BuildDirs.Root, jobUUID), now, now)
+		return
+	}
+	if err := os.RemoveAll(filepath.Join(r.cfg.BuildDirs.Root, jobUUID)); err != nil {
+		r.logger.Warn().Err(err).Str("uuid", jobUUID).Msg("Error removing build directory")
+	}
+}
+
+func (d *buildDirs) use(name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.inUse[name] = true
+}
+
+func (d *buildDirs) release(name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.inUse, name)
+}
+
+func (d *buildDirs) used(name string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.inUse[name]
+}
+
+// sweepBuildDirs removes old build directories until ctx is cancelled.
+func (r *Runner) sweepBuildDirs(ctx context.Context) {
+	ticker := time.NewTicker(buildDirSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.sweepBuildDirsOnce(); err != nil {
+			r.logger.Warn().Err(err).Str("root", r.cfg.BuildDirs.Root).Msg("Error cleaning up build directories")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+type buildDir struct {
+	name    string
+	modTime time.Time
+	size    uint64
+}
+
+func (r *Runner) sweepBuildDirsOnce() error {
+	cfg := r.cfg.BuildDirs
+	entries, err := os.ReadDir(cfg.Root)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("listing build directories: %w", err)
+	}
+
+	var dirs []buildDir
+	var total uint64
+	for _, entry := range entries {
+		if !entry.IsDir() || r.buildDirs.used(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		dir := buildDir{name: entry.Name(), modTime: info.ModTime()}
+		if cfg.MaxAge > 0 && time.Since(dir.modTime) > cfg.MaxAge {
+			r.removeBuildDir(dir, "expired")
+			continue
+		}
+		if cfg.MaxSize > 0 {
+			dir.size = dirSize(filepath.Join(cfg.Root, dir.name))
+			total += dir.size
+		}
+		dirs = append(dirs, dir)
+	}
+
+	if cfg.MaxSize == 0 || total <= cfg.MaxSize {
+		return nil
+	}
+	sort.Slice(dirs, func(i, j int) bool { return dirs[i].modTime.Before(dirs[j].modTime) })
+	for _, dir := range dirs {
+		if total <= cfg.MaxSize {
+			break
+		}
+		r.removeBuildDir(dir, "over size limit")
+		total -= dir.size
+	}
+	return nil
+}
+
+func (r *Runner) removeBuildDir(dir buildDir, reason string) {
+	if err := os.RemoveAll(filepath.Join(r.cfg.BuildDirs.Root, dir.name)); err != nil {
+		r.logger.Warn().Err(err).Str("dir", dir.name).Msg("Error removing build directory")
+		return
+	}
+	r.logger.Info().Str("dir", dir.name).Str("reason", reason).Time("last_used", dir.modTime).Msg("Removed build directory")
+}
+
+// dirSize adds up the sizes of the files under path, skipping anything it
+// can't read.
+func dirSize(path string) uint64 {
+	var size uint64
+	filepath.WalkDir(path, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if entry.Type().IsRegular() {
+			if info, err := entry.Info(); err == nil {
+				size += uint64(info.Size())
+			}
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,130 @@
+//go:build linux
+
+package worker
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// cpuPeriod is the cgroup CPU accounting period, in microseconds.
+const cpuPeriod = 100000
+
+// prepareCgroupParent creates the cgroup v2 directory job cgroups go under and
+// enables the controllers the limits need in it. The worker must have been
+// delegated this part of the hierarchy, e.g. with systemd's Delegate=yes.
+func prepareCgroupParent(parent string, limits JobLimits) error {
+	if _, err := os.Stat("/sys/fs/cgroup/cgroup.controllers"); err != nil {
+		return fmt.Errorf("job resource limits need cgroup v2 mounted at /sys/fs/cgroup")
+	}
+	if err := os.MkdirAll(parent, 0o755); err != nil {
+		return fmt.Errorf("creating cgroup %s: %w", parent, err)
+	}
+
+	var controllers []string
+	if limits.CPU > 0 {
+		controllers = append(controllers, "+cpu")
+	}
+	if limits.Memory > 0 {
+		controllers = append(controllers, "+memory")
+	}
+	if err := os.WriteFile(filepath.Join(parent, "cgroup.subtree_control"), []byte(strings.Join(controllers, " ")), 0o644); err != nil {
+		return fmt.Errorf("enabling cgroup controllers in %s: %w", parent, err)
+	}
+	return nil
+}
+
+// jobCgroup is the cgroup a single job's agent runs in.
+type jobCgroup struct {
+	path string
+	dir  *os.File
+}
+
+func newJobCgroup(parent, name string, limits JobLimits) (*jobCgroup, error) {
+	path := filepath.Join(parent, name)
+	if err := os.Mkdir(path, 0o755); err != nil && !errors.Is(err, os.ErrExist) {
+		return nil, fmt.Errorf("creating cgroup: %w", err)
+	}
+	cg := &jobCgroup{path: path}
+
+	if limits.CPU > 0 {
+		quota := int(limits.CPU * cpuPeriod)
+		if err := cg.write("cpu.max", fmt.Sprintf("%d %d", quota, cpuPeriod)); err != nil {
+			cg.remove()
+			return nil, err
+		}
+	}
+	if limits.Memory > 0 {
+		if err := cg.write("memory.max", strconv.FormatInt(limits.Memory, 10)); err != nil {
+			cg.remove()
+			return nil, err
+		}
+		// Without swap the kernel OOM-kills the build rather than thrashing.
+		// Not every kernel has swap accounting, so this is best effort.
+		cg.write("memory.swap.max", "0")
+	}
+
+	dir, err := os.Open(path)
+	if err != nil {
+		cg.remove()
+		return nil, fmt.Errorf("opening cgroup: %w", err)
+	}
+	cg.dir = dir
+	return cg, nil
+}
+
+func (c *jobCgroup) write(file, value string) error {
+	if err := os.WriteFile(filepath.Join(c.path, file), []byte(value), 0o644); err != nil {
+		return fmt.Errorf("setting %s: %w", file, err)
+	}
+	return nil
+}
+
+// apply makes cmd start inside the cgroup, so the agent and everything it
+// spawns are limited from the first instruction.
+func (c *jobCgroup) apply(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{UseCgroupFD: true, CgroupFD: int(c.dir.Fd())}
+}
+
+// oomKilled reports whether the kernel killed anything in the cgroup for
+// exceeding its memory limit.
+func (c *jobCgroup) oomKilled() bool {
+	f, err := os.Open(filepath.Join(c.path, "memory.events"))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name, value, _ := strings.Cut(scanner.Text(), " ")
+		if name == "oom_kill" {
+			count, _ := strconv.Atoi(value)
+			return count > 0
+		}
+	}
+	return false
+}
+
+// remove kills anything the build left running in the cgroup and deletes it.
+func (c *jobCgroup) remove() {
+	if c.dir != nil {
+		c.dir.Close()
+	}
+	c.write("cgroup.kill", "1")
+	// The cgroup can't be removed until the killed processes have exited.
+	for range 20 {
+		if err := os.Remove(c.path); err == nil || errors.Is(err, os.ErrNotExist) {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
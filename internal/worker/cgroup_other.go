@@ -0,0 +1,24 @@
+//go:build !linux
+
+package worker
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+func prepareCgroupParent(parent string, limits JobLimits) error {
+	return fmt.Errorf("job resource limits need Linux cgroup v2")
+}
+
+type jobCgroup struct{}
+
+func newJobCgroup(parent, name string, limits JobLimits) (*jobCgroup, error) {
+	return nil, fmt.Errorf("job resource limits need Linux cgroup v2")
+}
+
+func (c *jobCgroup) apply(cmd *exec.Cmd) {}
+
+func (c *jobCgroup) oomKilled() bool { return false }
+
+func (c *jobCgroup) remove() {}
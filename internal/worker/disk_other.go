@@ -0,0 +1,9 @@
+//go:build !unix
+
+package worker
+
+import "fmt"
+
+func freeDiskSpace(path string) (uint64, error) {
+	return 0, fmt.Errorf("checking free disk space isn't supported on this platform")
+}
@@ -0,0 +1,84 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+)
+
+// DockerConfig describes the containers DockerExecutor runs agents in.
+type DockerConfig struct {
+	// DockerPath is the docker CLI to run containers with.
+	DockerPath string
+	// Image must have buildkite-agent on its PATH.
+	Image string
+	// Volumes are bind mounts in docker's -v format, e.g.
+	// /var/run/docker.sock:/var/run/docker.sock.
+	Volumes []string
+	// Env sets extra variables in the container, as KEY=VALUE or as KEY to
+	// pass through the worker's own value.
+	Env []string
+	// Limits are applied to each container with --cpus and --memory.
+	Limits JobLimits
+}
+
+// DockerExecutor runs each job's agent in a fresh container, which is removed
+// when the agent exits so no state leaks between builds.
+type DockerExecutor struct {
+	cfg DockerConfig
+}
+
+func NewDockerExecutor(cfg DockerConfig) *DockerExecutor {
+	return &DockerExecutor{cfg: cfg}
+}
+
+func (e *DockerExecutor) Run(ctx context.Context, job *types.Job, agent AgentCommand, started func(pid int)) error {
+	args := []string{
+		"run", "--rm",
+		"--name", fmt.Sprintf("buildkite-job-%s", job.UUID),
+		"--label", fmt.Sprintf("buildkite.job-uuid=%s", job.UUID),
+		"--entrypoint", "buildkite-agent",
+	}
+	if e.cfg.Limits.CPU > 0 {
+		args = append(args, "--cpus", strconv.FormatFloat(e.cfg.Limits.CPU, 'f', -1, 64))
+	}
+	if e.cfg.Limits.Memory > 0 {
+		// Matching --memory-swap stops the container swapping past its limit.
+		memory := strconv.FormatInt(e.cfg.Limits.Memory, 10)
+		args = append(args, "--memory", memory, "--memory-swap", memory)
+	}
+	for _, volume := range e.cfg.Volumes {
+		args = append(args, "--volume", volume)
+	}
+	for _, env := range e.cfg.Env {
+		args = append(args, "--env", env)
+	}
+	// Pass the agent's variables through by name so secrets such as the token
+	// don't show up in the docker command line.
+	for _, env := range agent.Env {
+		key, _, _ := strings.Cut(env, "=")
+		args = append(args, "--env", key)
+	}
+	args = append(args, e.cfg.Image)
+	args = append(args, agent.Args...)
+
+	// docker run forwards the SIGTERM we send on cancellation to the agent.
+	cmd := exec.CommandContext(ctx, e.cfg.DockerPath, args...)
+	cmd.Env = append(os.Environ(), agent.Env...)
+	if err := runCommand(cmd, agent, started); err != nil {
+		// The container is gone by the time docker run exits, so its OOM flag
+		// can't be checked; 137 is how docker reports a kernel OOM kill.
+		var exitErr *exec.ExitError
+		if e.cfg.Limits.Memory > 0 && errors.As(err, &exitErr) && exitErr.ExitCode() == 137 {
+			return fmt.Errorf("agent container was killed, most likely for exceeding its memory limit of %dMiB: %w", e.cfg.Limits.Memory>>20, err)
+		}
+		return fmt.Errorf("running agent container: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+	"github.com/rs/zerolog/log"
+)
+
+// taskPollInterval is how often a job's ECS task is checked for completion.
+const taskPollInterval = 5 * time.Second
+
+// ECSConfig describes the ECS tasks ECSExecutor runs agents in.
+type ECSConfig struct {
+	Region  string
+	Cluster string
+	// TaskDefinition's agent container must have buildkite-agent as its
+	// entrypoint, as the buildkite/agent image does.
+	TaskDefinition string
+	Container      string
+	// LaunchType is FARGATE or EC2. Fargate tasks need Subnets, and
+	// optionally SecurityGroups and a public IP.
+	LaunchType     string
+	Subnets        []string
+	SecurityGroups []string
+	AssignPublicIP bool
+	// Endpoint overrides the regional ECS endpoint, e.g. for testing.
+	Endpoint string
+}
+
+// ECSExecutor runs each job's agent in its own ECS task, passing the agent
+// arguments as a container override, and settles the job from the agent
+// container's exit code once the task stops.
+type ECSExecutor struct {
+	cfg        ECSConfig
+	endpoint   string
+	httpClient *http.Client
+	creds      *awsCredentialSource
+}
+
+func NewECSExecutor(cfg ECSConfig) (*ECSExecutor, error) {
+	if cfg.Region == "" {
+		return nil, fmt.Errorf("an AWS region is required for the ecs executor")
+	}
+	if cfg.TaskDefinition == "" {
+		return nil, fmt.Errorf("a task definition is required for the ecs executor")
+	}
+
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://ecs.%s.amazonaws.com", cfg.Region)
+	}
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	return &ECSExecutor{
+		cfg:        cfg,
+		endpoint:   strings.TrimSuffix(endpoint, "/"),
+		httpClient: httpClient,
+		creds:      &awsCredentialSource{httpClient: httpClient},
+	}, nil
+}
+
+type ecsTask struct {
+	TaskArn       string `json:"taskArn"`
+	LastStatus    string `json:"lastStatus"`
+	StoppedReason string `json:"stoppedReason"`
+	Containers    []struct {
+		Name     string `json:"name"`
+		ExitCode *int   `json:"exitCode"`
+		Reason   string `json:"reason"`
+	} `json:"containers"`
+}
+
+type ecsTasksResponse struct {
+	Tasks    []ecsTask `json:"tasks"`
+	Failures []struct {
+		Arn    string `json:"arn"`
+		Reason string `json:"reason"`
+	} `json:"failures"`
+}
+
+func (e *ECSExecutor) Run(ctx context.Context, job *types.Job, agent AgentCommand, started func(pid int)) error {
+	environment := make([]map[string]string, 0, len(agent.Env))
+	for _, variable := range agent.Env {
+		key, value, _ := strings.Cut(variable, "=")
+		environment = append(environment, map[string]string{"name": key, "value": value})
+	}
+
+	runTask := map[string]any{
+		"cluster":        e.cfg.Cluster,
+		"taskDefinition": e.cfg.TaskDefinition,
+		"count":          1,
+		"startedBy":      job.UUID,
+		"overrides": map[string]any{
+			"containerOverrides": []map[string]any{{
+				"name":        e.cfg.Container,
+				"command":     agent.Args,
+				"environment": environment,
+			}},
+		},
+	}
+	if e.cfg.LaunchType != "" {
+		runTask["launchType"] = e.cfg.LaunchType
+	}
+	if len(e.cfg.Subnets) > 0 {
+		assignPublicIP := "DISABLED"
+		if e.cfg.AssignPublicIP {
+			assignPublicIP = "ENABLED"
+		}
+		vpc := map[string]any{"subnets": e.cfg.Subnets, "assignPublicIp": assignPublicIP}
+		if len(e.cfg.SecurityGroups) > 0 {
+			vpc["securityGroups"] = e.cfg.SecurityGroups
+		}
+		runTask["networkConfiguration"] = map[string]any{"awsvpcConfiguration": vpc}
+	}
+
+	var run ecsTasksResponse
+	if err := e.call(ctx, "RunTask", runTask, &run); err != nil {
+		return fmt.Errorf("running task: %w", err)
+	}
+	if len(run.Tasks) == 0 {
+		if len(run.Failures) > 0 {
+			return fmt.Errorf("running task: %s", run.Failures[0].Reason)
+		}
+		return fmt.Errorf("running task: no task started")
+	}
+	taskArn := run.Tasks[0].TaskArn
+	logger := log.With().Str("job_uuid", job.UUID).Str("task_arn", taskArn).Logger()
+	logger.Info().Msg("Started ECS task")
+
+	ticker := time.NewTicker(taskPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Stopping sends the agent SIGTERM so it can cancel the job.
+			stop := map[string]any{"cluster": e.cfg.Cluster, "task": taskArn, "reason": "Buildkite job interrupted"}
+			if err := e.call(context.WithoutCancel(ctx), "StopTask", stop, nil); err != nil {
+				logger.Warn().Err(err).Msg("Error stopping ECS task")
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		var describe ecsTasksResponse
+		err := e.call(ctx, "DescribeTasks", map[string]any{"cluster": e.cfg.Cluster, "tasks": []string{taskArn}}, &describe)
+		if err != nil {
+			if ctx.Err() != nil {
+				continue
+			}
+			return fmt.Errorf("describing task: %w", err)
+		}
+		if len(describe.Tasks) == 0 || describe.Tasks[0].LastStatus != "STOPPED" {
+			continue
+		}
+
+		task := describe.Tasks[0]
+		for _, container := range task.Containers {
+			if container.Name != e.cfg.Container {
+				continue
+			}
+			if container.ExitCode == nil {
+				return fmt.Errorf("task stopped before the agent exited: %s", task.StoppedReason)
+			}
+			if *container.ExitCode != 0 {
+				return fmt.Errorf("agent exited with code %d", *container.ExitCode)
+			}
+			return nil
+		}
+		return fmt.Errorf("task stopped without an %q container: %s", e.cfg.Container, task.StoppedReason)
+	}
+}
+
+// call makes an ECS API request, decoding the response into out if non-nil.
+func (e *ECSExecutor) call(ctx context.Context, action string, body, out any) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("marshaling request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint+"/", bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "AmazonEC2ContainerServiceV20141113."+action)
+
+	creds, err := e.creds.get(ctx)
+	if err != nil {
+		return err
+	}
+	signAWSRequest(req, payload, creds, e.cfg.Region, "ecs", time.Now())
+
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(respBody))
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("decoding response: %w", err)
+		}
+	}
+	return nil
+}
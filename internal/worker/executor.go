@@ -0,0 +1,114 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+)
+
+// AgentCommand is a buildkite-agent invocation that acquires and runs one job.
+type AgentCommand struct {
+	// Args are the arguments to buildkite-agent, starting with "start".
+	Args []string
+	// Env holds KEY=VALUE variables the agent needs, such as its token.
+	Env []string
+	// Stdout and Stderr receive the agent's output, for executors that run it
+	// locally.
+	Stdout, Stderr io.Writer
+}
+
+// Executor runs buildkite-agent for a claimed job, whether directly on the host,
+// in a container or somewhere else entirely.
+type Executor interface {
+	// Run runs the agent until it exits, returning an error if it failed.
+	// Cancelling ctx interrupts the agent. If the agent runs as a local
+	// process, Run calls started with its PID so a restarted worker can
+	// re-attach to it.
+	Run(ctx context.Context, job *types.Job, agent AgentCommand, started func(pid int)) error
+}
+
+// JobLimits caps the resources each job's agent may use. Zero means no limit.
+type JobLimits struct {
+	// CPU is in cores, e.g. 0.5 or 2.
+	CPU float64
+	// Memory is in bytes.
+	Memory int64
+}
+
+func (l JobLimits) enabled() bool {
+	return l.CPU > 0 || l.Memory > 0
+}
+
+// HostExecutor runs the agent as a child process of the worker. With limits,
+// each agent runs in its own cgroup under cgroupParent.
+type HostExecutor struct {
+	agentPath    string
+	cgroupParent string
+	limits       JobLimits
+}
+
+func NewHostExecutor(agentPath, cgroupParent string, limits JobLimits) (*HostExecutor, error) {
+	if limits.enabled() {
+		if err := prepareCgroupParent(cgroupParent, limits); err != nil {
+			return nil, err
+		}
+	}
+	return &HostExecutor{agentPath: agentPath, cgroupParent: cgroupParent, limits: limits}, nil
+}
+
+func (e *HostExecutor) Run(ctx context.Context, job *types.Job, agent AgentCommand, started func(pid int)) error {
+	cmd := exec.CommandContext(ctx, e.agentPath, agent.Args...)
+	cmd.Env = append(os.Environ(), agent.Env...)
+
+	var cgroup *jobCgroup
+	if e.limits.enabled() {
+		var err error
+		cgroup, err = newJobCgroup(e.cgroupParent, fmt.Sprintf("job-%s", job.UUID), e.limits)
+		if err != nil {
+			return fmt.Errorf("creating job cgroup: %w", err)
+		}
+		defer cgroup.remove()
+		cgroup.apply(cmd)
+	}
+
+	if err := runCommand(cmd, agent, started); err != nil {
+		if cgroup != nil && cgroup.oomKilled() {
+			return fmt.Errorf("agent exceeded its memory limit of %dMiB and was killed: %w", e.limits.Memory>>20, err)
+		}
+		return fmt.Errorf("running buildkite-agent: %w", err)
+	}
+	return nil
+}
+
+// agentStopTimeout is how long an interrupted agent gets to exit before it is
+// killed.
+const agentStopTimeout = 30 * time.Second
+
+// runCommand runs a command that hosts the agent, sending its output where the
+// agent command says. Unless cmd already has a Cancel function, cancelling sends it
+// SIGTERM.
+func runCommand(cmd *exec.Cmd, agent AgentCommand, started func(pid int)) error {
+	// Ask the agent to stop so it can cancel the job in Buildkite, only
+	// killing it if it doesn't.
+	if cmd.Cancel == nil {
+		cmd.Cancel = func() error {
+			return cmd.Process.Signal(syscall.SIGTERM)
+		}
+	}
+	cmd.WaitDelay = agentStopTimeout
+
+	cmd.Stdout = agent.Stdout
+	cmd.Stderr = agent.Stderr
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	started(cmd.Process.Pid)
+	return cmd.Wait()
+}
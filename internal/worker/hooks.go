@@ -0,0 +1,42 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+)
+
+// runHook runs an operator-supplied hook executable for a job, with the job's
+// details and attached variables in its environment and its output captured in
+// the job's log. It does nothing if path is empty.
+func (r *Runner) runHook(ctx context.Context, name, path string, job *types.Job, output *jobLog, extraEnv ...string) error {
+	if path == "" {
+		return nil
+	}
+
+	r.logger.Info().Str("uuid", job.UUID).Str("hook", name).Msg("Running hook")
+
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Env = append(os.Environ(), types.EnvList(job.Env)...)
+	cmd.Env = append(cmd.Env,
+		"BUILDKITE_JOB_ID="+job.UUID,
+		"BUILDKITE_BUILD_ID="+job.BuildUUID,
+		"BUILDKITE_PIPELINE_SLUG="+job.PipelineSlug,
+		"BUILDKITE_AGENT_QUERY_RULES="+strings.Join(job.AgentQueryRules, ","),
+		"BUILDKITE_SCHEDULER_QUEUE="+job.QueueKey,
+		"BUILDKITE_SCHEDULER_WORKER_ID="+r.cfg.WorkerID,
+		"BUILDKITE_SCHEDULER_HOOK="+name,
+	)
+	cmd.Env = append(cmd.Env, extraEnv...)
+	cmd.Stdout = output.stream(name)
+	cmd.Stderr = cmd.Stdout
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s hook: %w", name, err)
+	}
+	return nil
+}
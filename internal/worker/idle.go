@@ -0,0 +1,70 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// idleTracker records how long the worker has gone without a job, shared by
+// all of its slots.
+type idleTracker struct {
+	mu    sync.Mutex
+	busy  int
+	since time.Time
+}
+
+func newIdleTracker() *idleTracker {
+	return &idleTracker{since: time.Now()}
+}
+
+// start records that a slot has a job. It does nothing on a nil tracker, as do
+// the other methods.
+func (t *idleTracker) start() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.busy++
+}
+
+func (t *idleTracker) finish() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.busy--
+	if t.busy == 0 {
+		t.since = time.Now()
+	}
+}
+
+// idleFor returns how long it's been since any slot had a job.
+func (t *idleTracker) idleFor() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.busy > 0 {
+		return 0
+	}
+	return time.Since(t.since)
+}
+
+// watchIdle blocks until the worker has gone IdleTimeout without a job,
+// returning true, or until ctx is cancelled.
+func (r *Runner) watchIdle(ctx context.Context) bool {
+	ticker := time.NewTicker(min(r.cfg.IdleTimeout, 10*time.Second))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+			if r.idle.idleFor() >= r.cfg.IdleTimeout {
+				return true
+			}
+		}
+	}
+}
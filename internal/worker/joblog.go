@@ -0,0 +1,198 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+	"github.com/rs/zerolog"
+)
+
+// jobLogTailSize is how much of a job's most recent output is kept in memory
+// for uploading.
+const jobLogTailSize = 1 << 20
+
+// LogConfig describes how job output is captured and where it is uploaded.
+type LogConfig struct {
+	// Dir, if set, gets a <job uuid>.log file of each job's output.
+	Dir string
+	// Upload is "server" or "s3" to upload the tail of a failed job's output,
+	// or empty to keep it local.
+	Upload string
+	// UploadAll uploads every job's output, not just failed ones.
+	UploadAll bool
+	// S3Bucket, S3Prefix and S3Region say where S3 uploads go; objects are
+	// named <prefix><job uuid>.log.
+	S3Bucket string
+	S3Prefix string
+	S3Region string
+}
+
+// jobLog captures the output of a job's agent and hooks. Each line is logged
+// tagged with the job and the stream it came from, appended to the job's log
+// file if there is one, and kept in a bounded buffer for uploading.
+type jobLog struct {
+	uuid   string
+	logger zerolog.Logger
+
+	mu      sync.Mutex
+	file    *os.File
+	tail    []byte
+	streams []*logStream
+}
+
+// openJobLog starts capturing a job's output. If its log file can't be
+// created, output is still logged and kept for uploading.
+func (r *Runner) openJobLog(job *types.Job) *jobLog {
+	l := &jobLog{uuid: job.UUID, logger: r.logger}
+	if r.cfg.Logs.Dir == "" {
+		return l
+	}
+
+	if err := os.MkdirAll(r.cfg.Logs.Dir, 0o755); err != nil {
+		r.logger.Warn().Err(err).Str("job_uuid", job.UUID).Msg("Error creating log directory")
+		return l
+	}
+	file, err := os.OpenFile(filepath.Join(r.cfg.Logs.Dir, job.UUID+".log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		r.logger.Warn().Err(err).Str("job_uuid", job.UUID).Msg("Error opening job log")
+		return l
+	}
+	l.file = file
+	return l
+}
+
+// stream returns a writer for one of the job's output streams, such as the
+// agent's "stdout" or a hook's.
+func (l *jobLog) stream(name string) io.Writer {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s := &logStream{log: l, name: name}
+	l.streams = append(l.streams, s)
+	return s
+}
+
+// Close logs any unterminated lines and closes the log file.
+func (l *jobLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, s := range l.streams {
+		if len(s.partial) > 0 {
+			l.logLine(s.name, string(s.partial))
+			s.partial = nil
+		}
+	}
+	if l.file != nil {
+		return l.file.Close()
+	}
+	return nil
+}
+
+// contents returns the most recent output.
+func (l *jobLog) contents() []byte {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return bytes.Clone(l.tail)
+}
+
+func (l *jobLog) write(p []byte) {
+	if l.file != nil {
+		// Output is too useful to lose over a full disk, so keep going.
+		if _, err := l.file.Write(p); err != nil {
+			l.logger.Warn().Err(err).Str("job_uuid", l.uuid).Msg("Error writing job log")
+			l.file.Close()
+			l.file = nil
+		}
+	}
+	l.tail = append(l.tail, p...)
+	if len(l.tail) > jobLogTailSize {
+		l.tail = l.tail[len(l.tail)-jobLogTailSize:]
+	}
+}
+
+func (l *jobLog) logLine(stream, line string) {
+	l.logger.Info().Str("job_uuid", l.uuid).Str("stream", stream).Msg(strings.TrimSuffix(line, "\r"))
+}
+
+type logStream struct {
+	log     *jobLog
+	name    string
+	partial []byte
+}
+
+func (s *logStream) Write(p []byte) (int, error) {
+	s.log.mu.Lock()
+	defer s.log.mu.Unlock()
+	s.log.write(p)
+
+	data := append(s.partial, p...)
+	for {
+		line, rest, found := bytes.Cut(data, []byte("\n"))
+		if !found {
+			break
+		}
+		if len(line) > 0 {
+			s.log.logLine(s.name, string(line))
+		}
+		data = rest
+	}
+	s.partial = bytes.Clone(data)
+	return len(p), nil
+}
+
+// uploadJobLog sends the tail of a job's output wherever LogConfig says.
+// Without UploadAll, only failed jobs' output is uploaded.
+func (r *Runner) uploadJobLog(ctx context.Context, l *jobLog, failed bool) error {
+	if !failed && !r.cfg.Logs.UploadAll {
+		return nil
+	}
+	switch r.cfg.Logs.Upload {
+	case "server":
+		return r.post(ctx, fmt.Sprintf("%s/v1/jobs/%s/log", r.cfg.APIServer, url.PathEscape(l.uuid)), "", l.contents())
+	case "s3":
+		return r.uploadJobLogToS3(ctx, l)
+	}
+	return nil
+}
+
+func (r *Runner) uploadJobLogToS3(ctx context.Context, l *jobLog) error {
+	cfg := r.cfg.Logs
+	payload := l.contents()
+	objectURL := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", cfg.S3Bucket, cfg.S3Region, url.PathEscape(cfg.S3Prefix+l.uuid+".log"))
+	// url.PathEscape escapes the slashes in prefixes, which S3 keys keep.
+	objectURL = strings.ReplaceAll(objectURL, "%2F", "/")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, objectURL, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	req.Header.Set("X-Amz-Content-Sha256", sha256Hex(payload))
+
+	creds, err := r.awsCreds.get(ctx)
+	if err != nil {
+		return err
+	}
+	signAWSRequest(req, payload, creds, cfg.S3Region, "s3", time.Now())
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
@@ -0,0 +1,374 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+	// podPollInterval is how often a job's pod is checked for completion.
+	podPollInterval = 2 * time.Second
+)
+
+// KubernetesConfig describes the pods KubernetesExecutor runs agents in.
+type KubernetesConfig struct {
+	// APIServer is the Kubernetes API URL. If empty, the in-cluster service
+	// account is used.
+	APIServer string
+	Namespace string
+	// PodTemplate, if set, is a file holding a Pod as JSON to base each job's
+	// pod on. A container named "agent" is created or filled in to run the
+	// agent.
+	PodTemplate    string
+	Image          string
+	ServiceAccount string
+	// NodeSelectorRules maps query rule keys to node labels, e.g.
+	// arch=kubernetes.io/arch, so a job's rules pick the nodes it runs on.
+	NodeSelectorRules map[string]string
+	// TokenSecret, as name/key, reads the agent token from a Secret rather
+	// than putting it in the pod spec.
+	TokenSecret string
+	// Limits become the agent container's resource limits. A container killed
+	// for exceeding its memory limit fails with reason OOMKilled.
+	Limits JobLimits
+}
+
+// KubernetesExecutor runs each job's agent in its own pod, waiting for the pod
+// to finish and deleting it afterwards.
+type KubernetesExecutor struct {
+	cfg      KubernetesConfig
+	client   *kubeClient
+	template map[string]any
+}
+
+func NewKubernetesExecutor(cfg KubernetesConfig) (*KubernetesExecutor, error) {
+	client, err := newKubeClient(cfg.APIServer)
+	if err != nil {
+		return nil, err
+	}
+
+	template := map[string]any{}
+	if cfg.PodTemplate != "" {
+		data, err := os.ReadFile(cfg.PodTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("reading pod template: %w", err)
+		}
+		if err := json.Unmarshal(data, &template); err != nil {
+			return nil, fmt.Errorf("parsing pod template: %w", err)
+		}
+	}
+
+	// The API server would reject every pod without an image, failing every
+	// job.
+	if cfg.Image == "" && templateImage(template) == "" {
+		return nil, fmt.Errorf("agent pods need an image: set --k8s-image or one for the pod template's agent container")
+	}
+
+	return &KubernetesExecutor{cfg: cfg, client: client, template: template}, nil
+}
+
+// templateImage returns the image of the pod template's agent container, or
+// "" if it doesn't set one.
+func templateImage(template map[string]any) string {
+	spec, _ := template["spec"].(map[string]any)
+	containers, _ := spec["containers"].([]any)
+	for _, c := range containers {
+		if c, ok := c.(map[string]any); ok && c["name"] == "agent" {
+			image, _ := c["image"].(string)
+			return image
+		}
+	}
+	return ""
+}
+
+func (e *KubernetesExecutor) Run(ctx context.Context, job *types.Job, agent AgentCommand, started func(pid int)) error {
+	pod, err := e.podSpec(job, agent)
+	if err != nil {
+		return err
+	}
+	name := podName(job)
+	podsPath := fmt.Sprintf("/api/v1/namespaces/%s/pods", url.PathEscape(e.cfg.Namespace))
+
+	// A pod left behind by a previous run of this worker is watched rather
+	// than replaced.
+	err = e.client.do(ctx, http.MethodPost, podsPath, pod, nil)
+	var statusErr *kubeStatusError
+	if errors.As(err, &statusErr) && statusErr.code == http.StatusConflict {
+		err = nil
+	}
+	if err != nil {
+		return fmt.Errorf("creating pod: %w", err)
+	}
+
+	podPath := fmt.Sprintf("%s/%s", podsPath, url.PathEscape(name))
+	defer func() {
+		// The agent gets the usual grace period to cancel its job if we're
+		// interrupted.
+		if err := e.client.do(context.WithoutCancel(ctx), http.MethodDelete, podPath, nil, nil); err != nil {
+			var statusErr *kubeStatusError
+			if !errors.As(err, &statusErr) || statusErr.code != http.StatusNotFound {
+				log.Warn().Err(err).Str("pod", name).Msg("Error deleting pod")
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(podPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		var status podStatus
+		if err := e.client.do(ctx, http.MethodGet, podPath, nil, &status); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("getting pod: %w", err)
+		}
+
+		switch status.Status.Phase {
+		case "Succeeded":
+			return nil
+		case "Failed":
+			return fmt.Errorf("pod %s failed: %s", name, status.failureReason())
+		}
+	}
+}
+
+func podName(job *types.Job) string {
+	return fmt.Sprintf("buildkite-job-%s", job.UUID)
+}
+
+// podSpec builds the job's pod from the template, filling in the agent
+// container and scheduling constraints.
+func (e *KubernetesExecutor) podSpec(job *types.Job, agent AgentCommand) (map[string]any, error) {
+	// Round-trip the template so each job gets its own copy to modify.
+	data, err := json.Marshal(e.template)
+	if err != nil {
+		return nil, fmt.Errorf("copying pod template: %w", err)
+	}
+	pod := map[string]any{}
+	if err := json.Unmarshal(data, &pod); err != nil {
+		return nil, fmt.Errorf("copying pod template: %w", err)
+	}
+
+	pod["apiVersion"] = "v1"
+	pod["kind"] = "Pod"
+	metadata := childMap(pod, "metadata")
+	metadata["name"] = podName(job)
+	metadata["namespace"] = e.cfg.Namespace
+	childMap(metadata, "labels")["buildkite.com/job-uuid"] = job.UUID
+
+	spec := childMap(pod, "spec")
+	spec["restartPolicy"] = "Never"
+	if e.cfg.ServiceAccount != "" {
+		spec["serviceAccountName"] = e.cfg.ServiceAccount
+	}
+	for _, rule := range job.AgentQueryRules {
+		key, value, _ := strings.Cut(rule, "=")
+		if label, ok := e.cfg.NodeSelectorRules[key]; ok {
+			childMap(spec, "nodeSelector")[label] = value
+		}
+	}
+
+	containers, _ := spec["containers"].([]any)
+	var container map[string]any
+	for _, c := range containers {
+		if c, ok := c.(map[string]any); ok && c["name"] == "agent" {
+			container = c
+		}
+	}
+	if container == nil {
+		container = map[string]any{"name": "agent"}
+		containers = append(containers, container)
+		spec["containers"] = containers
+	}
+
+	if e.cfg.Image != "" {
+		container["image"] = e.cfg.Image
+	}
+	container["command"] = []string{"buildkite-agent"}
+	container["args"] = agent.Args
+
+	env, _ := container["env"].([]any)
+	for _, variable := range agent.Env {
+		key, value, _ := strings.Cut(variable, "=")
+		if key == "BUILDKITE_AGENT_TOKEN" && e.cfg.TokenSecret != "" {
+			secret, secretKey, _ := strings.Cut(e.cfg.TokenSecret, "/")
+			env = append(env, map[string]any{
+				"name": key,
+				"valueFrom": map[string]any{
+					"secretKeyRef": map[string]any{"name": secret, "key": secretKey},
+				},
+			})
+			continue
+		}
+		env = append(env, map[string]any{"name": key, "value": value})
+	}
+	container["env"] = env
+
+	// Request what the scheduler matched the job against.
+	if len(job.Resources) > 0 {
+		requests := childMap(childMap(container, "resources"), "requests")
+		if cpu, ok := job.Resources["cpu"]; ok {
+			requests["cpu"] = strconv.FormatFloat(cpu, 'f', -1, 64)
+		}
+		if mem, ok := job.Resources["mem"]; ok {
+			requests["memory"] = strconv.FormatFloat(mem, 'f', 0, 64)
+		}
+	}
+
+	if e.cfg.Limits.CPU > 0 {
+		childMap(childMap(container, "resources"), "limits")["cpu"] = strconv.FormatFloat(e.cfg.Limits.CPU, 'f', -1, 64)
+	}
+	if e.cfg.Limits.Memory > 0 {
+		childMap(childMap(container, "resources"), "limits")["memory"] = strconv.FormatInt(e.cfg.Limits.Memory, 10)
+	}
+
+	return pod, nil
+}
+
+// childMap returns m[key] as a map, creating it if needed.
+func childMap(m map[string]any, key string) map[string]any {
+	child, ok := m[key].(map[string]any)
+	if !ok {
+		child = map[string]any{}
+		m[key] = child
+	}
+	return child
+}
+
+type podStatus struct {
+	Status struct {
+		Phase             string `json:"phase"`
+		Message           string `json:"message"`
+		ContainerStatuses []struct {
+			Name  string `json:"name"`
+			State struct {
+				Terminated *struct {
+					ExitCode int    `json:"exitCode"`
+					Reason   string `json:"reason"`
+				} `json:"terminated"`
+			} `json:"state"`
+		} `json:"containerStatuses"`
+	} `json:"status"`
+}
+
+func (s podStatus) failureReason() string {
+	for _, container := range s.Status.ContainerStatuses {
+		if container.Name == "agent" && container.State.Terminated != nil {
+			return fmt.Sprintf("agent exited with code %d (%s)", container.State.Terminated.ExitCode, container.State.Terminated.Reason)
+		}
+	}
+	return s.Status.Message
+}
+
+// kubeClient is a minimal client for the Kubernetes REST API.
+type kubeClient struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+}
+
+func newKubeClient(apiServer string) (*kubeClient, error) {
+	if apiServer != "" {
+		return &kubeClient{baseURL: strings.TrimSuffix(apiServer, "/"), httpClient: &http.Client{Timeout: 30 * time.Second}}, nil
+	}
+
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a cluster and no Kubernetes API server given")
+	}
+	token, err := os.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return nil, fmt.Errorf("reading service account token: %w", err)
+	}
+	ca, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("reading cluster CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("parsing cluster CA")
+	}
+
+	return &kubeClient{
+		baseURL: fmt.Sprintf("https://%s", net.JoinHostPort(host, port)),
+		token:   strings.TrimSpace(string(token)),
+		httpClient: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+	}, nil
+}
+
+type kubeStatusError struct {
+	code    int
+	message string
+}
+
+func (e *kubeStatusError) Error() string {
+	return fmt.Sprintf("unexpected status %d: %s", e.code, e.message)
+}
+
+func (c *kubeClient) do(ctx context.Context, method, path string, body, out any) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("marshaling request: %w", err)
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reqBody)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return &kubeStatusError{code: resp.StatusCode, message: string(respBody)}
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("decoding response: %w", err)
+		}
+	}
+	return nil
+}
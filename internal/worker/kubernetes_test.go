@@ -0,0 +1,154 @@
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+)
+
+func TestNewKubernetesExecutorNeedsImage(t *testing.T) {
+	dir := t.TempDir()
+	template := func(name, pod string) string {
+		path := filepath.Join(dir, name+".json")
+		if err := os.WriteFile(path, []byte(pod), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	for _, tc := range []struct {
+		name  string
+		cfg   KubernetesConfig
+		valid bool
+	}{
+		{"image", KubernetesConfig{Image: "buildkite/agent:3"}, true},
+		{"no image", KubernetesConfig{}, false},
+		{"template image", KubernetesConfig{PodTemplate: template("agent", `{"spec":{"containers":[{"name":"agent","image":"agent:custom"}]}}`)}, true},
+		{"template without agent image", KubernetesConfig{PodTemplate: template("sidecar", `{"spec":{"containers":[{"name":"sidecar","image":"proxy"}]}}`)}, false},
+	} {
+		tc.cfg.APIServer = "http://kubernetes.invalid"
+		_, err := NewKubernetesExecutor(tc.cfg)
+		if (err == nil) != tc.valid {
+			t.Errorf("%s: got %v, want valid %v", tc.name, err, tc.valid)
+		}
+	}
+}
+
+func TestPodSpec(t *testing.T) {
+	job := &types.Job{
+		UUID:            "abc",
+		AgentQueryRules: []string{"queue=k8s", "arch=arm64"},
+		Resources:       types.Resources{"cpu": 2, "mem": 4e9},
+	}
+	agent := AgentCommand{
+		Args: []string{"start", "--acquire-job=abc"},
+		Env:  []string{"BUILDKITE_AGENT_TOKEN=secret", "BUILDKITE_BUILD_PATH=/builds"},
+	}
+
+	for _, tc := range []struct {
+		name     string
+		cfg      KubernetesConfig
+		template string
+		want     string
+	}{
+		{
+			name: "no template",
+			cfg:  KubernetesConfig{Namespace: "ci", Image: "buildkite/agent:3"},
+			want: `{
+				"apiVersion": "v1", "kind": "Pod",
+				"metadata": {"name": "buildkite-job-abc", "namespace": "ci", "labels": {"buildkite.com/job-uuid": "abc"}},
+				"spec": {
+					"restartPolicy": "Never",
+					"containers": [{
+						"name": "agent", "image": "buildkite/agent:3",
+						"command": ["buildkite-agent"], "args": ["start", "--acquire-job=abc"],
+						"env": [{"name": "BUILDKITE_AGENT_TOKEN", "value": "secret"}, {"name": "BUILDKITE_BUILD_PATH", "value": "/builds"}],
+						"resources": {"requests": {"cpu": "2", "memory": "4000000000"}}
+					}]
+				}
+			}`,
+		},
+		{
+			name: "template",
+			cfg: KubernetesConfig{
+				Namespace:         "ci",
+				ServiceAccount:    "agent",
+				NodeSelectorRules: map[string]string{"arch": "kubernetes.io/arch"},
+				TokenSecret:       "agent-token/token",
+				Limits:            JobLimits{CPU: 4, Memory: 8e9},
+			},
+			template: `{
+				"metadata": {"labels": {"team": "payments"}},
+				"spec": {
+					"nodeSelector": {"pool": "ci"},
+					"containers": [
+						{"name": "proxy", "image": "proxy:1"},
+						{"name": "agent", "image": "agent:custom", "env": [{"name": "HTTP_PROXY", "value": "http://localhost:3128"}]}
+					]
+				}
+			}`,
+			want: `{
+				"apiVersion": "v1", "kind": "Pod",
+				"metadata": {"name": "buildkite-job-abc", "namespace": "ci", "labels": {"team": "payments", "buildkite.com/job-uuid": "abc"}},
+				"spec": {
+					"restartPolicy": "Never",
+					"serviceAccountName": "agent",
+					"nodeSelector": {"pool": "ci", "kubernetes.io/arch": "arm64"},
+					"containers": [
+						{"name": "proxy", "image": "proxy:1"},
+						{
+							"name": "agent", "image": "agent:custom",
+							"command": ["buildkite-agent"], "args": ["start", "--acquire-job=abc"],
+							"env": [
+								{"name": "HTTP_PROXY", "value": "http://localhost:3128"},
+								{"name": "BUILDKITE_AGENT_TOKEN", "valueFrom": {"secretKeyRef": {"name": "agent-token", "key": "token"}}},
+								{"name": "BUILDKITE_BUILD_PATH", "value": "/builds"}
+							],
+							"resources": {
+								"requests": {"cpu": "2", "memory": "4000000000"},
+								"limits": {"cpu": "4", "memory": "8000000000"}
+							}
+						}
+					]
+				}
+			}`,
+		},
+	} {
+		e := &KubernetesExecutor{cfg: tc.cfg, template: map[string]any{}}
+		if tc.template != "" {
+			if err := json.Unmarshal([]byte(tc.template), &e.template); err != nil {
+				t.Fatal(err)
+			}
+		}
+		pod, err := e.podSpec(job, agent)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		// Compare as decoded JSON, as the spec is sent.
+		data, err := json.Marshal(pod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got, want any
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal([]byte(tc.want), &want); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got pod %s", tc.name, data)
+		}
+
+		// Each job gets its own copy of the template.
+		if containers := e.template["spec"]; tc.template != "" && strings.Contains(fmt.Sprint(containers), "buildkite-agent") {
+			t.Errorf("%s: pod spec modified the template", tc.name)
+		}
+	}
+}
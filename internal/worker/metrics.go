@@ -0,0 +1,38 @@
+package worker
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/metrics"
+)
+
+// Metrics holds the worker's metrics, kept apart from the server's so the
+// worker's listener serves only its own.
+var Metrics = &metrics.Registry{}
+
+var (
+	claimsAttempted = Metrics.NewCounterVec("scheduler_worker_claims_total",
+		"Claim requests made to the API server, by whether they got a job, got none or failed.", "result")
+	jobsRun = Metrics.NewCounterVec("scheduler_worker_jobs_total",
+		"Claimed jobs run, by result.", "result")
+	agentDuration = Metrics.NewHistogramVec("scheduler_worker_agent_duration_seconds",
+		"Time each run of the agent took, by whether it succeeded.",
+		[]float64{1, 5, 15, 30, 60, 120, 300, 600, 1200, 1800, 3600, 7200}, "result")
+	acquireFailures = Metrics.NewCounterVec("scheduler_worker_acquire_failures_total",
+		"Agent runs that failed within the acquire window, by whether they were retried.", "outcome")
+
+	// slotsTotal and slotsBusy count the job slots of every runner in the
+	// process, and those of them running a job.
+	slotsTotal, slotsBusy atomic.Int64
+
+	_ = Metrics.NewGaugeFunc("scheduler_worker_slots",
+		"Job slots in this process, by whether they're running a job.", []string{"state"},
+		func(ctx context.Context) ([]metrics.Sample, error) {
+			busy := slotsBusy.Load()
+			return []metrics.Sample{
+				{Labels: []string{"busy"}, Value: float64(busy)},
+				{Labels: []string{"idle"}, Value: float64(slotsTotal.Load() - busy)},
+			}, nil
+		})
+)
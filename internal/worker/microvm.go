@@ -0,0 +1,107 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+	"github.com/rs/zerolog/log"
+)
+
+// MicroVMConfig describes the Firecracker microVMs MicroVMExecutor boots with
+// ignite.
+type MicroVMConfig struct {
+	IgnitePath string
+	// Image is an OCI image with buildkite-agent on its PATH, booted as the
+	// VM's root filesystem.
+	Image string
+	// KernelImage overrides ignite's default kernel.
+	KernelImage string
+	CPUs        int
+	Memory      string
+	DiskSize    string
+}
+
+// MicroVMExecutor boots a throwaway Firecracker microVM per job and runs the
+// agent inside it, so untrusted builds can't reach the host or each other. The
+// VM is removed once the agent exits.
+type MicroVMExecutor struct {
+	cfg MicroVMConfig
+}
+
+func NewMicroVMExecutor(cfg MicroVMConfig) *MicroVMExecutor {
+	return &MicroVMExecutor{cfg: cfg}
+}
+
+func (e *MicroVMExecutor) Run(ctx context.Context, job *types.Job, agent AgentCommand, started func(pid int)) error {
+	name := fmt.Sprintf("buildkite-job-%s", job.UUID)
+	logger := log.With().Str("job_uuid", job.UUID).Str("vm", name).Logger()
+
+	runArgs := []string{"run", e.cfg.Image, "--name", name, "--ssh"}
+	if e.cfg.KernelImage != "" {
+		runArgs = append(runArgs, "--kernel-image", e.cfg.KernelImage)
+	}
+	if e.cfg.CPUs > 0 {
+		runArgs = append(runArgs, "--cpus", fmt.Sprint(e.cfg.CPUs))
+	}
+	if e.cfg.Memory != "" {
+		runArgs = append(runArgs, "--memory", e.cfg.Memory)
+	}
+	if e.cfg.DiskSize != "" {
+		runArgs = append(runArgs, "--size", e.cfg.DiskSize)
+	}
+	if output, err := exec.CommandContext(ctx, e.cfg.IgnitePath, runArgs...).CombinedOutput(); err != nil {
+		return fmt.Errorf("booting microVM: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	logger.Info().Msg("Booted microVM")
+
+	defer func() {
+		output, err := exec.CommandContext(context.WithoutCancel(ctx), e.cfg.IgnitePath, "rm", "--force", name).CombinedOutput()
+		if err != nil {
+			logger.Warn().Err(err).Str("output", strings.TrimSpace(string(output))).Msg("Error removing microVM")
+		}
+	}()
+
+	// Copy the agent's environment in as a file rather than putting secrets
+	// such as the token on a command line.
+	envFile, err := os.CreateTemp("", "buildkite-env-")
+	if err != nil {
+		return fmt.Errorf("creating env file: %w", err)
+	}
+	defer os.Remove(envFile.Name())
+	for _, variable := range agent.Env {
+		key, value, _ := strings.Cut(variable, "=")
+		fmt.Fprintf(envFile, "export %s=%s\n", key, shellQuote(value))
+	}
+	if err := envFile.Close(); err != nil {
+		return fmt.Errorf("writing env file: %w", err)
+	}
+	const vmEnvFile = "/tmp/buildkite-env"
+	if output, err := exec.CommandContext(ctx, e.cfg.IgnitePath, "cp", envFile.Name(), name+":"+vmEnvFile).CombinedOutput(); err != nil {
+		return fmt.Errorf("copying env into microVM: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	// ignite exec runs its command through a shell over SSH, so it's passed as
+	// one quoted string.
+	script := []string{fmt.Sprintf(". %s && rm %s && exec buildkite-agent", vmEnvFile, vmEnvFile)}
+	for _, arg := range agent.Args {
+		script = append(script, shellQuote(arg))
+	}
+	cmd := exec.CommandContext(ctx, e.cfg.IgnitePath, "exec", name, strings.Join(script, " "))
+	// Signalling the local ignite process wouldn't reach the agent, so signal
+	// it inside the VM instead.
+	cmd.Cancel = func() error {
+		return exec.Command(e.cfg.IgnitePath, "exec", name, "pkill -TERM -x buildkite-agent").Run()
+	}
+	if err := runCommand(cmd, agent, started); err != nil {
+		return fmt.Errorf("running agent in microVM: %w", err)
+	}
+	return nil
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
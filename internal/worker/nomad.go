@@ -0,0 +1,181 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+	"github.com/rs/zerolog/log"
+)
+
+// nomadPollInterval is how often a dispatched Nomad job is checked for
+// completion.
+const nomadPollInterval = 5 * time.Second
+
+// NomadConfig describes the parameterized Nomad job NomadExecutor dispatches.
+type NomadConfig struct {
+	Address   string
+	Token     string
+	Namespace string
+	// Job is the parameterized job to dispatch. It is passed the Buildkite job
+	// UUID, the agent's arguments and its environment as meta; see the README.
+	Job string
+}
+
+// NomadExecutor dispatches a parameterized Nomad job per Buildkite job and
+// waits for the dispatched job to finish.
+type NomadExecutor struct {
+	cfg        NomadConfig
+	httpClient *http.Client
+}
+
+func NewNomadExecutor(cfg NomadConfig) (*NomadExecutor, error) {
+	if cfg.Job == "" {
+		return nil, fmt.Errorf("a parameterized job is required for the nomad executor")
+	}
+	cfg.Address = strings.TrimSuffix(cfg.Address, "/")
+	return &NomadExecutor{cfg: cfg, httpClient: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+type nomadAllocation struct {
+	ID                string `json:"ID"`
+	ClientStatus      string `json:"ClientStatus"`
+	ClientDescription string `json:"ClientDescription"`
+	TaskStates        map[string]struct {
+		Failed bool `json:"Failed"`
+	} `json:"TaskStates"`
+}
+
+func (e *NomadExecutor) Run(ctx context.Context, job *types.Job, agent AgentCommand, started func(pid int)) error {
+	meta := map[string]string{
+		"buildkite_job_uuid":   job.UUID,
+		"buildkite_agent_args": strings.Join(agent.Args, " "),
+	}
+	for _, variable := range agent.Env {
+		key, value, _ := strings.Cut(variable, "=")
+		meta[strings.ToLower(key)] = value
+	}
+
+	var dispatch struct {
+		DispatchedJobID string `json:"DispatchedJobID"`
+	}
+	dispatchPath := fmt.Sprintf("/v1/job/%s/dispatch", url.PathEscape(e.cfg.Job))
+	if err := e.call(ctx, http.MethodPost, dispatchPath, map[string]any{"Meta": meta}, &dispatch); err != nil {
+		return fmt.Errorf("dispatching nomad job: %w", err)
+	}
+	jobID := dispatch.DispatchedJobID
+	jobPath := fmt.Sprintf("/v1/job/%s", url.PathEscape(jobID))
+	logger := log.With().Str("job_uuid", job.UUID).Str("nomad_job", jobID).Logger()
+	logger.Info().Msg("Dispatched Nomad job")
+
+	ticker := time.NewTicker(nomadPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Stopping the job signals its tasks with their kill signal, so
+			// the agent can cancel the build.
+			if err := e.call(context.WithoutCancel(ctx), http.MethodDelete, jobPath, nil, nil); err != nil {
+				logger.Warn().Err(err).Msg("Error stopping Nomad job")
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		var status struct {
+			Status string `json:"Status"`
+		}
+		if err := e.call(ctx, http.MethodGet, jobPath, nil, &status); err != nil {
+			if ctx.Err() != nil {
+				continue
+			}
+			return fmt.Errorf("getting nomad job: %w", err)
+		}
+		if status.Status != "dead" {
+			continue
+		}
+
+		var allocations []nomadAllocation
+		if err := e.call(ctx, http.MethodGet, jobPath+"/allocations", nil, &allocations); err != nil {
+			if ctx.Err() != nil {
+				continue
+			}
+			return fmt.Errorf("listing nomad allocations: %w", err)
+		}
+		return allocationsResult(allocations)
+	}
+}
+
+// allocationsResult succeeds if any allocation of a finished job completed
+// without a failed task, since Nomad may have rescheduled failed ones.
+func allocationsResult(allocations []nomadAllocation) error {
+	for _, alloc := range allocations {
+		if alloc.ClientStatus != "complete" {
+			continue
+		}
+		failed := false
+		for _, task := range alloc.TaskStates {
+			failed = failed || task.Failed
+		}
+		if !failed {
+			return nil
+		}
+	}
+	if len(allocations) == 0 {
+		return fmt.Errorf("nomad job finished without running")
+	}
+	last := allocations[0]
+	return fmt.Errorf("nomad allocation %s %s: %s", last.ID, last.ClientStatus, last.ClientDescription)
+}
+
+// call makes a Nomad API request, decoding the response into out if non-nil.
+func (e *NomadExecutor) call(ctx context.Context, method, path string, body, out any) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("marshaling request: %w", err)
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	requestURL := e.cfg.Address + path
+	if e.cfg.Namespace != "" {
+		requestURL += "?namespace=" + url.QueryEscape(e.cfg.Namespace)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, requestURL, reqBody)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if e.cfg.Token != "" {
+		req.Header.Set("X-Nomad-Token", e.cfg.Token)
+	}
+
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(respBody))
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("decoding response: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// preflightCacheTTL is how long a preflight result is reused, so slots
+// claiming back to back don't rerun the checks for every job.
+const preflightCacheTTL = 10 * time.Second
+
+// PreflightConfig describes the host checks a worker runs before claiming a
+// job. Checks with zero values are skipped.
+type PreflightConfig struct {
+	// DiskPath must have at least MinFreeDisk bytes free.
+	DiskPath    string
+	MinFreeDisk uint64
+	// DockerPath is a docker CLI that must be able to reach its daemon.
+	DockerPath string
+	// MaxClockSkew is how far the host clock may drift from the API server's.
+	MaxClockSkew time.Duration
+}
+
+func (c PreflightConfig) enabled() bool {
+	return c.MinFreeDisk > 0 || c.DockerPath != "" || c.MaxClockSkew > 0
+}
+
+// hostHealth is the latest preflight result, shared by all slots.
+type hostHealth struct {
+	mu        sync.Mutex
+	checkedAt time.Time
+	err       error
+}
+
+// preflight runs the host checks, or returns the recent result. The server is
+// told whenever the result changes, so it stops counting an unhealthy worker
+// as capacity.
+func (r *Runner) preflight(ctx context.Context) error {
+	if r.health == nil {
+		return nil
+	}
+	r.health.mu.Lock()
+	defer r.health.mu.Unlock()
+	if time.Since(r.health.checkedAt) < preflightCacheTTL {
+		return preflightError(r.health.err)
+	}
+
+	err := r.runPreflightChecks(ctx)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	changed := r.health.checkedAt.IsZero() || (err == nil) != (r.health.err == nil)
+	r.health.checkedAt = time.Now()
+	r.health.err = err
+
+	if changed {
+		if err != nil {
+			r.logger.Warn().Err(err).Msg("Preflight checks failed, not claiming jobs")
+		} else {
+			r.logger.Info().Msg("Preflight checks passed")
+		}
+		if reportErr := r.sendWorkerHeartbeat(ctx, err); reportErr != nil {
+			r.logger.Warn().Err(reportErr).Msg("Error reporting worker health")
+		}
+	}
+	return preflightError(err)
+}
+
+func preflightError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("preflight checks failed: %w", err)
+}
+
+// healthError returns the latest preflight failure, if any.
+func (r *Runner) healthError() error {
+	r.health.mu.Lock()
+	defer r.health.mu.Unlock()
+	return r.health.err
+}
+
+func (r *Runner) runPreflightChecks(ctx context.Context) error {
+	cfg := r.cfg.Preflight
+	var failures []string
+
+	if cfg.MinFreeDisk > 0 {
+		free, err := freeDiskSpace(cfg.DiskPath)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("checking free disk space on %s: %v", cfg.DiskPath, err))
+		} else if free < cfg.MinFreeDisk {
+			failures = append(failures, fmt.Sprintf("only %dMiB free on %s, need %dMiB", free>>20, cfg.DiskPath, cfg.MinFreeDisk>>20))
+		}
+	}
+
+	if cfg.DockerPath != "" {
+		dockerCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		output, err := exec.CommandContext(dockerCtx, cfg.DockerPath, "info", "--format", "{{.ServerVersion}}").CombinedOutput()
+		cancel()
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("docker daemon unavailable: %v: %s", err, strings.TrimSpace(string(output))))
+		}
+	}
+
+	if cfg.MaxClockSkew > 0 {
+		skew, err := r.clockSkew(ctx)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("checking clock skew: %v", err))
+		} else if skew > cfg.MaxClockSkew {
+			failures = append(failures, fmt.Sprintf("clock is %s out from the API server", skew.Round(time.Second)))
+		}
+	}
+
+	if len(failures) > 0 {
+		return errors.New(strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// clockSkew compares the host clock with the Date header of an API server
+// response. Date has one second resolution, so small skews don't register.
+func (r *Runner) clockSkew(ctx context.Context) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.cfg.APIServer+"/health", nil)
+	if err != nil {
+		return 0, fmt.Errorf("creating request: %w", err)
+	}
+	sent := time.Now()
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	received := time.Now()
+
+	serverTime, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, fmt.Errorf("parsing server date: %w", err)
+	}
+	local := sent.Add(received.Sub(sent) / 2)
+	skew := local.Sub(serverTime)
+	if skew < 0 {
+		skew = -skew
+	}
+	// Allow for the server truncating to the second.
+	return max(skew-time.Second, 0), nil
+}
@@ -0,0 +1,57 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+)
+
+// registrationInterval is how often the worker re-registers, so the server's
+// registry catches up if it lost the worker's details.
+const registrationInterval = 5 * time.Minute
+
+// registerWorker tells the server what this worker is and can do, then keeps
+// re-registering until ctx is cancelled.
+func (r *Runner) registerWorker(ctx context.Context, subs []Subscription) {
+	ticker := time.NewTicker(registrationInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.register(ctx, subs); err != nil && ctx.Err() == nil {
+			r.logger.Warn().Err(err).Msg("Error registering worker")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Runner) register(ctx context.Context, subs []Subscription) error {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	worker := types.Worker{
+		ID:        r.cfg.WorkerID,
+		Hostname:  hostname,
+		Tags:      r.cfg.Tags,
+		Executor:  r.cfg.ExecutorType,
+		Resources: r.cfg.Resources,
+	}
+	for _, sub := range subs {
+		worker.QueryRules = append(worker.QueryRules, types.NormalizeQueryRules(r.subscriber(sub).queryRules()))
+		worker.Concurrency += sub.Concurrency
+	}
+
+	body, err := json.Marshal(worker)
+	if err != nil {
+		return fmt.Errorf("marshaling registration: %w", err)
+	}
+	return r.post(ctx, r.cfg.APIServer+"/v1/workers", "", body)
+}
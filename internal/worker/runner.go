@@ -1,92 +1,463 @@
 package worker
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
-	"os/exec"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/buildkite/buildkite-custom-scheduler/internal/tracing"
 	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
 	"github.com/rs/zerolog"
-	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// Config describes how a worker finds jobs and runs the agent for them.
+type Config struct {
+	APIServer string
+	// ServerToken, if set, is sent to the API server as a bearer token.
+	ServerToken     string
+	AgentQueryRules []string
+	Tags            []string
+	Queue           string
+	BuildkiteToken  string
+	PollInterval    time.Duration
+	WorkerID        string
+
+	// Executor runs the agent for each job, and ExecutorType names it when
+	// the worker registers.
+	Executor     Executor
+	ExecutorType string
+
+	// Resources advertises this worker's capacity (e.g. cpu=8, mem=16g) for
+	// resource-aware scheduling.
+	Resources []string
+
+	// StateFile, when set, is where the worker records its in-flight job so it
+	// can resume or settle it after a restart.
+	StateFile string
+
+	// ClaimWait is how long the server may hold a claim request open waiting
+	// for a matching job. Zero polls without waiting.
+	ClaimWait time.Duration
+
+	// Stream subscribes to the server's job offers, so idle slots claim as
+	// soon as a job is waiting, falling back to polling while disconnected.
+	Stream bool
+
+	// MaxBackoff caps how long the worker waits before retrying after repeated
+	// errors claiming or running jobs. Backoff starts at PollInterval.
+	MaxBackoff time.Duration
+
+	// DrainTimeout is how long running jobs get to finish once the worker is
+	// stopped before their agents are interrupted.
+	DrainTimeout time.Duration
+
+	// PreJobHook and PostJobHook are executables run on the worker host
+	// before and after each job's agent, with the job's details in their
+	// environment. A failing pre-job hook aborts the job; a failing post-job
+	// hook fails it.
+	PreJobHook  string
+	PostJobHook string
+
+	// Concurrency is how many jobs the worker runs at once, each in its own
+	// slot. Values below one are treated as one.
+	Concurrency int
+
+	// Subscriptions, when set, replace AgentQueryRules, Queue and Concurrency
+	// with several rule sets to claim for, each with its own slots. Tags
+	// apply to all of them.
+	Subscriptions []Subscription
+
+	// TerminationNotices, "aws" or "gcp", watches the instance metadata
+	// service for spot or preemption notices. On notice the worker stops
+	// claiming, interrupts its jobs so they're retried elsewhere and
+	// deregisters, without waiting for DrainTimeout.
+	TerminationNotices string
+
+	// IdleTimeout, when non-zero, stops the worker once it has gone this long
+	// without a job, so autoscaled fleets can scale in. With DeregisterOnIdle
+	// the worker also deregisters, requeueing anything it claimed as it
+	// stopped.
+	IdleTimeout      time.Duration
+	DeregisterOnIdle bool
+
+	// Preflight are host checks run before claiming. While they fail the
+	// worker doesn't claim jobs and reports itself unhealthy to the server.
+	Preflight PreflightConfig
+
+	// AcquireRetries is how many more times to run the agent for a job when
+	// it fails within AcquireWindow of starting. A failure that fast usually
+	// means the agent couldn't acquire the job, say from a network blip or a
+	// Buildkite 5xx, rather than that the build failed.
+	AcquireRetries int
+	AcquireWindow  time.Duration
+
+	// BuildDirs gives each job its own build directory, cleaned up after it.
+	BuildDirs BuildDirConfig
+
+	// Logs says where job output is captured and uploaded, beyond the
+	// worker's own log.
+	Logs LogConfig
+
+	// HeartbeatInterval is how often to tell the server this worker is alive
+	// and renew the claim on a running job, so the server doesn't mark the
+	// worker offline or requeue the job. Zero disables heartbeats.
+	HeartbeatInterval time.Duration
+}
+
 type Runner struct {
-	apiServer          string
-	agentQueryRules    []string
-	tags               []string
-	queue              string
-	buildkiteAgentPath string
-	buildkiteToken     string
-	pollInterval       time.Duration
-	httpClient         *http.Client
-	workerID           string
-	logger             zerolog.Logger
-}
-
-func NewRunner(apiServer string, agentQueryRules, tags []string, queue, buildkiteAgentPath, buildkiteToken string, pollInterval time.Duration, workerID string, logger zerolog.Logger) *Runner {
-	return &Runner{
-		apiServer:          apiServer,
-		agentQueryRules:    agentQueryRules,
-		tags:               tags,
-		queue:              queue,
-		buildkiteAgentPath: buildkiteAgentPath,
-		buildkiteToken:     buildkiteToken,
-		pollInterval:       pollInterval,
+	cfg        Config
+	httpClient *http.Client
+	logger     zerolog.Logger
+
+	// usage is shared by all slots, and nil when no resources are advertised.
+	usage *resourceUsage
+
+	// offers is shared by all slots, and nil unless streaming.
+	offers *offerStream
+
+	// idle is shared by all slots, and nil without an idle timeout.
+	idle *idleTracker
+
+	// health is shared by all slots, and nil without preflight checks.
+	health *hostHealth
+
+	// buildDirs is shared by all slots, and nil without build directories.
+	buildDirs *buildDirs
+
+	// awsCreds signs job log uploads to S3.
+	awsCreds *awsCredentialSource
+}
+
+func NewRunner(cfg Config, logger zerolog.Logger) *Runner {
+	r := &Runner{
+		cfg: cfg,
 		httpClient: &http.Client{
-			Timeout: 10 * time.Second,
+			Timeout:   10*time.Second + cfg.ClaimWait,
+			Transport: tracing.Transport("scheduler", http.DefaultTransport),
 		},
-		workerID: workerID,
-		logger:   logger,
+		logger: logger,
+	}
+	if cfg.Logs.Upload == "s3" {
+		r.awsCreds = &awsCredentialSource{httpClient: &http.Client{Timeout: 10 * time.Second}}
 	}
+	return r
 }
 
 func (r *Runner) Start(ctx context.Context) error {
-	r.logger.Info().Strs("query_rules", r.agentQueryRules).Msg("Starting worker")
-	r.logger.Info().Dur("poll_interval", r.pollInterval).Msg("Poll interval")
+	subs := r.subscriptions()
+	slots := 0
+	for _, sub := range subs {
+		r.logger.Info().Strs("query_rules", sub.AgentQueryRules).Int("concurrency", sub.Concurrency).Msg("Starting worker")
+		slots += sub.Concurrency
+	}
+	slotsTotal.Add(int64(slots))
+	defer slotsTotal.Add(-int64(slots))
+	r.logger.Info().Dur("poll_interval", r.cfg.PollInterval).Msg("Poll interval")
+
+	if len(r.cfg.Resources) > 0 {
+		total, err := types.ParseResources(r.cfg.Resources, nil)
+		if err != nil {
+			return fmt.Errorf("parsing resources: %w", err)
+		}
+		r.usage = newResourceUsage(total)
+	}
 
-	ticker := time.NewTicker(r.pollInterval)
-	defer ticker.Stop()
+	// Running jobs get their own context so they can finish after ctx is
+	// cancelled. It's only cancelled if they outlast the drain timeout, or the
+	// instance is going away.
+	jobCtx, killJobs := context.WithCancelCause(context.WithoutCancel(ctx))
+	defer killJobs(nil)
+
+	ctx, stopClaiming := context.WithCancel(ctx)
+	defer stopClaiming()
+
+	// background are the goroutines besides the slots, such as heartbeats.
+	// They stop with jobCtx, and are waited for, within reason, so nothing is
+	// still talking to the server once Start returns.
+	var background sync.WaitGroup
+	spawn := func(f func()) {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			f()
+		}()
+	}
+	defer func() {
+		stopClaiming()
+		killJobs(nil)
+		if !waitFor(&background, shutdownWait) {
+			r.logger.Warn().Dur("waited", shutdownWait).Msg("Background work still running, stopping anyway")
+		}
+	}()
 
-	for {
-		select {
-		case <-ctx.Done():
-			r.logger.Info().Msg("Worker shutting down")
-			return ctx.Err()
-		case <-ticker.C:
-			if err := r.processNextJob(ctx); err != nil {
-				if err != ErrNoJobAvailable {
-					r.logger.Error().Err(err).Msg("Error processing job")
-				}
+	if r.cfg.TerminationNotices != "" {
+		spawn(func() { r.watchTermination(jobCtx, stopClaiming, killJobs) })
+	}
+
+	if r.cfg.Preflight.enabled() {
+		r.health = &hostHealth{}
+	}
+
+	var stoppedIdle atomic.Bool
+	if r.cfg.IdleTimeout > 0 {
+		r.idle = newIdleTracker()
+		spawn(func() {
+			if r.watchIdle(ctx) {
+				r.logger.Info().Dur("idle_timeout", r.cfg.IdleTimeout).Msg("Worker idle, stopping")
+				stoppedIdle.Store(true)
+				stopClaiming()
+			}
+		})
+	}
+
+	if r.cfg.BuildDirs.Root != "" {
+		r.buildDirs = newBuildDirs()
+		if r.cfg.BuildDirs.MaxAge > 0 || r.cfg.BuildDirs.MaxSize > 0 {
+			spawn(func() { r.sweepBuildDirs(jobCtx) })
+		}
+	}
+
+	spawn(func() { r.registerWorker(jobCtx, subs) })
+	spawn(func() { r.heartbeatWorker(jobCtx) })
+
+	var wg sync.WaitGroup
+	// With several slots each keeps its job in a file of its own, leaving the
+	// base file to record the worker ID for the next run, once any job a
+	// single-slot run left in it is settled.
+	if r.cfg.StateFile != "" && slots > 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.idle.start()
+			if err := r.resumeJob(jobCtx); err != nil {
+				r.logger.Error().Err(err).Msg("Error resuming job from previous run")
 			}
+			r.idle.finish()
+			if err := r.saveState("", "", 0); err != nil {
+				r.logger.Error().Err(err).Msg("Error recording worker ID")
+			}
+		}()
+	}
+	id := 0
+	for _, sub := range subs {
+		subscriber := r.subscriber(sub)
+		// Each subscription streams offers for its own rules.
+		if r.cfg.Stream {
+			subscriber.offers = newOfferStream()
+			spawn(func() { subscriber.streamOffers(ctx) })
+		}
+		for range sub.Concurrency {
+			slot := subscriber.slot(id, slots)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				slot.runSlot(ctx, jobCtx)
+			}()
+			id++
+		}
+	}
+
+	<-ctx.Done()
+	r.logger.Info().Dur("drain_timeout", r.cfg.DrainTimeout).Msg("Worker draining, waiting for running jobs to finish")
+	if !waitFor(&wg, r.cfg.DrainTimeout) {
+		r.logger.Warn().Msg("Drain timeout reached, interrupting running jobs")
+		killJobs(nil)
+		if !waitFor(&wg, shutdownWait) {
+			r.logger.Warn().Dur("waited", shutdownWait).Msg("Jobs still running after being interrupted, stopping anyway")
 		}
 	}
+
+	if errors.Is(context.Cause(jobCtx), ErrInstanceTerminating) {
+		if err := r.deregister(context.WithoutCancel(ctx)); err != nil {
+			r.logger.Error().Err(err).Msg("Error deregistering worker")
+		}
+		r.logger.Info().Msg("Worker stopped for instance termination")
+		return nil
+	}
+	if stoppedIdle.Load() {
+		if r.cfg.DeregisterOnIdle {
+			if err := r.deregister(context.WithoutCancel(ctx)); err != nil {
+				r.logger.Error().Err(err).Msg("Error deregistering worker")
+			}
+		}
+		r.logger.Info().Msg("Worker stopped after idle timeout")
+		return nil
+	}
+	r.logger.Info().Msg("Worker stopped")
+	return ctx.Err()
+}
+
+// shutdownWait bounds how long Start waits, once it's stopping, for
+// interrupted jobs and background work to finish.
+const shutdownWait = 10 * time.Second
+
+// waitFor waits for wg for at most timeout, reporting whether it finished.
+func waitFor(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
 }
 
 var ErrNoJobAvailable = fmt.Errorf("no job available")
 
-func (r *Runner) processNextJob(ctx context.Context) error {
-	job, err := r.getJob(ctx)
+// ErrJobCancelled is returned by the server for jobs cancelled in Buildkite.
+var ErrJobCancelled = fmt.Errorf("job was cancelled")
+
+// processNextJob claims a job using ctx and runs it using jobCtx, so a job that
+// has been claimed can finish after the worker stops claiming.
+func (r *Runner) processNextJob(ctx, jobCtx context.Context) error {
+	if err := r.preflight(ctx); err != nil {
+		return err
+	}
+
+	claimCtx, claimSpan := tracing.Start(ctx, "claim", trace.SpanKindInternal,
+		attribute.String("query_rules", types.NormalizeQueryRules(r.queryRules())))
+	job, err := r.getJob(claimCtx)
+	if job != nil {
+		claimSpan.SetAttributes(attribute.String("job.uuid", job.UUID))
+	}
+	tracing.End(claimSpan, err)
 	if err != nil {
+		claimsAttempted.With("error").Inc()
 		return err
 	}
 	if job == nil {
+		claimsAttempted.With("none").Inc()
 		return ErrNoJobAvailable
 	}
+	claimsAttempted.With("job").Inc()
+	slotsBusy.Add(1)
+	defer slotsBusy.Add(-1)
+
+	jobCtx, jobSpan := tracing.Start(jobCtx, "job", trace.SpanKindInternal,
+		attribute.String("job.uuid", job.UUID), attribute.String("job.queue", job.QueueKey))
+	defer jobSpan.End()
 
 	r.logger.Info().Str("uuid", job.UUID).Str("queue", job.QueueKey).Strs("rules", job.AgentQueryRules).Msg("Claimed job")
+	r.idle.start()
+	defer r.idle.finish()
+	if r.usage != nil {
+		defer r.usage.release(job.Resources)
+	}
+
+	if err := r.saveState(job.UUID, job.ClaimToken, 0); err != nil {
+		r.logger.Error().Err(err).Str("uuid", job.UUID).Msg("Error saving worker state")
+	}
+	defer func() {
+		// If we're shutting down mid-job, leave the state for the next run to
+		// settle.
+		if jobCtx.Err() != nil {
+			return
+		}
+		if err := r.saveState("", "", 0); err != nil {
+			r.logger.Error().Err(err).Msg("Error clearing worker state")
+		}
+	}()
+
+	// Once started, the job can no longer be requeued to another worker, so
+	// don't run it if the server has already given it away.
+	if err := r.postJobAction(jobCtx, job.UUID, job.ClaimToken, "start"); err != nil {
+		if errors.Is(err, ErrJobCancelled) {
+			r.logger.Info().Str("uuid", job.UUID).Msg("Job was cancelled before it started")
+			return nil
+		}
+		r.logger.Error().Err(err).Str("uuid", job.UUID).Msg("Error marking job started")
+		return err
+	}
+
+	runCtx, cancelRun := context.WithCancelCause(jobCtx)
+	heartbeatCtx, stopHeartbeat := context.WithCancel(runCtx)
+	go r.heartbeatJob(heartbeatCtx, job.UUID, job.ClaimToken, cancelRun)
+	output := r.openJobLog(job)
+	var buildPath string
+	var hookEnv []string
+	if r.buildDirs != nil {
+		if buildPath, err = r.createBuildDir(job.UUID); err == nil {
+			defer r.releaseBuildDir(job.UUID)
+			hookEnv = append(hookEnv, "BUILDKITE_BUILD_PATH="+buildPath)
+		}
+	}
+	// A failing pre-job hook aborts the job without running the agent.
+	if err == nil {
+		err = r.runHook(runCtx, "pre-job", r.cfg.PreJobHook, job, output, hookEnv...)
+	}
+	if err == nil {
+		agentCtx, agentSpan := tracing.Start(runCtx, "agent", trace.SpanKindInternal, attribute.String("job.uuid", job.UUID))
+		err = r.runAgent(agentCtx, job, output, buildPath)
+		tracing.End(agentSpan, err)
+	}
+	stopHeartbeat()
+	cancelled := errors.Is(context.Cause(runCtx), ErrJobCancelled)
+	cancelRun(nil)
+
+	// The post-job hook runs after every job, so it can clean up after failed
+	// and cancelled ones too. If it fails, so does the job.
+	result := "passed"
+	switch {
+	case cancelled:
+		result = "cancelled"
+	case err != nil:
+		result = "failed"
+	}
+	if hookErr := r.runHook(jobCtx, "post-job", r.cfg.PostJobHook, job, output, append(hookEnv, "BUILDKITE_SCHEDULER_JOB_RESULT="+result)...); hookErr != nil && err == nil {
+		err = hookErr
+	}
+	if closeErr := output.Close(); closeErr != nil {
+		r.logger.Warn().Err(closeErr).Str("uuid", job.UUID).Msg("Error closing job log")
+	}
+
+	// The instance won't be around to settle the job after a restart, so
+	// settle it now. A run cut short is failed without counting against the
+	// job's attempts.
+	cause := context.Cause(jobCtx)
+	terminating := errors.Is(cause, ErrInstanceTerminating)
+	if terminating {
+		jobCtx = context.WithoutCancel(jobCtx)
+		if err != nil {
+			err = cause
+		}
+	}
+	if uploadErr := r.uploadJobLog(jobCtx, output, err != nil && !cancelled); uploadErr != nil {
+		r.logger.Warn().Err(uploadErr).Str("uuid", job.UUID).Msg("Error uploading job log")
+	}
 
-	if err := r.runAgent(ctx, job.UUID); err != nil {
-		r.logger.Error().Err(err).Str("uuid", job.UUID).Msg("Error running agent")
+	if cancelled {
+		jobsRun.With("cancelled").Inc()
+		r.logger.Info().Str("uuid", job.UUID).Msg("Interrupted cancelled job")
+		return nil
+	}
+	if err != nil {
+		jobsRun.With("failed").Inc()
+		r.logger.Error().Err(err).Str("uuid", job.UUID).Msg("Error running job")
+		tracing.Fail(jobSpan, err)
+		if failErr := r.failJob(jobCtx, job.UUID, job.ClaimToken, err, terminating); failErr != nil {
+			r.logger.Error().Err(failErr).Str("uuid", job.UUID).Msg("Error marking job failed")
+		}
 		return err
 	}
 
-	if err := r.completeJob(ctx, job.UUID); err != nil {
+	jobsRun.With("passed").Inc()
+	if err := r.completeJob(jobCtx, job.UUID, job.ClaimToken); err != nil {
 		r.logger.Error().Err(err).Str("uuid", job.UUID).Msg("Error marking job complete")
 	}
 
@@ -94,19 +465,35 @@ func (r *Runner) processNextJob(ctx context.Context) error {
 	return nil
 }
 
+// queryRules returns the rules the worker matches jobs with: its agent query
+// rules plus its queue.
+func (r *Runner) queryRules() []string {
+	if r.cfg.Queue == "" {
+		return r.cfg.AgentQueryRules
+	}
+	return append([]string{fmt.Sprintf("queue=%s", r.cfg.Queue)}, r.cfg.AgentQueryRules...)
+}
+
 func (r *Runner) getJob(ctx context.Context) (*types.Job, error) {
-	queryRules := r.agentQueryRules
-	if r.queue != "" {
-		queryRules = append([]string{fmt.Sprintf("queue=%s", r.queue)}, queryRules...)
+	params := url.Values{}
+	params.Set("query", types.NormalizeQueryRules(r.queryRules()))
+	// While the job stream is up, slots only claim when offered a job, so
+	// there's no point waiting for one.
+	if r.cfg.ClaimWait > 0 && (r.offers == nil || !r.offers.connected.Load()) {
+		params.Set("wait", r.cfg.ClaimWait.String())
+	}
+	if r.usage != nil {
+		r.usage.claiming.Lock()
+		defer r.usage.claiming.Unlock()
+		params.Set("resources", strings.Join(r.usage.free().Rules(), ","))
 	}
-	queryParam := types.NormalizeQueryRules(queryRules)
-	url := fmt.Sprintf("%s/jobs?query=%s", r.apiServer, queryParam)
+	jobsURL := fmt.Sprintf("%s/v1/jobs?%s", r.cfg.APIServer, params.Encode())
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, jobsURL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("creating request: %w", err)
 	}
-	req.Header.Set("X-Worker-ID", r.workerID)
+	r.setAPIHeaders(req)
 
 	resp, err := r.httpClient.Do(req)
 	if err != nil {
@@ -120,6 +507,12 @@ func (r *Runner) getJob(ctx context.Context) (*types.Job, error) {
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
+		// A paused queue is the same as an empty one: wait for the next poll.
+		var apiErr types.APIErrorResponse
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Error.Code == types.ErrorQueuePaused {
+			r.logger.Debug().Str("reason", apiErr.Error.Message).Msg("Queue is paused")
+			return nil, nil
+		}
 		return nil, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
 	}
 
@@ -127,14 +520,31 @@ func (r *Runner) getJob(ctx context.Context) (*types.Job, error) {
 	if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
 		return nil, fmt.Errorf("decoding job: %w", err)
 	}
+	if r.usage != nil {
+		r.usage.acquire(job.Resources)
+	}
 
 	return &job, nil
 }
 
-func (r *Runner) runAgent(ctx context.Context, jobUUID string) error {
-	allTags := make([]string, 0, len(r.agentQueryRules)+len(r.tags))
-	allTags = append(allTags, r.agentQueryRules...)
-	allTags = append(allTags, r.tags...)
+// acquireRetryDelay is how long to wait before the first retry of a failed
+// acquisition.
+const acquireRetryDelay = 2 * time.Second
+
+func (r *Runner) runAgent(ctx context.Context, job *types.Job, output *jobLog, buildPath string) error {
+	jobUUID := job.UUID
+	allTags := make([]string, 0, len(r.cfg.AgentQueryRules)+len(r.cfg.Tags)+len(job.Resources))
+	allTags = append(allTags, r.cfg.AgentQueryRules...)
+	allTags = append(allTags, r.cfg.Tags...)
+
+	// Resource requirements were matched by the scheduler rather than by tag,
+	// so echo the job's own resource rules back as tags for the agent.
+	for _, rule := range job.AgentQueryRules {
+		key, _, _ := strings.Cut(rule, "=")
+		if _, ok := job.Resources[key]; ok {
+			allTags = append(allTags, rule)
+		}
+	}
 
 	tagsValue := r.normalizeTags(allTags)
 
@@ -143,29 +553,64 @@ func (r *Runner) runAgent(ctx context.Context, jobUUID string) error {
 		hostname = "unknown"
 	}
 
-	args := []string{
-		"start",
-		"--acquire-job", jobUUID,
-		"--token", r.buildkiteToken,
-		"--tags", tagsValue,
-		"--name", fmt.Sprintf("worker-%s", hostname),
+	agent := AgentCommand{
+		Args: []string{
+			"start",
+			"--acquire-job", jobUUID,
+			"--tags", tagsValue,
+			"--name", fmt.Sprintf("worker-%s", hostname),
+		},
+		Stdout: output.stream("stdout"),
+		Stderr: output.stream("stderr"),
 	}
 
-	if r.queue != "" {
-		args = append(args, "--queue", r.queue)
+	// Export whatever the server attached to the job, except that the job
+	// can't swap out the worker's agent token.
+	for _, variable := range types.EnvList(job.Env) {
+		if !strings.HasPrefix(variable, "BUILDKITE_AGENT_TOKEN=") {
+			agent.Env = append(agent.Env, variable)
+		}
 	}
+	agent.Env = append(agent.Env, fmt.Sprintf("BUILDKITE_AGENT_TOKEN=%s", r.cfg.BuildkiteToken))
 
-	cmd := exec.CommandContext(ctx, r.buildkiteAgentPath, args...)
+	if r.cfg.Queue != "" {
+		agent.Args = append(agent.Args, "--queue", r.cfg.Queue)
+	}
+	if buildPath != "" {
+		agent.Args = append(agent.Args, "--build-path", buildPath)
+	}
 
-	cmd.Stdout = &prefixedWriter{prefix: fmt.Sprintf("[%s] ", jobUUID[:8])}
-	cmd.Stderr = &prefixedWriter{prefix: fmt.Sprintf("[%s] ", jobUUID[:8])}
+	r.logger.Info().Str("job_uuid", jobUUID).Str("tags", tagsValue).Str("queue", r.cfg.Queue).Str("name", hostname).Msg("Starting agent")
+	retry := &backoff{base: acquireRetryDelay, max: 8 * acquireRetryDelay}
+	for attempt := 0; ; attempt++ {
+		startedAt := time.Now()
+		err := r.cfg.Executor.Run(ctx, job, agent, func(pid int) {
+			if err := r.saveState(jobUUID, job.ClaimToken, pid); err != nil {
+				r.logger.Error().Err(err).Str("job_uuid", jobUUID).Msg("Error saving worker state")
+			}
+		})
+		ran := time.Since(startedAt)
+		agentResult := "passed"
+		if err != nil {
+			agentResult = "failed"
+		}
+		agentDuration.With(agentResult).Observe(ran.Seconds())
+		if err == nil || ctx.Err() != nil || ran >= r.cfg.AcquireWindow {
+			return err
+		}
+		if attempt >= r.cfg.AcquireRetries {
+			acquireFailures.With("gave_up").Inc()
+			return err
+		}
+		acquireFailures.With("retried").Inc()
 
-	r.logger.Info().Str("job_uuid", jobUUID).Str("tags", tagsValue).Str("queue", r.queue).Str("name", hostname).Msg("Starting agent")
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("running buildkite-agent: %w", err)
+		delay := retry.next()
+		r.logger.Warn().Err(err).Str("job_uuid", jobUUID).Int("attempt", attempt+1).Dur("retry_in", delay).Msg("Agent failed to acquire job, retrying")
+		trace.SpanFromContext(ctx).AddEvent("retrying acquire", trace.WithAttributes(attribute.Int("attempt", attempt+1), attribute.String("error", err.Error())))
+		if !sleep(ctx, delay) {
+			return err
+		}
 	}
-
-	return nil
 }
 
 // normalizeTags combines tags into a comma-separated string. For the "queue" key,
@@ -200,39 +645,188 @@ func (r *Runner) normalizeTags(tags []string) string {
 	return strings.Join(result, ",")
 }
 
-func (r *Runner) completeJob(ctx context.Context, jobUUID string) error {
-	url := fmt.Sprintf("%s/jobs/%s/complete", r.apiServer, jobUUID)
+type failJobRequest struct {
+	Error     string `json:"error"`
+	Retryable bool   `json:"retryable,omitempty"`
+}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
-	if err != nil {
-		return fmt.Errorf("creating request: %w", err)
+func (r *Runner) completeJob(ctx context.Context, jobUUID, claimToken string) error {
+	postURL := fmt.Sprintf("%s/v1/jobs/%s/complete", r.cfg.APIServer, jobUUID)
+	if err := r.postIdempotent(ctx, postURL, claimToken, jobIdempotencyKey("complete", jobUUID, claimToken), nil); err != nil {
+		return fmt.Errorf("posting complete: %w", err)
 	}
-	req.Header.Set("X-Worker-ID", r.workerID)
+	return nil
+}
 
-	resp, err := r.httpClient.Do(req)
+// failJob reports a failed run so the server can finish the job or, after too
+// many failures, dead-letter it. Retryable failures weren't the job's fault
+// and don't count towards its attempts.
+func (r *Runner) failJob(ctx context.Context, jobUUID, claimToken string, runErr error, retryable bool) error {
+	body, err := json.Marshal(failJobRequest{Error: runErr.Error(), Retryable: retryable})
 	if err != nil {
-		return fmt.Errorf("completing job: %w", err)
+		return fmt.Errorf("marshaling failure: %w", err)
 	}
-	defer resp.Body.Close()
+	postURL := fmt.Sprintf("%s/v1/jobs/%s/fail", r.cfg.APIServer, jobUUID)
+	if err := r.postIdempotent(ctx, postURL, claimToken, jobIdempotencyKey("fail", jobUUID, claimToken), body); err != nil {
+		return fmt.Errorf("posting fail: %w", err)
+	}
+	return nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
+// heartbeatJob keeps our claim on a job alive until ctx is cancelled. If the
+// server reports that the job has been cancelled, it calls cancelRun to
+// interrupt the agent.
+func (r *Runner) heartbeatJob(ctx context.Context, jobUUID, claimToken string, cancelRun context.CancelCauseFunc) {
+	if r.cfg.HeartbeatInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(r.cfg.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := r.postJobAction(ctx, jobUUID, claimToken, "heartbeat")
+			if errors.Is(err, ErrJobCancelled) {
+				r.logger.Info().Str("uuid", jobUUID).Msg("Job cancelled, interrupting agent")
+				cancelRun(ErrJobCancelled)
+				return
+			}
+			if err != nil && ctx.Err() == nil {
+				r.logger.Warn().Err(err).Str("uuid", jobUUID).Msg("Error heartbeating job")
+			}
+		}
+	}
+}
+
+// heartbeatWorker tells the server this worker is alive until ctx is cancelled.
+func (r *Runner) heartbeatWorker(ctx context.Context) {
+	if r.cfg.HeartbeatInterval <= 0 {
+		return
 	}
 
+	ticker := time.NewTicker(r.cfg.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			var healthErr error
+			if r.health != nil {
+				healthErr = r.healthError()
+			}
+			if err := r.sendWorkerHeartbeat(ctx, healthErr); err != nil && ctx.Err() == nil {
+				r.logger.Warn().Err(err).Msg("Error sending worker heartbeat")
+			}
+		}
+	}
+}
+
+// sendWorkerHeartbeat tells the server this worker is alive and, if it runs
+// preflight checks, whether they're passing.
+func (r *Runner) sendWorkerHeartbeat(ctx context.Context, healthErr error) error {
+	var body []byte
+	if r.health != nil {
+		req := workerHeartbeatRequest{Healthy: healthErr == nil}
+		if healthErr != nil {
+			req.Reason = healthErr.Error()
+		}
+		var err error
+		if body, err = json.Marshal(req); err != nil {
+			return fmt.Errorf("marshaling heartbeat: %w", err)
+		}
+	}
+	return r.post(ctx, fmt.Sprintf("%s/v1/workers/%s/heartbeat", r.cfg.APIServer, url.PathEscape(r.cfg.WorkerID)), "", body)
+}
+
+type workerHeartbeatRequest struct {
+	Healthy bool   `json:"healthy"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+func (r *Runner) postJobAction(ctx context.Context, jobUUID, claimToken, action string) error {
+	if err := r.post(ctx, fmt.Sprintf("%s/v1/jobs/%s/%s", r.cfg.APIServer, jobUUID, action), claimToken, nil); err != nil {
+		return fmt.Errorf("posting %s: %w", action, err)
+	}
 	return nil
 }
 
-type prefixedWriter struct {
-	prefix string
+// setAPIHeaders identifies the worker and the protocol version it speaks,
+// and authenticates it if the server needs a token.
+func (r *Runner) setAPIHeaders(req *http.Request) {
+	req.Header.Set("X-Worker-ID", r.cfg.WorkerID)
+	req.Header.Set("Scheduler-API-Version", "1")
+	if r.cfg.ServerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+r.cfg.ServerToken)
+	}
+}
+
+// idempotentRetries is how many times a complete or fail that got no
+// response is retried.
+const idempotentRetries = 3
+
+// jobIdempotencyKey identifies a job action for one claim, so a retry of it,
+// even from after a restart, gets the outcome of the first attempt rather
+// than a conflict if that one did reach the server.
+func jobIdempotencyKey(action, jobUUID, claimToken string) string {
+	return action + ":" + jobUUID + ":" + claimToken
+}
+
+func (r *Runner) post(ctx context.Context, postURL, claimToken string, body []byte) error {
+	return r.postIdempotent(ctx, postURL, claimToken, "", body)
 }
 
-func (w *prefixedWriter) Write(p []byte) (n int, err error) {
-	lines := strings.Split(string(p), "\n")
-	for _, line := range lines {
-		if line != "" {
-			log.Info().Str("prefix", w.prefix).Msg(line)
+// postIdempotent posts with an Idempotency-Key, if idempotencyKey is set,
+// and then retries requests that fail without a response.
+func (r *Runner) postIdempotent(ctx context.Context, postURL, claimToken, idempotencyKey string, body []byte) error {
+	retry := &backoff{base: r.cfg.PollInterval, max: r.cfg.MaxBackoff}
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, postURL, bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("creating request: %w", err)
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		r.setAPIHeaders(req)
+		if claimToken != "" {
+			req.Header.Set("X-Claim-Token", claimToken)
+		}
+		if idempotencyKey != "" {
+			req.Header.Set("Idempotency-Key", idempotencyKey)
+		}
+
+		resp, err := r.httpClient.Do(req)
+		if err != nil {
+			if idempotencyKey == "" || attempt == idempotentRetries || ctx.Err() != nil {
+				return err
+			}
+			delay := retry.next()
+			r.logger.Warn().Err(err).Str("url", postURL).Dur("retry_in", delay).Msg("Request failed, retrying")
+			if !sleep(ctx, delay) {
+				return err
+			}
+			continue
 		}
+		return checkResponse(resp)
+	}
+}
+
+func checkResponse(resp *http.Response) error {
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusGone {
+		return ErrJobCancelled
+	}
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(respBody))
 	}
-	return len(p), nil
+
+	return nil
 }
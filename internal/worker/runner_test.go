@@ -0,0 +1,25 @@
+package worker
+
+import (
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	r := NewRunner(Config{}, zerolog.Nop())
+	for _, tc := range []struct {
+		tags []string
+		want string
+	}{
+		{nil, ""},
+		{[]string{"queue=default", "arch=amd64", "queue=production"}, "arch=amd64,queue=production"},
+		{[]string{"os=linux", "os=linux", "docker=true"}, "os=linux,os=linux,docker=true"},
+		{[]string{"malformed", "queue=build"}, "queue=build"},
+		{[]string{"queue=", "env=a=b"}, "env=a=b"},
+	} {
+		if got := r.normalizeTags(tc.tags); got != tc.want {
+			t.Errorf("normalizeTags(%q) = %q, want %q", tc.tags, got, tc.want)
+		}
+	}
+}
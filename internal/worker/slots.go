@@ -0,0 +1,137 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+)
+
+// slot returns a copy of the runner for one of its concurrent job slots, with
+// its own logger and, when running more than one slot, its own state file.
+func (r *Runner) slot(id, slots int) *Runner {
+	slot := *r
+	slot.logger = r.logger.With().Int("slot", id).Logger()
+	if r.cfg.StateFile != "" && slots > 1 {
+		slot.cfg.StateFile = fmt.Sprintf("%s.%d", r.cfg.StateFile, id)
+	}
+	return &slot
+}
+
+// runSlot claims and runs jobs one at a time until ctx is cancelled, then
+// returns once its running job has finished or jobCtx is cancelled.
+func (r *Runner) runSlot(ctx, jobCtx context.Context) {
+	if r.cfg.StateFile != "" {
+		r.idle.start()
+		if err := r.resumeJob(jobCtx); err != nil {
+			r.logger.Error().Err(err).Msg("Error resuming job from previous run")
+		}
+		r.idle.finish()
+	}
+
+	ticker := time.NewTicker(r.cfg.PollInterval)
+	defer ticker.Stop()
+
+	var offered <-chan struct{}
+	if r.offers != nil {
+		offered = r.offers.wait()
+	}
+
+	errBackoff := &backoff{base: r.cfg.PollInterval, max: r.cfg.MaxBackoff}
+	for {
+		if !r.waitForWork(ctx, ticker, offered) {
+			r.logger.Debug().Msg("Slot stopped")
+			return
+		}
+
+		// Take the next offer channel before claiming, so an offer made while
+		// we claim isn't missed.
+		if r.offers != nil {
+			offered = r.offers.wait()
+		}
+		err := r.processNextJob(ctx, jobCtx)
+		for err == nil && ctx.Err() == nil {
+			// There may be more jobs waiting that won't be offered again, so
+			// keep claiming until we come back empty.
+			err = r.processNextJob(ctx, jobCtx)
+		}
+		if ctx.Err() != nil {
+			r.logger.Debug().Msg("Slot stopped")
+			return
+		}
+		if err == nil || err == ErrNoJobAvailable {
+			errBackoff.reset()
+			continue
+		}
+
+		delay := errBackoff.next()
+		r.logger.Error().Err(err).Dur("retry_in", delay).Msg("Error processing job")
+		if !sleep(ctx, delay) {
+			r.logger.Debug().Msg("Slot stopped")
+			return
+		}
+	}
+}
+
+// waitForWork blocks until it's time to look for a job: the next poll tick, or
+// while the job stream is connected, the next offer. It returns false once ctx
+// is done.
+func (r *Runner) waitForWork(ctx context.Context, ticker *time.Ticker, offered <-chan struct{}) bool {
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-offered:
+			return true
+		case <-ticker.C:
+			if r.offers == nil || !r.offers.connected.Load() {
+				return true
+			}
+		}
+	}
+}
+
+// resourceUsage tracks how much of a worker's advertised resources its slots'
+// jobs are using, so each claim only offers what is still free.
+type resourceUsage struct {
+	// claiming is held for the whole of a claim request, so slots claiming at
+	// the same time don't both offer the same capacity.
+	claiming sync.Mutex
+
+	mu    sync.Mutex
+	total types.Resources
+	used  types.Resources
+}
+
+func newResourceUsage(total types.Resources) *resourceUsage {
+	return &resourceUsage{total: total, used: types.Resources{}}
+}
+
+// free returns the resources not in use by a running job.
+func (u *resourceUsage) free() types.Resources {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	free := make(types.Resources, len(u.total))
+	for key, quantity := range u.total {
+		free[key] = max(quantity-u.used[key], 0)
+	}
+	return free
+}
+
+func (u *resourceUsage) acquire(r types.Resources) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for key, quantity := range r {
+		u.used[key] += quantity
+	}
+}
+
+func (u *resourceUsage) release(r types.Resources) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for key, quantity := range r {
+		u.used[key] -= quantity
+	}
+}
@@ -0,0 +1,36 @@
+package worker
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+	"github.com/rs/zerolog"
+)
+
+func TestSlotStateFile(t *testing.T) {
+	r := NewRunner(Config{StateFile: "/var/lib/worker/state"}, zerolog.Nop())
+	if got := r.slot(0, 1).cfg.StateFile; got != "/var/lib/worker/state" {
+		t.Errorf("single slot got state file %s, want the worker's", got)
+	}
+	if got := r.slot(2, 3).cfg.StateFile; got != "/var/lib/worker/state.2" {
+		t.Errorf("slot 2 got state file %s, want state.2", got)
+	}
+	if got := NewRunner(Config{}, zerolog.Nop()).slot(1, 3).cfg.StateFile; got != "" {
+		t.Errorf("got state file %q without one configured", got)
+	}
+}
+
+func TestResourceUsage(t *testing.T) {
+	u := newResourceUsage(types.Resources{"cpu": 8, "gpu": 1})
+	u.acquire(types.Resources{"cpu": 6, "gpu": 1})
+	u.acquire(types.Resources{"cpu": 4})
+	if got, want := u.free(), (types.Resources{"cpu": 0, "gpu": 0}); !reflect.DeepEqual(got, want) {
+		t.Errorf("free %v, want %v: overcommitted resources aren't offered", got, want)
+	}
+
+	u.release(types.Resources{"cpu": 6, "gpu": 1})
+	if got, want := u.free(), (types.Resources{"cpu": 4, "gpu": 1}); !reflect.DeepEqual(got, want) {
+		t.Errorf("free %v, want %v", got, want)
+	}
+}
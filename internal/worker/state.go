@@ -0,0 +1,162 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// jobState is what the worker persists about its in-flight job, so a restarted
+// worker can pick up where it left off instead of orphaning the claim.
+type jobState struct {
+	WorkerID   string    `json:"worker_id"`
+	JobUUID    string    `json:"job_uuid,omitempty"`
+	ClaimToken string    `json:"claim_token,omitempty"`
+	AgentPID   int       `json:"agent_pid,omitempty"`
+	Updated    time.Time `json:"updated"`
+}
+
+// StateWorkerID returns the worker ID recorded in a state file, or an empty
+// string if there is none. Reusing it keeps a restarted worker's identity, and
+// with it ownership of any job it had claimed.
+func StateWorkerID(path string) (string, error) {
+	state, err := loadState(path)
+	if err != nil || state == nil {
+		return "", err
+	}
+	return state.WorkerID, nil
+}
+
+func loadState(path string) (*jobState, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading state file: %w", err)
+	}
+
+	var state jobState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("decoding state file: %w", err)
+	}
+	return &state, nil
+}
+
+// saveState records the in-flight job, if any. It is a no-op when no state
+// file is configured.
+func (r *Runner) saveState(jobUUID, claimToken string, agentPID int) error {
+	if r.cfg.StateFile == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(jobState{
+		WorkerID:   r.cfg.WorkerID,
+		JobUUID:    jobUUID,
+		ClaimToken: claimToken,
+		AgentPID:   agentPID,
+		Updated:    time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("encoding state: %w", err)
+	}
+
+	// Write then rename so a crash mid-write can't leave a truncated file.
+	tmp, err := os.CreateTemp(filepath.Dir(r.cfg.StateFile), ".worker-state-*")
+	if err != nil {
+		return fmt.Errorf("creating state file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing state file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), r.cfg.StateFile); err != nil {
+		return fmt.Errorf("replacing state file: %w", err)
+	}
+	return nil
+}
+
+// resumeJob settles a job left in flight by a previous run of this worker. If
+// the agent is still running we re-attach, heartbeating the job until the agent
+// exits; if it had already started we mark the job complete, since Buildkite
+// has its result; and if the agent never started we fail the job so it can be
+// retried.
+func (r *Runner) resumeJob(ctx context.Context) error {
+	state, err := loadState(r.cfg.StateFile)
+	if err != nil {
+		return err
+	}
+	if state == nil || state.JobUUID == "" {
+		return nil
+	}
+
+	logger := r.logger.With().Str("uuid", state.JobUUID).Int("agent_pid", state.AgentPID).Logger()
+
+	switch {
+	case state.AgentPID == 0:
+		logger.Warn().Msg("Worker restarted before starting agent, failing job")
+		if err := r.failJob(ctx, state.JobUUID, state.ClaimToken, fmt.Errorf("worker restarted before starting agent"), false); err != nil {
+			logger.Error().Err(err).Msg("Error marking job failed")
+		}
+
+	case processAlive(state.AgentPID):
+		logger.Info().Msg("Re-attaching to running agent")
+		if r.buildDirs != nil {
+			r.buildDirs.use(state.JobUUID)
+			defer r.buildDirs.release(state.JobUUID)
+		}
+		heartbeatCtx, stopHeartbeat := context.WithCancel(ctx)
+		go r.heartbeatJob(heartbeatCtx, state.JobUUID, state.ClaimToken, func(error) {
+			logger.Info().Msg("Job cancelled, interrupting agent")
+			if process, err := os.FindProcess(state.AgentPID); err == nil {
+				process.Signal(syscall.SIGTERM)
+			}
+		})
+		err := waitForExit(ctx, state.AgentPID)
+		stopHeartbeat()
+		if err != nil {
+			return err
+		}
+		fallthrough
+
+	default:
+		logger.Info().Msg("Agent from previous run has exited, completing job")
+		if err := r.completeJob(ctx, state.JobUUID, state.ClaimToken); err != nil {
+			logger.Error().Err(err).Msg("Error marking job complete")
+		}
+	}
+
+	return r.saveState("", "", 0)
+}
+
+func processAlive(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return process.Signal(syscall.Signal(0)) == nil
+}
+
+// waitForExit polls until a process that isn't our child exits.
+func waitForExit(ctx context.Context, pid int) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for processAlive(pid) {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package worker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestStateFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	r := NewRunner(Config{WorkerID: "w1", StateFile: path}, zerolog.Nop())
+
+	if id, err := StateWorkerID(path); err != nil || id != "" {
+		t.Fatalf("missing state file: got %q, %v, want no worker ID", id, err)
+	}
+	if err := r.saveState("job", "token", 42); err != nil {
+		t.Fatal(err)
+	}
+	state, err := loadState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.WorkerID != "w1" || state.JobUUID != "job" || state.ClaimToken != "token" || state.AgentPID != 42 {
+		t.Errorf("loaded %+v, want what was saved", state)
+	}
+	if id, err := StateWorkerID(path); err != nil || id != "w1" {
+		t.Errorf("got worker ID %q, %v, want w1", id, err)
+	}
+
+	if err := os.WriteFile(path, []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := StateWorkerID(path); err == nil {
+		t.Error("read a worker ID from a corrupt state file")
+	}
+}
+
+func TestResumeJob(t *testing.T) {
+	// A process that has exited, standing in for an agent that finished while
+	// the worker was down.
+	exited := exec.Command("true")
+	if err := exited.Run(); err != nil {
+		t.Skip("can't run true:", err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		job  string
+		pid  int
+		want string
+	}{
+		{"agent never started", "job", 0, "/v1/jobs/job/fail"},
+		{"agent exited", "job", exited.Process.Pid, "/v1/jobs/job/complete"},
+		{"nothing in flight", "", 0, ""},
+	} {
+		var got string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = r.URL.Path
+			if claimToken := r.Header.Get("X-Claim-Token"); claimToken != "token" {
+				t.Errorf("%s: sent claim token %q, want token", tc.name, claimToken)
+			}
+		}))
+
+		path := filepath.Join(t.TempDir(), "state.json")
+		r := NewRunner(Config{APIServer: server.URL, WorkerID: "w1", StateFile: path}, zerolog.Nop())
+		if err := r.saveState(tc.job, "token", tc.pid); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.resumeJob(context.Background()); err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+		server.Close()
+
+		if got != tc.want {
+			t.Errorf("%s: posted to %q, want %q", tc.name, got, tc.want)
+		}
+		state, err := loadState(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state.JobUUID != "" || state.WorkerID != "w1" {
+			t.Errorf("%s: left state %+v, want just the worker ID", tc.name, state)
+		}
+	}
+}
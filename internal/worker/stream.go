@@ -0,0 +1,105 @@
+package worker
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/buildkite/buildkite-custom-scheduler/internal/types"
+)
+
+// offerStream tracks the server's stream of job offers, which wakes idle slots
+// as soon as matching jobs are waiting. While it is disconnected, slots poll.
+type offerStream struct {
+	connected atomic.Bool
+
+	mu      sync.Mutex
+	offered chan struct{}
+}
+
+func newOfferStream() *offerStream {
+	return &offerStream{offered: make(chan struct{})}
+}
+
+// wait returns a channel that is closed on the next offer.
+func (s *offerStream) wait() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.offered
+}
+
+func (s *offerStream) offer() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	close(s.offered)
+	s.offered = make(chan struct{})
+}
+
+// streamOffers keeps the offer stream connected until ctx is cancelled,
+// reconnecting with backoff after it drops.
+func (r *Runner) streamOffers(ctx context.Context) {
+	reconnect := &backoff{base: r.cfg.PollInterval, max: r.cfg.MaxBackoff}
+	for {
+		connectedAt := time.Now()
+		err := r.subscribeOffers(ctx)
+		r.offers.connected.Store(false)
+		if ctx.Err() != nil {
+			return
+		}
+		// A stream that stayed up for a while was healthy, so start backing
+		// off from scratch.
+		if time.Since(connectedAt) > r.cfg.MaxBackoff {
+			reconnect.reset()
+		}
+
+		delay := reconnect.next()
+		r.logger.Warn().Err(err).Dur("retry_in", delay).Msg("Job stream disconnected, falling back to polling")
+		if !sleep(ctx, delay) {
+			return
+		}
+	}
+}
+
+func (r *Runner) subscribeOffers(ctx context.Context) error {
+	params := url.Values{}
+	params.Set("query", types.NormalizeQueryRules(r.queryRules()))
+	streamURL := fmt.Sprintf("%s/v1/jobs/stream?%s", r.cfg.APIServer, params.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, streamURL, nil)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	r.setAPIHeaders(req)
+	req.Header.Set("Accept", "text/event-stream")
+
+	// The stream stays open indefinitely, so it can't share the API client's
+	// request timeout.
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("connecting to job stream: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	r.offers.connected.Store(true)
+	r.logger.Info().Msg("Subscribed to job stream")
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if scanner.Text() == "event: offer" {
+			r.offers.offer()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading job stream: %w", err)
+	}
+	return fmt.Errorf("job stream closed")
+}
@@ -0,0 +1,40 @@
+package worker
+
+// Subscription is one set of query rules the worker claims jobs for, with its
+// own slots.
+type Subscription struct {
+	AgentQueryRules []string
+	// Concurrency is how many slots claim for the subscription. Zero uses the
+	// worker's Concurrency.
+	Concurrency int
+}
+
+// subscriptions returns what the worker claims for: its Subscriptions, or
+// without any, its own queue and query rules.
+func (r *Runner) subscriptions() []Subscription {
+	if len(r.cfg.Subscriptions) == 0 {
+		return []Subscription{{AgentQueryRules: r.cfg.AgentQueryRules, Concurrency: max(r.cfg.Concurrency, 1)}}
+	}
+	subs := make([]Subscription, len(r.cfg.Subscriptions))
+	for i, sub := range r.cfg.Subscriptions {
+		if sub.Concurrency < 1 {
+			sub.Concurrency = max(r.cfg.Concurrency, 1)
+		}
+		subs[i] = sub
+	}
+	return subs
+}
+
+// subscriber returns a copy of the runner that claims for one subscription,
+// to make its slots from.
+func (r *Runner) subscriber(sub Subscription) *Runner {
+	subscriber := *r
+	subscriber.cfg.Concurrency = sub.Concurrency
+	// Subscriptions carry their own queue rule, if any.
+	if len(r.cfg.Subscriptions) > 0 {
+		subscriber.cfg.AgentQueryRules = sub.AgentQueryRules
+		subscriber.cfg.Queue = ""
+		subscriber.logger = r.logger.With().Strs("query_rules", sub.AgentQueryRules).Logger()
+	}
+	return &subscriber
+}
@@ -0,0 +1,74 @@
+package worker
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestSubscriptions(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		cfg  Config
+		want []Subscription
+	}{
+		{
+			"own rules",
+			Config{AgentQueryRules: []string{"os=linux"}, Concurrency: 3},
+			[]Subscription{{AgentQueryRules: []string{"os=linux"}, Concurrency: 3}},
+		},
+		{
+			"one slot by default",
+			Config{AgentQueryRules: []string{"os=linux"}},
+			[]Subscription{{AgentQueryRules: []string{"os=linux"}, Concurrency: 1}},
+		},
+		{
+			"subscriptions",
+			Config{
+				AgentQueryRules: []string{"os=linux"},
+				Concurrency:     2,
+				Subscriptions: []Subscription{
+					{AgentQueryRules: []string{"queue=build"}, Concurrency: 4},
+					{AgentQueryRules: []string{"queue=deploy"}},
+				},
+			},
+			[]Subscription{
+				{AgentQueryRules: []string{"queue=build"}, Concurrency: 4},
+				{AgentQueryRules: []string{"queue=deploy"}, Concurrency: 2},
+			},
+		},
+	} {
+		r := NewRunner(tc.cfg, zerolog.Nop())
+		if got := r.subscriptions(); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestSubscriber(t *testing.T) {
+	// Without subscriptions, the worker's queue still applies.
+	r := NewRunner(Config{AgentQueryRules: []string{"os=linux"}, Queue: "build"}, zerolog.Nop())
+	sub := r.subscriber(r.subscriptions()[0])
+	if got, want := sub.queryRules(), []string{"queue=build", "os=linux"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got rules %v, want %v", got, want)
+	}
+
+	// Subscriptions replace the worker's queue and rules with their own.
+	r = NewRunner(Config{
+		AgentQueryRules: []string{"os=linux"},
+		Queue:           "build",
+		Subscriptions:   []Subscription{{AgentQueryRules: []string{"queue=deploy", "env=prod"}, Concurrency: 2}},
+	}, zerolog.Nop())
+	sub = r.subscriber(r.subscriptions()[0])
+	if got, want := sub.queryRules(), []string{"queue=deploy", "env=prod"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got rules %v, want %v", got, want)
+	}
+	if sub.cfg.Concurrency != 2 {
+		t.Errorf("got concurrency %d, want the subscription's 2", sub.cfg.Concurrency)
+	}
+	// The original runner is untouched.
+	if r.cfg.Queue != "build" || !reflect.DeepEqual(r.cfg.AgentQueryRules, []string{"os=linux"}) {
+		t.Errorf("subscriber modified the worker's own config: %+v", r.cfg)
+	}
+}
@@ -0,0 +1,185 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// terminationPollInterval is how often the instance metadata service is
+// checked for a termination notice. AWS gives two minutes' warning and GCP
+// thirty seconds, so this needs to be short.
+const terminationPollInterval = 5 * time.Second
+
+var (
+	awsMetadataURL = "http://169.254.169.254"
+	gcpMetadataURL = "http://metadata.google.internal"
+)
+
+// ErrInstanceTerminating is the cause jobs are interrupted with when the
+// worker's instance is about to be reclaimed.
+var ErrInstanceTerminating = fmt.Errorf("instance is being terminated")
+
+// terminationWatcher polls a cloud provider's metadata service for notice that
+// a spot or preemptible instance is about to be reclaimed.
+type terminationWatcher struct {
+	provider   string
+	httpClient *http.Client
+	logger     zerolog.Logger
+
+	// awsToken is the cached IMDSv2 session token.
+	awsToken        string
+	awsTokenExpires time.Time
+}
+
+func newTerminationWatcher(provider string, logger zerolog.Logger) *terminationWatcher {
+	return &terminationWatcher{provider: provider, httpClient: &http.Client{Timeout: 2 * time.Second}, logger: logger}
+}
+
+// wait blocks until a termination notice arrives, returning its description,
+// or until ctx is cancelled.
+func (w *terminationWatcher) wait(ctx context.Context) (string, error) {
+	ticker := time.NewTicker(terminationPollInterval)
+	defer ticker.Stop()
+
+	for {
+		var notice string
+		var err error
+		switch w.provider {
+		case "aws":
+			notice, err = w.awsNotice(ctx)
+		case "gcp":
+			notice, err = w.gcpNotice(ctx)
+		default:
+			return "", fmt.Errorf("unknown termination notice provider %q", w.provider)
+		}
+		if err != nil && ctx.Err() == nil {
+			w.logger.Warn().Err(err).Str("provider", w.provider).Msg("Error checking for termination notice")
+		}
+		if notice != "" {
+			return notice, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// awsNotice checks the EC2 spot instance-action, which only exists once AWS
+// has scheduled the instance to be stopped or terminated.
+func (w *terminationWatcher) awsNotice(ctx context.Context) (string, error) {
+	if w.awsToken == "" || time.Now().After(w.awsTokenExpires) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, awsMetadataURL+"/latest/api/token", nil)
+		if err != nil {
+			return "", fmt.Errorf("creating token request: %w", err)
+		}
+		req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600")
+		token, err := w.get(req)
+		if err != nil {
+			return "", fmt.Errorf("getting IMDS token: %w", err)
+		}
+		w.awsToken = token
+		w.awsTokenExpires = time.Now().Add(6*time.Hour - time.Minute)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, awsMetadataURL+"/latest/meta-data/spot/instance-action", nil)
+	if err != nil {
+		return "", fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", w.awsToken)
+	body, err := w.get(req)
+	if err != nil || body == "" {
+		return "", err
+	}
+
+	var action struct {
+		Action string `json:"action"`
+		Time   string `json:"time"`
+	}
+	if err := json.Unmarshal([]byte(body), &action); err != nil {
+		return "", fmt.Errorf("decoding instance action: %w", err)
+	}
+	return fmt.Sprintf("EC2 spot instance %s scheduled for %s", action.Action, action.Time), nil
+}
+
+// gcpNotice checks whether the GCE instance has been preempted.
+func (w *terminationWatcher) gcpNotice(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, gcpMetadataURL+"/computeMetadata/v1/instance/preempted", nil)
+	if err != nil {
+		return "", fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	body, err := w.get(req)
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(body) != "TRUE" {
+		return "", nil
+	}
+	return "GCE instance preempted", nil
+}
+
+// get makes a metadata request, returning an empty body for 404s.
+func (w *terminationWatcher) get(req *http.Request) (string, error) {
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "", nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("reading response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
+	}
+	return string(body), nil
+}
+
+// watchTermination stops the worker as soon as its instance is given notice:
+// claiming stops, running agents are interrupted so their jobs can be failed
+// for retry elsewhere, and the worker deregisters once they have exited.
+func (r *Runner) watchTermination(ctx context.Context, stopClaiming context.CancelFunc, killJobs context.CancelCauseFunc) {
+	notice, err := newTerminationWatcher(r.cfg.TerminationNotices, r.logger).wait(ctx)
+	if err != nil {
+		return
+	}
+	r.logger.Warn().Str("notice", notice).Msg("Instance is being terminated, interrupting jobs")
+	killJobs(fmt.Errorf("%w: %s", ErrInstanceTerminating, notice))
+	stopClaiming()
+}
+
+// deregister tells the server this worker is gone, so any job it had claimed
+// but not started is requeued straight away.
+func (r *Runner) deregister(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, fmt.Sprintf("%s/v1/workers/%s", r.cfg.APIServer, url.PathEscape(r.cfg.WorkerID)), nil)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	r.setAPIHeaders(req)
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
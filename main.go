@@ -1,28 +1,82 @@
 package main
 
 import (
+	"context"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/alecthomas/kong"
 	"github.com/buildkite/buildkite-custom-scheduler/internal/commands"
+	"github.com/buildkite/buildkite-custom-scheduler/internal/config"
+	"github.com/buildkite/buildkite-custom-scheduler/internal/version"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
-var cli struct {
-	Server commands.ServerCmd `cmd:"" help:"Start the API server"`
-	Worker commands.WorkerCmd `cmd:"" help:"Start a worker"`
-}
+type cli struct {
+	VersionFlag           kong.VersionFlag `name:"version" help:"Print the version and exit"`
+	commands.LogFlags     `embed:""`
+	commands.TracingFlags `embed:""`
 
-func main() {
-	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	Server  commands.ServerCmd     `cmd:"" help:"Start the API server"`
+	Worker  commands.WorkerCmd     `cmd:"" help:"Start a worker"`
+	Pool    commands.WorkerPoolCmd `cmd:"" name:"worker-pool" help:"Run a fixed number of workers in one process, restarting any that fail"`
+	Dev     commands.DevCmd        `cmd:"" help:"Run the server, in-memory Redis and workers in one process for local development"`
+	Cleanup commands.CleanupCmd    `cmd:"" help:"Requeue or expunge orphaned jobs"`
+	Purge   commands.PurgeCmd      `cmd:"" help:"Release reservations and delete the scheduler's state from Redis"`
+	Tokens  commands.TokensCmd     `cmd:"" help:"Manage scoped API tokens"`
+	Stats   commands.StatsCmd      `cmd:"" help:"Show queue depths and worker capacity from the API server"`
+	Jobs    commands.JobsCmd       `cmd:"" help:"List, inspect and requeue jobs through the API server"`
+	Queue   commands.QueueCmd      `cmd:"" help:"Pause and resume queues through the API server"`
+	Doctor  commands.DoctorCmd     `cmd:"" help:"Check Redis, the Stacks API, DNS and the agent binary"`
+	Version commands.VersionCmd    `cmd:"" help:"Print the version"`
+}
 
-	ctx := kong.Parse(&cli,
+func newParser(c *cli) (*kong.Kong, error) {
+	return kong.New(c,
 		kong.Name("buildkite-custom-scheduler"),
 		kong.Description("A custom Buildkite scheduler using the Stacks API"),
 		kong.UsageOnError(),
+		kong.Configuration(config.Load),
+		kong.Vars{"version": version.String()},
 	)
+}
+
+// reload parses the command line, environment and config file again, for
+// the server to pick up changed settings.
+func reload() (*commands.ServerCmd, commands.LogFlags, error) {
+	var c cli
+	parser, err := newParser(&c)
+	if err != nil {
+		return nil, commands.LogFlags{}, err
+	}
+	if _, err := parser.Parse(os.Args[1:]); err != nil {
+		return nil, commands.LogFlags{}, err
+	}
+	return &c.Server, c.LogFlags, nil
+}
+
+func main() {
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+
+	var c cli
+	parser, err := newParser(&c)
+	if err != nil {
+		panic(err)
+	}
+	ctx, err := parser.Parse(os.Args[1:])
+	parser.FatalIfErrorf(err)
+
+	ctx.FatalIfErrorf(c.LogFlags.Apply())
+	flushSpans, err := c.TracingFlags.Apply(context.Background(), strings.Fields(ctx.Command())[0])
+	ctx.FatalIfErrorf(err)
+	err = ctx.Run(commands.Reloader(reload))
 
-	err := ctx.Run()
+	flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if flushErr := flushSpans(flushCtx); flushErr != nil {
+		log.Warn().Err(flushErr).Msg("Error exporting traces")
+	}
 	ctx.FatalIfErrorf(err)
 }